@@ -10,13 +10,17 @@ import (
 	"syscall"
 	"time"
 
+	"relay/internal/api"
+	"relay/internal/blobstore"
 	"relay/internal/config"
+	"relay/internal/database"
 	"relay/internal/llm"
 	"relay/internal/loadbalancer"
 	"relay/internal/processor"
 	"relay/internal/provider"
 	"relay/internal/queue"
 	"relay/internal/recipient"
+	"relay/internal/retention"
 	"relay/internal/smtp"
 	"relay/internal/webhook"
 	"relay/internal/webui"
@@ -26,6 +30,32 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
+// localQueue is a queue kept in this process, used when no database queue is
+type localQueue interface {
+	queue.Queue
+	webui.QueueStats
+}
+
+// newLocalQueue opens the file queue, or the in-memory queue when the backend is
+// memory or the file queue cannot be opened
+func newLocalQueue(cfg config.QueueConfig) localQueue {
+	if cfg.Backend != "memory" {
+		fileQueue, err := queue.NewFileQueue(cfg.StoragePath)
+		if err == nil {
+			log.Printf("Using file queue at %s", cfg.StoragePath)
+			return fileQueue
+		}
+		log.Printf("Failed to open file queue: %v", err)
+	}
+
+	log.Println("Using in-memory queue instead - queued messages are lost on restart")
+	memQueue := queue.NewMemoryQueue()
+	if memQueue == nil {
+		log.Fatal("Failed to create memory queue - no queue backend available")
+	}
+	return memQueue
+}
+
 // LegacyProcessorAdapter adapts the unified processor for WebUI compatibility
 type LegacyProcessorAdapter struct {
 	unifiedProcessor *processor.UnifiedProcessor
@@ -63,25 +93,68 @@ func main() {
 	var statsQueue webui.QueueStats
 	var recipientService *recipient.Service
 
-	// Try MySQL first, fall back to memory queue
-	mysqlQueue, err := queue.NewMySQLQueue(&cfg.MySQL)
+	// Open the queue backend. MySQL and Redis fall back to the file queue, which
+	// keeps messages on local disk, and the in-memory queue is the last resort.
+	var mysqlQueue *queue.MySQLQueue
+	switch cfg.Queue.Backend {
+	case "mysql":
+		mysqlQueue, err = queue.NewMySQLQueue(&cfg.MySQL)
+		if err != nil {
+			log.Printf("Failed to initialize MySQL queue: %v", err)
+			mysqlQueue = nil
+		} else {
+			q = mysqlQueue
+			statsQueue = mysqlQueue
+			defer mysqlQueue.Close()
+		}
+	case "postgres":
+		pgDB, pgErr := database.OpenPostgres(&cfg.Database.Postgres)
+		if pgErr == nil {
+			mysqlQueue, pgErr = queue.NewSQLQueue(pgDB)
+		}
+		if pgErr != nil {
+			log.Printf("Failed to initialize PostgreSQL queue: %v", pgErr)
+			mysqlQueue = nil
+		} else {
+			q = mysqlQueue
+			statsQueue = mysqlQueue
+			defer mysqlQueue.Close()
+		}
+	case "redis":
+		redisQueue, err := queue.NewRedisQueue(&cfg.Queue.Redis)
+		if err != nil {
+			log.Printf("Failed to initialize Redis queue: %v", err)
+		} else {
+			q = redisQueue
+			statsQueue = redisQueue
+			defer redisQueue.Close()
+		}
+	}
+	if q == nil {
+		local := newLocalQueue(cfg.Queue)
+		q = local
+		statsQueue = local
+		defer local.Close()
+	}
+
+	// With an attachment store the queue keeps only attachment references, and
+	// providers stream the content from the store when they build the message
+	attachmentStore, err := blobstore.New(cfg.Attachments)
 	if err != nil {
-		log.Printf("Failed to initialize MySQL queue: %v", err)
-		log.Println("Using in-memory queue instead")
-		memQueue := queue.NewMemoryQueue()
-		if memQueue == nil {
-			log.Fatal("Failed to create memory queue - both MySQL and memory queues unavailable")
+		log.Fatalf("Failed to open attachment store: %v", err)
+	}
+	if attachmentStore != nil {
+		if storer, ok := q.(queue.AttachmentStorer); ok {
+			storer.SetAttachmentStore(attachmentStore)
 		}
-		q = memQueue
-		statsQueue = memQueue
-		log.Println("Warning: Recipient tracking disabled - requires MySQL database")
-	} else {
-		q = mysqlQueue
-		statsQueue = mysqlQueue
-		defer mysqlQueue.Close()
+		log.Printf("Attachment content is kept in the %s attachment store", cfg.Attachments.Backend)
+	}
 
-		// Create shared database connection pool
-		sharedDB, err = sql.Open("mysql", cfg.MySQL.GetDSN())
+	if mysqlQueue == nil && (cfg.Queue.Backend == "mysql" || cfg.Queue.Backend == "postgres") {
+		log.Println("Warning: Recipient tracking disabled - requires a MySQL or PostgreSQL database")
+	} else {
+		// Create shared database connection pool (MySQL or PostgreSQL, per DB_DRIVER)
+		sharedDB, err = database.Open(cfg)
 		if err != nil {
 			log.Fatalf("Failed to create shared database connection: %v", err)
 		}
@@ -147,6 +220,7 @@ func main() {
 	if providerRouter == nil {
 		log.Fatal("Failed to create provider router")
 	}
+	providerRouter.SetAttachmentStore(attachmentStore)
 	
 	// Initialize all providers based on workspace configuration
 	if err := providerRouter.InitializeProviders(); err != nil {
@@ -174,8 +248,10 @@ func main() {
 	// Initialize load balancer after provider router (optional - only if database is available)
 	if sharedDB != nil {
 		// Use shared database connection for load balancer
-		// Import sqlx for the load balancer
-		dbx := sqlx.NewDb(sharedDB, "mysql")
+		// Import sqlx for the load balancer. Queries keep ? placeholders on
+		// PostgreSQL too; the database package rebinds them in the driver, and
+		// naming the dialect keeps sqlx's own binding in step with it.
+		dbx := sqlx.NewDb(sharedDB, string(database.For(sharedDB)))
 		if dbx == nil {
 			log.Printf("Warning: Failed to create sqlx database connection for load balancer")
 			log.Println("Load balancing disabled - will use direct domain routing only")
@@ -255,6 +331,7 @@ func main() {
 	if smtpServer == nil {
 		log.Fatal("Failed to create SMTP server")
 	}
+	smtpServer.SetDedupWindow(cfg.Queue.DedupWindow)
 	// TODO: Integrate load balancer with SMTP server for generic domain routing
 	
 	// For WebUI server, create a compatibility processor interface  
@@ -275,6 +352,30 @@ func main() {
 		}
 	}
 
+	// Mandrill-compatible HTTP API shares the SMTP intake rules and queue
+	if cfg.MandrillAPI.Enabled {
+		if len(cfg.MandrillAPI.Keys) == 0 {
+			log.Printf("Warning: MANDRILL_API_KEYS is empty - all Mandrill API requests will be rejected")
+		}
+		mandrillAPI := api.NewMandrillAPI(sharedDB, q, workspaceManager, cfg.MandrillAPI.Keys)
+		mandrillAPI.SetAttachmentStore(attachmentStore)
+		mandrillAPI.SetDedupWindow(cfg.Queue.DedupWindow)
+		webServer.RegisterMandrillAPI(mandrillAPI)
+	}
+
+	// Retention archives and purges finished messages (only for queues that support it)
+	var retentionService *retention.Service
+	if cfg.Retention.Enabled {
+		if archiver, ok := q.(queue.Archiver); !ok {
+			log.Printf("Warning: The %s queue does not support message retention - RETENTION_ENABLED ignored", cfg.Queue.Backend)
+		} else if archive, err := retention.OpenArchive(cfg.Retention, cfg.Attachments.S3); err != nil {
+			log.Printf("Warning: Message retention disabled: %v", err)
+		} else {
+			retentionService = retention.NewService(archiver, archive, workspaceManager, attachmentStore, cfg.Retention)
+			webServer.RegisterArchiveAPI(api.NewArchiveAPI(retentionService))
+		}
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(3)
 
@@ -297,6 +398,14 @@ func main() {
 		unifiedProcessor.Start()
 	}()
 
+	if retentionService != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retentionService.Start()
+		}()
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
@@ -306,6 +415,10 @@ func main() {
 	
 	// Stop the unified processor gracefully
 	unifiedProcessor.Stop()
+
+	if retentionService != nil {
+		retentionService.Stop()
+	}
 	
 	// Shutdown provider router
 	providerRouter.Shutdown(nil)
@@ -0,0 +1,9 @@
+//go:build postgres
+
+package main
+
+// Links the pgx PostgreSQL driver for DB_DRIVER=postgres. It is behind a build
+// tag so MySQL-only binaries do not carry it:
+//
+//	go build -tags postgres ./cmd/server
+import _ "github.com/jackc/pgx/v5/stdlib"
@@ -30,6 +30,7 @@ import {
   ResponsiveContainer,
 } from 'recharts';
 import { useStats, useRateLimits, useHealth } from '@/services/metrics';
+import { useWorkers } from '@/services/workers';
 import { ChartPalette } from '@/assets/styles/theme';
 
 const statusColors = ChartPalette.status;
@@ -38,6 +39,7 @@ function MetricsPage() {
   const { data: stats, isLoading: statsLoading } = useStats();
   const { data: rateLimits, isLoading: rateLimitsLoading } = useRateLimits();
   const { data: health, isLoading: healthLoading } = useHealth();
+  const { data: workers } = useWorkers();
 
   // Ensure data is properly initialized and safe for Recharts
   const hourlyData = React.useMemo(() => {
@@ -102,6 +104,19 @@ function MetricsPage() {
     }));
   }, [stats?.provider_stats]);
 
+  // One bar per lane, highest first, so empty lanes still show up
+  const laneData = React.useMemo(() => {
+    const depths = stats?.lane_depths || [];
+    return ['high', 'normal', 'low'].map(priority => {
+      const depth = depths.find(d => d.priority === priority);
+      return {
+        priority,
+        queued: depth?.queued || 0,
+        processing: depth?.processing || 0,
+      };
+    });
+  }, [stats?.lane_depths]);
+
   if (statsLoading || rateLimitsLoading || healthLoading) {
     return <LinearProgress />;
   }
@@ -356,6 +371,26 @@ function MetricsPage() {
         </Paper>
       </GridLegacy>
 
+      {/* Queue Depth by Priority */}
+      <GridLegacy item xs={12} lg={6}>
+        <Paper sx={{ p: 2 }}>
+          <Typography variant="h6" gutterBottom>
+            Queue Depth by Priority
+          </Typography>
+          <ResponsiveContainer width="100%" height={300}>
+            <BarChart data={laneData} isAnimationActive={false}>
+              <CartesianGrid strokeDasharray="3 3" />
+              <XAxis dataKey="priority" />
+              <YAxis allowDecimals={false} />
+              <Tooltip />
+              <Legend />
+              <Bar dataKey="queued" stackId="lane" fill={statusColors.queued} name="Queued" isAnimationActive={false} />
+              <Bar dataKey="processing" stackId="lane" fill={statusColors.processing} name="Processing" isAnimationActive={false} />
+            </BarChart>
+          </ResponsiveContainer>
+        </Paper>
+      </GridLegacy>
+
       {/* Rate Limits */}
       <GridLegacy item xs={12} lg={6}>
         <Paper sx={{ p: 2 }}>
@@ -385,6 +420,43 @@ function MetricsPage() {
         </Paper>
       </GridLegacy>
 
+      {/* Workers */}
+      {workers && workers.total > 0 && (
+        <GridLegacy item xs={12}>
+          <Paper sx={{ p: 2 }}>
+            <Typography variant="h6" gutterBottom>
+              Workers ({workers.active} active)
+            </Typography>
+            <Box sx={{ mt: 2 }}>
+              {workers.workers.map((worker) => (
+                <Box
+                  key={worker.id}
+                  sx={{ display: 'flex', alignItems: 'center', gap: 2, mb: 1.5, flexWrap: 'wrap' }}
+                >
+                  <Chip
+                    label={worker.status}
+                    size="small"
+                    color={worker.status === 'active' ? 'success' : worker.status === 'unresponsive' ? 'warning' : 'default'}
+                  />
+                  <Typography variant="body2" sx={{ fontWeight: 600, minWidth: 200 }}>
+                    {worker.id}
+                  </Typography>
+                  <Typography variant="body2" color="text.secondary">
+                    Last heartbeat {new Date(worker.last_heartbeat_at).toLocaleTimeString()}
+                  </Typography>
+                  <Typography variant="body2" color="text.secondary">
+                    {worker.held_messages.length} processing
+                  </Typography>
+                  {worker.held_messages.slice(0, 5).map((id) => (
+                    <Chip key={id} label={id.slice(0, 8)} size="small" variant="outlined" />
+                  ))}
+                </Box>
+              ))}
+            </Box>
+          </Paper>
+        </GridLegacy>
+      )}
+
       {/* Average Processing Time */}
       <GridLegacy item xs={12}>
         <Paper sx={{ p: 2 }}>
@@ -176,6 +176,7 @@ export const ChartPalette = {
     sent: color_green_success,
     failed: color_red_warning,
     auth_error: color_orange_warning,
+    dead: color_black_secondary,
   },
   providers: {
     gmail: '#4285F4',
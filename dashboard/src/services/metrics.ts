@@ -12,6 +12,7 @@ export interface StatsResponse {
   success_rate: number;
   hourly_stats: HourlyStat[];
   provider_stats: ProviderStat[];
+  lane_depths?: LaneDepth[];
 }
 
 export interface HourlyStat {
@@ -28,6 +29,12 @@ export interface ProviderStat {
   failed: number;
 }
 
+export interface LaneDepth {
+  priority: 'high' | 'normal' | 'low';
+  queued: number;
+  processing: number;
+}
+
 export interface RateLimitsResponse {
   workspace_limits: WorkspaceLimit[];
   user_limits: UserLimit[];
@@ -0,0 +1,28 @@
+import useSWR from 'swr';
+import { api } from './network';
+
+export interface Worker {
+  id: string;
+  hostname: string;
+  pid: number;
+  started_at: string;
+  last_heartbeat_at: string;
+  heartbeat_interval_seconds: number;
+  stopped_at?: string;
+  status: 'active' | 'unresponsive' | 'stopped';
+  held_messages: string[];
+}
+
+export interface WorkersResponse {
+  workers: Worker[];
+  active: number;
+  total: number;
+}
+
+// Worker registry: relay replicas sharing the queue and the messages each one holds
+export function useWorkers() {
+  return useSWR<WorkersResponse>('/workers', api.get, {
+    refreshInterval: 15000,
+    revalidateOnFocus: true,
+  });
+}
@@ -3,7 +3,11 @@
 export interface ApiStats {
   total: number;
   statusCounts: Array<{
-    Status: 'queued' | 'processing' | 'sent' | 'failed' | 'auth_error';
+    Status: 'queued' | 'processing' | 'sent' | 'failed' | 'auth_error' | 'dead';
+    Count: number;
+  }>;
+  laneCounts?: Array<{
+    Priority: 'high' | 'normal' | 'low';
     Count: number;
   }>;
 }
@@ -16,7 +20,7 @@ export interface Message {
   cc_emails?: string[];
   bcc_emails?: string[];
   subject?: string;
-  status: 'queued' | 'processing' | 'sent' | 'failed' | 'auth_error';
+  status: 'queued' | 'processing' | 'sent' | 'failed' | 'auth_error' | 'dead';
   provider?: string;
   created_at: string;
   sent_at?: string;
@@ -28,6 +32,8 @@ export interface Message {
   campaign_id?: string;
   user_id?: string;
   retry_count?: number;
+  locked_by?: string;
+  priority?: 'high' | 'normal' | 'low';
 }
 
 export interface WorkspaceRateLimit {
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"relay/internal/retention"
+
+	"github.com/gorilla/mux"
+)
+
+// ArchiveAPI reads messages back from the retention archive, restores them for
+// investigation and runs the retention job on demand
+type ArchiveAPI struct {
+	retention *retention.Service
+}
+
+func NewArchiveAPI(service *retention.Service) *ArchiveAPI {
+	return &ArchiveAPI{retention: service}
+}
+
+func (api *ArchiveAPI) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/archive/messages/{id}", api.GetArchivedMessage).Methods("GET")
+	router.HandleFunc("/api/archive/messages/{id}/restore", api.RestoreMessage).Methods("POST")
+	router.HandleFunc("/api/retention/run", api.RunRetention).Methods("POST")
+}
+
+// GetArchivedMessage returns the archived copy of a message with its content
+// and attempt history, without restoring it
+func (api *ArchiveAPI) GetArchivedMessage(w http.ResponseWriter, r *http.Request) {
+	record, err := api.retention.Find(mux.Vars(r)["id"])
+	if errors.Is(err, retention.ErrNotArchived) {
+		http.Error(w, "Archived message not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+// RestoreMessage puts an archived message back into the queue, where the
+// message and dead letter APIs can inspect it. It is not sent again.
+func (api *ArchiveAPI) RestoreMessage(w http.ResponseWriter, r *http.Request) {
+	msg, err := api.retention.Restore(mux.Vars(r)["id"])
+	if errors.Is(err, retention.ErrNotArchived) {
+		http.Error(w, "Archived message not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error: Failed to restore archived message: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":          msg.ID,
+		"status":      msg.Status,
+		"restored_at": msg.RestoredAt,
+	})
+}
+
+// RunRetention runs the retention job now instead of waiting for its interval
+func (api *ArchiveAPI) RunRetention(w http.ResponseWriter, r *http.Request) {
+	result, err := api.retention.Run()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
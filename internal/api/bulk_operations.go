@@ -0,0 +1,227 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"relay/internal/database"
+	"relay/internal/queue"
+	"relay/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+// BulkOperationsAPI cancels, requeues, reschedules or deletes every message
+// matching a filter, for stopping a campaign that went out wrong. Each
+// operation that changes messages is recorded in the bulk_operations table
+// when a database is available, and in the log otherwise.
+type BulkOperationsAPI struct {
+	queue queue.BulkManager
+	db    *sql.DB
+}
+
+func NewBulkOperationsAPI(manager queue.BulkManager, db *sql.DB) *BulkOperationsAPI {
+	return &BulkOperationsAPI{queue: manager, db: db}
+}
+
+// BulkOperationFilter selects messages; at least one field other than statuses is required
+type BulkOperationFilter struct {
+	Statuses             []models.MessageStatus `json:"statuses,omitempty"`
+	WorkspaceID          string                 `json:"workspace_id,omitempty"`
+	From                 string                 `json:"from,omitempty"`
+	EmailType            string                 `json:"email_type,omitempty"`
+	InvitationDispatchID string                 `json:"invitation_dispatch_id,omitempty"`
+	Tag                  string                 `json:"tag,omitempty"`
+	Subject              string                 `json:"subject,omitempty"`
+	QueuedAfter          *time.Time             `json:"queued_after,omitempty"`
+	QueuedBefore         *time.Time             `json:"queued_before,omitempty"`
+}
+
+type BulkOperationRequest struct {
+	Action queue.BulkAction    `json:"action"`
+	Filter BulkOperationFilter `json:"filter"`
+	SendAt *time.Time          `json:"send_at,omitempty"` // Required by reschedule
+	DryRun bool                `json:"dry_run"`
+	Actor  string              `json:"actor"` // Who is doing it; required unless dry_run
+	Reason string              `json:"reason,omitempty"`
+}
+
+// BulkOperation is the audit record of an applied bulk operation
+type BulkOperation struct {
+	ID         int64               `json:"id"`
+	Action     queue.BulkAction    `json:"action"`
+	Filter     BulkOperationFilter `json:"filter"`
+	SendAt     *time.Time          `json:"send_at,omitempty"`
+	Actor      string              `json:"actor"`
+	Reason     string              `json:"reason,omitempty"`
+	RemoteAddr string              `json:"remote_addr,omitempty"`
+	Affected   int                 `json:"affected"`
+	CreatedAt  time.Time           `json:"created_at"`
+}
+
+func (api *BulkOperationsAPI) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/bulk-operations", api.ApplyBulkOperation).Methods("POST")
+	router.HandleFunc("/api/bulk-operations", api.ListBulkOperations).Methods("GET")
+}
+
+// ApplyBulkOperation counts the matching messages when dry_run is set and
+// applies the action otherwise
+func (api *BulkOperationsAPI) ApplyBulkOperation(w http.ResponseWriter, r *http.Request) {
+	var req BulkOperationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Action {
+	case queue.BulkCancel, queue.BulkRequeue, queue.BulkDelete:
+	case queue.BulkReschedule:
+		if req.SendAt == nil {
+			http.Error(w, "send_at is required to reschedule", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "action must be cancel, requeue, reschedule or delete", http.StatusBadRequest)
+		return
+	}
+	f := req.Filter
+	if f.WorkspaceID == "" && f.From == "" && f.EmailType == "" && f.InvitationDispatchID == "" &&
+		f.Tag == "" && f.Subject == "" && f.QueuedAfter == nil && f.QueuedBefore == nil {
+		http.Error(w, "filter must select messages by at least one field other than statuses", http.StatusBadRequest)
+		return
+	}
+	if !req.DryRun && req.Actor == "" {
+		http.Error(w, "actor is required", http.StatusBadRequest)
+		return
+	}
+
+	filter := queue.BulkFilter{
+		Statuses:             f.Statuses,
+		WorkspaceID:          f.WorkspaceID,
+		From:                 f.From,
+		EmailType:            f.EmailType,
+		InvitationDispatchID: f.InvitationDispatchID,
+		Tag:                  f.Tag,
+		Subject:              f.Subject,
+		QueuedAfter:          f.QueuedAfter,
+		QueuedBefore:         f.QueuedBefore,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if req.DryRun {
+		matched, err := api.queue.CountBulk(req.Action, filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"action":  req.Action,
+			"dry_run": true,
+			"matched": matched,
+		})
+		return
+	}
+
+	var sendAt time.Time
+	if req.SendAt != nil {
+		sendAt = *req.SendAt
+	}
+	affected, err := api.queue.ApplyBulk(req.Action, filter, sendAt)
+	op := BulkOperation{
+		Action:     req.Action,
+		Filter:     f,
+		SendAt:     req.SendAt,
+		Actor:      req.Actor,
+		Reason:     req.Reason,
+		RemoteAddr: r.RemoteAddr,
+		Affected:   affected,
+		CreatedAt:  time.Now(),
+	}
+	if err != nil {
+		// Backends that change messages one at a time may stop part way
+		log.Printf("Error: Bulk %s by %s failed after %d messages: %v", req.Action, req.Actor, affected, err)
+		if affected > 0 {
+			api.audit(&op)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	api.audit(&op)
+
+	json.NewEncoder(w).Encode(op)
+}
+
+// audit records an applied operation. The messages have already changed, so a
+// failure to record it is logged rather than returned.
+func (api *BulkOperationsAPI) audit(op *BulkOperation) {
+	filterJSON, _ := json.Marshal(op.Filter)
+	log.Printf("Bulk %s by %s changed %d messages (filter %s, reason %q)", op.Action, op.Actor, op.Affected, filterJSON, op.Reason)
+
+	if api.db == nil {
+		return
+	}
+	id, err := database.For(api.db).InsertID(api.db, `
+		INSERT INTO bulk_operations (action, filter, send_at, actor, reason, remote_addr, affected, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, string(op.Action), string(filterJSON), op.SendAt, op.Actor, op.Reason, op.RemoteAddr, op.Affected, op.CreatedAt)
+	if err != nil {
+		log.Printf("Error: Failed to record bulk operation audit: %v", err)
+		return
+	}
+	op.ID = id
+}
+
+// ListBulkOperations returns the audit records of applied operations, newest first
+func (api *BulkOperationsAPI) ListBulkOperations(w http.ResponseWriter, r *http.Request) {
+	if api.db == nil {
+		http.Error(w, "Bulk operation audit requires a database", http.StatusNotImplemented)
+		return
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	rows, err := api.db.Query(`
+		SELECT id, action, filter, send_at, actor, reason, remote_addr, affected, created_at
+		FROM bulk_operations
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		log.Printf("Error fetching bulk operations: %v", err)
+		http.Error(w, "Failed to fetch bulk operations", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	operations := []BulkOperation{}
+	for rows.Next() {
+		var op BulkOperation
+		var filterJSON string
+		var sendAt sql.NullTime
+		var reason, remoteAddr sql.NullString
+		if err := rows.Scan(&op.ID, &op.Action, &filterJSON, &sendAt, &op.Actor, &reason, &remoteAddr, &op.Affected, &op.CreatedAt); err != nil {
+			log.Printf("Error scanning bulk operation: %v", err)
+			continue
+		}
+		json.Unmarshal([]byte(filterJSON), &op.Filter)
+		if sendAt.Valid {
+			op.SendAt = &sendAt.Time
+		}
+		op.Reason = reason.String
+		op.RemoteAddr = remoteAddr.String
+		operations = append(operations, op)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"operations": operations,
+	})
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"relay/internal/queue"
+	"relay/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+// DeadLetterStore is the queue backend behind DeadLettersAPI
+type DeadLetterStore interface {
+	queue.DeadLetterQueue
+	Get(id string) (*models.Message, error)
+}
+
+// DeadLettersAPI lists, inspects, replays and discards messages in the dead status
+type DeadLettersAPI struct {
+	store DeadLetterStore
+}
+
+func NewDeadLettersAPI(store DeadLetterStore) *DeadLettersAPI {
+	return &DeadLettersAPI{store: store}
+}
+
+type DeadLetter struct {
+	ID         string           `json:"id"`
+	FromEmail  string           `json:"from_email"`
+	ToEmails   []string         `json:"to_emails"`
+	Subject    string           `json:"subject"`
+	ProviderID string           `json:"provider_id,omitempty"` // Workspace
+	SentVia    string           `json:"sent_via,omitempty"`    // Provider of the last attempt
+	Error      string           `json:"error"`
+	Attempts   int              `json:"attempts"`
+	QueuedAt   time.Time        `json:"queued_at"`
+	FailedAt   *time.Time       `json:"failed_at,omitempty"`
+	History    []models.Attempt `json:"history,omitempty"`
+}
+
+// DeadLetterBulkRequest selects dead letters by ID; ProviderID is only used by replay
+type DeadLetterBulkRequest struct {
+	IDs        []string `json:"ids"`
+	ProviderID string   `json:"provider_id,omitempty"`
+}
+
+func (api *DeadLettersAPI) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/dead-letters", api.ListDeadLetters).Methods("GET")
+	router.HandleFunc("/api/dead-letters/replay", api.ReplayDeadLetters).Methods("POST")
+	router.HandleFunc("/api/dead-letters/discard", api.DiscardDeadLetters).Methods("POST")
+	router.HandleFunc("/api/dead-letters/{id}", api.GetDeadLetter).Methods("GET")
+	router.HandleFunc("/api/dead-letters/{id}", api.DiscardDeadLetter).Methods("DELETE")
+}
+
+func (api *DeadLettersAPI) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	filter := queue.DeadLetterFilter{
+		ProviderID: r.URL.Query().Get("provider_id"),
+		From:       r.URL.Query().Get("from"),
+		Limit:      100,
+	}
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 1000 {
+		filter.Limit = l
+	}
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && o >= 0 {
+		filter.Offset = o
+	}
+
+	messages, total, err := api.store.ListDead(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deadLetters := make([]DeadLetter, 0, len(messages))
+	for _, msg := range messages {
+		deadLetters = append(deadLetters, deadLetter(msg))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages": deadLetters,
+		"total":    total,
+		"limit":    filter.Limit,
+		"offset":   filter.Offset,
+	})
+}
+
+// GetDeadLetter returns a dead letter with its full attempt history
+func (api *DeadLettersAPI) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	msg, err := api.store.Get(id)
+	if err != nil || msg == nil || msg.Status != models.StatusDead {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+
+	attempts, err := api.store.GetAttempts(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := deadLetter(msg)
+	result.History = attempts
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ReplayDeadLetters requeues dead letters with a fresh retry budget,
+// optionally pinned to provider_id
+func (api *DeadLettersAPI) ReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeDeadLetterBulkRequest(w, r)
+	if !ok {
+		return
+	}
+
+	replayed, err := api.store.Replay(req.IDs, req.ProviderID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "Dead letters queued for replay",
+		"requested":   len(req.IDs),
+		"replayed":    replayed,
+		"provider_id": req.ProviderID,
+	})
+}
+
+func (api *DeadLettersAPI) DiscardDeadLetters(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeDeadLetterBulkRequest(w, r)
+	if !ok {
+		return
+	}
+
+	discarded, err := api.store.Discard(req.IDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "Dead letters discarded",
+		"requested": len(req.IDs),
+		"discarded": discarded,
+	})
+}
+
+func (api *DeadLettersAPI) DiscardDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	discarded, err := api.store.Discard([]string{id})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if discarded == 0 {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "Dead letter discarded",
+		"id":     id,
+	})
+}
+
+func decodeDeadLetterBulkRequest(w http.ResponseWriter, r *http.Request) (DeadLetterBulkRequest, bool) {
+	var req DeadLetterBulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	if len(req.IDs) == 0 {
+		http.Error(w, "ids is required", http.StatusBadRequest)
+		return req, false
+	}
+	if len(req.IDs) > 1000 {
+		http.Error(w, "at most 1000 ids per request", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func deadLetter(msg *models.Message) DeadLetter {
+	return DeadLetter{
+		ID:         msg.ID,
+		FromEmail:  msg.From,
+		ToEmails:   msg.To,
+		Subject:    msg.Subject,
+		ProviderID: msg.ProviderID,
+		SentVia:    msg.SentVia,
+		Error:      msg.Error,
+		Attempts:   msg.RetryCount,
+		QueuedAt:   msg.QueuedAt,
+		FailedAt:   msg.ProcessedAt,
+	}
+}
@@ -0,0 +1,755 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"relay/internal/blobstore"
+	"relay/internal/mimeparser"
+	"relay/internal/queue"
+	"relay/internal/smtp"
+	"relay/internal/validation"
+	"relay/internal/workspace"
+	"relay/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+// mandrillTimeFormat is the UTC timestamp format used throughout the Mandrill API
+const mandrillTimeFormat = "2006-01-02 15:04:05"
+
+// errQueueFailed marks errors caused by the queue rather than by the request
+var errQueueFailed = errors.New("failed to queue message")
+
+// MandrillAPI implements the Mandrill REST endpoints our services call directly,
+// so existing Mandrill clients can be pointed at the relay without code changes.
+// Messages are queued through the same intake rules as SMTP.
+type MandrillAPI struct {
+	db               *sql.DB
+	queue            queue.Queue
+	workspaceManager *workspace.Manager
+	keys             map[string]bool
+	blobs            blobstore.Store // Serves attachment content the queue externalized
+	dedupWindow      time.Duration   // How long retried requests return the original message ID
+}
+
+func NewMandrillAPI(db *sql.DB, q queue.Queue, workspaceManager *workspace.Manager, keys []string) *MandrillAPI {
+	keySet := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		keySet[key] = true
+	}
+	return &MandrillAPI{
+		db:               db,
+		queue:            q,
+		workspaceManager: workspaceManager,
+		keys:             keySet,
+	}
+}
+
+// SetAttachmentStore sets the store messages/content.json reads externalized attachments from
+func (api *MandrillAPI) SetAttachmentStore(store blobstore.Store) {
+	api.blobs = store
+}
+
+// SetDedupWindow sets how long an Idempotency-Key or Message-ID keeps later
+// copies of a message from being queued; zero turns deduplication off
+func (api *MandrillAPI) SetDedupWindow(window time.Duration) {
+	api.dedupWindow = window
+}
+
+// MandrillRecipient is an entry of message.to
+type MandrillRecipient struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+	Type  string `json:"type,omitempty"` // to, cc or bcc
+}
+
+// MandrillAttachment is an entry of message.attachments or message.images
+type MandrillAttachment struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"` // base64 encoded
+}
+
+type MandrillMergeVar struct {
+	Name    string      `json:"name"`
+	Content interface{} `json:"content"`
+}
+
+type MandrillRecipientMergeVars struct {
+	Rcpt string             `json:"rcpt"`
+	Vars []MandrillMergeVar `json:"vars"`
+}
+
+type MandrillRecipientMetadata struct {
+	Rcpt   string                 `json:"rcpt"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// MandrillMessageRequest is the message object accepted by send.json and send-template.json
+type MandrillMessageRequest struct {
+	HTML               string                       `json:"html"`
+	Text               string                       `json:"text"`
+	Subject            string                       `json:"subject"`
+	FromEmail          string                       `json:"from_email"`
+	FromName           string                       `json:"from_name"`
+	To                 []MandrillRecipient          `json:"to"`
+	Headers            map[string]string            `json:"headers"`
+	Important          bool                         `json:"important"`
+	PreserveRecipients bool                         `json:"preserve_recipients"`
+	Merge              *bool                        `json:"merge"`
+	MergeLanguage      string                       `json:"merge_language"`
+	GlobalMergeVars    []MandrillMergeVar           `json:"global_merge_vars"`
+	MergeVars          []MandrillRecipientMergeVars `json:"merge_vars"`
+	Tags               []string                     `json:"tags"`
+	Metadata           map[string]interface{}       `json:"metadata"`
+	RecipientMetadata  []MandrillRecipientMetadata  `json:"recipient_metadata"`
+	Attachments        []MandrillAttachment         `json:"attachments"`
+	Images             []MandrillAttachment         `json:"images"`
+
+	// template is the slug of the stored template the message was rendered from
+	template string
+	// idempotencyKey is the request's Idempotency-Key header
+	idempotencyKey string
+	// expiresAt is the request's expires_at
+	expiresAt *time.Time
+}
+
+type MandrillSendRequest struct {
+	Key       string                 `json:"key"`
+	Message   MandrillMessageRequest `json:"message"`
+	Async     bool                   `json:"async"`
+	SendAt    string                 `json:"send_at"`
+	ExpiresAt string                 `json:"expires_at"` // Relay extension: not sent after this time
+}
+
+type MandrillSendRawRequest struct {
+	Key        string   `json:"key"`
+	RawMessage string   `json:"raw_message"`
+	FromEmail  string   `json:"from_email"`
+	FromName   string   `json:"from_name"`
+	To         []string `json:"to"`
+	Async      bool     `json:"async"`
+	SendAt     string   `json:"send_at"`
+	ExpiresAt  string   `json:"expires_at"` // Relay extension, as in send.json
+}
+
+type MandrillSendTemplateRequest struct {
+	Key             string                    `json:"key"`
+	TemplateName    string                    `json:"template_name"`
+	TemplateContent []MandrillTemplateContent `json:"template_content"`
+	Message         MandrillMessageRequest    `json:"message"`
+	Async           bool                      `json:"async"`
+	SendAt          string                    `json:"send_at"`
+	ExpiresAt       string                    `json:"expires_at"` // Relay extension, as in send.json
+}
+
+// MandrillSendResult is the per-recipient result returned by the send endpoints
+type MandrillSendResult struct {
+	Email        string  `json:"email"`
+	Status       string  `json:"status"` // queued, scheduled, rejected or invalid
+	RejectReason *string `json:"reject_reason"`
+	ID           string  `json:"_id"`
+}
+
+// MandrillError is Mandrill's error response body
+type MandrillError struct {
+	Status  string `json:"status"`
+	Code    int    `json:"code"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+func (api *MandrillAPI) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/1.0/messages/send.json", api.Send).Methods("POST")
+	router.HandleFunc("/api/1.0/messages/send-raw.json", api.SendRaw).Methods("POST")
+	router.HandleFunc("/api/1.0/messages/send-template.json", api.SendTemplate).Methods("POST")
+	router.HandleFunc("/api/1.0/messages/info.json", api.Info).Methods("POST")
+	router.HandleFunc("/api/1.0/messages/search.json", api.Search).Methods("POST")
+	router.HandleFunc("/api/1.0/messages/content.json", api.Content).Methods("POST")
+	router.HandleFunc("/api/1.0/messages/list-scheduled.json", api.ListScheduled).Methods("POST")
+	router.HandleFunc("/api/1.0/messages/cancel-scheduled.json", api.CancelScheduled).Methods("POST")
+	router.HandleFunc("/api/1.0/messages/reschedule.json", api.Reschedule).Methods("POST")
+
+	router.HandleFunc("/api/1.0/templates/add.json", api.AddTemplate).Methods("POST")
+	router.HandleFunc("/api/1.0/templates/info.json", api.TemplateInfo).Methods("POST")
+	router.HandleFunc("/api/1.0/templates/update.json", api.UpdateTemplate).Methods("POST")
+	router.HandleFunc("/api/1.0/templates/delete.json", api.DeleteTemplate).Methods("POST")
+	router.HandleFunc("/api/1.0/templates/list.json", api.ListTemplates).Methods("POST")
+}
+
+// Send handles messages/send.json
+func (api *MandrillAPI) Send(w http.ResponseWriter, r *http.Request) {
+	var req MandrillSendRequest
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+
+	sendAt, err := parseSendAt(req.SendAt)
+	if err != nil {
+		writeMandrillError(w, -2, "ValidationError", err.Error())
+		return
+	}
+	expiresAt, err := parseExpiresAt(req.ExpiresAt)
+	if err != nil {
+		writeMandrillError(w, -2, "ValidationError", err.Error())
+		return
+	}
+
+	req.Message.idempotencyKey = requestIdempotencyKey(r)
+	req.Message.expiresAt = expiresAt
+	results, err := api.queueMessage(&req.Message, sendAt)
+	if err != nil {
+		writeSendError(w, err)
+		return
+	}
+
+	writeMandrillJSON(w, results)
+}
+
+// SendTemplate handles messages/send-template.json
+func (api *MandrillAPI) SendTemplate(w http.ResponseWriter, r *http.Request) {
+	var req MandrillSendTemplateRequest
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+
+	sendAt, err := parseSendAt(req.SendAt)
+	if err != nil {
+		writeMandrillError(w, -2, "ValidationError", err.Error())
+		return
+	}
+	expiresAt, err := parseExpiresAt(req.ExpiresAt)
+	if err != nil {
+		writeMandrillError(w, -2, "ValidationError", err.Error())
+		return
+	}
+
+	template, err := api.getTemplate(req.TemplateName)
+	if err == sql.ErrNoRows {
+		writeMandrillError(w, 5, "Unknown_Template", fmt.Sprintf("No such template %q", req.TemplateName))
+		return
+	}
+	if err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+
+	template.applyTo(&req.Message, req.TemplateContent)
+
+	req.Message.idempotencyKey = requestIdempotencyKey(r)
+	req.Message.expiresAt = expiresAt
+	results, err := api.queueMessage(&req.Message, sendAt)
+	if err != nil {
+		writeSendError(w, err)
+		return
+	}
+
+	writeMandrillJSON(w, results)
+}
+
+// SendRaw handles messages/send-raw.json. The raw message is parsed by the same
+// MIME intake as SMTP DATA and queued as one message for all recipients.
+func (api *MandrillAPI) SendRaw(w http.ResponseWriter, r *http.Request) {
+	var req MandrillSendRawRequest
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+
+	sendAt, err := parseSendAt(req.SendAt)
+	if err != nil {
+		writeMandrillError(w, -2, "ValidationError", err.Error())
+		return
+	}
+	expiresAt, err := parseExpiresAt(req.ExpiresAt)
+	if err != nil {
+		writeMandrillError(w, -2, "ValidationError", err.Error())
+		return
+	}
+
+	raw := []byte(req.RawMessage)
+	if len(raw) == 0 {
+		writeMandrillError(w, -2, "ValidationError", "raw_message is required")
+		return
+	}
+
+	parsed, err := mimeparser.Parse(raw)
+	if err != nil {
+		writeMandrillError(w, -2, "ValidationError", fmt.Sprintf("invalid raw_message: %v", err))
+		return
+	}
+
+	// Envelope defaults come from the message headers, as with Mandrill
+	from := req.FromEmail
+	if from == "" {
+		if addr, err := mail.ParseAddress(mimeparser.DecodeHeaderValue(parsed.Get("From"))); err == nil {
+			from = addr.Address
+		}
+	}
+	if from == "" {
+		writeMandrillError(w, -2, "ValidationError", "from_email is required when the raw message has no From header")
+		return
+	}
+
+	recipients := req.To
+	if len(recipients) == 0 {
+		for _, header := range []string{"To", "Cc", "Bcc"} {
+			if addresses, err := mail.ParseAddressList(mimeparser.DecodeHeaderValue(parsed.Get(header))); err == nil {
+				for _, addr := range addresses {
+					recipients = append(recipients, addr.Address)
+				}
+			}
+		}
+	}
+
+	var results []MandrillSendResult
+	var valid []string
+	for _, email := range recipients {
+		if err := validation.ValidateEmail(email); err != nil {
+			results = append(results, MandrillSendResult{Email: email, Status: "invalid"})
+			continue
+		}
+		valid = append(valid, email)
+	}
+	if len(valid) == 0 {
+		writeMandrillJSON(w, results)
+		return
+	}
+
+	intake, err := smtp.NewIntakeMessage(api.workspaceManager, from, valid)
+	if err != nil {
+		writeMandrillError(w, -2, "ValidationError", err.Error())
+		return
+	}
+	if err := intake.ParseRaw(raw); err != nil {
+		writeMandrillError(w, -2, "ValidationError", err.Error())
+		return
+	}
+
+	if key := requestIdempotencyKey(r); key != "" {
+		intake.SetIdempotencyKey(key)
+	}
+
+	msg := intake.Message()
+	if req.FromName != "" {
+		msg.Headers["From"] = (&mail.Address{Name: req.FromName, Address: msg.From}).String()
+	}
+
+	groupResults, err := api.enqueue(intake, valid, sendAt, expiresAt)
+	if err != nil {
+		writeSendError(w, err)
+		return
+	}
+
+	writeMandrillJSON(w, append(results, groupResults...))
+}
+
+// queueMessage converts a Mandrill message into queued messages. Unless
+// preserve_recipients is set, every recipient gets its own copy so per-recipient
+// merge vars and metadata can be applied, matching Mandrill's behavior.
+func (api *MandrillAPI) queueMessage(m *MandrillMessageRequest, sendAt *time.Time) ([]MandrillSendResult, error) {
+	if m.FromEmail == "" {
+		return nil, fmt.Errorf("from_email is required")
+	}
+	if len(m.To) == 0 {
+		return nil, fmt.Errorf("at least one recipient is required")
+	}
+	if m.HTML == "" && m.Text == "" {
+		return nil, fmt.Errorf("message must contain either html or text content")
+	}
+
+	var results []MandrillSendResult
+	var valid []MandrillRecipient
+	for _, rcpt := range m.To {
+		if err := validation.ValidateEmail(rcpt.Email); err != nil {
+			results = append(results, MandrillSendResult{Email: rcpt.Email, Status: "invalid"})
+			continue
+		}
+		valid = append(valid, rcpt)
+	}
+
+	var groups [][]MandrillRecipient
+	if m.PreserveRecipients {
+		if len(valid) > 0 {
+			groups = append(groups, valid)
+		}
+	} else {
+		for _, rcpt := range valid {
+			groups = append(groups, []MandrillRecipient{rcpt})
+		}
+	}
+
+	attachments, err := decodeMandrillAttachments(m.Attachments, m.Images)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every copy is built before any is queued, so a request that fails
+	// validation queues nothing rather than reaching some recipients
+	intakes := make([]*smtp.IntakeMessage, 0, len(groups))
+	for _, group := range groups {
+		intake, err := api.buildGroup(m, group, attachments)
+		if err != nil {
+			return nil, err
+		}
+		intakes = append(intakes, intake)
+	}
+
+	for i, intake := range intakes {
+		groupResults, err := api.enqueue(intake, groupEmails(groups[i]), sendAt, m.expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, groupResults...)
+	}
+
+	return results, nil
+}
+
+// groupEmails returns the addresses of a recipient group
+func groupEmails(group []MandrillRecipient) []string {
+	emails := make([]string, 0, len(group))
+	for _, rcpt := range group {
+		emails = append(emails, rcpt.Email)
+	}
+	return emails
+}
+
+// buildGroup builds the message delivered to the given recipients. Each copy
+// gets its own attachment slice, since queueing may externalize it in place.
+func (api *MandrillAPI) buildGroup(m *MandrillMessageRequest, group []MandrillRecipient, attachments []models.Attachment) (*smtp.IntakeMessage, error) {
+	intake, err := smtp.NewIntakeMessage(api.workspaceManager, m.FromEmail, groupEmails(group))
+	if err != nil {
+		return nil, err
+	}
+	msg := intake.Message()
+
+	// Tags and metadata go through the same X-MC-* handling as SMTP intake
+	headers := make(map[string]string, len(m.Headers)+3)
+	for name, value := range m.Headers {
+		headers[name] = value
+	}
+	if len(m.Tags) > 0 {
+		tags, _ := json.Marshal(m.Tags)
+		headers["X-MC-Tags"] = string(tags)
+	}
+	if metadata := recipientMetadata(m, group); len(metadata) > 0 {
+		encoded, _ := json.Marshal(metadata)
+		headers["X-MC-Metadata"] = string(encoded)
+	}
+	if m.Important {
+		headers["X-MC-Important"] = "true"
+	}
+	intake.ApplyHeaders(headers)
+	if m.idempotencyKey != "" {
+		intake.SetIdempotencyKey(m.idempotencyKey)
+	}
+
+	if m.FromName != "" {
+		msg.Headers["From"] = (&mail.Address{Name: m.FromName, Address: msg.From}).String()
+	}
+
+	// Split recipients by type; single-recipient copies always address the recipient directly
+	if len(group) > 1 {
+		msg.To, msg.CC, msg.BCC = nil, nil, nil
+		for _, rcpt := range group {
+			switch strings.ToLower(rcpt.Type) {
+			case "cc":
+				msg.CC = append(msg.CC, rcpt.Email)
+			case "bcc":
+				msg.BCC = append(msg.BCC, rcpt.Email)
+			default:
+				msg.To = append(msg.To, rcpt.Email)
+			}
+		}
+		if len(msg.To) == 0 {
+			// Providers need at least one primary recipient
+			msg.To, msg.CC = msg.CC, nil
+			if len(msg.To) == 0 {
+				msg.To, msg.BCC = msg.BCC, nil
+			}
+		}
+	}
+
+	vars := mergeVarsFor(m, group)
+	if m.Merge == nil || *m.Merge {
+		msg.Subject = renderMergeTags(m.Subject, vars, m.MergeLanguage, false)
+		msg.HTML = renderMergeTags(m.HTML, vars, m.MergeLanguage, true)
+		msg.Text = renderMergeTags(m.Text, vars, m.MergeLanguage, false)
+	} else {
+		msg.Subject, msg.HTML, msg.Text = m.Subject, m.HTML, m.Text
+	}
+
+	if len(attachments) > 0 {
+		msg.Attachments = append([]models.Attachment(nil), attachments...)
+	}
+
+	if m.template != "" {
+		msg.Metadata["template"] = m.template
+	}
+
+	return intake, nil
+}
+
+// enqueue queues the intake's message, or rejects it when the sender has no
+// workspace, and returns per-recipient results. A retry of a message queued
+// under the same idempotency key gets the original message's ID.
+func (api *MandrillAPI) enqueue(intake *smtp.IntakeMessage, recipients []string, sendAt, expiresAt *time.Time) ([]MandrillSendResult, error) {
+	msg := intake.Message()
+	results := make([]MandrillSendResult, 0, len(recipients))
+
+	if msg.ProviderID == "" {
+		// No workspace can send for this domain
+		reason := "unsigned"
+		for _, email := range recipients {
+			results = append(results, MandrillSendResult{Email: email, Status: "rejected", RejectReason: &reason, ID: msg.ID})
+		}
+		log.Printf("Mandrill API rejected message %s: no workspace for sender %s", msg.ID, msg.From)
+		return results, nil
+	}
+
+	if sendAt != nil {
+		msg.SendAt = sendAt
+	}
+	if expiresAt != nil {
+		msg.ExpiresAt = expiresAt
+	}
+	intake.ApplyExpiry()
+	msg.Metadata["source"] = "mandrill_api"
+
+	id, duplicate, err := queue.EnqueueDeduplicated(api.queue, msg, intake.IdempotencyKey(), api.dedupWindow)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errQueueFailed, err)
+	}
+	if duplicate {
+		log.Printf("Mandrill API message %s is a retry of message %s - not queued again", msg.ID, id)
+	} else {
+		log.Printf("Mandrill API queued message %s from %s to %d recipient(s)", msg.ID, msg.From, len(recipients))
+	}
+
+	status := "queued"
+	if msg.SendAt != nil {
+		status = "scheduled"
+	}
+	for _, email := range recipients {
+		results = append(results, MandrillSendResult{Email: email, Status: status, ID: id})
+	}
+	return results, nil
+}
+
+// requestIdempotencyKey returns the request's Idempotency-Key or X-Idempotency-Key header
+func requestIdempotencyKey(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get("Idempotency-Key")); key != "" {
+		return key
+	}
+	return strings.TrimSpace(r.Header.Get("X-Idempotency-Key"))
+}
+
+// decodeRequest decodes a JSON request body, writing a Mandrill error on failure
+func (api *MandrillAPI) decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeMandrillError(w, -2, "ValidationError", fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// checkKey validates the API key, writing a Mandrill Invalid_Key error when it is unknown
+func (api *MandrillAPI) checkKey(w http.ResponseWriter, key string) bool {
+	if key == "" || !api.keys[key] {
+		writeMandrillError(w, -1, "Invalid_Key", "Invalid API key")
+		return false
+	}
+	return true
+}
+
+// parseSendAt parses Mandrill's send_at ("YYYY-MM-DD HH:MM:SS", UTC). Times in
+// the past mean "send now", as in Mandrill.
+func parseSendAt(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	sendAt, err := time.ParseInLocation(mandrillTimeFormat, value, time.UTC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid send_at %q: expected YYYY-MM-DD HH:MM:SS in UTC", value)
+	}
+	if !sendAt.After(time.Now()) {
+		return nil, nil
+	}
+	return &sendAt, nil
+}
+
+// parseExpiresAt parses the expires_at extension, in send_at's format. Unlike
+// send_at a time in the past is an error, since the message could never be sent.
+func parseExpiresAt(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	expiresAt, err := time.ParseInLocation(mandrillTimeFormat, value, time.UTC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expires_at %q: expected YYYY-MM-DD HH:MM:SS in UTC", value)
+	}
+	if !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("expires_at %q is in the past", value)
+	}
+	return &expiresAt, nil
+}
+
+// recipientMetadata merges message metadata with recipient_metadata for single-recipient copies
+func recipientMetadata(m *MandrillMessageRequest, group []MandrillRecipient) map[string]interface{} {
+	metadata := make(map[string]interface{}, len(m.Metadata))
+	for k, v := range m.Metadata {
+		metadata[k] = v
+	}
+	if len(group) == 1 {
+		for _, rm := range m.RecipientMetadata {
+			if strings.EqualFold(rm.Rcpt, group[0].Email) {
+				for k, v := range rm.Values {
+					metadata[k] = v
+				}
+			}
+		}
+	}
+	return metadata
+}
+
+// mergeVarsFor returns global merge vars overridden by the recipient's merge vars.
+// Names are case-insensitive, so keys are upper-cased.
+func mergeVarsFor(m *MandrillMessageRequest, group []MandrillRecipient) map[string]string {
+	vars := make(map[string]string)
+	for _, v := range m.GlobalMergeVars {
+		vars[strings.ToUpper(v.Name)] = mergeVarString(v.Content)
+	}
+	if len(group) == 1 {
+		for _, rv := range m.MergeVars {
+			if strings.EqualFold(rv.Rcpt, group[0].Email) {
+				for _, v := range rv.Vars {
+					vars[strings.ToUpper(v.Name)] = mergeVarString(v.Content)
+				}
+			}
+		}
+	}
+	return vars
+}
+
+func mergeVarString(content interface{}) string {
+	switch v := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64, bool:
+		return fmt.Sprint(v)
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
+
+var (
+	mailchimpMergeTag  = regexp.MustCompile(`\*\|([A-Za-z0-9_]+)\|\*`)
+	handlebarsMergeTag = regexp.MustCompile(`\{\{(\{?)\s*([A-Za-z0-9_]+)\s*\}?\}\}`)
+)
+
+// renderMergeTags replaces simple merge tags (*|NAME|* or {{name}} for the
+// handlebars merge language). Tags without a value render empty, as in Mandrill;
+// conditional and special tags are left untouched.
+func renderMergeTags(content string, vars map[string]string, language string, isHTML bool) string {
+	if content == "" {
+		return content
+	}
+
+	if strings.EqualFold(language, "handlebars") {
+		return handlebarsMergeTag.ReplaceAllStringFunc(content, func(tag string) string {
+			match := handlebarsMergeTag.FindStringSubmatch(tag)
+			value := vars[strings.ToUpper(match[2])]
+			// {{name}} is HTML-escaped, {{{name}}} is inserted as-is
+			if isHTML && match[1] == "" {
+				return html.EscapeString(value)
+			}
+			return value
+		})
+	}
+
+	return mailchimpMergeTag.ReplaceAllStringFunc(content, func(tag string) string {
+		name := mailchimpMergeTag.FindStringSubmatch(tag)[1]
+		return vars[strings.ToUpper(name)]
+	})
+}
+
+// decodeMandrillAttachments decodes base64 attachments; images become inline parts referenced by name
+func decodeMandrillAttachments(attachments, images []MandrillAttachment) ([]models.Attachment, error) {
+	var result []models.Attachment
+	for _, att := range attachments {
+		content, err := base64.StdEncoding.DecodeString(att.Content)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %s is not valid base64: %w", att.Name, err)
+		}
+		result = append(result, models.Attachment{
+			Name:        att.Name,
+			Type:        "attachment",
+			Content:     content,
+			ContentType: att.Type,
+		})
+	}
+	for _, img := range images {
+		content, err := base64.StdEncoding.DecodeString(img.Content)
+		if err != nil {
+			return nil, fmt.Errorf("image %s is not valid base64: %w", img.Name, err)
+		}
+		result = append(result, models.Attachment{
+			Name:        img.Name,
+			Type:        "inline",
+			Content:     content,
+			ContentType: img.Type,
+			ContentID:   img.Name,
+		})
+	}
+	return result, nil
+}
+
+func writeMandrillJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeSendError reports queue failures as GeneralError and everything else as ValidationError
+func writeSendError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errQueueFailed) {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+	writeMandrillError(w, -2, "ValidationError", err.Error())
+}
+
+// writeMandrillError writes an error in Mandrill's format. Mandrill reports all
+// API errors with HTTP 500, and clients rely on the body's name field.
+func writeMandrillError(w http.ResponseWriter, code int, name, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(MandrillError{
+		Status:  "error",
+		Code:    code,
+		Name:    name,
+		Message: message,
+	})
+}
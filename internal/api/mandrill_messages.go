@@ -0,0 +1,625 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"relay/internal/blobstore"
+	"relay/internal/database"
+)
+
+// MandrillMessageInfo is the response shape of messages/info.json and the
+// entries of messages/search.json
+type MandrillMessageInfo struct {
+	TS           int64                  `json:"ts"`
+	ID           string                 `json:"_id"`
+	Sender       string                 `json:"sender"`
+	Template     *string                `json:"template"`
+	Subject      string                 `json:"subject"`
+	Email        string                 `json:"email"`
+	Tags         []string               `json:"tags"`
+	Opens        int                    `json:"opens"`
+	OpensDetail  []MandrillOpenDetail   `json:"opens_detail"`
+	Clicks       int                    `json:"clicks"`
+	ClicksDetail []MandrillClickDetail  `json:"clicks_detail"`
+	State        string                 `json:"state"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	SMTPEvents   []MandrillSMTPEvent    `json:"smtp_events"`
+}
+
+type MandrillOpenDetail struct {
+	TS       int64   `json:"ts"`
+	IP       string  `json:"ip"`
+	Location *string `json:"location"`
+	UA       string  `json:"ua"`
+}
+
+type MandrillClickDetail struct {
+	TS       int64   `json:"ts"`
+	URL      string  `json:"url"`
+	IP       string  `json:"ip"`
+	Location *string `json:"location"`
+	UA       string  `json:"ua"`
+}
+
+type MandrillSMTPEvent struct {
+	TS   int64  `json:"ts"`
+	Type string `json:"type"`
+	Diag string `json:"diag"`
+}
+
+// MandrillMessageContent is the response shape of messages/content.json
+type MandrillMessageContent struct {
+	TS          int64                       `json:"ts"`
+	ID          string                      `json:"_id"`
+	FromEmail   string                      `json:"from_email"`
+	FromName    string                      `json:"from_name"`
+	Subject     string                      `json:"subject"`
+	To          MandrillContentRecipient    `json:"to"`
+	Tags        []string                    `json:"tags"`
+	Headers     map[string]string           `json:"headers"`
+	Text        string                      `json:"text"`
+	HTML        string                      `json:"html"`
+	Attachments []MandrillContentAttachment `json:"attachments"`
+}
+
+type MandrillContentRecipient struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type MandrillContentAttachment struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Binary  bool   `json:"binary"`
+}
+
+type mandrillMessageLookup struct {
+	Key string `json:"key"`
+	ID  string `json:"id"`
+}
+
+type mandrillSearchRequest struct {
+	Key      string   `json:"key"`
+	Query    string   `json:"query"`
+	DateFrom string   `json:"date_from"`
+	DateTo   string   `json:"date_to"`
+	Tags     []string `json:"tags"`
+	Senders  []string `json:"senders"`
+	Limit    int      `json:"limit"`
+}
+
+// messageRecipientRow is one message joined with one of its recipients
+type messageRecipientRow struct {
+	messageID     string
+	fromEmail     string
+	toEmails      sql.NullString
+	subject       sql.NullString
+	metadata      sql.NullString
+	status        string
+	queuedAt      time.Time
+	sendAt        sql.NullTime
+	sentAt        sql.NullTime
+	errorMsg      sql.NullString
+	recipientRow  sql.NullInt64
+	email         sql.NullString
+	delivery      sql.NullString
+	recipientSent sql.NullTime
+	bounceReason  sql.NullString
+	opens         sql.NullInt64
+	clicks        sql.NullInt64
+}
+
+const messageRecipientSelect = `
+		SELECT m.id, m.from_email, m.to_emails, m.subject, m.metadata, m.status,
+			m.queued_at, m.send_at, m.sent_at, m.error,
+			mr.id, r.email_address, mr.delivery_status, mr.sent_at, mr.bounce_reason, mr.opens, mr.clicks
+		FROM messages m
+		LEFT JOIN message_recipients mr ON mr.message_id = m.id
+		LEFT JOIN recipients r ON r.id = mr.recipient_id
+`
+
+// Info handles messages/info.json. Messages with several recipients report the
+// first primary recipient, since Mandrill IDs identify a single recipient.
+func (api *MandrillAPI) Info(w http.ResponseWriter, r *http.Request) {
+	var req mandrillMessageLookup
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+	if api.db == nil {
+		writeMandrillError(w, -1, "GeneralError", "message info requires a database connection")
+		return
+	}
+
+	rows, err := api.queryMessageRecipients(`WHERE m.id = ?
+		ORDER BY CASE mr.recipient_type WHEN 'TO' THEN 0 WHEN 'CC' THEN 1 ELSE 2 END, mr.id
+		LIMIT 1`, req.ID)
+	if err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		writeMandrillError(w, 11, "Unknown_Message", fmt.Sprintf("No message exists with the id '%s'", req.ID))
+		return
+	}
+
+	infos, err := api.buildMessageInfos(rows)
+	if err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+
+	writeMandrillJSON(w, infos[0])
+}
+
+// Search handles messages/search.json, returning one entry per message recipient
+func (api *MandrillAPI) Search(w http.ResponseWriter, r *http.Request) {
+	var req mandrillSearchRequest
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+	if api.db == nil {
+		writeMandrillError(w, -1, "GeneralError", "message search requires a database connection")
+		return
+	}
+
+	where, args, err := buildSearchFilter(database.For(api.db), &req)
+	if err != nil {
+		writeMandrillError(w, -2, "ValidationError", err.Error())
+		return
+	}
+
+	limit := 100
+	if req.Limit > 0 && req.Limit <= 1000 {
+		limit = req.Limit
+	}
+	args = append(args, limit)
+
+	rows, err := api.queryMessageRecipients(`WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY m.queued_at DESC, mr.id
+		LIMIT ?`, args...)
+	if err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+
+	infos, err := api.buildMessageInfos(rows)
+	if err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+
+	writeMandrillJSON(w, infos)
+}
+
+// Content handles messages/content.json
+func (api *MandrillAPI) Content(w http.ResponseWriter, r *http.Request) {
+	var req mandrillMessageLookup
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+
+	msg, err := api.queue.Get(req.ID)
+	if err != nil || msg == nil {
+		writeMandrillError(w, 11, "Unknown_Message", fmt.Sprintf("No message exists with the id '%s'", req.ID))
+		return
+	}
+
+	content := MandrillMessageContent{
+		TS:          msg.QueuedAt.Unix(),
+		ID:          msg.ID,
+		FromEmail:   msg.From,
+		Subject:     msg.Subject,
+		Tags:        metadataTags(msg.Metadata),
+		Headers:     msg.Headers,
+		Text:        msg.Text,
+		HTML:        msg.HTML,
+		Attachments: []MandrillContentAttachment{},
+	}
+	if content.Headers == nil {
+		content.Headers = map[string]string{}
+	}
+	if from, ok := msg.Headers["From"]; ok {
+		if addr, err := mail.ParseAddress(from); err == nil {
+			content.FromName = addr.Name
+		}
+	}
+	if len(msg.To) > 0 {
+		content.To.Email = msg.To[0]
+	}
+
+	for _, att := range msg.Attachments {
+		data, err := blobstore.ReadAll(r.Context(), api.blobs, att)
+		if err != nil {
+			writeMandrillError(w, -1, "GeneralError", err.Error())
+			return
+		}
+		content.Attachments = append(content.Attachments, MandrillContentAttachment{
+			Name:    att.Name,
+			Type:    att.ContentType,
+			Content: base64.StdEncoding.EncodeToString(data),
+			Binary:  !strings.HasPrefix(att.ContentType, "text/"),
+		})
+	}
+
+	writeMandrillJSON(w, content)
+}
+
+// queryMessageRecipients runs messageRecipientSelect with the given WHERE/ORDER clause
+func (api *MandrillAPI) queryMessageRecipients(clause string, args ...interface{}) ([]messageRecipientRow, error) {
+	rows, err := api.db.Query(messageRecipientSelect+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []messageRecipientRow
+	for rows.Next() {
+		var row messageRecipientRow
+		err := rows.Scan(
+			&row.messageID, &row.fromEmail, &row.toEmails, &row.subject, &row.metadata, &row.status,
+			&row.queuedAt, &row.sendAt, &row.sentAt, &row.errorMsg,
+			&row.recipientRow, &row.email, &row.delivery, &row.recipientSent, &row.bounceReason, &row.opens, &row.clicks,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// buildMessageInfos converts rows to Mandrill message info, loading engagement details in one query
+func (api *MandrillAPI) buildMessageInfos(rows []messageRecipientRow) ([]MandrillMessageInfo, error) {
+	var recipientIDs []interface{}
+	for _, row := range rows {
+		if row.recipientRow.Valid {
+			recipientIDs = append(recipientIDs, row.recipientRow.Int64)
+		}
+	}
+
+	events, err := api.loadRecipientEvents(recipientIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]MandrillMessageInfo, 0, len(rows))
+	for _, row := range rows {
+		info := MandrillMessageInfo{
+			TS:           row.queuedAt.Unix(),
+			ID:           row.messageID,
+			Sender:       row.fromEmail,
+			Subject:      row.subject.String,
+			Email:        row.email.String,
+			Tags:         []string{},
+			OpensDetail:  []MandrillOpenDetail{},
+			ClicksDetail: []MandrillClickDetail{},
+			Metadata:     map[string]interface{}{},
+			SMTPEvents:   []MandrillSMTPEvent{},
+		}
+
+		if !row.email.Valid && row.toEmails.Valid {
+			// Recipient tracking rows are missing; fall back to the message's first recipient
+			var to []string
+			json.Unmarshal([]byte(row.toEmails.String), &to)
+			if len(to) > 0 {
+				info.Email = to[0]
+			}
+		}
+
+		var metadata map[string]interface{}
+		if row.metadata.Valid {
+			json.Unmarshal([]byte(row.metadata.String), &metadata)
+		}
+		info.Tags = metadataTags(metadata)
+		if mc, ok := metadata["mc_metadata"].(map[string]interface{}); ok {
+			info.Metadata = mc
+		}
+		if template, ok := metadata["template"].(string); ok && template != "" {
+			info.Template = &template
+		}
+
+		info.Opens = int(row.opens.Int64)
+		info.Clicks = int(row.clicks.Int64)
+
+		var complaint, unsubscribed bool
+		for _, event := range events[row.recipientRow.Int64] {
+			switch event.eventType {
+			case "OPEN":
+				info.OpensDetail = append(info.OpensDetail, MandrillOpenDetail{
+					TS: event.createdAt.Unix(), IP: event.ip.String, UA: event.userAgent.String,
+				})
+			case "CLICK":
+				info.ClicksDetail = append(info.ClicksDetail, MandrillClickDetail{
+					TS: event.createdAt.Unix(), URL: event.url, IP: event.ip.String, UA: event.userAgent.String,
+				})
+			case "COMPLAINT":
+				complaint = true
+			case "UNSUBSCRIBE":
+				unsubscribed = true
+			}
+		}
+
+		info.State = mandrillState(row)
+		if complaint {
+			info.State = "spam"
+		} else if unsubscribed {
+			info.State = "unsub"
+		}
+		info.SMTPEvents = smtpEvents(row)
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// recipientEvent is an engagement event from recipient_events
+type recipientEvent struct {
+	eventType string
+	url       string
+	ip        sql.NullString
+	userAgent sql.NullString
+	createdAt time.Time
+}
+
+// loadRecipientEvents loads engagement events keyed by message_recipients.id
+func (api *MandrillAPI) loadRecipientEvents(recipientIDs []interface{}) (map[int64][]recipientEvent, error) {
+	events := make(map[int64][]recipientEvent)
+	if len(recipientIDs) == 0 {
+		return events, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(recipientIDs)), ",")
+	rows, err := api.db.Query(`
+		SELECT message_recipient_id, event_type, event_data, ip_address, user_agent, created_at
+		FROM recipient_events
+		WHERE message_recipient_id IN (`+placeholders+`)
+		ORDER BY created_at ASC
+	`, recipientIDs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var event recipientEvent
+		var eventData sql.NullString
+		if err := rows.Scan(&id, &event.eventType, &eventData, &event.ip, &event.userAgent, &event.createdAt); err != nil {
+			return nil, err
+		}
+		if eventData.Valid {
+			var data map[string]interface{}
+			if json.Unmarshal([]byte(eventData.String), &data) == nil {
+				event.url, _ = data["url"].(string)
+			}
+		}
+		events[id] = append(events[id], event)
+	}
+	return events, rows.Err()
+}
+
+// mandrillState maps recipient delivery status, falling back to the message status
+func mandrillState(row messageRecipientRow) string {
+	switch row.delivery.String {
+	case "SENT":
+		return "sent"
+	case "BOUNCED":
+		return "bounced"
+	case "FAILED":
+		return "rejected"
+	case "DEFERRED":
+		return "deferred"
+	}
+
+	switch row.status {
+	case "sent":
+		return "sent"
+	case "dead", "cancelled", "expired":
+		return "rejected"
+	case "failed", "auth_error":
+		// failed messages are waiting for a retry
+		return "deferred"
+	}
+
+	if row.sendAt.Valid && row.sendAt.Time.After(time.Now()) {
+		return "scheduled"
+	}
+	return "queued"
+}
+
+// smtpEvents reconstructs the SMTP events Mandrill reports from the stored delivery outcome
+func smtpEvents(row messageRecipientRow) []MandrillSMTPEvent {
+	events := []MandrillSMTPEvent{}
+
+	sentAt := row.recipientSent
+	if !sentAt.Valid {
+		sentAt = row.sentAt
+	}
+
+	switch mandrillState(row) {
+	case "sent":
+		if sentAt.Valid {
+			events = append(events, MandrillSMTPEvent{TS: sentAt.Time.Unix(), Type: "sent", Diag: "250 OK"})
+		}
+	case "bounced", "rejected":
+		diag := row.bounceReason.String
+		if diag == "" {
+			diag = row.errorMsg.String
+		}
+		ts := row.queuedAt
+		if sentAt.Valid {
+			ts = sentAt.Time
+		}
+		events = append(events, MandrillSMTPEvent{TS: ts.Unix(), Type: "bounced", Diag: diag})
+	case "deferred":
+		events = append(events, MandrillSMTPEvent{TS: row.queuedAt.Unix(), Type: "deferred", Diag: row.errorMsg.String})
+	}
+
+	return events
+}
+
+// metadataTags returns the X-MC-Tags stored in message metadata
+func metadataTags(metadata map[string]interface{}) []string {
+	tags := []string{}
+	if raw, ok := metadata["tags"].([]interface{}); ok {
+		for _, tag := range raw {
+			if s, ok := tag.(string); ok {
+				tags = append(tags, s)
+			}
+		}
+	} else if raw, ok := metadata["tags"].([]string); ok {
+		tags = append(tags, raw...)
+	}
+	return tags
+}
+
+var metadataFieldName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// buildSearchFilter translates a Mandrill search request into SQL conditions.
+// Supported query terms: field:value for email, full_email, sender, subject,
+// tags and u_<metadata field>; bare words match subject, sender or recipients.
+func buildSearchFilter(d database.Dialect, req *mandrillSearchRequest) ([]string, []interface{}, error) {
+	where := []string{"1=1"}
+	args := []interface{}{}
+
+	if req.DateFrom != "" {
+		from, err := parseSearchDate(req.DateFrom)
+		if err != nil {
+			return nil, nil, err
+		}
+		where = append(where, "m.queued_at >= ?")
+		args = append(args, from)
+	}
+	if req.DateTo != "" {
+		to, err := parseSearchDate(req.DateTo)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(req.DateTo) == len("2006-01-02") {
+			to = to.Add(24 * time.Hour) // Whole day is inclusive
+		}
+		where = append(where, "m.queued_at < ?")
+		args = append(args, to)
+	}
+
+	if len(req.Senders) > 0 {
+		where = append(where, "m.from_email IN ("+strings.TrimSuffix(strings.Repeat("?,", len(req.Senders)), ",")+")")
+		for _, sender := range req.Senders {
+			args = append(args, sender)
+		}
+	}
+
+	if len(req.Tags) > 0 {
+		var tagConds []string
+		for _, tag := range req.Tags {
+			tagConds = append(tagConds, d.JSONContains(d.JSONValue("m.metadata", "tags")))
+			args = append(args, tag)
+		}
+		where = append(where, "("+strings.Join(tagConds, " OR ")+")")
+	}
+
+	for _, term := range tokenizeSearchQuery(req.Query) {
+		if term == "*" {
+			continue
+		}
+
+		field, value := "", term
+		if idx := strings.Index(term, ":"); idx > 0 {
+			field, value = strings.ToLower(term[:idx]), term[idx+1:]
+		}
+		pattern := likeContains(value)
+
+		switch {
+		case field == "":
+			where = append(where, "(m.subject LIKE ? ESCAPE '!' OR m.from_email LIKE ? ESCAPE '!' OR m.to_emails LIKE ? ESCAPE '!')")
+			args = append(args, pattern, pattern, pattern)
+		case field == "email":
+			where = append(where, "(r.email_address LIKE ? ESCAPE '!' OR (r.email_address IS NULL AND m.to_emails LIKE ? ESCAPE '!'))")
+			args = append(args, pattern, pattern)
+		case field == "full_email":
+			where = append(where, "r.email_address = ?")
+			args = append(args, strings.ToLower(value))
+		case field == "sender" || field == "from_email":
+			where = append(where, "m.from_email LIKE ? ESCAPE '!'")
+			args = append(args, pattern)
+		case field == "subject":
+			where = append(where, "m.subject LIKE ? ESCAPE '!'")
+			args = append(args, pattern)
+		case field == "tags":
+			where = append(where, d.JSONContains(d.JSONValue("m.metadata", "tags")))
+			args = append(args, value)
+		case strings.HasPrefix(field, "u_"):
+			name := strings.TrimPrefix(field, "u_")
+			if !metadataFieldName.MatchString(name) {
+				return nil, nil, fmt.Errorf("invalid metadata field %q", name)
+			}
+			where = append(where, d.JSONText("m.metadata", "mc_metadata", name)+" = ?")
+			args = append(args, value)
+		default:
+			return nil, nil, fmt.Errorf("unsupported search field %q", field)
+		}
+	}
+
+	return where, args, nil
+}
+
+// likeContains returns a LIKE pattern, used with ESCAPE '!', that matches value
+// anywhere. Wildcards in value match literally. '!' is used rather than a
+// backslash since MySQL and PostgreSQL read backslashes in literals differently.
+func likeContains(value string) string {
+	return "%" + strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value) + "%"
+}
+
+// tokenizeSearchQuery splits a query on whitespace, keeping double-quoted phrases together without their quotes
+func tokenizeSearchQuery(query string) []string {
+	var terms []string
+	var current strings.Builder
+	inQuotes := false
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if current.Len() > 0 {
+				terms = append(terms, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		terms = append(terms, current.String())
+	}
+	return terms
+}
+
+// parseSearchDate accepts Mandrill's YYYY-MM-DD or YYYY-MM-DD HH:MM:SS (UTC)
+func parseSearchDate(value string) (time.Time, error) {
+	for _, layout := range []string{mandrillTimeFormat, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q: expected YYYY-MM-DD or YYYY-MM-DD HH:MM:SS", value)
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"relay/internal/queue"
+	"relay/pkg/models"
+)
+
+// MandrillScheduledMessage is the response shape of the scheduled message endpoints
+type MandrillScheduledMessage struct {
+	ID        string `json:"_id"`
+	CreatedAt string `json:"created_at"`
+	SendAt    string `json:"send_at"`
+	FromEmail string `json:"from_email"`
+	To        string `json:"to"`
+	Subject   string `json:"subject"`
+}
+
+type mandrillScheduledRequest struct {
+	Key    string `json:"key"`
+	ID     string `json:"id"`
+	To     string `json:"to"`
+	SendAt string `json:"send_at"`
+}
+
+// ListScheduled handles messages/list-scheduled.json, optionally filtered by recipient
+func (api *MandrillAPI) ListScheduled(w http.ResponseWriter, r *http.Request) {
+	var req mandrillScheduledRequest
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+	scheduler, ok := api.scheduler(w)
+	if !ok {
+		return
+	}
+
+	messages, err := scheduler.ListScheduled(req.To, 1000)
+	if err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+
+	results := make([]MandrillScheduledMessage, 0, len(messages))
+	for _, msg := range messages {
+		results = append(results, scheduledMessage(msg, *msg.SendAt))
+	}
+
+	writeMandrillJSON(w, results)
+}
+
+// CancelScheduled handles messages/cancel-scheduled.json
+func (api *MandrillAPI) CancelScheduled(w http.ResponseWriter, r *http.Request) {
+	var req mandrillScheduledRequest
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+	scheduler, ok := api.scheduler(w)
+	if !ok {
+		return
+	}
+
+	// Load first so the cancelled message can be returned
+	msg, err := api.queue.Get(req.ID)
+	if err != nil || msg == nil {
+		writeUnknownScheduled(w, req.ID)
+		return
+	}
+
+	if err := scheduler.CancelScheduled(req.ID); err != nil {
+		writeScheduleError(w, req.ID, err)
+		return
+	}
+
+	writeMandrillJSON(w, scheduledMessage(msg, *msg.SendAt))
+}
+
+// Reschedule handles messages/reschedule.json
+func (api *MandrillAPI) Reschedule(w http.ResponseWriter, r *http.Request) {
+	var req mandrillScheduledRequest
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+	scheduler, ok := api.scheduler(w)
+	if !ok {
+		return
+	}
+
+	sendAt, err := parseSendAt(req.SendAt)
+	if err != nil {
+		writeMandrillError(w, -2, "ValidationError", err.Error())
+		return
+	}
+	if sendAt == nil {
+		writeMandrillError(w, -2, "ValidationError", "send_at must be in the future")
+		return
+	}
+
+	if err := scheduler.Reschedule(req.ID, *sendAt); err != nil {
+		writeScheduleError(w, req.ID, err)
+		return
+	}
+
+	msg, err := api.queue.Get(req.ID)
+	if err != nil || msg == nil {
+		writeUnknownScheduled(w, req.ID)
+		return
+	}
+
+	writeMandrillJSON(w, scheduledMessage(msg, *sendAt))
+}
+
+func scheduledMessage(msg *models.Message, sendAt time.Time) MandrillScheduledMessage {
+	return MandrillScheduledMessage{
+		ID:        msg.ID,
+		CreatedAt: msg.QueuedAt.UTC().Format(mandrillTimeFormat),
+		SendAt:    sendAt.UTC().Format(mandrillTimeFormat),
+		FromEmail: msg.From,
+		To:        strings.Join(msg.To, ", "),
+		Subject:   msg.Subject,
+	}
+}
+
+// scheduler returns the queue's scheduling support, writing an error when it has none
+func (api *MandrillAPI) scheduler(w http.ResponseWriter) (queue.Scheduler, bool) {
+	scheduler, ok := api.queue.(queue.Scheduler)
+	if !ok {
+		writeMandrillError(w, -1, "GeneralError", "the configured queue does not support scheduled messages")
+	}
+	return scheduler, ok
+}
+
+func writeScheduleError(w http.ResponseWriter, id string, err error) {
+	if errors.Is(err, queue.ErrNotScheduled) {
+		writeUnknownScheduled(w, id)
+		return
+	}
+	writeMandrillError(w, -1, "GeneralError", err.Error())
+}
+
+func writeUnknownScheduled(w http.ResponseWriter, id string) {
+	writeMandrillError(w, 11, "Unknown_Message", fmt.Sprintf("No scheduled message exists with the id '%s'", id))
+}
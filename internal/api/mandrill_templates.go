@@ -0,0 +1,385 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MandrillTemplateContent fills an mc:edit region of a template
+type MandrillTemplateContent struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// MandrillTemplate is a stored template in Mandrill's response shape. Templates
+// are not versioned, so the publish_* fields mirror the current content.
+type MandrillTemplate struct {
+	Slug             string   `json:"slug"`
+	Name             string   `json:"name"`
+	Labels           []string `json:"labels"`
+	Code             string   `json:"code"`
+	Subject          string   `json:"subject"`
+	FromEmail        string   `json:"from_email"`
+	FromName         string   `json:"from_name"`
+	Text             string   `json:"text"`
+	PublishName      string   `json:"publish_name"`
+	PublishCode      string   `json:"publish_code"`
+	PublishSubject   string   `json:"publish_subject"`
+	PublishFromEmail string   `json:"publish_from_email"`
+	PublishFromName  string   `json:"publish_from_name"`
+	PublishText      string   `json:"publish_text"`
+	PublishedAt      string   `json:"published_at"`
+	CreatedAt        string   `json:"created_at"`
+	UpdatedAt        string   `json:"updated_at"`
+}
+
+// mandrillTemplateRequest is the body of templates/add.json and templates/update.json.
+// Pointer fields distinguish "not provided" from empty values on update.
+type mandrillTemplateRequest struct {
+	Key       string    `json:"key"`
+	Name      string    `json:"name"`
+	FromEmail *string   `json:"from_email"`
+	FromName  *string   `json:"from_name"`
+	Subject   *string   `json:"subject"`
+	Code      *string   `json:"code"`
+	Text      *string   `json:"text"`
+	Labels    *[]string `json:"labels"`
+}
+
+type mandrillTemplateLookup struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	Label string `json:"label"`
+}
+
+var slugInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// templateSlug derives the template slug from its name the way Mandrill does
+func templateSlug(name string) string {
+	return strings.Trim(slugInvalidChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+const templateColumns = `slug, name, from_email, from_name, subject, code, text, labels, created_at, updated_at`
+
+// getTemplate loads a template by slug or name
+func (api *MandrillAPI) getTemplate(name string) (*MandrillTemplate, error) {
+	if api.db == nil {
+		return nil, fmt.Errorf("templates require a database connection")
+	}
+
+	row := api.db.QueryRow(`
+		SELECT `+templateColumns+`
+		FROM mandrill_templates
+		WHERE slug = ? OR name = ?
+		LIMIT 1
+	`, templateSlug(name), name)
+
+	return scanTemplate(row)
+}
+
+func scanTemplate(row rowScanner) (*MandrillTemplate, error) {
+	var t MandrillTemplate
+	var fromEmail, fromName, subject, code, text, labels sql.NullString
+	var createdAt, updatedAt time.Time
+
+	if err := row.Scan(&t.Slug, &t.Name, &fromEmail, &fromName, &subject, &code, &text, &labels, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	t.FromEmail = fromEmail.String
+	t.FromName = fromName.String
+	t.Subject = subject.String
+	t.Code = code.String
+	t.Text = text.String
+	t.Labels = []string{}
+	if labels.Valid {
+		json.Unmarshal([]byte(labels.String), &t.Labels)
+	}
+
+	t.PublishName = t.Name
+	t.PublishCode = t.Code
+	t.PublishSubject = t.Subject
+	t.PublishFromEmail = t.FromEmail
+	t.PublishFromName = t.FromName
+	t.PublishText = t.Text
+	t.CreatedAt = createdAt.UTC().Format(mandrillTimeFormat)
+	t.UpdatedAt = updatedAt.UTC().Format(mandrillTimeFormat)
+	t.PublishedAt = t.UpdatedAt
+
+	return &t, nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// applyTo renders the template into the message. Template content fills the
+// mc:edit regions of the template code; message fields take precedence over
+// the template's subject, sender and text.
+func (t *MandrillTemplate) applyTo(m *MandrillMessageRequest, content []MandrillTemplateContent) {
+	code := t.Code
+	for _, c := range content {
+		code = replaceEditableRegion(code, c.Name, c.Content)
+	}
+	m.HTML = code
+	m.template = t.Slug
+
+	if m.Subject == "" {
+		m.Subject = t.Subject
+	}
+	if m.FromEmail == "" {
+		m.FromEmail = t.FromEmail
+	}
+	if m.FromName == "" {
+		m.FromName = t.FromName
+	}
+	if m.Text == "" {
+		m.Text = t.Text
+	}
+}
+
+// replaceEditableRegion replaces the inner content of the element marked mc:edit="name"
+func replaceEditableRegion(code, name, content string) string {
+	openTag := regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9]*)\b[^>]*\bmc:edit=["']` + regexp.QuoteMeta(name) + `["'][^>]*>`)
+	loc := openTag.FindStringSubmatchIndex(code)
+	if loc == nil {
+		return code
+	}
+
+	tag := code[loc[2]:loc[3]]
+	start := loc[1]
+	end := findClosingTag(code[start:], tag)
+	if end < 0 {
+		return code
+	}
+
+	return code[:start] + content + code[start+end:]
+}
+
+// findClosingTag returns the offset of the closing tag matching an already opened element, or -1
+func findClosingTag(s, tag string) int {
+	tags := regexp.MustCompile(`(?i)<(/?)` + regexp.QuoteMeta(tag) + `\b[^>]*>`)
+	depth := 0
+	for _, m := range tags.FindAllStringSubmatchIndex(s, -1) {
+		if m[3] > m[2] {
+			if depth == 0 {
+				return m[0]
+			}
+			depth--
+		} else if !strings.HasSuffix(s[m[0]:m[1]], "/>") {
+			depth++
+		}
+	}
+	return -1
+}
+
+// AddTemplate handles templates/add.json
+func (api *MandrillAPI) AddTemplate(w http.ResponseWriter, r *http.Request) {
+	var req mandrillTemplateRequest
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+	if api.db == nil {
+		writeMandrillError(w, -1, "GeneralError", "templates require a database connection")
+		return
+	}
+
+	slug := templateSlug(req.Name)
+	if slug == "" {
+		writeMandrillError(w, -2, "ValidationError", "template name is required")
+		return
+	}
+
+	var exists int
+	api.db.QueryRow("SELECT COUNT(*) FROM mandrill_templates WHERE slug = ?", slug).Scan(&exists)
+	if exists > 0 {
+		writeMandrillError(w, 6, "Invalid_Template", fmt.Sprintf("A template with name %q already exists", req.Name))
+		return
+	}
+
+	labels := []string{}
+	if req.Labels != nil {
+		labels = *req.Labels
+	}
+	labelsJSON, _ := json.Marshal(labels)
+
+	_, err := api.db.Exec(`
+		INSERT INTO mandrill_templates (slug, name, from_email, from_name, subject, code, text, labels)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, slug, req.Name, stringValue(req.FromEmail), stringValue(req.FromName), stringValue(req.Subject),
+		stringValue(req.Code), stringValue(req.Text), string(labelsJSON))
+	if err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+
+	api.writeTemplate(w, slug)
+}
+
+// UpdateTemplate handles templates/update.json; omitted fields are left unchanged
+func (api *MandrillAPI) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	var req mandrillTemplateRequest
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+
+	template, err := api.getTemplate(req.Name)
+	if err == sql.ErrNoRows {
+		writeMandrillError(w, 5, "Unknown_Template", fmt.Sprintf("No such template %q", req.Name))
+		return
+	}
+	if err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+	for column, value := range map[string]*string{
+		"from_email": req.FromEmail,
+		"from_name":  req.FromName,
+		"subject":    req.Subject,
+		"code":       req.Code,
+		"text":       req.Text,
+	} {
+		if value != nil {
+			sets = append(sets, column+" = ?")
+			args = append(args, *value)
+		}
+	}
+	if req.Labels != nil {
+		labelsJSON, _ := json.Marshal(*req.Labels)
+		sets = append(sets, "labels = ?")
+		args = append(args, string(labelsJSON))
+	}
+
+	if len(sets) > 0 {
+		args = append(args, template.Slug)
+		query := "UPDATE mandrill_templates SET " + strings.Join(sets, ", ") + " WHERE slug = ?"
+		if _, err := api.db.Exec(query, args...); err != nil {
+			writeMandrillError(w, -1, "GeneralError", err.Error())
+			return
+		}
+	}
+
+	api.writeTemplate(w, template.Slug)
+}
+
+// TemplateInfo handles templates/info.json
+func (api *MandrillAPI) TemplateInfo(w http.ResponseWriter, r *http.Request) {
+	var req mandrillTemplateLookup
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+
+	api.writeTemplate(w, req.Name)
+}
+
+// DeleteTemplate handles templates/delete.json and returns the deleted template
+func (api *MandrillAPI) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	var req mandrillTemplateLookup
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+
+	template, err := api.getTemplate(req.Name)
+	if err == sql.ErrNoRows {
+		writeMandrillError(w, 5, "Unknown_Template", fmt.Sprintf("No such template %q", req.Name))
+		return
+	}
+	if err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+
+	if _, err := api.db.Exec("DELETE FROM mandrill_templates WHERE slug = ?", template.Slug); err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+
+	writeMandrillJSON(w, template)
+}
+
+// ListTemplates handles templates/list.json, optionally filtered by label
+func (api *MandrillAPI) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	var req mandrillTemplateLookup
+	if !api.decodeRequest(w, r, &req) {
+		return
+	}
+	if !api.checkKey(w, req.Key) {
+		return
+	}
+	if api.db == nil {
+		writeMandrillError(w, -1, "GeneralError", "templates require a database connection")
+		return
+	}
+
+	rows, err := api.db.Query("SELECT " + templateColumns + " FROM mandrill_templates ORDER BY name")
+	if err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	templates := []*MandrillTemplate{}
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			continue
+		}
+		if req.Label != "" && !containsFold(template.Labels, req.Label) {
+			continue
+		}
+		templates = append(templates, template)
+	}
+
+	writeMandrillJSON(w, templates)
+}
+
+// writeTemplate writes the template with the given name, or Unknown_Template
+func (api *MandrillAPI) writeTemplate(w http.ResponseWriter, name string) {
+	template, err := api.getTemplate(name)
+	if err == sql.ErrNoRows {
+		writeMandrillError(w, 5, "Unknown_Template", fmt.Sprintf("No such template %q", name))
+		return
+	}
+	if err != nil {
+		writeMandrillError(w, -1, "GeneralError", err.Error())
+		return
+	}
+	writeMandrillJSON(w, template)
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,486 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"relay/internal/config"
+	"relay/internal/database"
+	"relay/internal/queue"
+	"relay/internal/workspace"
+	"relay/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRenderMergeTags(t *testing.T) {
+	vars := map[string]string{"FNAME": "Ana", "LINK": "<b>x</b>"}
+
+	tests := []struct {
+		name     string
+		content  string
+		language string
+		isHTML   bool
+		want     string
+	}{
+		{"mailchimp", "Hi *|fname|*!", "", false, "Hi Ana!"},
+		{"mailchimp missing var renders empty", "Hi *|LNAME|*.", "mailchimp", false, "Hi ."},
+		{"mailchimp special tag untouched", "*|UNSUB:http://x|*", "", false, "*|UNSUB:http://x|*"},
+		{"handlebars escaped in html", "{{ link }}", "handlebars", true, "&lt;b&gt;x&lt;/b&gt;"},
+		{"handlebars triple stash raw", "{{{link}}}", "handlebars", true, "<b>x</b>"},
+		{"handlebars block untouched", "{{#if fname}}y{{/if}}", "handlebars", true, "{{#if fname}}y{{/if}}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderMergeTags(tt.content, vars, tt.language, tt.isHTML); got != tt.want {
+				t.Errorf("renderMergeTags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceEditableRegion(t *testing.T) {
+	code := `<div mc:edit="main"><div>old</div></div><p mc:edit="footer">f</p>`
+
+	got := replaceEditableRegion(code, "main", "new")
+	want := `<div mc:edit="main">new</div><p mc:edit="footer">f</p>`
+	if got != want {
+		t.Errorf("replaceEditableRegion() = %q, want %q", got, want)
+	}
+
+	if got := replaceEditableRegion(code, "missing", "x"); got != code {
+		t.Errorf("unknown region should leave code unchanged, got %q", got)
+	}
+}
+
+func TestTemplateSlug(t *testing.T) {
+	if got := templateSlug("Welcome Email (v2)"); got != "welcome-email-v2" {
+		t.Errorf("templateSlug() = %q", got)
+	}
+}
+
+func TestTokenizeSearchQuery(t *testing.T) {
+	got := tokenizeSearchQuery(`subject:"weekly digest" email:ana@example.com  hello`)
+	want := []string{"subject:weekly digest", "email:ana@example.com", "hello"}
+	if len(got) != len(want) {
+		t.Fatalf("tokenizeSearchQuery() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("term %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	req := &mandrillSearchRequest{Query: "u_account:42"}
+	if _, args, err := buildSearchFilter(database.MySQL, req); err != nil || len(args) != 1 || args[0] != "42" {
+		t.Errorf("buildSearchFilter() args = %v, err = %v", args, err)
+	}
+	req.Query = "u_bad-field:1"
+	if _, _, err := buildSearchFilter(database.MySQL, req); err == nil {
+		t.Error("expected invalid metadata field to be rejected")
+	}
+}
+
+func TestLikeContains(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"hello", "%hello%"},
+		{"50% off", "%50!% off%"},
+		{"first_name", "%first!_name%"},
+		{"wow!", "%wow!!%"},
+		{`C:\path`, `%C:\path%`},
+		{"", "%%"},
+	}
+	for _, tt := range tests {
+		if got := likeContains(tt.value); got != tt.want {
+			t.Errorf("likeContains(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBuildSearchFilterEscapesLike(t *testing.T) {
+	tests := []struct {
+		query     string
+		wantWhere string
+		wantArgs  []interface{}
+	}{
+		{
+			query:     "100%",
+			wantWhere: "(m.subject LIKE ? ESCAPE '!' OR m.from_email LIKE ? ESCAPE '!' OR m.to_emails LIKE ? ESCAPE '!')",
+			wantArgs:  []interface{}{"%100!%%", "%100!%%", "%100!%%"},
+		},
+		{
+			query:     "email:a_b@example.org",
+			wantWhere: "(r.email_address LIKE ? ESCAPE '!' OR (r.email_address IS NULL AND m.to_emails LIKE ? ESCAPE '!'))",
+			wantArgs:  []interface{}{"%a!_b@example.org%", "%a!_b@example.org%"},
+		},
+		{
+			query:     "sender:news_letter@example.com",
+			wantWhere: "m.from_email LIKE ? ESCAPE '!'",
+			wantArgs:  []interface{}{"%news!_letter@example.com%"},
+		},
+		{
+			query:     `subject:"50% off!"`,
+			wantWhere: "m.subject LIKE ? ESCAPE '!'",
+			wantArgs:  []interface{}{"%50!% off!!%"},
+		},
+		{
+			// Exact matches take the value as is
+			query:     "full_email:A_B@example.org",
+			wantWhere: "r.email_address = ?",
+			wantArgs:  []interface{}{"a_b@example.org"},
+		},
+	}
+	for _, tt := range tests {
+		where, args, err := buildSearchFilter(database.MySQL, &mandrillSearchRequest{Query: tt.query})
+		if err != nil {
+			t.Errorf("buildSearchFilter(%q): %v", tt.query, err)
+			continue
+		}
+		if where[len(where)-1] != tt.wantWhere {
+			t.Errorf("buildSearchFilter(%q) where = %q, want %q", tt.query, where, tt.wantWhere)
+		}
+		if fmt.Sprint(args) != fmt.Sprint(tt.wantArgs) {
+			t.Errorf("buildSearchFilter(%q) args = %q, want %q", tt.query, args, tt.wantArgs)
+		}
+	}
+}
+
+func TestMandrillSearchMatchesWildcardsLiterally(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	api, _ := newTestMandrillAPI(t, db)
+
+	mock.ExpectQuery(`AND m\.subject LIKE \? ESCAPE '!'`).
+		WithArgs("%50!%!_off%", 100).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "from_email", "to_emails", "subject", "metadata", "status", "queued_at", "send_at", "sent_at", "error",
+			"recipient_id", "email_address", "delivery_status", "recipient_sent_at", "bounce_reason", "opens", "clicks",
+		}))
+
+	var results []MandrillMessageInfo
+	body := map[string]interface{}{"key": "test-key", "query": "subject:50%_off"}
+	if code := callMandrill(t, api.Search, body, nil, &results); code != http.StatusOK {
+		t.Fatalf("Search = %d", code)
+	}
+	if len(results) != 0 {
+		t.Errorf("Search returned %d results, want none", len(results))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseExpiresAt(t *testing.T) {
+	if expiresAt, err := parseExpiresAt(""); expiresAt != nil || err != nil {
+		t.Errorf("empty expires_at = %v, %v", expiresAt, err)
+	}
+
+	future := time.Now().UTC().Add(time.Hour).Format(mandrillTimeFormat)
+	if expiresAt, err := parseExpiresAt(future); err != nil || expiresAt == nil {
+		t.Errorf("future expires_at = %v, %v", expiresAt, err)
+	}
+
+	past := time.Now().UTC().Add(-time.Minute).Format(mandrillTimeFormat)
+	if _, err := parseExpiresAt(past); err == nil {
+		t.Error("expires_at in the past was accepted")
+	}
+	if _, err := parseExpiresAt("tomorrow"); err == nil {
+		t.Error("malformed expires_at was accepted")
+	}
+}
+
+func newTestMandrillAPI(t *testing.T, db *sql.DB) (*MandrillAPI, *queue.MemoryQueue) {
+	t.Helper()
+	q := queue.NewMemoryQueue()
+	workspaces := workspace.NewManager(&config.WorkspaceConfig{ID: "ws", Domain: "example.com"})
+	return NewMandrillAPI(db, q, workspaces, []string{"test-key"}), q
+}
+
+// callMandrill posts body to handler and decodes the response into out,
+// returning the HTTP status
+func callMandrill(t *testing.T, handler http.HandlerFunc, body interface{}, headers map[string]string, out interface{}) int {
+	t.Helper()
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/1.0/messages", bytes.NewReader(encoded))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return rec.Code
+}
+
+// queuedMessages dequeues every message the API queued for immediate sending
+func queuedMessages(t *testing.T, q *queue.MemoryQueue) []*models.Message {
+	t.Helper()
+	msgs, err := q.Dequeue(100)
+	if err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	return msgs
+}
+
+func TestMandrillSend(t *testing.T) {
+	api, q := newTestMandrillAPI(t, nil)
+
+	var results []MandrillSendResult
+	code := callMandrill(t, api.Send, MandrillSendRequest{
+		Key: "test-key",
+		Message: MandrillMessageRequest{
+			FromEmail: "news@example.com",
+			Subject:   "Hi *|FNAME|*",
+			Text:      "Hello",
+			To:        []MandrillRecipient{{Email: "ana@example.org"}, {Email: "not-an-address"}, {Email: "bo@example.org"}},
+			MergeVars: []MandrillRecipientMergeVars{{Rcpt: "ana@example.org", Vars: []MandrillMergeVar{{Name: "FNAME", Content: "Ana"}}}},
+		},
+	}, map[string]string{"Idempotency-Key": "req-1"}, &results)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+
+	statuses := map[string]string{}
+	for _, r := range results {
+		statuses[r.Email] = r.Status
+	}
+	if statuses["ana@example.org"] != "queued" || statuses["bo@example.org"] != "queued" || statuses["not-an-address"] != "invalid" {
+		t.Errorf("results = %+v", results)
+	}
+
+	msgs := queuedMessages(t, q)
+	if len(msgs) != 2 {
+		t.Fatalf("queued %d messages, want one per valid recipient", len(msgs))
+	}
+	for _, msg := range msgs {
+		if msg.ProviderID != "ws" || msg.Metadata["source"] != "mandrill_api" {
+			t.Errorf("message %s queued for %q from %v", msg.ID, msg.ProviderID, msg.Metadata["source"])
+		}
+		if msg.To[0] == "ana@example.org" && msg.Subject != "Hi Ana" {
+			t.Errorf("merge vars not applied, subject %q", msg.Subject)
+		}
+	}
+}
+
+func TestMandrillSendRejectsBeforeQueueing(t *testing.T) {
+	api, q := newTestMandrillAPI(t, nil)
+
+	var mandrillErr MandrillError
+	code := callMandrill(t, api.Send, MandrillSendRequest{Key: "wrong", Message: MandrillMessageRequest{}}, nil, &mandrillErr)
+	if code != http.StatusInternalServerError || mandrillErr.Name != "Invalid_Key" {
+		t.Errorf("unknown key = %d %+v", code, mandrillErr)
+	}
+
+	// A bad attachment fails the whole request; no recipient gets a copy
+	code = callMandrill(t, api.Send, MandrillSendRequest{
+		Key: "test-key",
+		Message: MandrillMessageRequest{
+			FromEmail:   "news@example.com",
+			Text:        "Hello",
+			To:          []MandrillRecipient{{Email: "ana@example.org"}, {Email: "bo@example.org"}},
+			Attachments: []MandrillAttachment{{Name: "a.txt", Type: "text/plain", Content: "not base64!"}},
+		},
+	}, nil, &mandrillErr)
+	if code != http.StatusInternalServerError || mandrillErr.Name != "ValidationError" {
+		t.Errorf("bad attachment = %d %+v", code, mandrillErr)
+	}
+
+	// So does a preserved group over the recipient limit
+	var to []MandrillRecipient
+	for i := 0; i < 101; i++ {
+		to = append(to, MandrillRecipient{Email: fmt.Sprintf("user%d@example.org", i)})
+	}
+	code = callMandrill(t, api.Send, MandrillSendRequest{
+		Key:     "test-key",
+		Message: MandrillMessageRequest{FromEmail: "news@example.com", Text: "Hello", To: to, PreserveRecipients: true},
+	}, nil, &mandrillErr)
+	if code != http.StatusInternalServerError || mandrillErr.Name != "ValidationError" {
+		t.Errorf("too many recipients = %d %+v", code, mandrillErr)
+	}
+
+	if msgs := queuedMessages(t, q); len(msgs) != 0 {
+		t.Errorf("rejected requests queued %d messages", len(msgs))
+	}
+}
+
+func TestMandrillSendUnknownSenderIsRejected(t *testing.T) {
+	api, q := newTestMandrillAPI(t, nil)
+
+	var results []MandrillSendResult
+	callMandrill(t, api.Send, MandrillSendRequest{
+		Key:     "test-key",
+		Message: MandrillMessageRequest{FromEmail: "news@unknown.test", Text: "Hello", To: []MandrillRecipient{{Email: "ana@example.org"}}},
+	}, nil, &results)
+	if len(results) != 1 || results[0].Status != "rejected" || results[0].RejectReason == nil || *results[0].RejectReason != "unsigned" {
+		t.Errorf("results = %+v", results)
+	}
+	if msgs := queuedMessages(t, q); len(msgs) != 0 {
+		t.Errorf("queued %d messages for a sender without a workspace", len(msgs))
+	}
+}
+
+func TestMandrillSendRaw(t *testing.T) {
+	api, q := newTestMandrillAPI(t, nil)
+
+	raw := "From: Ana <ana@example.com>\r\n" +
+		"To: bo@example.org\r\n" +
+		"Cc: cy@example.org\r\n" +
+		"Subject: Raw hello\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Body\r\n"
+
+	var results []MandrillSendResult
+	code := callMandrill(t, api.SendRaw, MandrillSendRawRequest{Key: "test-key", RawMessage: raw}, nil, &results)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %+v, want the To and Cc recipients", results)
+	}
+
+	msgs := queuedMessages(t, q)
+	if len(msgs) != 1 {
+		t.Fatalf("queued %d messages, want one for all recipients", len(msgs))
+	}
+	if msgs[0].From != "ana@example.com" || msgs[0].Subject != "Raw hello" || msgs[0].ProviderID != "ws" {
+		t.Errorf("queued message from %q subject %q workspace %q", msgs[0].From, msgs[0].Subject, msgs[0].ProviderID)
+	}
+
+	var mandrillErr MandrillError
+	code = callMandrill(t, api.SendRaw, MandrillSendRawRequest{Key: "test-key", RawMessage: "Subject: no sender\r\n\r\nBody\r\n", To: []string{"bo@example.org"}}, nil, &mandrillErr)
+	if code != http.StatusInternalServerError || mandrillErr.Name != "ValidationError" {
+		t.Errorf("raw message without a sender = %d %+v", code, mandrillErr)
+	}
+}
+
+func TestMandrillSendTemplate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	api, q := newTestMandrillAPI(t, db)
+
+	columns := []string{"slug", "name", "from_email", "from_name", "subject", "code", "text", "labels", "created_at", "updated_at"}
+	now := time.Now()
+	mock.ExpectQuery("FROM mandrill_templates").
+		WithArgs("welcome", "welcome").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(
+			"welcome", "welcome", "hello@example.com", "Hello", "Welcome *|FNAME|*",
+			`<div mc:edit="main">default</div>`, "", "[]", now, now))
+	mock.ExpectQuery("FROM mandrill_templates").
+		WithArgs("missing", "missing").
+		WillReturnError(sql.ErrNoRows)
+
+	var results []MandrillSendResult
+	code := callMandrill(t, api.SendTemplate, MandrillSendTemplateRequest{
+		Key:             "test-key",
+		TemplateName:    "welcome",
+		TemplateContent: []MandrillTemplateContent{{Name: "main", Content: "Thanks for joining"}},
+		Message: MandrillMessageRequest{
+			To:              []MandrillRecipient{{Email: "ana@example.org"}},
+			GlobalMergeVars: []MandrillMergeVar{{Name: "FNAME", Content: "Ana"}},
+		},
+	}, nil, &results)
+	if code != http.StatusOK || len(results) != 1 || results[0].Status != "queued" {
+		t.Fatalf("send-template = %d %+v", code, results)
+	}
+
+	msgs := queuedMessages(t, q)
+	if len(msgs) != 1 {
+		t.Fatalf("queued %d messages", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.From != "hello@example.com" || msg.Subject != "Welcome Ana" || msg.Metadata["template"] != "welcome" {
+		t.Errorf("queued message from %q subject %q template %v", msg.From, msg.Subject, msg.Metadata["template"])
+	}
+	if !strings.Contains(msg.HTML, "Thanks for joining") {
+		t.Errorf("template content not applied: %q", msg.HTML)
+	}
+
+	var mandrillErr MandrillError
+	code = callMandrill(t, api.SendTemplate, MandrillSendTemplateRequest{
+		Key:          "test-key",
+		TemplateName: "missing",
+		Message:      MandrillMessageRequest{To: []MandrillRecipient{{Email: "ana@example.org"}}},
+	}, nil, &mandrillErr)
+	if code != http.StatusInternalServerError || mandrillErr.Name != "Unknown_Template" {
+		t.Errorf("unknown template = %d %+v", code, mandrillErr)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRequestIdempotencyKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"none", nil, ""},
+		{"standard header", map[string]string{"Idempotency-Key": " order-1 "}, "order-1"},
+		{"x header", map[string]string{"X-Idempotency-Key": "order-2"}, "order-2"},
+		{"standard header wins", map[string]string{"Idempotency-Key": "order-1", "X-Idempotency-Key": "order-2"}, "order-1"},
+		{"blank standard header falls back", map[string]string{"Idempotency-Key": "  ", "X-Idempotency-Key": "order-2"}, "order-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/1.0/messages/send.json", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if got := requestIdempotencyKey(req); got != tt.want {
+				t.Errorf("requestIdempotencyKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMandrillSendDeduplicatesRetries(t *testing.T) {
+	api, q := newTestMandrillAPI(t, nil)
+	api.SetDedupWindow(time.Hour)
+
+	send := func(key string) string {
+		var results []MandrillSendResult
+		callMandrill(t, api.Send, MandrillSendRequest{
+			Key:     "test-key",
+			Message: MandrillMessageRequest{FromEmail: "news@example.com", Text: "Hello", To: []MandrillRecipient{{Email: "ana@example.org"}}},
+		}, map[string]string{"Idempotency-Key": key}, &results)
+		if len(results) != 1 || results[0].ID == "" {
+			t.Fatalf("results = %+v", results)
+		}
+		return results[0].ID
+	}
+
+	first := send("order-1")
+	if retry := send("order-1"); retry != first {
+		t.Errorf("retry returned %s, want the original %s", retry, first)
+	}
+	if other := send("order-2"); other == first {
+		t.Error("a different key returned the original message")
+	}
+	if msgs := queuedMessages(t, q); len(msgs) != 2 {
+		t.Errorf("queued %d messages, want 2", len(msgs))
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"strings"
 	"time"
 
+	"relay/pkg/models"
+
 	"github.com/gorilla/mux"
 )
 
@@ -31,11 +33,13 @@ type Message struct {
 	TextBody   string            `json:"text_body,omitempty"`
 	Headers    map[string]string `json:"headers,omitempty"`
 	Status     string            `json:"status"`
+	Priority   string            `json:"priority"`
 	Provider   string            `json:"provider,omitempty"`
 	CreatedAt  time.Time         `json:"created_at"`
 	SentAt     *time.Time        `json:"sent_at,omitempty"`
 	Error      string            `json:"error,omitempty"`
 	RetryCount int               `json:"retry_count"`
+	LockedBy   string            `json:"locked_by,omitempty"` // Worker holding the message while processing
 }
 
 type MessagesResponse struct {
@@ -49,6 +53,7 @@ func (api *MessagesAPI) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/api/messages", api.ListMessages).Methods("GET")
 	router.HandleFunc("/api/messages/{id}", api.GetMessage).Methods("GET")
 	router.HandleFunc("/api/messages/{id}/resend", api.ResendMessage).Methods("POST")
+	router.HandleFunc("/api/messages/{id}/priority", api.SetMessagePriority).Methods("PUT")
 }
 
 func (api *MessagesAPI) ListMessages(w http.ResponseWriter, r *http.Request) {
@@ -97,8 +102,8 @@ func (api *MessagesAPI) ListMessages(w http.ResponseWriter, r *http.Request) {
 	query := `
 		SELECT 
 			id, from_email, to_emails, cc_emails, bcc_emails,
-			subject, html_body, text_body, headers, status,
-			provider_id, queued_at, sent_at, error, retry_count
+			subject, html_body, text_body, headers, status, priority,
+			provider_id, queued_at, sent_at, error, retry_count, locked_by
 		FROM messages
 		WHERE ` + strings.Join(where, " AND ") + `
 		ORDER BY queued_at DESC
@@ -116,13 +121,13 @@ func (api *MessagesAPI) ListMessages(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var msg Message
 		var toEmails, ccEmails, bccEmails sql.NullString
-		var htmlBody, textBody, headers, provider, errorMsg sql.NullString
+		var htmlBody, textBody, headers, provider, errorMsg, lockedBy sql.NullString
 		var sentAt sql.NullTime
 
 		err := rows.Scan(
 			&msg.ID, &msg.FromEmail, &toEmails, &ccEmails, &bccEmails,
-			&msg.Subject, &htmlBody, &textBody, &headers, &msg.Status,
-			&provider, &msg.CreatedAt, &sentAt, &errorMsg, &msg.RetryCount,
+			&msg.Subject, &htmlBody, &textBody, &headers, &msg.Status, &msg.Priority,
+			&provider, &msg.CreatedAt, &sentAt, &errorMsg, &msg.RetryCount, &lockedBy,
 		)
 		if err != nil {
 			continue
@@ -157,6 +162,7 @@ func (api *MessagesAPI) ListMessages(w http.ResponseWriter, r *http.Request) {
 		if errorMsg.Valid {
 			msg.Error = errorMsg.String
 		}
+		msg.LockedBy = lockedBy.String
 
 		// Extract from name from headers if available
 		if fromHeader, ok := msg.Headers["From"]; ok {
@@ -186,21 +192,21 @@ func (api *MessagesAPI) GetMessage(w http.ResponseWriter, r *http.Request) {
 	query := `
 		SELECT 
 			id, from_email, to_emails, cc_emails, bcc_emails,
-			subject, html_body, text_body, headers, status,
-			provider_id, queued_at, sent_at, error, retry_count
+			subject, html_body, text_body, headers, status, priority,
+			provider_id, queued_at, sent_at, error, retry_count, locked_by
 		FROM messages
 		WHERE id = ?
 	`
 
 	var msg Message
 	var toEmails, ccEmails, bccEmails sql.NullString
-	var htmlBody, textBody, headers, provider, errorMsg sql.NullString
+	var htmlBody, textBody, headers, provider, errorMsg, lockedBy sql.NullString
 	var sentAt sql.NullTime
 
 	err := api.db.QueryRow(query, id).Scan(
 		&msg.ID, &msg.FromEmail, &toEmails, &ccEmails, &bccEmails,
-		&msg.Subject, &htmlBody, &textBody, &headers, &msg.Status,
-		&provider, &msg.CreatedAt, &sentAt, &errorMsg, &msg.RetryCount,
+		&msg.Subject, &htmlBody, &textBody, &headers, &msg.Status, &msg.Priority,
+		&provider, &msg.CreatedAt, &sentAt, &errorMsg, &msg.RetryCount, &lockedBy,
 	)
 
 	if err == sql.ErrNoRows {
@@ -241,6 +247,7 @@ func (api *MessagesAPI) GetMessage(w http.ResponseWriter, r *http.Request) {
 	if errorMsg.Valid {
 		msg.Error = errorMsg.String
 	}
+	msg.LockedBy = lockedBy.String
 
 	// Extract from name from headers if available
 	if fromHeader, ok := msg.Headers["From"]; ok {
@@ -257,15 +264,16 @@ func (api *MessagesAPI) ResendMessage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	// Reset message status to queued for retry
+	// Reset message status to queued for retry with a fresh retry budget
 	query := `
 		UPDATE messages 
 		SET status = 'queued', 
 		    error = NULL,
-		    retry_count = retry_count + 1,
+		    retry_count = 0,
+		    next_attempt_at = NULL,
 		    processed_at = NULL,
 		    sent_at = NULL
-		WHERE id = ? AND status IN ('failed', 'auth_error')
+		WHERE id = ? AND status IN ('failed', 'auth_error', 'dead')
 	`
 
 	result, err := api.db.Exec(query, id)
@@ -287,3 +295,55 @@ func (api *MessagesAPI) ResendMessage(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// SetMessagePriority moves a message that is still waiting to be sent to another priority lane
+func (api *MessagesAPI) SetMessagePriority(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req struct {
+		Priority string `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	priority, ok := models.ParsePriority(req.Priority)
+	if !ok {
+		http.Error(w, "priority must be high, normal or low", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		UPDATE messages
+		SET priority = ?
+		WHERE id = ? AND (status = 'queued' OR (status = 'failed' AND next_attempt_at IS NOT NULL))
+	`
+
+	result, err := api.db.Exec(query, priority, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		// MySQL reports rows already in the requested lane as unaffected
+		var waiting int
+		api.db.QueryRow(`
+			SELECT COUNT(*) FROM messages
+			WHERE id = ? AND priority = ? AND (status = 'queued' OR (status = 'failed' AND next_attempt_at IS NOT NULL))
+		`, id, priority).Scan(&waiting)
+		rowsAffected = int64(waiting)
+	}
+	if rowsAffected == 0 {
+		http.Error(w, "Message not found or no longer waiting to be sent", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "Message priority updated",
+		"id":       id,
+		"priority": string(priority),
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"time"
 
+	"relay/internal/database"
+
 	"github.com/gorilla/mux"
 )
 
@@ -27,6 +29,7 @@ type StatsResponse struct {
 	SuccessRate        float64        `json:"success_rate"`
 	HourlyStats        []HourlyStat   `json:"hourly_stats"`
 	ProviderStats      []ProviderStat `json:"provider_stats"`
+	LaneDepths         []LaneDepth    `json:"lane_depths"`
 }
 
 type HourlyStat struct {
@@ -43,6 +46,13 @@ type ProviderStat struct {
 	Failed   int64  `json:"failed"`
 }
 
+// LaneDepth is the backlog of one priority lane
+type LaneDepth struct {
+	Priority   string `json:"priority"`
+	Queued     int64  `json:"queued"` // Waiting to be sent, including pending retries
+	Processing int64  `json:"processing"`
+}
+
 type RateLimitsResponse struct {
 	WorkspaceLimits []WorkspaceLimit `json:"workspace_limits"`
 	UserLimits      []UserLimit      `json:"user_limits"`
@@ -103,7 +113,7 @@ func (api *MetricsAPI) GetStats(w http.ResponseWriter, r *http.Request) {
 					stats.MessagesProcessing = count
 				case "sent":
 					stats.MessagesSent = count
-				case "failed", "auth_error":
+				case "failed", "auth_error", "dead":
 					stats.MessagesFailed += count
 				}
 			}
@@ -118,27 +128,31 @@ func (api *MetricsAPI) GetStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get today's message count
+	now := time.Now()
+	year, month, day := now.Date()
 	todayQuery := `
 		SELECT COUNT(*) 
 		FROM messages 
-		WHERE DATE(queued_at) = CURDATE()
+		WHERE queued_at >= ?
 	`
-	api.db.QueryRow(todayQuery).Scan(&stats.MessagesToday)
+	api.db.QueryRow(todayQuery, time.Date(year, month, day, 0, 0, 0, 0, now.Location())).Scan(&stats.MessagesToday)
 
-	// Get hourly stats for the last 24 hours
+	// Get hourly stats for the last 24 hours. Grouping by the label as well keeps
+	// PostgreSQL happy; it adds nothing beyond the date and hour.
+	d := database.For(api.db)
 	hourlyQuery := `
 		SELECT 
-			DATE_FORMAT(queued_at, '%H:00') as hour,
+			` + d.DateFormat("queued_at", "%H:00") + ` as hour,
 			SUM(CASE WHEN status = 'sent' THEN 1 ELSE 0 END) as sent,
-			SUM(CASE WHEN status IN ('failed', 'auth_error') THEN 1 ELSE 0 END) as failed,
+			SUM(CASE WHEN status IN ('failed', 'auth_error', 'dead') THEN 1 ELSE 0 END) as failed,
 			SUM(CASE WHEN status = 'queued' THEN 1 ELSE 0 END) as queued,
-			AVG(TIMESTAMPDIFF(MICROSECOND, queued_at, sent_at) / 1000) as avg_processing_time
+			AVG(` + d.Milliseconds("queued_at", "sent_at") + `) as avg_processing_time
 		FROM messages
-		WHERE queued_at >= NOW() - INTERVAL 24 HOUR
-		GROUP BY DATE_FORMAT(queued_at, '%Y-%m-%d %H')
+		WHERE queued_at >= ?
+		GROUP BY ` + d.DateFormat("queued_at", "%Y-%m-%d %H") + `, ` + d.DateFormat("queued_at", "%H:00") + `
 		ORDER BY MIN(queued_at)
 	`
-	rows, err = api.db.Query(hourlyQuery)
+	rows, err = api.db.Query(hourlyQuery, now.Add(-24*time.Hour))
 	if err == nil {
 		defer rows.Close()
 		for rows.Next() {
@@ -158,12 +172,12 @@ func (api *MetricsAPI) GetStats(w http.ResponseWriter, r *http.Request) {
 		SELECT 
 			COALESCE(provider_id, 'unassigned') as provider,
 			SUM(CASE WHEN status = 'sent' THEN 1 ELSE 0 END) as sent,
-			SUM(CASE WHEN status IN ('failed', 'auth_error') THEN 1 ELSE 0 END) as failed
+			SUM(CASE WHEN status IN ('failed', 'auth_error', 'dead') THEN 1 ELSE 0 END) as failed
 		FROM messages
-		WHERE queued_at >= NOW() - INTERVAL 24 HOUR
+		WHERE queued_at >= ?
 		GROUP BY provider_id
 	`
-	rows, err = api.db.Query(providerQuery)
+	rows, err = api.db.Query(providerQuery, now.Add(-24*time.Hour))
 	if err == nil {
 		defer rows.Close()
 		for rows.Next() {
@@ -174,6 +188,27 @@ func (api *MetricsAPI) GetStats(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Get queue depth per priority lane
+	laneQuery := `
+		SELECT
+			priority,
+			SUM(CASE WHEN status = 'queued' OR (status = 'failed' AND next_attempt_at IS NOT NULL) THEN 1 ELSE 0 END) as queued,
+			SUM(CASE WHEN status = 'processing' THEN 1 ELSE 0 END) as processing
+		FROM messages
+		WHERE status IN ('queued', 'processing', 'failed')
+		GROUP BY priority
+	`
+	rows, err = api.db.Query(laneQuery)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var depth LaneDepth
+			if err := rows.Scan(&depth.Priority, &depth.Queued, &depth.Processing); err == nil {
+				stats.LaneDepths = append(stats.LaneDepths, depth)
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
 }
@@ -284,9 +319,9 @@ func (api *MetricsAPI) GetHealth(w http.ResponseWriter, r *http.Request) {
 		SELECT COUNT(*) 
 		FROM messages 
 		WHERE status = 'processing' 
-		AND processed_at < NOW() - INTERVAL 10 MINUTE
+		AND processed_at < ?
 	`
-	api.db.QueryRow(stuckQuery).Scan(&stuckCount)
+	api.db.QueryRow(stuckQuery, time.Now().Add(-10*time.Minute)).Scan(&stuckCount)
 	if stuckCount > 0 {
 		response.Healthy = false
 		response.Errors = append(response.Errors, "Messages stuck in processing state")
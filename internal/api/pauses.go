@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"relay/internal/queue"
+
+	"github.com/gorilla/mux"
+)
+
+// PausesAPI pauses and resumes sending globally or for one workspace, provider
+// or sender. Paused messages stay queued and are sent once the pause ends.
+type PausesAPI struct {
+	queue queue.Pauser
+}
+
+func NewPausesAPI(pauser queue.Pauser) *PausesAPI {
+	return &PausesAPI{queue: pauser}
+}
+
+type PauseRequest struct {
+	Scope       queue.PauseScope `json:"scope"`
+	Key         string           `json:"key,omitempty"` // Workspace ID, provider ID or sender address; omitted for global
+	Reason      string           `json:"reason,omitempty"`
+	PausedBy    string           `json:"paused_by"`
+	ResumeAt    *time.Time       `json:"resume_at,omitempty"`
+	ResumeAfter string           `json:"resume_after,omitempty"` // Duration such as "30m", instead of resume_at
+}
+
+func (api *PausesAPI) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/pauses", api.ListPauses).Methods("GET")
+	router.HandleFunc("/api/pauses", api.Pause).Methods("POST")
+	router.HandleFunc("/api/pauses", api.Resume).Methods("DELETE")
+}
+
+func (api *PausesAPI) ListPauses(w http.ResponseWriter, r *http.Request) {
+	pauses, err := api.queue.ListPauses()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pauses == nil {
+		pauses = []queue.Pause{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pauses": pauses,
+		"total":  len(pauses),
+	})
+}
+
+// Pause creates a pause, replacing any existing one with the same scope and key
+func (api *PausesAPI) Pause(w http.ResponseWriter, r *http.Request) {
+	var req PauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.PausedBy == "" {
+		http.Error(w, "paused_by is required", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	pause := queue.Pause{
+		Scope:    req.Scope,
+		Key:      req.Key,
+		Reason:   req.Reason,
+		PausedBy: req.PausedBy,
+		PausedAt: now,
+		ResumeAt: req.ResumeAt,
+	}
+	if req.ResumeAfter != "" {
+		if req.ResumeAt != nil {
+			http.Error(w, "Use either resume_at or resume_after", http.StatusBadRequest)
+			return
+		}
+		after, err := time.ParseDuration(req.ResumeAfter)
+		if err != nil || after <= 0 {
+			http.Error(w, "resume_after must be a positive duration such as 30m", http.StatusBadRequest)
+			return
+		}
+		resumeAt := now.Add(after)
+		pause.ResumeAt = &resumeAt
+	}
+	if pause.ResumeAt != nil && !pause.ResumeAt.After(now) {
+		http.Error(w, "resume_at must be in the future", http.StatusBadRequest)
+		return
+	}
+	if err := pause.Normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.queue.Pause(pause); err != nil {
+		log.Printf("Error pausing %s %s: %v", pause.Scope, pause.Key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("%s by %s (reason %q)", pause, pause.PausedBy, pause.Reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(pause)
+}
+
+// Resume removes the pause given by the scope and key query parameters
+func (api *PausesAPI) Resume(w http.ResponseWriter, r *http.Request) {
+	pause := queue.Pause{
+		Scope: queue.PauseScope(r.URL.Query().Get("scope")),
+		Key:   r.URL.Query().Get("key"),
+	}
+	if err := pause.Normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resumed, err := api.queue.Resume(pause.Scope, pause.Key)
+	if err != nil {
+		log.Printf("Error resuming %s %s: %v", pause.Scope, pause.Key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !resumed {
+		http.Error(w, "Pause not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Resumed %s %s", pause.Scope, pause.Key)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"scope":   pause.Scope,
+		"key":     pause.Key,
+		"resumed": true,
+	})
+}
@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"time"
 
+	"relay/internal/database"
+
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
@@ -287,11 +289,7 @@ func (api *PoolsAPI) UpdatePool(w http.ResponseWriter, r *http.Request) {
 		memberQuery := `
 			INSERT INTO pool_members (pool_id, provider_id, weight, priority, enabled)
 			VALUES (?, ?, ?, ?, ?)
-			ON DUPLICATE KEY UPDATE
-				weight = VALUES(weight),
-				priority = VALUES(priority),
-				enabled = VALUES(enabled)
-		`
+			` + database.For(api.db).Upsert([]string{"pool_id", "provider_id"}, "weight", "priority", "enabled")
 		_, err = tx.Exec(memberQuery, id, providerID, 1, i, true)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
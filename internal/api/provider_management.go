@@ -10,6 +10,9 @@ import (
 	"strings"
 	"time"
 
+	"relay/internal/config"
+	"relay/internal/database"
+
 	"github.com/gorilla/mux"
 )
 
@@ -163,6 +166,16 @@ func (api *ProviderManagementAPI) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/api/workspaces/{id}/rate-limits", api.GetRateLimits).Methods("GET")
 	router.HandleFunc("/api/workspaces/{id}/rate-limits", api.UpdateRateLimits).Methods("PUT")
 	
+	// Retry policy endpoints
+	router.HandleFunc("/api/workspaces/{id}/retry-policy", api.GetRetryPolicy).Methods("GET")
+	router.HandleFunc("/api/workspaces/{id}/retry-policy", api.UpdateRetryPolicy).Methods("PUT")
+	router.HandleFunc("/api/workspaces/{id}/retention-policy", api.GetRetentionPolicy).Methods("GET")
+	router.HandleFunc("/api/workspaces/{id}/retention-policy", api.UpdateRetentionPolicy).Methods("PUT")
+	router.HandleFunc("/api/workspaces/{id}/message-ttl", api.GetMessageTTL).Methods("GET")
+	router.HandleFunc("/api/workspaces/{id}/message-ttl", api.UpdateMessageTTL).Methods("PUT")
+	router.HandleFunc("/api/workspaces/{id}/failover", api.GetFailover).Methods("GET")
+	router.HandleFunc("/api/workspaces/{id}/failover", api.UpdateFailover).Methods("PUT")
+	
 	// User rate limits endpoints
 	router.HandleFunc("/api/workspaces/{id}/user-rate-limits", api.ListUserRateLimits).Methods("GET")
 	router.HandleFunc("/api/workspaces/{id}/user-rate-limits", api.CreateUserRateLimit).Methods("POST")
@@ -327,20 +340,13 @@ func (api *ProviderManagementAPI) CreateProvider(w http.ResponseWriter, r *http.
 		VALUES (?, ?, ?, ?, ?, ?)
 	`
 	
-	result, err := tx.Exec(query, req.ProviderID, req.Type, req.Name, req.Domain, req.Enabled, req.Priority)
+	providerID, err := database.For(api.db).InsertID(tx, query, req.ProviderID, req.Type, req.Name, req.Domain, req.Enabled, req.Priority)
 	if err != nil {
 		log.Printf("Error creating provider: %v", err)
 		http.Error(w, "Failed to create provider", http.StatusInternalServerError)
 		return
 	}
 	
-	providerID, err := result.LastInsertId()
-	if err != nil {
-		log.Printf("Error getting provider ID: %v", err)
-		http.Error(w, "Failed to create provider", http.StatusInternalServerError)
-		return
-	}
-	
 	// Update service account JSON if provided (for Gmail providers)
 	if req.ServiceAccountJSON != "" && req.Type == "gmail" {
 		updateCredsQuery := `
@@ -648,11 +654,7 @@ func (api *ProviderManagementAPI) UpdateRateLimits(w http.ResponseWriter, r *htt
 	query := `
 		INSERT INTO provider_rate_limits (provider_id, daily, hourly, per_user_daily, per_user_hourly)
 		VALUES (?, ?, ?, ?, ?)
-		ON DUPLICATE KEY UPDATE
-		daily = VALUES(daily),
-		hourly = VALUES(hourly),
-		per_user_daily = VALUES(per_user_daily),
-		per_user_hourly = VALUES(per_user_hourly),
+		` + database.For(api.db).Upsert([]string{"provider_id"}, "daily", "hourly", "per_user_daily", "per_user_hourly") + `,
 		updated_at = NOW()
 	`
 	
@@ -677,6 +679,258 @@ func (api *ProviderManagementAPI) UpdateRateLimits(w http.ResponseWriter, r *htt
 	json.NewEncoder(w).Encode(rateLimit)
 }
 
+// Retry Policy Operations
+
+// GetRetryPolicy returns the workspace's retry policy override; an empty policy means the global defaults apply
+func (api *ProviderManagementAPI) GetRetryPolicy(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	
+	var policyJSON sql.NullString
+	err := api.db.QueryRow("SELECT retry_policy FROM providers WHERE provider_id = ? LIMIT 1", workspaceID).Scan(&policyJSON)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error fetching retry policy: %v", err)
+		http.Error(w, "Failed to fetch retry policy", http.StatusInternalServerError)
+		return
+	}
+	
+	policy := config.RetryPolicy{}
+	if policyJSON.Valid && policyJSON.String != "" {
+		json.Unmarshal([]byte(policyJSON.String), &policy)
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// UpdateRetryPolicy sets the workspace's retry policy override; zero fields inherit the global policy
+func (api *ProviderManagementAPI) UpdateRetryPolicy(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	
+	var policy config.RetryPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	
+	// Defensive programming: validate policy bounds
+	if policy.MaxAttempts < 0 || policy.MaxAttempts > 50 {
+		http.Error(w, "max_attempts must be between 0 and 50", http.StatusBadRequest)
+		return
+	}
+	if policy.InitialDelaySeconds < 0 || policy.MaxDelaySeconds < 0 {
+		http.Error(w, "retry delays cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if policy.Multiplier != 0 && policy.Multiplier < 1 {
+		http.Error(w, "multiplier must be at least 1", http.StatusBadRequest)
+		return
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		http.Error(w, "jitter must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+	
+	policyJSON, _ := json.Marshal(policy)
+	result, err := api.db.Exec("UPDATE providers SET retry_policy = ? WHERE provider_id = ?", string(policyJSON), workspaceID)
+	if err != nil {
+		log.Printf("Error updating retry policy: %v", err)
+		http.Error(w, "Failed to update retry policy", http.StatusInternalServerError)
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// Retention Policy Operations
+
+// GetRetentionPolicy returns the workspace's retention policy override; zero fields inherit the global policy
+func (api *ProviderManagementAPI) GetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	
+	var policyJSON sql.NullString
+	err := api.db.QueryRow("SELECT retention_policy FROM providers WHERE provider_id = ? LIMIT 1", workspaceID).Scan(&policyJSON)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error fetching retention policy: %v", err)
+		http.Error(w, "Failed to fetch retention policy", http.StatusInternalServerError)
+		return
+	}
+	
+	policy := config.RetentionPolicy{}
+	if policyJSON.Valid && policyJSON.String != "" {
+		json.Unmarshal([]byte(policyJSON.String), &policy)
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// UpdateRetentionPolicy sets the workspace's retention policy override. Zero
+// fields inherit the global policy and negative ones keep the data forever.
+func (api *ProviderManagementAPI) UpdateRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	
+	var policy config.RetentionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	
+	// Defensive programming: a row cannot lose its body after it is deleted
+	if policy.DeleteDays > 0 && ((policy.BodyDays > policy.DeleteDays) || (policy.AttachmentDays > policy.DeleteDays)) {
+		http.Error(w, "body_days and attachment_days cannot exceed delete_days", http.StatusBadRequest)
+		return
+	}
+	
+	policyJSON, _ := json.Marshal(policy)
+	result, err := api.db.Exec("UPDATE providers SET retention_policy = ? WHERE provider_id = ?", string(policyJSON), workspaceID)
+	if err != nil {
+		log.Printf("Error updating retention policy: %v", err)
+		http.Error(w, "Failed to update retention policy", http.StatusInternalServerError)
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// Message TTL Operations
+
+// GetMessageTTL returns the workspace's default time to live in seconds by email_type
+func (api *ProviderManagementAPI) GetMessageTTL(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	
+	var ttlJSON sql.NullString
+	err := api.db.QueryRow("SELECT message_ttl FROM providers WHERE provider_id = ? LIMIT 1", workspaceID).Scan(&ttlJSON)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error fetching message TTL: %v", err)
+		http.Error(w, "Failed to fetch message TTL", http.StatusInternalServerError)
+		return
+	}
+	
+	ttl := map[string]int{}
+	if ttlJSON.Valid && ttlJSON.String != "" {
+		json.Unmarshal([]byte(ttlJSON.String), &ttl)
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ttl)
+}
+
+// UpdateMessageTTL replaces the workspace's default time to live in seconds by
+// email_type. Messages of other email types do not expire unless they ask to.
+func (api *ProviderManagementAPI) UpdateMessageTTL(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	
+	var ttl map[string]int
+	if err := json.NewDecoder(r.Body).Decode(&ttl); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	for emailType, seconds := range ttl {
+		if emailType == "" || seconds <= 0 {
+			http.Error(w, "Each entry needs an email_type and a positive number of seconds", http.StatusBadRequest)
+			return
+		}
+	}
+	
+	ttlJSON, _ := json.Marshal(ttl)
+	result, err := api.db.Exec("UPDATE providers SET message_ttl = ? WHERE provider_id = ?", string(ttlJSON), workspaceID)
+	if err != nil {
+		log.Printf("Error updating message TTL: %v", err)
+		http.Error(w, "Failed to update message TTL", http.StatusInternalServerError)
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ttl)
+}
+
+// Failover Operations
+
+// GetFailover returns the providers the workspace's messages are tried through
+// in turn within one send attempt
+func (api *ProviderManagementAPI) GetFailover(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	
+	var failoverJSON sql.NullString
+	err := api.db.QueryRow("SELECT failover FROM providers WHERE provider_id = ? LIMIT 1", workspaceID).Scan(&failoverJSON)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error fetching failover chain: %v", err)
+		http.Error(w, "Failed to fetch failover chain", http.StatusInternalServerError)
+		return
+	}
+	
+	failover := []string{}
+	if failoverJSON.Valid && failoverJSON.String != "" {
+		json.Unmarshal([]byte(failoverJSON.String), &failover)
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(failover)
+}
+
+// UpdateFailover replaces the workspace's failover chain: provider types such as
+// "gmail" or provider IDs, in the order they are tried. An empty list turns
+// failover off.
+func (api *ProviderManagementAPI) UpdateFailover(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	
+	var failover []string
+	if err := json.NewDecoder(r.Body).Decode(&failover); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	for _, entry := range failover {
+		if strings.TrimSpace(entry) == "" {
+			http.Error(w, "Each entry needs a provider type or ID", http.StatusBadRequest)
+			return
+		}
+	}
+	if failover == nil {
+		failover = []string{}
+	}
+	
+	failoverJSON, _ := json.Marshal(failover)
+	result, err := api.db.Exec("UPDATE providers SET failover = ? WHERE provider_id = ?", string(failoverJSON), workspaceID)
+	if err != nil {
+		log.Printf("Error updating failover chain: %v", err)
+		http.Error(w, "Failed to update failover chain", http.StatusInternalServerError)
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(failover)
+}
+
 // User Rate Limits Operations
 func (api *ProviderManagementAPI) ListUserRateLimits(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -761,20 +1015,13 @@ func (api *ProviderManagementAPI) CreateUserRateLimit(w http.ResponseWriter, r *
 		VALUES (?, ?, ?, ?)
 	`
 	
-	result, err := api.db.Exec(query, req.ProviderID, req.UserEmail, req.Daily, req.Hourly)
+	userRateLimitID, err := database.For(api.db).InsertID(api.db, query, req.ProviderID, req.UserEmail, req.Daily, req.Hourly)
 	if err != nil {
 		log.Printf("Error creating user rate limit: %v", err)
 		http.Error(w, "Failed to create user rate limit", http.StatusInternalServerError)
 		return
 	}
 	
-	userRateLimitID, err := result.LastInsertId()
-	if err != nil {
-		log.Printf("Error getting user rate limit ID: %v", err)
-		http.Error(w, "Failed to create user rate limit", http.StatusInternalServerError)
-		return
-	}
-	
 	userRateLimit := WorkspaceUserRateLimit{
 		ID:          int(userRateLimitID),
 		ProviderID: req.ProviderID,
@@ -911,20 +1158,13 @@ func (api *ProviderManagementAPI) CreateHeaderRule(w http.ResponseWriter, r *htt
 		VALUES (?, ?, ?, ?, ?, ?)
 	`
 	
-	result, err := api.db.Exec(query, req.ProviderID, req.HeaderName, req.Action, req.Value, req.Condition, req.Enabled)
+	ruleID, err := database.For(api.db).InsertID(api.db, query, req.ProviderID, req.HeaderName, req.Action, req.Value, req.Condition, req.Enabled)
 	if err != nil {
 		log.Printf("Error creating header rule: %v", err)
 		http.Error(w, "Failed to create header rule", http.StatusInternalServerError)
 		return
 	}
 	
-	ruleID, err := result.LastInsertId()
-	if err != nil {
-		log.Printf("Error getting header rule ID: %v", err)
-		http.Error(w, "Failed to create header rule", http.StatusInternalServerError)
-		return
-	}
-	
 	headerRule := ProviderHeaderRewriteRule{
 		ID:         int(ruleID),
 		ProviderID: req.ProviderID,
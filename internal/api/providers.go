@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"time"
 
+	"relay/internal/database"
+
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
@@ -397,9 +399,7 @@ func (api *ProvidersAPI) UploadCredentials(w http.ResponseWriter, r *http.Reques
 		UPDATE providers 
 		SET service_account_json = ?,
 		    credentials_updated_at = NOW(),
-		    provider_config = JSON_SET(COALESCE(provider_config, '{}'), 
-		                              '$.service_account_file', NULL,
-		                              '$.has_credentials', true)
+		    provider_config = ` + database.For(api.db).JSONMerge("provider_config", `{"service_account_file": null, "has_credentials": true}`) + `
 		WHERE id = ? AND provider_type = 'gmail'
 	`
 
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"relay/internal/queue"
+
+	"github.com/gorilla/mux"
+)
+
+// ScheduledAPI lists, reschedules and cancels messages waiting for their send_at
+type ScheduledAPI struct {
+	scheduler queue.Scheduler
+}
+
+func NewScheduledAPI(scheduler queue.Scheduler) *ScheduledAPI {
+	return &ScheduledAPI{scheduler: scheduler}
+}
+
+type ScheduledMessage struct {
+	ID        string    `json:"id"`
+	FromEmail string    `json:"from_email"`
+	ToEmails  []string  `json:"to_emails"`
+	Subject   string    `json:"subject"`
+	QueuedAt  time.Time `json:"queued_at"`
+	SendAt    time.Time `json:"send_at"`
+}
+
+type RescheduleRequest struct {
+	SendAt time.Time `json:"send_at"`
+}
+
+func (api *ScheduledAPI) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/scheduled", api.ListScheduled).Methods("GET")
+	router.HandleFunc("/api/scheduled/{id}", api.Reschedule).Methods("PUT")
+	router.HandleFunc("/api/scheduled/{id}", api.CancelScheduled).Methods("DELETE")
+}
+
+func (api *ScheduledAPI) ListScheduled(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	messages, err := api.scheduler.ListScheduled(r.URL.Query().Get("to"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	scheduled := make([]ScheduledMessage, 0, len(messages))
+	for _, msg := range messages {
+		scheduled = append(scheduled, ScheduledMessage{
+			ID:        msg.ID,
+			FromEmail: msg.From,
+			ToEmails:  msg.To,
+			Subject:   msg.Subject,
+			QueuedAt:  msg.QueuedAt,
+			SendAt:    *msg.SendAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages": scheduled,
+		"total":    len(scheduled),
+	})
+}
+
+// Reschedule moves a scheduled message; send_at is an RFC 3339 timestamp
+func (api *ScheduledAPI) Reschedule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req RescheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !req.SendAt.After(time.Now()) {
+		http.Error(w, "send_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	if err := api.scheduler.Reschedule(id, req.SendAt); err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "Message rescheduled",
+		"id":      id,
+		"send_at": req.SendAt,
+	})
+}
+
+func (api *ScheduledAPI) CancelScheduled(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := api.scheduler.CancelScheduled(id); err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "Scheduled message cancelled",
+		"id":     id,
+	})
+}
+
+func writeSchedulerError(w http.ResponseWriter, err error) {
+	if errors.Is(err, queue.ErrNotScheduled) {
+		http.Error(w, "Message not found or not scheduled", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"relay/internal/queue"
+
+	"github.com/gorilla/mux"
+)
+
+// WorkersAPI shows the relay replicas processing the queue and the messages each one holds
+type WorkersAPI struct {
+	registry queue.WorkerRegistry
+}
+
+func NewWorkersAPI(registry queue.WorkerRegistry) *WorkersAPI {
+	return &WorkersAPI{registry: registry}
+}
+
+func (api *WorkersAPI) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/workers", api.ListWorkers).Methods("GET")
+}
+
+func (api *WorkersAPI) ListWorkers(w http.ResponseWriter, r *http.Request) {
+	workers, err := api.registry.ListWorkers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if workers == nil {
+		workers = []queue.WorkerInfo{}
+	}
+
+	active := 0
+	for _, worker := range workers {
+		if worker.Status == queue.WorkerStatusActive {
+			active++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"workers": workers,
+		"active":  active,
+		"total":   len(workers),
+	})
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"relay/internal/queue"
+	"relay/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+// stubRegistry lists fixed workers, as a registry backed by a database does
+type stubRegistry struct {
+	queue.WorkerRegistry
+	workers []queue.WorkerInfo
+	err     error
+}
+
+func (r stubRegistry) ListWorkers() ([]queue.WorkerInfo, error) {
+	return r.workers, r.err
+}
+
+func getWorkers(t *testing.T, registry queue.WorkerRegistry) *httptest.ResponseRecorder {
+	t.Helper()
+	router := mux.NewRouter()
+	NewWorkersAPI(registry).RegisterRoutes(router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/workers", nil))
+	return rec
+}
+
+func TestWorkersAPIListWorkers(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	now := time.Now()
+	for _, info := range []queue.WorkerInfo{
+		{ID: "relay-1", Hostname: "relay-a", StartedAt: now.Add(-time.Hour), LastHeartbeatAt: now, HeartbeatIntervalSeconds: 15},
+		{ID: "relay-2", Hostname: "relay-b", StartedAt: now.Add(-2 * time.Hour), LastHeartbeatAt: now.Add(-time.Hour), HeartbeatIntervalSeconds: 15},
+	} {
+		q.RegisterWorker(info)
+	}
+	q.SetWorkerID("relay-1")
+	q.Enqueue(&models.Message{ID: "msg-1", Status: models.StatusQueued, QueuedAt: now})
+	q.Dequeue(1)
+
+	rec := getWorkers(t, q)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /api/workers = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Workers []queue.WorkerInfo `json:"workers"`
+		Active  int                `json:"active"`
+		Total   int                `json:"total"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Active != 1 || body.Total != 2 || len(body.Workers) != 2 {
+		t.Fatalf("active %d, total %d, %d workers; want 1 of 2 active", body.Active, body.Total, len(body.Workers))
+	}
+	first, second := body.Workers[0], body.Workers[1]
+	if first.ID != "relay-1" || first.Status != queue.WorkerStatusActive || len(first.HeldMessages) != 1 || first.HeldMessages[0] != "msg-1" {
+		t.Errorf("first worker = %s %s holding %v, want relay-1 active holding msg-1", first.ID, first.Status, first.HeldMessages)
+	}
+	if second.ID != "relay-2" || second.Status != queue.WorkerStatusUnresponsive {
+		t.Errorf("second worker = %s %s, want relay-2 unresponsive", second.ID, second.Status)
+	}
+}
+
+func TestWorkersAPIListWorkersEmpty(t *testing.T) {
+	rec := getWorkers(t, stubRegistry{})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/workers = %d", rec.Code)
+	}
+	// No workers are listed as [] rather than null
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if string(body["workers"]) != "[]" || string(body["total"]) != "0" {
+		t.Errorf("workers %s, total %s; want an empty list", body["workers"], body["total"])
+	}
+}
+
+func TestWorkersAPIListWorkersError(t *testing.T) {
+	rec := getWorkers(t, stubRegistry{err: errors.New("database is down")})
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("GET /api/workers with a failing registry = %d, want 500", rec.Code)
+	}
+}
@@ -0,0 +1,143 @@
+// Package blobstore keeps attachment content outside the message, so queue rows
+// carry only a reference. Content is addressed by its SHA-256, which means an
+// attachment sent to many recipients, or in many messages, is stored once.
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"relay/internal/config"
+	"relay/pkg/models"
+)
+
+// ErrNotFound is returned when no blob is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+// ErrNoStore is returned when an attachment references a blob but no store is configured
+var ErrNoStore = errors.New("attachment references a blob but no attachment store is configured")
+
+// Store holds content-addressed blobs
+type Store interface {
+	// Put stores content and returns its key. Content that is already stored is
+	// not written again.
+	Put(ctx context.Context, content []byte) (string, error)
+	// Open streams the blob stored under key, or returns ErrNotFound
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key; deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Objects holds named objects, such as the retention archive, next to the
+// blobs. Names are slash separated paths; writing an object replaces any object
+// of the same name.
+type Objects interface {
+	PutObject(ctx context.Context, name string, content []byte) error
+	// OpenObject streams the object, or returns ErrNotFound
+	OpenObject(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// New opens the store selected by ATTACHMENT_STORE. The inline backend returns
+// a nil Store, which leaves attachment content in the message.
+func New(cfg config.AttachmentStoreConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", "inline":
+		return nil, nil
+	case "fs":
+		return NewFileStore(cfg.Path)
+	case "s3":
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unsupported ATTACHMENT_STORE %q (want inline, fs or s3)", cfg.Backend)
+	}
+}
+
+// Key returns the key content is stored under: its hex encoded SHA-256
+func Key(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// validKey reports whether key looks like one returned by Key. Keys become file
+// names and object paths, so anything else is refused.
+func validKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+// validName reports whether name is a relative slash separated path of plain
+// file names, which is safe as both a file path and an object key
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+		for _, r := range part {
+			if !(r == '-' || r == '_' || r == '.' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9')) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Externalize moves the content of each attachment into store, leaving the
+// blob key and size behind. Attachments already stored are left alone, and a
+// nil store keeps everything inline.
+func Externalize(ctx context.Context, store Store, attachments []models.Attachment) error {
+	if store == nil {
+		return nil
+	}
+	for i := range attachments {
+		att := &attachments[i]
+		if att.BlobKey != "" || len(att.Content) == 0 {
+			continue
+		}
+		key, err := store.Put(ctx, att.Content)
+		if err != nil {
+			return fmt.Errorf("failed to store attachment %s: %w", att.Name, err)
+		}
+		att.BlobKey = key
+		att.Size = int64(len(att.Content))
+		att.Content = nil
+	}
+	return nil
+}
+
+// Open streams an attachment's content, from the message when it is inline or
+// from store when the message holds only a reference
+func Open(ctx context.Context, store Store, att models.Attachment) (io.ReadCloser, error) {
+	if att.BlobKey == "" {
+		return io.NopCloser(bytes.NewReader(att.Content)), nil
+	}
+	if store == nil {
+		return nil, ErrNoStore
+	}
+	return store.Open(ctx, att.BlobKey)
+}
+
+// ReadAll returns an attachment's content, for callers such as JSON APIs that
+// need it in one piece
+func ReadAll(ctx context.Context, store Store, att models.Attachment) ([]byte, error) {
+	if att.BlobKey == "" {
+		return att.Content, nil
+	}
+	r, err := Open(ctx, store, att)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
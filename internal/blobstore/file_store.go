@@ -0,0 +1,115 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileStore keeps blobs as files under a root directory, fanned out by the
+// first two characters of the key so no directory grows too large
+type FileStore struct {
+	root string
+}
+
+// NewFileStore opens or creates a file store rooted at dir
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("attachment store path is empty")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create attachment store directory: %w", err)
+	}
+	return &FileStore{root: dir}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.root, key[:2], key)
+}
+
+// Put writes content to a temporary file and renames it into place, so a blob
+// is never visible half written
+func (s *FileStore) Put(ctx context.Context, content []byte) (string, error) {
+	key := Key(content)
+	path := s.path(key)
+	if _, err := os.Stat(path); err == nil {
+		return key, nil // Already stored
+	}
+	if err := writeFile(path, content); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// PutObject writes the object under the root like a blob, replacing it whole
+func (s *FileStore) PutObject(ctx context.Context, name string, content []byte) error {
+	if !validName(name) {
+		return fmt.Errorf("invalid object name %q", name)
+	}
+	return writeFile(filepath.Join(s.root, filepath.FromSlash(name)), content)
+}
+
+func (s *FileStore) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("invalid object name %q", name)
+	}
+	f, err := os.Open(filepath.Join(s.root, filepath.FromSlash(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// writeFile writes content to a synced temporary file next to path and renames
+// it into place
+func writeFile(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, fmt.Errorf("invalid blob key %q", key)
+	}
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"relay/internal/config"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty body, signed for GET, HEAD and DELETE
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Store keeps blobs as objects in an S3 bucket, or on any service speaking
+// the S3 API. Requests are signed with AWS Signature Version 4.
+type S3Store struct {
+	cfg      config.S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Store creates a store for the configured bucket. No request is made
+// until the first blob is stored or read.
+func NewS3Store(cfg config.S3Config) (*S3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("ATTACHMENT_S3_BUCKET is required for the s3 attachment store")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("ATTACHMENT_S3_ACCESS_KEY and ATTACHMENT_S3_SECRET_KEY are required for the s3 attachment store")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid ATTACHMENT_S3_ENDPOINT %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return &S3Store{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 60 * time.Second},
+		now:      time.Now,
+	}, nil
+}
+
+// objectURL returns the URL of the object holding key
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	object := s.cfg.Prefix + key
+	if s.cfg.PathStyle {
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + s.cfg.Bucket + "/" + object
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + object
+	}
+	return &u
+}
+
+// do sends a signed request for the object holding key
+func (s *S3Store) do(ctx context.Context, method, key string, body []byte, payloadHash string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key).String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	signRequest(req, s.cfg, payloadHash, s.now())
+	return s.client.Do(req)
+}
+
+// Put skips the upload when HEAD finds the object, which is stored under the
+// content hash and so cannot differ
+func (s *S3Store) Put(ctx context.Context, content []byte) (string, error) {
+	key := Key(content)
+
+	resp, err := s.do(ctx, http.MethodHead, key, nil, emptyPayloadHash)
+	if err != nil {
+		return "", fmt.Errorf("s3 HEAD failed: %w", err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return key, nil
+	case http.StatusNotFound:
+	default:
+		return "", fmt.Errorf("s3 HEAD returned status %d", resp.StatusCode)
+	}
+
+	// The payload hash is the key itself
+	resp, err = s.do(ctx, http.MethodPut, key, content, key)
+	if err != nil {
+		return "", fmt.Errorf("s3 PUT failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", s3Error("PUT", resp)
+	}
+	return key, nil
+}
+
+// Open returns the response body, so the object is streamed as it is read
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, fmt.Errorf("invalid blob key %q", key)
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, emptyPayloadHash)
+	if err != nil {
+		return nil, fmt.Errorf("s3 GET failed: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, s3Error("GET", resp)
+	}
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, emptyPayloadHash)
+	if err != nil {
+		return fmt.Errorf("s3 DELETE failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error("DELETE", resp)
+	}
+	return nil
+}
+
+// PutObject uploads the object, replacing any object of the same name
+func (s *S3Store) PutObject(ctx context.Context, name string, content []byte) error {
+	if !validName(name) {
+		return fmt.Errorf("invalid object name %q", name)
+	}
+	sum := sha256.Sum256(content)
+	resp, err := s.do(ctx, http.MethodPut, name, content, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return fmt.Errorf("s3 PUT failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("PUT", resp)
+	}
+	return nil
+}
+
+func (s *S3Store) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("invalid object name %q", name)
+	}
+	resp, err := s.do(ctx, http.MethodGet, name, nil, emptyPayloadHash)
+	if err != nil {
+		return nil, fmt.Errorf("s3 GET failed: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, s3Error("GET", resp)
+	}
+}
+
+// s3Error includes the start of the XML error document in the returned error
+func s3Error(method string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("s3 %s returned status %d: %s", method, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// signRequest adds the x-amz-date, x-amz-content-sha256 and Authorization
+// headers of AWS Signature Version 4. Every header already set on req is signed.
+func signRequest(req *http.Request, cfg config.S3Config, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+cfg.SecretKey), date)
+	key = hmacSHA256(key, cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath URI-encodes each segment of an object path the way S3 expects,
+// leaving only unreserved characters and the separating slashes as they are
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
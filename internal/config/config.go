@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"relay/internal/gateway"
@@ -18,6 +22,10 @@ type WorkspaceConfig struct {
 	Domains      []string                  `json:"domains,omitempty"`     // Multiple domains per workspace
 	DisplayName  string                    `json:"display_name"`
 	RateLimits   WorkspaceRateLimitConfig  `json:"rate_limits,omitempty"`
+	RetryPolicy  *RetryPolicy              `json:"retry_policy,omitempty"` // Overrides the global retry policy
+	Retention    *RetentionPolicy          `json:"retention,omitempty"`    // Overrides the default retention policy
+	MessageTTLSeconds map[string]int       `json:"message_ttl_seconds,omitempty"` // Time to live by email_type, e.g. {"otp": 600}
+	Failover     []string                  `json:"failover,omitempty"`     // Providers tried in order within one send attempt, by type or ID, e.g. ["gmail", "mailgun"]
 	LoadBalancing *WorkspaceLoadBalancingConfig `json:"load_balancing,omitempty"` // Load balancing configuration
 
 	// Gateway configurations - at least one must be specified
@@ -34,6 +42,12 @@ func (w *WorkspaceConfig) GetPrimaryDomain() string {
 	return w.Domain // Fallback to legacy single domain
 }
 
+// MessageTTL returns how long messages of emailType stay sendable, or zero when
+// they do not expire
+func (w *WorkspaceConfig) MessageTTL(emailType string) time.Duration {
+	return time.Duration(w.MessageTTLSeconds[emailType]) * time.Second
+}
+
 // GetCanRouteDomains returns the routing patterns for all domains
 func (w *WorkspaceConfig) GetCanRouteDomains() []string {
 	var routes []string
@@ -113,6 +127,9 @@ type WorkspaceGmailConfig struct {
 	RequireValidSender bool                          `json:"require_valid_sender,omitempty"` // Whether to validate sender emails
 	HeaderRewrite      WorkspaceGmailHeaderRewrite   `json:"header_rewrite,omitempty"`
 	EnableWebhooks     bool                          `json:"enable_webhooks"` // Enable webhook notifications
+	// RawPassthrough submits the original SMTP DATA bytes as the Gmail Raw payload,
+	// applying only From/header rewrites. Body personalization is skipped in this mode.
+	RawPassthrough bool `json:"raw_passthrough,omitempty"`
 }
 
 // WorkspaceGmailHeaderRewrite configures header rewriting for Gmail workspaces
@@ -191,6 +208,80 @@ type WorkspaceRateLimitConfig struct {
 	CustomUserLimits map[string]int `json:"custom_user_limits,omitempty"`
 }
 
+// RetryPolicy controls exponential backoff for transient send failures.
+// Zero fields in a workspace policy inherit the global value.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of send attempts, including the first
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// InitialDelaySeconds is the delay before the first retry
+	InitialDelaySeconds int `json:"initial_delay_seconds,omitempty"`
+
+	// MaxDelaySeconds caps the delay between attempts
+	MaxDelaySeconds int `json:"max_delay_seconds,omitempty"`
+
+	// Multiplier is applied to the delay after each attempt
+	Multiplier float64 `json:"multiplier,omitempty"`
+
+	// Jitter randomizes each delay by up to this fraction (0.0-1.0) in either direction
+	Jitter float64 `json:"jitter,omitempty"`
+}
+
+// Merge returns the policy with non-zero fields of override applied
+func (p RetryPolicy) Merge(override *RetryPolicy) RetryPolicy {
+	if override == nil {
+		return p
+	}
+	if override.MaxAttempts > 0 {
+		p.MaxAttempts = override.MaxAttempts
+	}
+	if override.InitialDelaySeconds > 0 {
+		p.InitialDelaySeconds = override.InitialDelaySeconds
+	}
+	if override.MaxDelaySeconds > 0 {
+		p.MaxDelaySeconds = override.MaxDelaySeconds
+	}
+	if override.Multiplier > 0 {
+		p.Multiplier = override.Multiplier
+	}
+	if override.Jitter > 0 {
+		p.Jitter = override.Jitter
+	}
+	return p
+}
+
+// RetentionPolicy controls how long finished (sent, dead, cancelled or expired) messages
+// keep their content. Each period is in days since the message finished; zero
+// or less keeps the data forever. Messages are archived before anything is removed.
+type RetentionPolicy struct {
+	// BodyDays strips the HTML, text and raw bodies
+	BodyDays int `json:"body_days,omitempty"`
+
+	// AttachmentDays strips the attachments
+	AttachmentDays int `json:"attachment_days,omitempty"`
+
+	// DeleteDays deletes the message and its attempt history
+	DeleteDays int `json:"delete_days,omitempty"`
+}
+
+// Merge returns the policy with non-zero fields of override applied; a
+// negative override keeps that data forever regardless of the default
+func (p RetentionPolicy) Merge(override *RetentionPolicy) RetentionPolicy {
+	if override == nil {
+		return p
+	}
+	if override.BodyDays != 0 {
+		p.BodyDays = override.BodyDays
+	}
+	if override.AttachmentDays != 0 {
+		p.AttachmentDays = override.AttachmentDays
+	}
+	if override.DeleteDays != 0 {
+		p.DeleteDays = override.DeleteDays
+	}
+	return p
+}
+
 // WorkspaceLoadBalancingConfig contains load balancing settings for a workspace
 type WorkspaceLoadBalancingConfig struct {
 	// Enabled indicates if this workspace participates in load balancing pools
@@ -216,15 +307,21 @@ type WorkspaceLoadBalancingConfig struct {
 }
 
 type Config struct {
-	SMTP    SMTPConfig
-	Gmail   GmailConfig
-	Gateway *EnhancedGatewayConfig // New gateway configuration
-	Queue   QueueConfig
-	Webhook WebhookConfig
-	LLM     LLMConfig
-	Server  ServerConfig
-	MySQL   MySQLConfig
-	Blaster BlasterConfig
+	SMTP     SMTPConfig
+	Gmail    GmailConfig
+	Gateway  *EnhancedGatewayConfig // New gateway configuration
+	Queue    QueueConfig
+	Webhook  WebhookConfig
+	LLM      LLMConfig
+	Server   ServerConfig
+	MySQL    MySQLConfig
+	Database DatabaseConfig
+	Blaster  BlasterConfig
+
+	Attachments AttachmentStoreConfig
+	Retention   RetentionConfig
+
+	MandrillAPI MandrillAPIConfig
 }
 
 type SMTPConfig struct {
@@ -241,11 +338,68 @@ type GmailConfig struct {
 }
 
 type QueueConfig struct {
+	Backend         string // mysql, postgres, redis, file or memory; all but memory fall back to file when unreachable
 	ProcessInterval time.Duration
 	BatchSize       int
 	MaxRetries      int
-	StoragePath     string
+	StoragePath     string // Directory of the file queue
 	DailyRateLimit  int
+	Retry           RetryPolicy   // Global retry policy; MaxAttempts defaults to MaxRetries
+	LeaseDuration   time.Duration // How long a dequeued message is held before another worker may take it
+	ReaperInterval  time.Duration // How often expired leases are returned to the queue
+
+	WorkerID          string        // Identifies this replica in locked_by and the worker registry; defaults to hostname-pid
+	HeartbeatInterval time.Duration // How often this replica reports to the worker registry
+
+	Concurrency ConcurrencyConfig
+
+	PriorityWeights map[string]int // Share of each dequeue batch per priority lane (high, normal, low)
+
+	WorkspaceWeights  map[string]int // Share of each dequeue batch per workspace; unlisted workspaces weigh 1
+	FairShareBySender bool           // Also share each workspace's slots evenly between its senders
+
+	DedupWindow time.Duration // How long an idempotency key or Message-ID keeps retries from being queued again; zero disables
+
+	Redis RedisConfig // Used by the redis backend
+}
+
+// RedisConfig locates the Redis server behind the redis queue backend
+type RedisConfig struct {
+	Addr      string
+	Password  string
+	DB        int
+	KeyPrefix string // Prepended to every key, so several relays can share one server
+	PoolSize  int    // Idle connections kept open
+}
+
+// ConcurrencyConfig bounds how many messages are sent at once, overall and per
+// provider and workspace, so one slow provider cannot hold up the others
+type ConcurrencyConfig struct {
+	Workers            int            // Size of the send worker pool
+	PerProvider        int            // Default concurrent sends per provider
+	PerWorkspace       int            // Default concurrent sends per workspace
+	ProviderOverrides  map[string]int // Keyed by provider ID or provider type (gmail, mailgun, mandrill)
+	WorkspaceOverrides map[string]int // Keyed by workspace ID
+}
+
+// ProviderLimit returns the concurrency limit for a provider, preferring an override
+// for its ID over one for its type
+func (c ConcurrencyConfig) ProviderLimit(providerID, providerType string) int {
+	if limit, ok := c.ProviderOverrides[providerID]; ok && limit > 0 {
+		return limit
+	}
+	if limit, ok := c.ProviderOverrides[providerType]; ok && limit > 0 {
+		return limit
+	}
+	return c.PerProvider
+}
+
+// WorkspaceLimit returns the concurrency limit for a workspace
+func (c ConcurrencyConfig) WorkspaceLimit(workspaceID string) int {
+	if limit, ok := c.WorkspaceOverrides[workspaceID]; ok && limit > 0 {
+		return limit
+	}
+	return c.PerWorkspace
 }
 
 type WebhookConfig struct {
@@ -269,6 +423,12 @@ type ServerConfig struct {
 	WebUIPort   int
 }
 
+// MandrillAPIConfig configures the Mandrill-compatible HTTP API
+type MandrillAPIConfig struct {
+	Enabled bool
+	Keys    []string // Accepted API keys; requests with any other key are rejected
+}
+
 type MySQLConfig struct {
 	Host     string
 	Port     int
@@ -283,6 +443,66 @@ func (m *MySQLConfig) GetDSN() string {
 		m.User, m.Password, m.Host, m.Port, m.Database)
 }
 
+// RetentionConfig drives the background job that archives and purges finished messages
+type RetentionConfig struct {
+	Enabled         bool
+	Interval        time.Duration   // How often the job runs
+	ArchiveStore    string          // fs or s3; rows are purged once archived, so every replica must reach the same archive
+	ArchivePath     string          // Directory of the compressed NDJSON archives with the fs store
+	ArchiveS3Prefix string          // Prefix of the archive objects in the s3 attachment store's bucket
+	BatchSize       int             // Messages archived per query
+	Default         RetentionPolicy // Applies to workspaces without their own policy
+}
+
+// AttachmentStoreConfig selects where attachment content is kept. With the
+// inline backend it stays in the message; fs and s3 store each distinct
+// attachment once, keyed by its SHA-256, and the message keeps a reference.
+type AttachmentStoreConfig struct {
+	Backend     string        // inline, fs or s3
+	Path        string        // Root directory of the fs backend
+	GracePeriod time.Duration // How long a blob no message references is kept before it is deleted
+	S3          S3Config
+}
+
+// S3Config locates a bucket on S3 or an S3-compatible service such as MinIO
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com or http://minio:9000
+	Region    string
+	Bucket    string
+	Prefix    string // Prepended to every object key
+	AccessKey string
+	SecretKey string
+	PathStyle bool // Address the bucket in the path rather than the host name, as MinIO expects
+}
+
+// DatabaseConfig selects the SQL database shared by the queue, recipient
+// tracking and provider management
+type DatabaseConfig struct {
+	Driver   string // mysql or postgres
+	Postgres PostgresConfig
+}
+
+type PostgresConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+	SSLMode  string
+}
+
+// GetDSN returns the PostgreSQL connection URL
+func (p *PostgresConfig) GetDSN() string {
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(p.User, p.Password),
+		Host:     net.JoinHostPort(p.Host, strconv.Itoa(p.Port)),
+		Path:     "/" + p.Database,
+		RawQuery: url.Values{"sslmode": {p.SSLMode}}.Encode(),
+	}
+	return dsn.String()
+}
+
 type BlasterConfig struct {
 	BaseURL string
 	APIKey  string
@@ -303,11 +523,47 @@ func Load() (*Config, error) {
 		Gmail:   loadGmailConfig(),
 		Gateway: loadGatewayConfig(),
 		Queue: QueueConfig{
+			Backend:         getEnvString("QUEUE_BACKEND", getEnvString("DB_DRIVER", "mysql")),
 			ProcessInterval: getEnvDuration("QUEUE_PROCESS_INTERVAL", 30*time.Second),
 			BatchSize:       getEnvInt("QUEUE_BATCH_SIZE", 10),
 			MaxRetries:      getEnvInt("QUEUE_MAX_RETRIES", 3),
 			StoragePath:     getEnvString("QUEUE_STORAGE_PATH", "./data/queue"),
 			DailyRateLimit:  getEnvInt("QUEUE_DAILY_RATE_LIMIT", 2000),
+			Retry: RetryPolicy{
+				MaxAttempts:         getEnvInt("QUEUE_MAX_RETRIES", 3),
+				InitialDelaySeconds: int(getEnvDuration("QUEUE_RETRY_INITIAL_DELAY", time.Minute).Seconds()),
+				MaxDelaySeconds:     int(getEnvDuration("QUEUE_RETRY_MAX_DELAY", time.Hour).Seconds()),
+				Multiplier:          getEnvFloat("QUEUE_RETRY_MULTIPLIER", 2.0),
+				Jitter:              getEnvFloat("QUEUE_RETRY_JITTER", 0.2),
+			},
+			LeaseDuration:  getEnvDuration("QUEUE_LEASE_DURATION", 5*time.Minute),
+			ReaperInterval: getEnvDuration("QUEUE_REAPER_INTERVAL", time.Minute),
+
+			WorkerID:          getEnvString("QUEUE_WORKER_ID", ""),
+			HeartbeatInterval: getEnvDuration("QUEUE_WORKER_HEARTBEAT_INTERVAL", 15*time.Second),
+
+			Concurrency: ConcurrencyConfig{
+				Workers:            getEnvInt("QUEUE_WORKERS", 10),
+				PerProvider:        getEnvInt("QUEUE_PROVIDER_CONCURRENCY", 4),
+				PerWorkspace:       getEnvInt("QUEUE_WORKSPACE_CONCURRENCY", 4),
+				ProviderOverrides:  getEnvIntMap("QUEUE_PROVIDER_CONCURRENCY_OVERRIDES"),
+				WorkspaceOverrides: getEnvIntMap("QUEUE_WORKSPACE_CONCURRENCY_OVERRIDES"),
+			},
+
+			PriorityWeights: getEnvIntMap("QUEUE_PRIORITY_WEIGHTS"),
+
+			WorkspaceWeights:  getEnvIntMap("QUEUE_WORKSPACE_WEIGHTS"),
+			FairShareBySender: getEnvBool("QUEUE_FAIR_SHARE_BY_SENDER", false),
+
+			DedupWindow: getEnvDuration("QUEUE_DEDUP_WINDOW", 24*time.Hour),
+
+			Redis: RedisConfig{
+				Addr:      getEnvString("REDIS_ADDR", "localhost:6379"),
+				Password:  getEnvString("REDIS_PASSWORD", ""),
+				DB:        getEnvInt("REDIS_DB", 0),
+				KeyPrefix: getEnvString("REDIS_KEY_PREFIX", "relay:"),
+				PoolSize:  getEnvInt("REDIS_POOL_SIZE", 10),
+			},
 		},
 		Webhook: WebhookConfig{
 			MandrillURL: getEnvString("MANDRILL_WEBHOOK_URL", ""),
@@ -334,10 +590,52 @@ func Load() (*Config, error) {
 			Password: getEnvString("MYSQL_PASSWORD", ""),
 			Database: getEnvString("MYSQL_DATABASE", "relay"),
 		},
+		Database: DatabaseConfig{
+			Driver: getEnvString("DB_DRIVER", "mysql"),
+			Postgres: PostgresConfig{
+				Host:     getEnvString("POSTGRES_HOST", "localhost"),
+				Port:     getEnvInt("POSTGRES_PORT", 5432),
+				User:     getEnvString("POSTGRES_USER", "postgres"),
+				Password: getEnvString("POSTGRES_PASSWORD", ""),
+				Database: getEnvString("POSTGRES_DATABASE", "relay"),
+				SSLMode:  getEnvString("POSTGRES_SSLMODE", "disable"),
+			},
+		},
 		Blaster: BlasterConfig{
 			BaseURL: getEnvString("BLASTER_BASE_URL", "http://localhost:3034"),
 			APIKey:  getEnvString("BLASTER_API_KEY", ""),
 		},
+		MandrillAPI: MandrillAPIConfig{
+			Enabled: getEnvBool("MANDRILL_API_ENABLED", false),
+			Keys:    getEnvStringSlice("MANDRILL_API_KEYS", nil),
+		},
+		Attachments: AttachmentStoreConfig{
+			Backend:     getEnvString("ATTACHMENT_STORE", "inline"),
+			Path:        getEnvString("ATTACHMENT_STORE_PATH", "./data/attachments"),
+			GracePeriod: getEnvDuration("ATTACHMENT_GC_GRACE", time.Hour),
+			S3: S3Config{
+				Endpoint:  getEnvString("ATTACHMENT_S3_ENDPOINT", "https://s3.amazonaws.com"),
+				Region:    getEnvString("ATTACHMENT_S3_REGION", "us-east-1"),
+				Bucket:    getEnvString("ATTACHMENT_S3_BUCKET", ""),
+				Prefix:    getEnvString("ATTACHMENT_S3_PREFIX", "attachments/"),
+				AccessKey: getEnvString("ATTACHMENT_S3_ACCESS_KEY", ""),
+				SecretKey: getEnvString("ATTACHMENT_S3_SECRET_KEY", ""),
+				PathStyle: getEnvBool("ATTACHMENT_S3_PATH_STYLE", true),
+			},
+		},
+		Retention: RetentionConfig{
+			Enabled:         getEnvBool("RETENTION_ENABLED", false),
+			Interval:        getEnvDuration("RETENTION_INTERVAL", time.Hour),
+			ArchiveStore:    getEnvString("RETENTION_ARCHIVE_STORE", "fs"),
+			ArchivePath:     getEnvString("RETENTION_ARCHIVE_PATH", "./data/archive"),
+			ArchiveS3Prefix: getEnvString("RETENTION_ARCHIVE_S3_PREFIX", "archive/"),
+			BatchSize:       getEnvInt("RETENTION_BATCH_SIZE", 500),
+			Default: RetentionPolicy{
+				BodyDays:       getEnvInt("RETENTION_BODY_DAYS", 0),
+				AttachmentDays: getEnvInt("RETENTION_ATTACHMENT_DAYS", 0),
+				DeleteDays:     getEnvInt("RETENTION_DELETE_DAYS", 0),
+			},
+		},
 	}
 
 	return cfg, nil
@@ -380,6 +678,45 @@ func getEnvBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		var f float64
+		if err := json.Unmarshal([]byte(value), &f); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+func getEnvStringSlice(key string, defaultValue []string) []string {
+	if value := os.Getenv(key); value != "" {
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+	return defaultValue
+}
+
+// getEnvIntMap parses "key=value" pairs separated by commas, e.g. "gmail=2,mailgun=8"
+func getEnvIntMap(key string) map[string]int {
+	values := make(map[string]int)
+	for _, pair := range getEnvStringSlice(key, nil) {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		var n int
+		if err := json.Unmarshal([]byte(strings.TrimSpace(value)), &n); err == nil {
+			values[strings.TrimSpace(name)] = n
+		}
+	}
+	return values
+}
+
 func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if d, err := time.ParseDuration(value); err == nil {
@@ -0,0 +1,35 @@
+// Package database opens the SQL database shared by the queue, recipient
+// tracking and provider management, and hides the differences between MySQL
+// and PostgreSQL behind a small Dialect.
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"relay/internal/config"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+var errNoPostgresDriver = errors.New("no PostgreSQL driver is linked into this build; rebuild with -tags postgres")
+
+// Open opens the database selected by DB_DRIVER. The connection is not
+// checked; callers Ping it once they have configured the pool.
+func Open(cfg *config.Config) (*sql.DB, error) {
+	switch cfg.Database.Driver {
+	case "", string(MySQL):
+		return sql.Open("mysql", cfg.MySQL.GetDSN())
+	case string(Postgres):
+		return OpenPostgres(&cfg.Database.Postgres)
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q (want mysql or postgres)", cfg.Database.Driver)
+	}
+}
+
+// OpenPostgres opens the PostgreSQL database, for the queue backend to use even
+// when DB_DRIVER still points the rest of the relay at MySQL
+func OpenPostgres(cfg *config.PostgresConfig) (*sql.DB, error) {
+	return openPostgres(cfg.GetDSN())
+}
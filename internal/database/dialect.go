@@ -0,0 +1,188 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Dialect identifies the SQL flavour behind a *sql.DB. Queries throughout the
+// relay are written with ? placeholders and portable SQL; the few statements
+// that differ between MySQL and PostgreSQL are built with these helpers.
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+)
+
+// For reports the dialect of a database opened by this package. Anything not
+// opened through OpenPostgres is treated as MySQL.
+func For(db *sql.DB) Dialect {
+	if db == nil {
+		return MySQL
+	}
+	if _, ok := db.Driver().(*postgresDriver); ok {
+		return Postgres
+	}
+	return MySQL
+}
+
+// Upsert returns the clause that turns an INSERT into an update of the given
+// columns when a row with the same conflict key already exists
+func (d Dialect) Upsert(conflict []string, update ...string) string {
+	sets := make([]string, len(update))
+	for i, column := range update {
+		if d == Postgres {
+			sets[i] = column + " = EXCLUDED." + column
+		} else {
+			sets[i] = column + " = VALUES(" + column + ")"
+		}
+	}
+	if d == Postgres {
+		return "ON CONFLICT (" + strings.Join(conflict, ", ") + ") DO UPDATE SET " + strings.Join(sets, ", ")
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+// Excluded refers to the value an upsert tried to insert into column
+func (d Dialect) Excluded(column string) string {
+	if d == Postgres {
+		return "EXCLUDED." + column
+	}
+	return "VALUES(" + column + ")"
+}
+
+// OnConflictIgnore returns the clause that makes an INSERT skip rows that
+// would duplicate a unique key, in place of MySQL's INSERT IGNORE
+func (d Dialect) OnConflictIgnore(column string) string {
+	if d == Postgres {
+		return "ON CONFLICT DO NOTHING"
+	}
+	return "ON DUPLICATE KEY UPDATE " + column + " = " + column
+}
+
+// Inserter is implemented by *sql.DB and *sql.Tx
+type Inserter interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// InsertID runs an INSERT into a table with an auto-increment id column and
+// returns the new id. PostgreSQL drivers do not support LastInsertId, so the
+// id is read back with RETURNING instead.
+func (d Dialect) InsertID(db Inserter, query string, args ...interface{}) (int64, error) {
+	if d == Postgres {
+		var id int64
+		err := db.QueryRow(strings.TrimRight(query, " \t\n;")+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// JSONValue extracts the JSON value at path (e.g. "mc_metadata", "team") from a JSON column
+func (d Dialect) JSONValue(column string, path ...string) string {
+	if d == Postgres {
+		return "(" + column + "::jsonb #> '{" + strings.Join(path, ",") + "}')"
+	}
+	return "JSON_EXTRACT(" + column + ", '$." + strings.Join(path, ".") + "')"
+}
+
+// JSONText extracts the value at path from a JSON column as unquoted text
+func (d Dialect) JSONText(column string, path ...string) string {
+	if d == Postgres {
+		return "(" + column + "::jsonb #>> '{" + strings.Join(path, ",") + "}')"
+	}
+	return "JSON_UNQUOTE(" + d.JSONValue(column, path...) + ")"
+}
+
+// JSONContains is true when the JSON array expr contains the string bound to
+// the following ? placeholder
+func (d Dialect) JSONContains(expr string) string {
+	if d == Postgres {
+		return "(" + expr + ")::jsonb @> jsonb_build_array(CAST(? AS TEXT))"
+	}
+	return "JSON_CONTAINS(" + expr + ", JSON_QUOTE(?))"
+}
+
+// JSONMerge merges the JSON object literal patch into a JSON column, treating
+// a NULL column as an empty object
+func (d Dialect) JSONMerge(column, patch string) string {
+	if d == Postgres {
+		return "(COALESCE(" + column + "::jsonb, '{}'::jsonb) || '" + patch + "'::jsonb)"
+	}
+	return "JSON_MERGE_PATCH(COALESCE(" + column + ", '{}'), '" + patch + "')"
+}
+
+// dateFormatVerbs maps the MySQL DATE_FORMAT specifiers used by the relay to to_char patterns
+var dateFormatVerbs = map[byte]string{
+	'Y': "YYYY",
+	'm': "MM",
+	'd': "DD",
+	'H': "HH24",
+	'i': "MI",
+	's': "SS",
+}
+
+// DateFormat formats a timestamp column with a MySQL DATE_FORMAT layout such
+// as "%Y-%m-%d %H:00". Only the specifiers in dateFormatVerbs are supported.
+func (d Dialect) DateFormat(column, layout string) string {
+	if d != Postgres {
+		return "DATE_FORMAT(" + column + ", '" + layout + "')"
+	}
+	var b strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] == '%' && i+1 < len(layout) {
+			if verb, ok := dateFormatVerbs[layout[i+1]]; ok {
+				b.WriteString(verb)
+				i++
+				continue
+			}
+		}
+		b.WriteByte(layout[i])
+	}
+	return "to_char(" + column + ", '" + b.String() + "')"
+}
+
+// Milliseconds returns the time from one timestamp column to another in milliseconds
+func (d Dialect) Milliseconds(from, to string) string {
+	if d == Postgres {
+		return "(EXTRACT(EPOCH FROM (" + to + " - " + from + ")) * 1000)"
+	}
+	return "(TIMESTAMPDIFF(MICROSECOND, " + from + ", " + to + ") / 1000)"
+}
+
+// Rebind rewrites ? placeholders to PostgreSQL's numbered $1, $2, ... form,
+// leaving question marks inside quoted strings and identifiers alone
+func Rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
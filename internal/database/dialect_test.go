@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT id FROM messages", "SELECT id FROM messages"},
+		{"SELECT id FROM messages WHERE status = ? AND provider_id = ?", "SELECT id FROM messages WHERE status = $1 AND provider_id = $2"},
+		{"SELECT '?' AS q, \"a?b\" FROM t WHERE x = ?", "SELECT '?' AS q, \"a?b\" FROM t WHERE x = $1"},
+		{"UPDATE t SET note = 'it''s ?' WHERE id IN (?, ?)", "UPDATE t SET note = 'it''s ?' WHERE id IN ($1, $2)"},
+	}
+	for _, tt := range tests {
+		if got := Rebind(tt.query); got != tt.want {
+			t.Errorf("Rebind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestDialectClauses(t *testing.T) {
+	if got := MySQL.Upsert([]string{"id"}, "hostname", "pid"); got != "ON DUPLICATE KEY UPDATE hostname = VALUES(hostname), pid = VALUES(pid)" {
+		t.Errorf("MySQL upsert = %q", got)
+	}
+	if got := Postgres.Upsert([]string{"id"}, "hostname", "pid"); got != "ON CONFLICT (id) DO UPDATE SET hostname = EXCLUDED.hostname, pid = EXCLUDED.pid" {
+		t.Errorf("Postgres upsert = %q", got)
+	}
+	if got := Postgres.DateFormat("queued_at", "%Y-%m-%d %H:00"); got != "to_char(queued_at, 'YYYY-MM-DD HH24:00')" {
+		t.Errorf("Postgres date format = %q", got)
+	}
+	if got := Postgres.JSONText("m.metadata", "mc_metadata", "team"); got != "(m.metadata::jsonb #>> '{mc_metadata,team}')" {
+		t.Errorf("Postgres JSON text = %q", got)
+	}
+}
+
+// recordingDriver stands in for pgx, remembering the SQL it was asked to prepare
+type recordingDriver struct{ queries []string }
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) { return &recordingConn{d}, nil }
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.queries = append(c.d.queries, query)
+	return recordingStmt{}, nil
+}
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type recordingStmt struct{}
+
+func (recordingStmt) Close() error                               { return nil }
+func (recordingStmt) NumInput() int                              { return -1 }
+func (recordingStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (recordingStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestOpenPostgresRebindsQueries(t *testing.T) {
+	base := &recordingDriver{}
+	sql.Register("pgx", base)
+
+	db, err := openPostgres("postgres://localhost/relay")
+	if err != nil {
+		t.Fatalf("openPostgres: %v", err)
+	}
+	defer db.Close()
+
+	if For(db) != Postgres {
+		t.Errorf("For(db) = %s, want postgres", For(db))
+	}
+	if _, err := db.Exec("UPDATE messages SET status = ? WHERE id = ?", "sent", "msg-1"); err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+	if len(base.queries) != 1 || base.queries[0] != "UPDATE messages SET status = $1 WHERE id = $2" {
+		t.Errorf("driver saw %q", base.queries)
+	}
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+)
+
+// postgresDrivers are the database/sql driver names tried, in order, for
+// PostgreSQL: pgx's stdlib adapter and lib/pq. Neither is linked by default;
+// build the server with -tags postgres to include pgx.
+var postgresDrivers = []string{"pgx", "postgres"}
+
+// postgresDriver wraps a registered PostgreSQL driver so the ? placeholders
+// used throughout the relay are rewritten to $1, $2, ... before they reach it
+type postgresDriver struct {
+	base driver.Driver
+}
+
+func (d *postgresDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.base.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresConn{Conn: conn}, nil
+}
+
+// postgresConnector opens rebinding connections through the base driver's
+// connector when it has one
+type postgresConnector struct {
+	driver *postgresDriver
+	base   driver.Connector
+	dsn    string
+}
+
+func (c *postgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if c.base == nil {
+		return c.driver.Open(c.dsn)
+	}
+	conn, err := c.base.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresConn{Conn: conn}, nil
+}
+
+func (c *postgresConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// postgresConn rebinds every query and forwards the optional driver interfaces
+// database/sql looks for. driver.ErrSkip makes database/sql fall back to
+// preparing the statement when the base connection lacks a fast path.
+type postgresConn struct {
+	driver.Conn
+}
+
+func (c *postgresConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(Rebind(query))
+}
+
+func (c *postgresConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, Rebind(query))
+	}
+	return c.Conn.Prepare(Rebind(query))
+}
+
+func (c *postgresConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *postgresConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := c.Conn.(driver.QueryerContext); ok {
+		return queryer.QueryContext(ctx, Rebind(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *postgresConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := c.Conn.(driver.ExecerContext); ok {
+		return execer.ExecContext(ctx, Rebind(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *postgresConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+func (c *postgresConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *postgresConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *postgresConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// openPostgres opens dsn with the first registered PostgreSQL driver, wrapped
+// so queries written for MySQL placeholders run unchanged
+func openPostgres(dsn string) (*sql.DB, error) {
+	for _, name := range postgresDrivers {
+		probe, err := sql.Open(name, dsn)
+		if err != nil {
+			continue // Not registered
+		}
+		base := probe.Driver()
+		probe.Close()
+
+		pg := &postgresDriver{base: base}
+		connector := &postgresConnector{driver: pg, dsn: dsn}
+		if ctxDriver, ok := base.(driver.DriverContext); ok {
+			if connector.base, err = ctxDriver.OpenConnector(dsn); err != nil {
+				return nil, err
+			}
+		}
+		return sql.OpenDB(connector), nil
+	}
+	return nil, errNoPostgresDriver
+}
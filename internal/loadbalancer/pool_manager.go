@@ -8,6 +8,7 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -508,7 +509,7 @@ func (pm *PoolManager) GetPoolStats(ctx context.Context, poolID string, hours in
 			COUNT(DISTINCT provider_id) as workspaces_used,
 			COUNT(DISTINCT sender_email) as unique_senders
 		FROM load_balancing_selections
-		WHERE pool_id = ? AND selected_at >= DATE_SUB(NOW(), INTERVAL ? HOUR)`
+		WHERE pool_id = ? AND selected_at >= ?`
 
 	var stats struct {
 		TotalSelections      int     `db:"total_selections"`
@@ -518,7 +519,7 @@ func (pm *PoolManager) GetPoolStats(ctx context.Context, poolID string, hours in
 		UniqueSenders        int     `db:"unique_senders"`
 	}
 
-	err := pm.db.GetContext(ctx, &stats, query, poolID, hours)
+	err := pm.db.GetContext(ctx, &stats, query, poolID, time.Now().Add(-time.Duration(hours)*time.Hour))
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("failed to query pool stats: %w", err)
 	}
@@ -0,0 +1,318 @@
+package mimeparser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+
+	"relay/pkg/models"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// maxPartDepth limits how deeply nested multipart bodies are walked
+const maxPartDepth = 10
+
+// Header is a single top-level header field with folding removed.
+// Values are kept as they appeared on the wire; use DecodeHeaderValue
+// for RFC 2047 encoded-word decoding of unstructured fields.
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Message is the decoded form of a raw RFC 5322 message
+type Message struct {
+	Headers     []Header
+	HTML        string
+	Text        string
+	Attachments []models.Attachment
+}
+
+// Get returns the first header value matching name (case-insensitive)
+func (m *Message) Get(name string) string {
+	for _, h := range m.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// wordDecoder decodes RFC 2047 encoded-words, including non UTF-8 charsets
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported charset %s: %w", charset, err)
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+// DecodeHeaderValue decodes RFC 2047 encoded-words in a header value.
+// If decoding fails the original value is returned unchanged.
+func DecodeHeaderValue(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		log.Printf("Warning: Failed to decode header value %q: %v", value, err)
+		return value
+	}
+	return decoded
+}
+
+// Parse decodes a raw message into headers, text/HTML bodies and attachments.
+// Multipart bodies are walked recursively, every transfer encoding is decoded
+// and text parts are converted to UTF-8.
+func Parse(data []byte) (*Message, error) {
+	headerBlock, body := SplitHeaderBody(data)
+	headers := parseHeaderBlock(headerBlock)
+
+	mimeHeader := make(textproto.MIMEHeader)
+	for _, h := range headers {
+		mimeHeader.Add(h.Name, h.Value)
+	}
+
+	msg := &Message{Headers: headers}
+	if err := msg.walkPart(mimeHeader, bytes.NewReader(body), 0); err != nil {
+		return nil, err
+	}
+
+	msg.HTML = strings.TrimSpace(msg.HTML)
+	msg.Text = strings.TrimSpace(msg.Text)
+	return msg, nil
+}
+
+// SplitHeaderBody splits a raw message at the first empty line into the
+// header block (without the separator) and the body
+func SplitHeaderBody(data []byte) ([]byte, []byte) {
+	for _, sep := range [][]byte{[]byte("\r\n\r\n"), []byte("\n\n")} {
+		if idx := bytes.Index(data, sep); idx >= 0 {
+			return data[:idx], data[idx+len(sep):]
+		}
+	}
+	// Headers only, or a message that starts with an empty line
+	if bytes.HasPrefix(data, []byte("\r\n")) {
+		return nil, data[2:]
+	}
+	if bytes.HasPrefix(data, []byte("\n")) {
+		return nil, data[1:]
+	}
+	return data, nil
+}
+
+// parseHeaderBlock parses header fields, unfolding continuation lines (RFC 5322 2.2.3)
+func parseHeaderBlock(block []byte) []Header {
+	var headers []Header
+	var current *Header
+
+	for _, line := range strings.Split(string(block), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && current != nil {
+			current.Value += " " + strings.TrimSpace(line)
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		headers = append(headers, Header{
+			Name:  strings.TrimSpace(parts[0]),
+			Value: strings.TrimSpace(parts[1]),
+		})
+		current = &headers[len(headers)-1]
+	}
+
+	return headers
+}
+
+// walkPart decodes a single MIME entity, recursing into multipart containers
+func (m *Message) walkPart(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	if depth > maxPartDepth {
+		return fmt.Errorf("MIME structure nested deeper than %d levels", maxPartDepth)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType == "" {
+		// RFC 2045 5.2: default to text/plain when Content-Type is missing or invalid
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	// A multipart body whose parts cannot be found is kept as text rather than
+	// rejecting the whole message
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if params["boundary"] == "" {
+			log.Printf("Warning: %s body without boundary parameter, reading it as text/plain", mediaType)
+		} else {
+			raw, err := io.ReadAll(body)
+			if err != nil {
+				return fmt.Errorf("failed to read %s body: %w", mediaType, err)
+			}
+			walked, err := m.walkMultipart(mediaType, raw, params["boundary"], depth)
+			if walked || err != nil {
+				return err
+			}
+			body = bytes.NewReader(raw)
+		}
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	content, err := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+	if err == io.ErrUnexpectedEOF {
+		// The last part of an unclosed multipart body, or truncated base64
+		log.Printf("Warning: %s part ends early, keeping its first %d bytes", mediaType, len(content))
+	} else if err != nil {
+		return fmt.Errorf("failed to decode %s part: %w", mediaType, err)
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	filename = DecodeHeaderValue(filename)
+
+	isBody := (mediaType == "text/plain" || mediaType == "text/html") &&
+		disposition != "attachment" && filename == ""
+
+	if isBody {
+		text := toUTF8(content, params["charset"])
+		if mediaType == "text/html" {
+			m.HTML = appendBody(m.HTML, text)
+		} else {
+			m.Text = appendBody(m.Text, text)
+		}
+		return nil
+	}
+
+	contentID := strings.Trim(header.Get("Content-Id"), "<> ")
+	attachmentType := "attachment"
+	if disposition == "inline" || (disposition == "" && contentID != "") {
+		attachmentType = "inline"
+	}
+
+	if filename == "" {
+		filename = defaultFilename(mediaType, len(m.Attachments)+1)
+	}
+
+	m.Attachments = append(m.Attachments, models.Attachment{
+		Name:        filename,
+		Type:        attachmentType,
+		Content:     content,
+		ContentType: mediaType,
+		ContentID:   contentID,
+	})
+	return nil
+}
+
+// walkMultipart walks the parts of a multipart body. It reports false, having
+// walked nothing, when no part is delimited by boundary. Parts read before a
+// missing closing boundary are kept.
+func (m *Message) walkMultipart(mediaType string, raw []byte, boundary string, depth int) (bool, error) {
+	reader := multipart.NewReader(bytes.NewReader(raw), boundary)
+	parts := 0
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			if parts == 0 {
+				log.Printf("Warning: %s body has no part delimited by %q, reading it as text/plain: %v", mediaType, boundary, err)
+				return false, nil
+			}
+			log.Printf("Warning: %s body is not closed by %q, keeping its %d part(s): %v", mediaType, boundary, parts, err)
+			return true, nil
+		}
+		parts++
+		if err := m.walkPart(part.Header, part, depth+1); err != nil {
+			return true, err
+		}
+	}
+}
+
+// decodeTransferEncoding wraps r with a decoder for the given Content-Transfer-Encoding
+func decodeTransferEncoding(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// The standard decoder skips CR and LF, so wrapped lines decode directly
+		return base64.NewDecoder(base64.StdEncoding, &base64Sanitizer{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		// 7bit, 8bit and binary need no decoding
+		return r
+	}
+}
+
+// base64Sanitizer drops whitespace that some clients emit inside base64 bodies
+type base64Sanitizer struct {
+	r io.Reader
+}
+
+func (s *base64Sanitizer) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	out := 0
+	for i := 0; i < n; i++ {
+		switch p[i] {
+		case ' ', '\t':
+			continue
+		}
+		p[out] = p[i]
+		out++
+	}
+	return out, err
+}
+
+// toUTF8 converts text content in the given charset to UTF-8
+func toUTF8(content []byte, charset string) string {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return string(content)
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		log.Printf("Warning: Unknown charset %s, keeping raw bytes", charset)
+		return string(content)
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(content)
+	if err != nil {
+		log.Printf("Warning: Failed to convert %s body to UTF-8: %v", charset, err)
+		return string(content)
+	}
+	return string(decoded)
+}
+
+// appendBody joins multiple text parts of the same type (e.g. in multipart/mixed)
+func appendBody(existing, text string) string {
+	if existing == "" {
+		return text
+	}
+	return existing + "\n" + text
+}
+
+// defaultFilename builds a name for parts that don't carry one
+func defaultFilename(mediaType string, index int) string {
+	ext := ".bin"
+	if mediaType == "text/calendar" {
+		ext = ".ics"
+	} else if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		ext = exts[0]
+	}
+	return fmt.Sprintf("part-%d%s", index, ext)
+}
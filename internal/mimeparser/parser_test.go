@@ -0,0 +1,160 @@
+package mimeparser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParse_SinglePartQuotedPrintable(t *testing.T) {
+	raw := "From: sender@example.com\r\n" +
+		"Subject: =?UTF-8?B?SGVsbG8gV8O2cmxk?=\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Caf=C3=A9 soft=\r\n" +
+		"break\r\n"
+
+	msg, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	if got := msg.Text; got != "Café softbreak" {
+		t.Errorf("Text = %q, want %q", got, "Café softbreak")
+	}
+	if got := DecodeHeaderValue(msg.Get("subject")); got != "Hello Wörld" {
+		t.Errorf("decoded subject = %q, want %q", got, "Hello Wörld")
+	}
+}
+
+func TestParse_FoldedHeaders(t *testing.T) {
+	raw := "Subject: first\r\n second\r\n\tthird\r\nX-Custom: value\r\n\r\nbody"
+
+	msg, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	if got := msg.Get("Subject"); got != "first second third" {
+		t.Errorf("Subject = %q", got)
+	}
+	if len(msg.Headers) != 2 || msg.Headers[1].Name != "X-Custom" {
+		t.Errorf("unexpected headers: %+v", msg.Headers)
+	}
+	if msg.Text != "body" {
+		t.Errorf("Text = %q", msg.Text)
+	}
+}
+
+func TestParse_MultipartWithAttachments(t *testing.T) {
+	raw := strings.Join([]string{
+		"From: sender@example.com",
+		"Content-Type: multipart/mixed; boundary=\"outer\"",
+		"",
+		"--outer",
+		"Content-Type: multipart/related; boundary=\"rel\"",
+		"",
+		"--rel",
+		"Content-Type: multipart/alternative; boundary=\"alt\"",
+		"",
+		"--alt",
+		"Content-Type: text/plain; charset=iso-8859-1",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"Gr=FC=DFe",
+		"--alt",
+		"Content-Type: text/html; charset=utf-8",
+		"Content-Transfer-Encoding: base64",
+		"",
+		"PHA+SGk8aW1nIHNyYz0iY2lkOmxvZ28iPjwvcD4=",
+		"--alt--",
+		"--rel",
+		"Content-Type: image/png",
+		"Content-Transfer-Encoding: base64",
+		"Content-ID: <logo>",
+		"Content-Disposition: inline",
+		"",
+		"iVBORw0K",
+		"--rel--",
+		"--outer",
+		"Content-Type: application/pdf",
+		"Content-Transfer-Encoding: base64",
+		"Content-Disposition: attachment; filename=\"=?UTF-8?Q?r=C3=A9sum=C3=A9.pdf?=\"",
+		"",
+		"JVBERi0x",
+		"--outer--",
+		"",
+	}, "\r\n")
+
+	msg, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	if msg.Text != "Grüße" {
+		t.Errorf("Text = %q, want %q", msg.Text, "Grüße")
+	}
+	if msg.HTML != `<p>Hi<img src="cid:logo"></p>` {
+		t.Errorf("HTML = %q", msg.HTML)
+	}
+	if len(msg.Attachments) != 2 {
+		t.Fatalf("got %d attachments, want 2", len(msg.Attachments))
+	}
+
+	logo := msg.Attachments[0]
+	if logo.Type != "inline" || logo.ContentID != "logo" || logo.ContentType != "image/png" {
+		t.Errorf("unexpected inline attachment: %+v", logo)
+	}
+	if !bytes.HasPrefix(logo.Content, []byte("\x89PNG")) {
+		t.Errorf("inline attachment not base64 decoded: %q", logo.Content)
+	}
+
+	pdf := msg.Attachments[1]
+	if pdf.Type != "attachment" || pdf.Name != "résumé.pdf" || string(pdf.Content) != "%PDF-1" {
+		t.Errorf("unexpected attachment: %+v", pdf)
+	}
+}
+
+func TestParse_BrokenMultipartFallsBackToText(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		text  string
+		parts int
+	}{
+		{
+			name: "no boundary parameter",
+			raw:  "Content-Type: multipart/mixed\r\n\r\nbody",
+			text: "body",
+		},
+		{
+			name: "boundary never used",
+			raw:  "Content-Type: multipart/mixed; boundary=\"b1\"\r\n\r\nplain body",
+			text: "plain body",
+		},
+		{
+			name: "closing boundary missing",
+			raw: "Content-Type: multipart/mixed; boundary=\"b1\"\r\n\r\n" +
+				"--b1\r\nContent-Type: text/plain\r\n\r\nfirst\r\n" +
+				"--b1\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=\"a.pdf\"\r\n\r\n%PDF\r\n",
+			text:  "first",
+			parts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := Parse([]byte(tt.raw))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if msg.Text != tt.text {
+				t.Errorf("Text = %q, want %q", msg.Text, tt.text)
+			}
+			if len(msg.Attachments) != tt.parts {
+				t.Errorf("got %d attachments, want %d", len(msg.Attachments), tt.parts)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package processor
+
+import (
+	"log"
+	"time"
+)
+
+// blobBatch is how many released blobs a collection deletes at a time
+const blobBatch = 100
+
+// runBlobCollector deletes attachment blobs that no message has referenced for
+// the configured grace period, on the lease reaper's interval. Queues without
+// an attachment store have nothing to collect.
+func (p *UnifiedProcessor) runBlobCollector() {
+	interval := p.config.Queue.ReaperInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.collectBlobs()
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// collectBlobs deletes every blob released before the grace period
+func (p *UnifiedProcessor) collectBlobs() {
+	releasedBefore := time.Now().Add(-p.config.Attachments.GracePeriod)
+	for {
+		deleted, err := p.blobs.CollectBlobs(releasedBefore, blobBatch)
+		if err != nil {
+			log.Printf("Error collecting attachment blobs: %v", err)
+			return
+		}
+		if deleted > 0 {
+			log.Printf("Deleted %d attachment blobs no message references", deleted)
+		}
+		if deleted < blobBatch {
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"relay/pkg/models"
+)
+
+// expiryBatch is how many expired messages a sweep takes from the queue at a time
+const expiryBatch = 100
+
+// expireMessage moves a message that will not be sent before its expires_at to
+// expired and reports it as a reject with reason "expired"
+func (p *UnifiedProcessor) expireMessage(ctx context.Context, msg *models.Message) {
+	err := msg.ExpiryError()
+	log.Printf("Message %s %v", msg.ID, err)
+	if updateErr := p.queue.UpdateStatus(msg.ID, models.StatusExpired, err); updateErr != nil {
+		log.Printf("Error: Failed to expire message %s: %v", msg.ID, updateErr)
+		return
+	}
+	p.notifyExpired(ctx, msg, err)
+}
+
+// notifyExpired marks the recipients of an expired message failed and sends
+// its reject webhook
+func (p *UnifiedProcessor) notifyExpired(ctx context.Context, msg *models.Message, err error) {
+	p.updateRecipientDeliveryStatus(msg, models.DeliveryStatusFailed, err.Error())
+
+	if p.webhookClient != nil && p.shouldSendWebhook(msg) {
+		if webhookErr := p.webhookClient.SendExpiredEvent(ctx, msg); webhookErr != nil {
+			log.Printf("Error sending webhook for message %s: %v", msg.ID, webhookErr)
+		}
+	}
+}
+
+// runExpirySweep expires waiting messages on the lease reaper's interval.
+// Dequeue leaves the messages of paused or rate limited workspaces in the
+// queue, so without the sweep they would only expire once released.
+func (p *UnifiedProcessor) runExpirySweep() {
+	interval := p.config.Queue.ReaperInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.sweepExpired()
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// sweepExpired expires every waiting message whose expires_at has passed
+func (p *UnifiedProcessor) sweepExpired() {
+	for {
+		messages, err := p.expirer.ExpireDue(expiryBatch)
+		if err != nil {
+			log.Printf("Error expiring messages: %v", err)
+			return
+		}
+		for _, msg := range messages {
+			p.notifyExpired(p.ctx, msg, msg.ExpiryError())
+		}
+		if len(messages) > 0 {
+			log.Printf("Expired %d messages that were not sent in time", len(messages))
+		}
+		if len(messages) < expiryBatch {
+			return
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"relay/internal/config"
+	"relay/internal/queue"
+	"relay/internal/webhook"
+	"relay/internal/workspace"
+	"relay/pkg/models"
+)
+
+// expiryTestProcessor returns a processor whose webhooks are collected by the returned function
+func expiryTestProcessor(t *testing.T) (*UnifiedProcessor, *queue.MemoryQueue, func() []models.MandrillWebhookEvent) {
+	t.Helper()
+	var mu sync.Mutex
+	var events []models.MandrillWebhookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []models.MandrillWebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("decode webhook: %v", err)
+		}
+		mu.Lock()
+		events = append(events, batch...)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	q := queue.NewMemoryQueue()
+	p := &UnifiedProcessor{
+		queue:  q,
+		config: &config.Config{},
+		workspaceManager: workspace.NewManager(&config.WorkspaceConfig{
+			ID:     "ws",
+			Domain: "example.com",
+			Gmail:  &config.WorkspaceGmailConfig{Enabled: true, EnableWebhooks: true},
+		}),
+		webhookClient: webhook.NewClient(&config.WebhookConfig{MandrillURL: server.URL, Timeout: time.Second}),
+		expirer:       q,
+		ctx:           context.Background(),
+	}
+	return p, q, func() []models.MandrillWebhookEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]models.MandrillWebhookEvent(nil), events...)
+	}
+}
+
+func expiringMessage(id string, expiresAt time.Time) *models.Message {
+	return &models.Message{
+		ID:         id,
+		From:       "sender@example.com",
+		To:         []string{"to@example.org"},
+		Subject:    "Your code",
+		ProviderID: "ws",
+		Status:     models.StatusQueued,
+		QueuedAt:   time.Now(),
+		ExpiresAt:  &expiresAt,
+	}
+}
+
+// checkExpired checks that msg was stored as expired and reported as a reject
+func checkExpired(t *testing.T, q *queue.MemoryQueue, events []models.MandrillWebhookEvent, msg *models.Message) {
+	t.Helper()
+	stored, err := q.Get(msg.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored.Status != models.StatusExpired || stored.Error != msg.ExpiryError().Error() || stored.RetryCount != 0 {
+		t.Errorf("stored status %s, error %q, retry count %d", stored.Status, stored.Error, stored.RetryCount)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("sent %d webhooks, want 1", len(events))
+	}
+	reject, _ := events[0].Msg.Metadata["reject"].(map[string]interface{})
+	if events[0].Event != "reject" || events[0].ID != msg.ID || reject["reason"] != "expired" {
+		t.Errorf("webhook %s for %s with reject %v, want a reject for %s with reason expired", events[0].Event, events[0].ID, reject, msg.ID)
+	}
+}
+
+func TestExpireMessage(t *testing.T) {
+	p, q, events := expiryTestProcessor(t)
+	msg := expiringMessage("msg-1", time.Now().Add(-time.Minute))
+	if err := q.Enqueue(msg); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	batch, err := q.Dequeue(1)
+	if err != nil || len(batch) != 1 {
+		t.Fatalf("Dequeue = %d messages, %v", len(batch), err)
+	}
+
+	p.expireMessage(context.Background(), batch[0])
+	checkExpired(t, q, events(), msg)
+}
+
+func TestSweepExpired(t *testing.T) {
+	p, q, events := expiryTestProcessor(t)
+	expired := expiringMessage("msg-1", time.Now().Add(-time.Minute))
+	waiting := expiringMessage("msg-2", time.Now().Add(time.Hour))
+	for _, msg := range []*models.Message{expired, waiting} {
+		if err := q.Enqueue(msg); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	// The sweep expires the message without it being dequeued, as when its workspace is paused
+	p.sweepExpired()
+	checkExpired(t, q, events(), expired)
+
+	if stored, _ := q.Get(waiting.ID); stored.Status != models.StatusQueued {
+		t.Errorf("message not yet expired has status %s", stored.Status)
+	}
+}
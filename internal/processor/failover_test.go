@@ -0,0 +1,157 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"relay/internal/config"
+	"relay/internal/provider"
+	"relay/internal/queue"
+	"relay/pkg/models"
+)
+
+type failoverTestProvider struct {
+	provider.Provider
+	id    string
+	err   error
+	sends int
+}
+
+func (p *failoverTestProvider) GetID() string                  { return p.id }
+func (p *failoverTestProvider) GetType() provider.ProviderType { return provider.ProviderTypeMailgun }
+
+func (p *failoverTestProvider) SendMessage(ctx context.Context, msg *models.Message) error {
+	p.sends++
+	if p.err == nil {
+		msg.ProviderMessageID = p.id + "-message"
+	}
+	return p.err
+}
+
+// checkingTestProvider can also be asked whether it accepted a message
+type checkingTestProvider struct {
+	failoverTestProvider
+	found bool
+}
+
+func (p *checkingTestProvider) FindSent(ctx context.Context, msg *models.Message) (string, bool, error) {
+	if !p.found {
+		return "", false, nil
+	}
+	return p.id + "-found", true, nil
+}
+
+func failoverTestProcessor(t *testing.T) (*UnifiedProcessor, *queue.MemoryQueue, *models.Message) {
+	t.Helper()
+	q := queue.NewMemoryQueue()
+	if err := q.Enqueue(&models.Message{
+		ID:         "msg-1",
+		From:       "sender@example.com",
+		To:         []string{"to@example.com"},
+		Subject:    "Failover",
+		ProviderID: "ws",
+		Status:     models.StatusQueued,
+		QueuedAt:   time.Now(),
+	}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	msgs, err := q.Dequeue(1)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("Dequeue = %d messages, %v", len(msgs), err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Queue.Retry = config.RetryPolicy{MaxAttempts: 3, InitialDelaySeconds: 60}
+	p := &UnifiedProcessor{
+		queue:  q,
+		config: cfg,
+		pool:   newSendPool(config.ConcurrencyConfig{}),
+		sends:  q,
+	}
+	return p, q, msgs[0]
+}
+
+func transportError(cause error) error {
+	return provider.NewSendError(provider.ErrorClassTransient, provider.ProviderTypeMailgun, "failed to send request", cause)
+}
+
+func TestSendViaChainFailover(t *testing.T) {
+	refused := provider.NewSendError(provider.ErrorClassTransient, provider.ProviderTypeMailgun, "service unavailable", nil)
+	refused.StatusCode = 503
+
+	tests := []struct {
+		name      string
+		first     provider.Provider
+		wantVia   string
+		wantSent  bool
+		nextSends int
+	}{
+		{
+			name:      "refusal fails over",
+			first:     &failoverTestProvider{id: "first", err: refused},
+			wantVia:   "second",
+			wantSent:  true,
+			nextSends: 1,
+		},
+		{
+			name:      "timeout without checker is retried",
+			first:     &failoverTestProvider{id: "first", err: transportError(context.DeadlineExceeded)},
+			wantVia:   "first",
+			nextSends: 0,
+		},
+		{
+			name: "timeout found by checker is sent",
+			first: &checkingTestProvider{
+				failoverTestProvider: failoverTestProvider{id: "first", err: transportError(context.DeadlineExceeded)},
+				found:                true,
+			},
+			wantVia:   "first",
+			wantSent:  true,
+			nextSends: 0,
+		},
+		{
+			name: "timeout not found by checker fails over",
+			first: &checkingTestProvider{
+				failoverTestProvider: failoverTestProvider{id: "first", err: transportError(errors.New("connection reset"))},
+			},
+			wantVia:   "second",
+			wantSent:  true,
+			nextSends: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, q, msg := failoverTestProcessor(t)
+			next := &failoverTestProvider{id: "second"}
+
+			via, err := p.sendViaChain(context.Background(), msg, []provider.Provider{tt.first, next})
+			if tt.wantSent != (err == nil) {
+				t.Fatalf("sendViaChain error = %v, want sent %v", err, tt.wantSent)
+			}
+			if via != tt.wantVia {
+				t.Errorf("sent via %q, want %q", via, tt.wantVia)
+			}
+			if next.sends != tt.nextSends {
+				t.Errorf("next provider called %d times, want %d", next.sends, tt.nextSends)
+			}
+
+			stored, err := q.Get(msg.ID)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if tt.wantSent {
+				if stored.Status != models.StatusSent || stored.SentVia != tt.wantVia {
+					t.Errorf("stored status %s via %q, want sent via %q", stored.Status, stored.SentVia, tt.wantVia)
+				}
+			} else if stored.Status != models.StatusFailed || stored.NextAttemptAt == nil {
+				t.Errorf("stored status %s, want failed awaiting retry", stored.Status)
+			}
+			if stored.ProviderID != "ws" {
+				t.Errorf("stored workspace %q, want ws", stored.ProviderID)
+			}
+		})
+	}
+}
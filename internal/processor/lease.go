@@ -0,0 +1,152 @@
+package processor
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"relay/internal/queue"
+	"relay/pkg/models"
+)
+
+// runLeaseReaper periodically returns messages whose lease expired, e.g. because
+// the worker holding them crashed, to the queue
+func (p *UnifiedProcessor) runLeaseReaper() {
+	interval := p.config.Queue.ReaperInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reaped, err := p.leaser.ReapExpiredLeases()
+			if err != nil {
+				log.Printf("Error reaping expired leases: %v", err)
+			} else if reaped > 0 {
+				log.Printf("Returned %d messages with expired leases to the queue", reaped)
+			}
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// leaseRenewInterval renews well before the lease runs out so a slow renewal does not lose it
+func (p *UnifiedProcessor) leaseRenewInterval() time.Duration {
+	interval := p.config.Queue.LeaseDuration / 3
+	if interval <= 0 {
+		interval = queue.DefaultLeaseDuration / 3
+	}
+	return interval
+}
+
+// leaseKeeper renews the leases on a dequeued batch until each message is released.
+// A nil leaseKeeper holds every message, for queues without leases.
+type leaseKeeper struct {
+	leaser queue.Leaser
+	mu     sync.Mutex
+	held   map[string]bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func startLeaseKeeper(leaser queue.Leaser, messages []*models.Message, interval time.Duration) *leaseKeeper {
+	if leaser == nil {
+		return nil
+	}
+
+	k := &leaseKeeper{
+		leaser: leaser,
+		held:   make(map[string]bool, len(messages)),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, msg := range messages {
+		if msg != nil {
+			k.held[msg.ID] = true
+		}
+	}
+
+	go k.run(interval)
+	return k
+}
+
+func (k *leaseKeeper) run(interval time.Duration) {
+	defer close(k.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			k.renew()
+		case <-k.stop:
+			return
+		}
+	}
+}
+
+func (k *leaseKeeper) renew() {
+	k.mu.Lock()
+	ids := make([]string, 0, len(k.held))
+	for id := range k.held {
+		ids = append(ids, id)
+	}
+	k.mu.Unlock()
+
+	for _, id := range ids {
+		err := k.leaser.RenewLease(id)
+		if errors.Is(err, queue.ErrLeaseLost) {
+			log.Printf("Warning: Lease on message %s was lost, another worker may process it", id)
+			k.release(id)
+		} else if err != nil {
+			log.Printf("Warning: Failed to renew lease on message %s: %v", id, err)
+		}
+	}
+}
+
+// holds reports whether the lease on id is still ours
+func (k *leaseKeeper) holds(id string) bool {
+	if k == nil {
+		return true
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.held[id]
+}
+
+// release stops renewing the lease on id once the message has left processing
+func (k *leaseKeeper) release(id string) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	delete(k.held, id)
+	k.mu.Unlock()
+}
+
+// Stop ends renewal for the batch
+func (k *leaseKeeper) Stop() {
+	if k == nil {
+		return
+	}
+	close(k.stop)
+	<-k.done
+}
+
+// lostLease reports whether a status update failed because the lease on msg was
+// reaped. The message may belong to another worker by now, so its outcome is left
+// for that worker to report.
+func lostLease(msg *models.Message, err error) bool {
+	if !errors.Is(err, queue.ErrLeaseLost) {
+		return false
+	}
+	log.Printf("Warning: Lease on message %s was lost before its status was recorded, leaving it to the worker that holds it", msg.ID)
+	return true
+}
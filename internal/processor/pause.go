@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"relay/internal/queue"
+	"relay/pkg/models"
+)
+
+// pauseRecheckInterval is how long a message held by a pause without a resume
+// time stays parked before it is checked again
+const pauseRecheckInterval = time.Minute
+
+// errPaused is returned by processMessage when every provider it could be sent
+// through is paused
+var errPaused = errors.New("provider is paused")
+
+// loadPauses returns the pauses in effect now. Backends without pause support
+// pause nothing, and a failure to load them is logged and sends as normal.
+func (p *UnifiedProcessor) loadPauses() *queue.PauseSet {
+	if p.pauser == nil {
+		return nil
+	}
+	pauses, err := p.pauser.ListPauses()
+	if err != nil {
+		log.Printf("Warning: Failed to load pauses: %v", err)
+		return nil
+	}
+	return queue.NewPauseSet(pauses, time.Now())
+}
+
+// findPause returns the pause holding a message before it is personalized and
+// routed: one on its workspace or sender, or on every provider of its sender's
+// domain. The queue leaves most of these out of the batch already; this catches
+// pauses created after the batch was dequeued and backends that cannot filter.
+func (p *UnifiedProcessor) findPause(msg *models.Message, pauses *queue.PauseSet) *queue.Pause {
+	if pause := pauses.Find(msg.ProviderID, "", msg.From); pause != nil || pauses == nil {
+		return pause
+	}
+
+	at := strings.LastIndex(msg.From, "@")
+	if at == -1 {
+		return nil
+	}
+	providers, err := p.providerRouter.GetProvidersByDomain(msg.From[at+1:])
+	if err != nil || len(providers) == 0 {
+		return nil
+	}
+	var held *queue.Pause
+	for _, candidate := range providers {
+		pause := pauses.Find("", candidate.GetID(), "")
+		if pause == nil {
+			return nil
+		}
+		if held == nil {
+			held = pause
+		}
+	}
+	return held
+}
+
+// holdPaused parks a paused message until the pause is due to end, or for
+// pauseRecheckInterval if it has no end. Unlike a rate limit deferral nothing is
+// reported to the recipient or the webhook, since the message is only waiting.
+func (p *UnifiedProcessor) holdPaused(msg *models.Message, pause *queue.Pause) {
+	until := time.Now().Add(pauseRecheckInterval)
+	if pause.ResumeAt != nil && pause.ResumeAt.Before(until) {
+		until = *pause.ResumeAt
+	}
+	log.Printf("Holding message %s until %s, %s", msg.ID, until.Format(time.RFC3339), pause)
+
+	// Only queues that store pauses hold messages, and Hold leaves the deferral
+	// time alone so a later rate limit deferral is still reported
+	if err := p.pauser.Hold(msg.ID, until, errors.New(pause.String())); err != nil {
+		log.Printf("ERROR: Failed to hold paused message %s: %v", msg.ID, err)
+	}
+}
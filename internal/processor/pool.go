@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"relay/internal/config"
+	"relay/internal/provider"
+)
+
+// saturatedRetryDelay is how long a message whose provider or workspace had no
+// free send slot waits in the queue before it is tried again
+const saturatedRetryDelay = 5 * time.Second
+
+// errSaturated is returned by sendViaChain when the message was put back in the
+// queue because its provider or workspace was at its concurrency limit
+var errSaturated = errors.New("no free send slot")
+
+// sendPool runs message sends on a bounded set of workers. Each send also takes a
+// slot for its workspace and provider. A message whose provider or workspace has
+// no free slot is put back in the queue rather than waited on, so a slow
+// provider only ties up its own slots and traffic for other providers keeps flowing.
+type sendPool struct {
+	config  config.ConcurrencyConfig
+	workers chan struct{}
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	limits map[string]chan struct{} // keyed by "provider:<id>" or "workspace:<id>"
+}
+
+func newSendPool(cfg config.ConcurrencyConfig) *sendPool {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	return &sendPool{
+		config:  cfg,
+		workers: make(chan struct{}, workers),
+		limits:  make(map[string]chan struct{}),
+	}
+}
+
+// available returns the number of idle workers
+func (sp *sendPool) available() int {
+	return cap(sp.workers) - len(sp.workers)
+}
+
+// inFlight returns the number of busy workers
+func (sp *sendPool) inFlight() int {
+	return len(sp.workers)
+}
+
+// run executes fn on a pool worker, waiting for one to become idle
+func (sp *sendPool) run(fn func()) {
+	sp.workers <- struct{}{}
+	sp.wg.Add(1)
+	go func() {
+		defer sp.wg.Done()
+		defer func() { <-sp.workers }()
+		fn()
+	}()
+}
+
+// wait blocks until every submitted send has finished
+func (sp *sendPool) wait() {
+	sp.wg.Wait()
+}
+
+// tryAcquireSend takes a slot for the provider and the workspace without
+// waiting and returns the function that releases both. It reports false, holding
+// nothing, when either is at its limit: a worker waiting for a busy provider
+// would be a worker taken from every other provider.
+func (sp *sendPool) tryAcquireSend(workspaceID string, p provider.Provider) (func(), bool) {
+	releaseProvider, ok := sp.tryAcquire("provider:"+p.GetID(), sp.config.ProviderLimit(p.GetID(), string(p.GetType())))
+	if !ok {
+		return nil, false
+	}
+	releaseWorkspace, ok := sp.tryAcquire("workspace:"+workspaceID, sp.config.WorkspaceLimit(workspaceID))
+	if !ok {
+		releaseProvider()
+		return nil, false
+	}
+	return func() {
+		releaseProvider()
+		releaseWorkspace()
+	}, true
+}
+
+// tryAcquire takes a slot from the semaphore for key if one is free; a limit of
+// zero or less means unlimited
+func (sp *sendPool) tryAcquire(key string, limit int) (func(), bool) {
+	if limit <= 0 {
+		return func() {}, true
+	}
+
+	sp.mu.Lock()
+	slots, exists := sp.limits[key]
+	if !exists {
+		slots = make(chan struct{}, limit)
+		sp.limits[key] = slots
+	}
+	sp.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"relay/internal/config"
+	"relay/internal/provider"
+	"relay/internal/queue"
+	"relay/pkg/models"
+)
+
+type poolTestProvider struct {
+	provider.Provider
+	id    string
+	block chan struct{} // SendMessage waits on it when set
+	sent  int32
+}
+
+func (p *poolTestProvider) GetID() string                  { return p.id }
+func (p *poolTestProvider) GetType() provider.ProviderType { return provider.ProviderTypeMailgun }
+
+func (p *poolTestProvider) SendMessage(ctx context.Context, msg *models.Message) error {
+	if p.block != nil {
+		<-p.block
+	}
+	atomic.AddInt32(&p.sent, 1)
+	return nil
+}
+
+func TestSendPoolTryAcquireSendBoundsProvidersAndWorkspaces(t *testing.T) {
+	pool := newSendPool(config.ConcurrencyConfig{
+		Workers:            8,
+		PerProvider:        2,
+		ProviderOverrides:  map[string]int{"fast": 4},
+		WorkspaceOverrides: map[string]int{"ws": 3},
+	})
+	slow, fast := &poolTestProvider{id: "slow"}, &poolTestProvider{id: "fast"}
+
+	var releases []func()
+	take := func(workspaceID string, p provider.Provider, want bool) {
+		t.Helper()
+		release, ok := pool.tryAcquireSend(workspaceID, p)
+		if ok != want {
+			t.Fatalf("tryAcquireSend(%s, %s) = %v, want %v", workspaceID, p.GetID(), ok, want)
+		}
+		if ok {
+			releases = append(releases, release)
+		}
+	}
+
+	take("open", slow, true)
+	take("open", slow, true)
+	take("open", slow, false) // Provider limit
+	for i := 0; i < 4; i++ {
+		take("open", fast, true)
+	}
+	take("open", fast, false) // Provider override
+
+	for _, release := range releases {
+		release()
+	}
+	releases = nil
+
+	take("ws", slow, true)
+	take("ws", fast, true)
+	take("ws", fast, true)
+	take("ws", fast, false) // Workspace limit
+	// The refused send gave its provider slot back
+	take("open", fast, true)
+	take("open", fast, true)
+
+	for _, release := range releases {
+		release()
+	}
+	take("ws", slow, true)
+}
+
+func TestSlowProviderDoesNotHoldUpOthers(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	cfg := &config.Config{}
+	cfg.Queue.Concurrency = config.ConcurrencyConfig{Workers: 4, PerProvider: 2, ProviderOverrides: map[string]int{"fast": 4}}
+	p := &UnifiedProcessor{queue: q, config: cfg, pool: newSendPool(cfg.Queue.Concurrency), pauser: q}
+
+	slow := &poolTestProvider{id: "slow", block: make(chan struct{})}
+	fast := &poolTestProvider{id: "fast"}
+	var routes []*poolTestProvider
+	for i := 0; i < 6; i++ {
+		routes = append(routes, slow)
+	}
+	for i := 0; i < 6; i++ {
+		routes = append(routes, fast)
+	}
+	for i := range routes {
+		q.Enqueue(&models.Message{ID: string(rune('a' + i)), From: "sender@example.com", To: []string{"to@example.org"},
+			ProviderID: "ws", Status: models.StatusQueued, QueuedAt: time.Now()})
+	}
+	batch, err := q.Dequeue(len(routes))
+	if err != nil || len(batch) != len(routes) {
+		t.Fatalf("Dequeue = %d messages, %v", len(batch), err)
+	}
+
+	// Each message takes a worker, as Process does, and sends through its provider
+	var mu sync.Mutex
+	outcome := map[string]error{}
+	for i, msg := range batch {
+		msg, via := msg, routes[i]
+		p.pool.run(func() {
+			_, err := p.sendViaChain(context.Background(), msg, []provider.Provider{via})
+			mu.Lock()
+			outcome[msg.ID] = err
+			mu.Unlock()
+		})
+	}
+
+	// While the slow provider holds its two slots, the fast one sends everything
+	// and the slow provider's other messages go back to the queue
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&fast.sent) < 6 {
+		if time.Now().After(deadline) {
+			t.Fatalf("fast provider sent %d of 6 messages while the slow one was saturated", atomic.LoadInt32(&fast.sent))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for p.pool.inFlight() > 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if inFlight := p.pool.inFlight(); inFlight != 2 {
+		t.Errorf("%d workers busy while the slow provider sends, want only its 2", inFlight)
+	}
+
+	close(slow.block)
+	p.pool.wait()
+
+	parked := 0
+	for i, msg := range batch {
+		stored, _ := q.Get(msg.ID)
+		switch {
+		case outcome[msg.ID] == errSaturated:
+			parked++
+			if routes[i] != slow || stored.Status != models.StatusQueued || stored.NextAttemptAt == nil {
+				t.Errorf("message %s put back via %s with status %s", msg.ID, routes[i].id, stored.Status)
+			}
+		case outcome[msg.ID] != nil:
+			t.Errorf("message %s: %v", msg.ID, outcome[msg.ID])
+		case stored.Status != models.StatusSent:
+			t.Errorf("message %s has status %s, want sent", msg.ID, stored.Status)
+		}
+	}
+	if parked != 4 || slow.sent != 2 {
+		t.Errorf("slow provider sent %d and put back %d messages, want 2 and 4", slow.sent, parked)
+	}
+}
@@ -0,0 +1,132 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"relay/internal/provider"
+	"relay/pkg/models"
+)
+
+// unconfirmedSendBatch is how many interrupted sends a reconciliation pass claims at a time
+const unconfirmedSendBatch = 100
+
+// runSendReconciler checks sends interrupted by a crash once at startup and
+// then on the lease reaper's interval
+func (p *UnifiedProcessor) runSendReconciler() {
+	interval := p.config.Queue.ReaperInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	p.reconcileSends()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.reconcileSends()
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// reconcileSends claims messages left in sending by a worker that stopped
+// renewing its lease and settles each one with its provider
+func (p *UnifiedProcessor) reconcileSends() {
+	for {
+		messages, err := p.sends.ClaimUnconfirmedSends(unconfirmedSendBatch)
+		if err != nil {
+			log.Printf("Error claiming interrupted sends: %v", err)
+			return
+		}
+		for _, msg := range messages {
+			p.reconcileSend(p.ctx, msg)
+		}
+		if len(messages) < unconfirmedSendBatch {
+			return
+		}
+	}
+}
+
+// reconcileSend asks the provider a message was handed to whether it accepted
+// it. A message the provider has is marked sent; one it does not have goes back
+// to the queue without counting the interrupted attempt. When the provider
+// cannot be asked, as with Mailgun and Mandrill which have no lookup, the message
+// is moved to the dead letters, where an operator can replay it after checking
+// the recipient's mailbox.
+func (p *UnifiedProcessor) reconcileSend(ctx context.Context, msg *models.Message) {
+	providerID := msg.SentVia
+	selected, err := p.providerRouter.GetProvider(providerID)
+	if err != nil {
+		p.abandonSend(msg, providerID, err)
+		return
+	}
+	checker, ok := selected.(provider.SendChecker)
+	if !ok {
+		p.abandonSend(msg, providerID, fmt.Errorf("%s provider cannot look up sent messages", selected.GetType()))
+		return
+	}
+
+	providerMessageID, found, err := checker.FindSent(ctx, msg)
+	if err != nil {
+		switch provider.ClassifyError(err).Class {
+		case provider.ErrorClassAuth, provider.ErrorClassConfiguration:
+			p.abandonSend(msg, providerID, err)
+		default:
+			// Checked again once this worker's claim on it expires
+			log.Printf("Warning: Failed to check interrupted send of message %s with %s: %v", msg.ID, providerID, err)
+		}
+		return
+	}
+
+	if found {
+		log.Printf("Interrupted send of message %s was accepted by %s as %s", msg.ID, providerID, providerMessageID)
+		msg.ProviderMessageID = providerMessageID
+		p.recordSent(ctx, msg, providerID)
+		return
+	}
+
+	log.Printf("Interrupted send of message %s never reached %s, returning it to the queue", msg.ID, providerID)
+	reason := fmt.Errorf("send via %s was interrupted before the provider accepted it", providerID)
+	if err := p.queue.UpdateStatus(msg.ID, models.StatusQueued, reason); err != nil {
+		log.Printf("Error: Failed to requeue message %s: %v", msg.ID, err)
+	}
+}
+
+// checkSent asks a provider whose send failed without a clear answer whether it
+// has the message anyway. It reports whether the provider has it, recording its
+// message ID, and whether the provider confirmed it does not. A provider that
+// cannot be asked confirms nothing.
+func (p *UnifiedProcessor) checkSent(ctx context.Context, msg *models.Message, selected provider.Provider) (sent, notSent bool) {
+	checker, ok := selected.(provider.SendChecker)
+	if !ok {
+		return false, false
+	}
+	providerMessageID, found, err := checker.FindSent(ctx, msg)
+	if err != nil {
+		log.Printf("Warning: Failed to check whether %s has message %s: %v", selected.GetID(), msg.ID, err)
+		return false, false
+	}
+	if found {
+		log.Printf("Message %s was accepted by %s as %s despite the error", msg.ID, selected.GetID(), providerMessageID)
+		msg.ProviderMessageID = providerMessageID
+	}
+	return found, !found
+}
+
+// abandonSend moves a message whose send outcome cannot be determined to the dead letters
+func (p *UnifiedProcessor) abandonSend(msg *models.Message, providerID string, cause error) {
+	err := fmt.Errorf("send outcome unknown, replay only if the message was not delivered: %w", cause)
+	log.Printf("Error: Cannot check interrupted send of message %s with %s: %v", msg.ID, providerID, cause)
+	p.recordAttempt(msg, providerID, "", err)
+	if updateErr := p.queue.UpdateStatusWithProvider(msg.ID, models.StatusDead, providerID, err); updateErr != nil {
+		log.Printf("Error: Failed to move message %s to dead letters: %v", msg.ID, updateErr)
+	}
+	p.updateRecipientDeliveryStatus(msg, models.DeliveryStatusFailed, err.Error())
+}
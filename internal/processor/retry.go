@@ -0,0 +1,71 @@
+package processor
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"relay/internal/config"
+	"relay/pkg/models"
+)
+
+// retryPolicyFor returns the global retry policy with the message workspace's overrides applied
+func (p *UnifiedProcessor) retryPolicyFor(msg *models.Message) config.RetryPolicy {
+	policy := p.config.Queue.Retry
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = p.config.Queue.MaxRetries
+	}
+
+	if p.workspaceManager != nil && msg.ProviderID != "" {
+		if workspace, err := p.workspaceManager.GetWorkspaceByID(msg.ProviderID); err == nil && workspace != nil {
+			policy = policy.Merge(workspace.RetryPolicy)
+		}
+	}
+
+	return policy
+}
+
+// nextAttemptTime returns when to retry a message that has failed attempts times,
+// or false when the policy allows no further attempts. A provider's retry-after
+// is honored when it is longer than the backoff delay.
+func nextAttemptTime(policy config.RetryPolicy, attempts int, retryAfter time.Duration, now time.Time) (time.Time, bool) {
+	if attempts >= policy.MaxAttempts {
+		return time.Time{}, false
+	}
+
+	delay := backoffDelay(policy, attempts, rand.Float64)
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return now.Add(delay), true
+}
+
+// backoffDelay computes InitialDelay * Multiplier^(attempts-1), capped at MaxDelay,
+// then spread by +/- Jitter so retries of a failed batch do not arrive together
+func backoffDelay(policy config.RetryPolicy, attempts int, random func() float64) time.Duration {
+	initial := time.Duration(policy.InitialDelaySeconds) * time.Second
+	if initial <= 0 {
+		initial = time.Minute
+	}
+	maxDelay := time.Duration(policy.MaxDelaySeconds) * time.Second
+	if maxDelay <= 0 {
+		maxDelay = time.Hour
+	}
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := float64(initial) * math.Pow(multiplier, float64(attempts-1))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+
+	jitter := math.Min(math.Max(policy.Jitter, 0), 1)
+	delay *= 1 + jitter*(2*random()-1)
+
+	return time.Duration(delay)
+}
@@ -0,0 +1,50 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"relay/internal/config"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	policy := config.RetryPolicy{MaxAttempts: 5, InitialDelaySeconds: 60, MaxDelaySeconds: 300, Multiplier: 2}
+	noJitter := func() float64 { return 0.5 }
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 5 * time.Minute}, // capped
+	}
+	for _, tt := range tests {
+		if got := backoffDelay(policy, tt.attempts, noJitter); got != tt.want {
+			t.Errorf("backoffDelay(attempts=%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	if got := backoffDelay(policy, 1, func() float64 { return 0 }); got != 30*time.Second {
+		t.Errorf("minimum jittered delay = %v, want 30s", got)
+	}
+	if got := backoffDelay(policy, 1, func() float64 { return 1 }); got != 90*time.Second {
+		t.Errorf("maximum jittered delay = %v, want 90s", got)
+	}
+}
+
+func TestNextAttemptTimeStopsAtMaxAttempts(t *testing.T) {
+	policy := config.RetryPolicy{MaxAttempts: 3, InitialDelaySeconds: 1}
+	now := time.Now()
+	if _, ok := nextAttemptTime(policy, 2, 0, now); !ok {
+		t.Error("expected a retry after 2 of 3 attempts")
+	}
+	if _, ok := nextAttemptTime(policy, 3, 0, now); ok {
+		t.Error("expected no retry after 3 of 3 attempts")
+	}
+	if next, _ := nextAttemptTime(policy, 1, time.Hour, now); !next.Equal(now.Add(time.Hour)) {
+		t.Errorf("retry-after should override a shorter backoff, got %v", next.Sub(now))
+	}
+}
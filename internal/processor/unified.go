@@ -2,6 +2,7 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -34,6 +35,14 @@ type UnifiedProcessor struct {
 	variableReplacer *variables.VariableReplacer
 	rateLimiter      *queue.WorkspaceAwareRateLimiter
 	recipientService *recipient.Service
+	pool             *sendPool
+	leaser           queue.Leaser           // Set when the queue leases dequeued messages
+	registry         queue.WorkerRegistry   // Set when the queue tracks replicas
+	sends            queue.SendTracker      // Set when the queue records sends before the provider call
+	pauser           queue.Pauser           // Set when the queue stores pauses
+	expirer          queue.Expirer          // Set when the queue can expire messages without dequeueing them
+	blobs            queue.AttachmentStorer // Set when the queue can move attachment content into a blob store
+	workerID         string
 	
 	// Processing control
 	mu         sync.Mutex
@@ -50,10 +59,46 @@ type UnifiedProcessStats struct {
 	Sent            int
 	Failed          int
 	RateLimited     int
+	Paused          int
+	Expired         int
+	Saturated       int // Put back because their provider or workspace had no free send slot
 	LastProcessedAt time.Time
 	ProviderStats   map[string]ProviderProcessStats
 }
 
+// add adds the counts of a finished batch to s
+func (s *UnifiedProcessStats) add(batch UnifiedProcessStats) {
+	s.TotalProcessed += batch.TotalProcessed
+	s.Sent += batch.Sent
+	s.Failed += batch.Failed
+	s.RateLimited += batch.RateLimited
+	s.Paused += batch.Paused
+	s.Expired += batch.Expired
+	s.Saturated += batch.Saturated
+	if batch.LastProcessedAt.After(s.LastProcessedAt) {
+		s.LastProcessedAt = batch.LastProcessedAt
+	}
+	if s.ProviderStats == nil {
+		s.ProviderStats = make(map[string]ProviderProcessStats)
+	}
+	for providerID, batchStats := range batch.ProviderStats {
+		total := s.ProviderStats[providerID]
+		total.Sent += batchStats.Sent
+		total.Failed += batchStats.Failed
+		s.ProviderStats[providerID] = total
+	}
+}
+
+// copy returns s with its own ProviderStats map, for callers outside p.mu
+func (s UnifiedProcessStats) copy() UnifiedProcessStats {
+	providerStats := make(map[string]ProviderProcessStats, len(s.ProviderStats))
+	for providerID, stats := range s.ProviderStats {
+		providerStats[providerID] = stats
+	}
+	s.ProviderStats = providerStats
+	return s
+}
+
 // ProviderProcessStats tracks stats per provider
 type ProviderProcessStats struct {
 	Sent   int
@@ -126,6 +171,7 @@ func NewUnifiedProcessor(
 		variableReplacer: variableReplacer,
 		rateLimiter:      queue.NewWorkspaceAwareRateLimiter(workspaces, cfg.Queue.DailyRateLimit),
 		recipientService: rs,
+		pool:             newSendPool(cfg.Queue.Concurrency),
 		ctx:              ctx,
 		cancel:           cancel,
 		stats: UnifiedProcessStats{
@@ -133,6 +179,56 @@ func NewUnifiedProcessor(
 		},
 	}
 	
+	processor.workerID = cfg.Queue.WorkerID
+	if processor.workerID == "" {
+		processor.workerID = queue.DefaultWorkerID()
+	}
+	
+	// Lease dequeued messages so a crashed worker's batch returns to the queue
+	if leaser, ok := q.(queue.Leaser); ok {
+		leaser.SetLeaseDuration(cfg.Queue.LeaseDuration)
+		leaser.SetWorkerID(processor.workerID)
+		processor.leaser = leaser
+	}
+	if registry, ok := q.(queue.WorkerRegistry); ok {
+		processor.registry = registry
+	}
+	if sends, ok := q.(queue.SendTracker); ok && processor.leaser != nil {
+		processor.sends = sends
+	}
+	if pauser, ok := q.(queue.Pauser); ok {
+		processor.pauser = pauser
+	}
+	if expirer, ok := q.(queue.Expirer); ok {
+		processor.expirer = expirer
+	}
+	if blobs, ok := q.(queue.AttachmentStorer); ok {
+		processor.blobs = blobs
+	}
+	
+	// Split dequeue batches across priority lanes by the configured weights
+	if lanes, ok := q.(queue.PriorityLanes); ok && len(cfg.Queue.PriorityWeights) > 0 {
+		weights := make(map[models.MessagePriority]int, len(cfg.Queue.PriorityWeights))
+		for lane, weight := range cfg.Queue.PriorityWeights {
+			weights[models.MessagePriority(lane)] = weight
+		}
+		lanes.SetLaneWeights(weights)
+	}
+	
+	// Share batches between workspaces and leave rate limited senders queued
+	if fair, ok := q.(queue.FairScheduler); ok {
+		share := queue.FairShare{
+			BySender: cfg.Queue.FairShareBySender,
+			Weights:  cfg.Queue.WorkspaceWeights,
+		}
+		if processor.rateLimiter != nil {
+			share.Throttled = processor.rateLimiter.Exhausted
+		}
+		fair.SetFairShare(share)
+	} else if len(cfg.Queue.WorkspaceWeights) > 0 || cfg.Queue.FairShareBySender {
+		log.Printf("Warning: %T does not share batches between workspaces; QUEUE_WORKSPACE_WEIGHTS and QUEUE_FAIR_SHARE_BY_SENDER are ignored", q)
+	}
+	
 	// Initialize rate limiter with historical data from the queue
 	log.Printf("Initializing unified processor rate limiter with historical data...")
 	if processor.rateLimiter != nil {
@@ -162,6 +258,23 @@ func (p *UnifiedProcessor) Start() {
 	
 	log.Println("Starting unified message processor...")
 	
+	if p.leaser != nil {
+		go p.runLeaseReaper()
+	}
+	if p.sends != nil {
+		go p.runSendReconciler()
+	}
+	if p.expirer != nil {
+		go p.runExpirySweep()
+	}
+	if p.blobs != nil {
+		go p.runBlobCollector()
+	}
+	if p.registry != nil {
+		p.registerWorker()
+		go p.runHeartbeat()
+	}
+	
 	ticker := time.NewTicker(p.config.Queue.ProcessInterval)
 	defer ticker.Stop()
 	
@@ -184,9 +297,18 @@ func (p *UnifiedProcessor) Stop() {
 	if p.cancel != nil {
 		p.cancel()
 	}
+	
+	// Let in-flight sends finish so their messages are not left to the lease reaper
+	p.pool.wait()
+	
+	if p.registry != nil {
+		p.deregisterWorker()
+	}
 }
 
-// Process handles a batch of messages from the queue
+// Process dequeues a batch sized to the idle send workers and hands each message
+// to the pool. It returns once the batch is dispatched; the batch stats are
+// published when its last message finishes.
 func (p *UnifiedProcessor) Process() error {
 	// Defensive programming: validate processor state
 	if p == nil {
@@ -214,16 +336,27 @@ func (p *UnifiedProcessor) Process() error {
 		p.mu.Unlock()
 	}()
 	
-	log.Println("Starting unified queue processing...")
+	// Only take as many messages as there are idle workers to send them
+	batchSize := p.config.Queue.BatchSize
+	if idle := p.pool.available(); idle < batchSize {
+		batchSize = idle
+	}
+	if batchSize <= 0 {
+		log.Printf("All %d send workers busy, skipping dequeue", p.pool.inFlight())
+		return nil
+	}
 	
-	// Reset stats for this run
-	stats := UnifiedProcessStats{
-		LastProcessedAt: time.Now(),
-		ProviderStats:   make(map[string]ProviderProcessStats),
+	// Nothing is dequeued while sending is paused everywhere
+	pauses := p.loadPauses()
+	if pause := pauses.Global(); pause != nil {
+		log.Printf("Skipping dequeue, %s", pause)
+		return nil
 	}
 	
+	log.Println("Starting unified queue processing...")
+	
 	// Dequeue messages
-	messages, err := p.queue.Dequeue(p.config.Queue.BatchSize)
+	messages, err := p.queue.Dequeue(batchSize)
 	if err != nil {
 		log.Printf("Error dequeuing messages: %v", err)
 		return err
@@ -235,7 +368,17 @@ func (p *UnifiedProcessor) Process() error {
 	
 	log.Printf("Processing %d messages from queue", len(messages))
 	
-	// Process each message
+	// Keep the batch leased while it is being sent
+	leases := startLeaseKeeper(p.leaser, messages, p.leaseRenewInterval())
+	run := &batchRun{
+		pauses: pauses,
+		stats: UnifiedProcessStats{
+			LastProcessedAt: time.Now(),
+			ProviderStats:   make(map[string]ProviderProcessStats),
+		},
+	}
+	
+	// Hand each message to the worker pool
 	for _, msg := range messages {
 		// Defensive check for nil message
 		if msg == nil {
@@ -243,77 +386,216 @@ func (p *UnifiedProcessor) Process() error {
 			continue
 		}
 		
-		stats.TotalProcessed++
-		
-		// Process recipient information for this message (defensive programming - continue on error)
-		if p.recipientService != nil {
-			if err := p.recipientService.ProcessMessageRecipients(msg); err != nil {
-				log.Printf("Warning: Failed to process recipients for message %s: %v", msg.ID, err)
-				// Continue processing the message even if recipient tracking fails
-			}
-		}
-		
-		// Check rate limit for this sender (provider-aware)
-		if p.rateLimiter != nil && !p.rateLimiter.Allow(msg.ProviderID, msg.From) {
-			log.Printf("Rate limit exceeded for sender %s in provider %s (message %s)", msg.From, msg.ProviderID, msg.ID)
-			stats.RateLimited++
-			
-			// Put back in queue as deferred
-			p.queue.UpdateStatusWithProvider(msg.ID, models.StatusQueued, "", fmt.Errorf("rate limit exceeded for sender %s in provider %s", msg.From, msg.ProviderID))
-			
-			if p.webhookClient != nil && p.shouldSendWebhook(msg) {
-				p.webhookClient.SendDeferredEvent(context.Background(), msg, fmt.Sprintf("Rate limit exceeded for %s in provider %s", msg.From, msg.ProviderID))
-			}
-			
-			// Log rate limit status for this sender
-			if p.rateLimiter != nil {
-				sent, remaining, resetTime := p.rateLimiter.GetStatus(msg.ProviderID, msg.From)
-				log.Printf("Rate limit status for %s in provider %s: %d sent, %d remaining, resets at %s",
-					msg.From, msg.ProviderID, sent, remaining, resetTime.Format(time.RFC3339))
-			} else {
-				log.Printf("Rate limiter is nil, cannot get status for %s", msg.From)
-			}
-			
+		if !leases.holds(msg.ID) {
+			log.Printf("Warning: Skipping message %s, its lease expired before it was sent", msg.ID)
 			continue
 		}
 		
-		// Process the message
-		providerID, err := p.processMessage(msg)
-		if err != nil {
-			stats.Failed++
-			if providerID != "" {
-				providerStats := stats.ProviderStats[providerID]
-				providerStats.Failed++
-				stats.ProviderStats[providerID] = providerStats
-			}
-		} else {
-			stats.Sent++
-			if providerID != "" {
-				providerStats := stats.ProviderStats[providerID]
-				providerStats.Sent++
-				stats.ProviderStats[providerID] = providerStats
-			}
+		msg := msg
+		run.wg.Add(1)
+		p.pool.run(func() {
+			defer run.wg.Done()
+			defer leases.release(msg.ID)
+			p.handleMessage(msg, run)
+		})
+	}
+	
+	go func() {
+		run.wg.Wait()
+		leases.Stop()
+		p.finishBatch(run)
+	}()
+	
+	return nil
+}
+
+// handleMessage checks the sender's rate limit and sends one message on a pool worker
+func (p *UnifiedProcessor) handleMessage(msg *models.Message, run *batchRun) {
+	// Process recipient information for this message (defensive programming - continue on error)
+	if p.recipientService != nil {
+		if err := p.recipientService.ProcessMessageRecipients(msg); err != nil {
+			log.Printf("Warning: Failed to process recipients for message %s: %v", msg.ID, err)
+			// Continue processing the message even if recipient tracking fails
+		}
+	}
+	
+	// Mail that is no longer useful is dropped instead of sent late
+	if msg.Expired(time.Now()) {
+		run.recordExpired()
+		p.expireMessage(context.Background(), msg)
+		return
+	}
+	
+	// Paused messages stay queued without counting against the rate limit
+	if pause := p.findPause(msg, run.pauses); pause != nil {
+		run.recordPaused()
+		p.holdPaused(msg, pause)
+		return
+	}
+	
+	// Check rate limit for this sender (provider-aware)
+	if p.rateLimiter != nil && !p.rateLimiter.Allow(msg.ProviderID, msg.From) {
+		run.recordRateLimited()
+		p.deferRateLimited(msg)
+		return
+	}
+	
+	// Process the message
+	providerID, err := p.processMessage(msg, run.pauses)
+	if errors.Is(err, errPaused) {
+		run.recordPaused()
+		return
+	}
+	if errors.Is(err, errSaturated) {
+		run.recordSaturated()
+		return
+	}
+	run.record(providerID, err)
+}
+
+// parkSaturated puts a message whose provider or workspace has no free send slot
+// back in the queue for a moment, freeing its worker for other providers
+func (p *UnifiedProcessor) parkSaturated(msg *models.Message, providerID string) {
+	until := time.Now().Add(saturatedRetryDelay)
+	reason := fmt.Errorf("no free send slot for provider %s or workspace %s", providerID, msg.ProviderID)
+	log.Printf("Putting message %s back in the queue until %s, %v", msg.ID, until.Format(time.RFC3339), reason)
+	
+	// Hold leaves the deferral time alone so a later rate limit deferral is still reported
+	var err error
+	if p.pauser != nil {
+		err = p.pauser.Hold(msg.ID, until, reason)
+	} else {
+		_, err = p.queue.Defer(msg.ID, until, reason)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to put message %s back in the queue: %v", msg.ID, err)
+	}
+}
+
+// deferRateLimited parks a rate limited message until its sender's or workspace's
+// limit resets, instead of requeueing it for the next tick. The deferral webhook
+// is only sent the first time a message is parked.
+func (p *UnifiedProcessor) deferRateLimited(msg *models.Message) {
+	until := p.rateLimitReset(msg)
+	reason := fmt.Errorf("rate limit exceeded for sender %s in provider %s", msg.From, msg.ProviderID)
+	log.Printf("Rate limit exceeded for sender %s in provider %s, deferring message %s until %s",
+		msg.From, msg.ProviderID, msg.ID, until.Format(time.RFC3339))
+	
+	first, err := p.queue.Defer(msg.ID, until, reason)
+	if err != nil {
+		log.Printf("ERROR: Failed to defer message %s: %v", msg.ID, err)
+		return
+	}
+	if !first {
+		return
+	}
+	
+	p.updateRecipientDeliveryStatus(msg, models.DeliveryStatusDeferred, reason.Error())
+	
+	if p.webhookClient != nil && p.shouldSendWebhook(msg) {
+		p.webhookClient.SendDeferredEvent(context.Background(), msg, fmt.Sprintf("Rate limit exceeded for %s in provider %s", msg.From, msg.ProviderID))
+	}
+}
+
+// rateLimitReset returns when the exhausted sender or workspace limit next frees
+// up a send, falling back to a minute from now if the limiter has no reset time
+func (p *UnifiedProcessor) rateLimitReset(msg *models.Message) time.Time {
+	now := time.Now()
+	until := now
+	
+	if _, remaining, resetTime := p.rateLimiter.GetStatus(msg.ProviderID, msg.From); remaining == 0 && resetTime.After(until) {
+		until = resetTime
+	}
+	if p.rateLimiter.Exhausted(msg.ProviderID, "") {
+		if _, _, resetTime := p.rateLimiter.GetWorkspaceStatus(msg.ProviderID); resetTime.After(until) {
+			until = resetTime
 		}
 	}
 	
-	// Update global stats
+	if !until.After(now) {
+		until = now.Add(time.Minute)
+	}
+	return until
+}
+
+// finishBatch adds the stats of a batch whose messages have all finished to the
+// processor's totals. Batches overlap, so they are added rather than replaced.
+func (p *UnifiedProcessor) finishBatch(run *batchRun) {
+	stats := run.stats
+	
 	p.mu.Lock()
-	p.stats = stats
+	p.stats.add(stats)
 	p.mu.Unlock()
 	
-	log.Printf("Unified queue processing completed: %d total, %d sent, %d failed, %d rate limited",
-		stats.TotalProcessed, stats.Sent, stats.Failed, stats.RateLimited)
+	log.Printf("Unified queue processing completed: %d total, %d sent, %d failed, %d rate limited, %d paused, %d expired, %d put back",
+		stats.TotalProcessed, stats.Sent, stats.Failed, stats.RateLimited, stats.Paused, stats.Expired, stats.Saturated)
 	
 	// Log provider-specific stats
 	for providerID, providerStats := range stats.ProviderStats {
 		log.Printf("Provider %s: %d sent, %d failed", providerID, providerStats.Sent, providerStats.Failed)
 	}
+}
+
+// batchRun collects the outcome of one batch while its messages are sent concurrently
+type batchRun struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	pauses *queue.PauseSet // Pauses in effect when the batch was dequeued
+	stats  UnifiedProcessStats
+}
+
+func (r *batchRun) recordRateLimited() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	
-	return nil
+	r.stats.TotalProcessed++
+	r.stats.RateLimited++
+}
+
+func (r *batchRun) recordPaused() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	
+	r.stats.TotalProcessed++
+	r.stats.Paused++
+}
+
+func (r *batchRun) recordSaturated() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	
+	r.stats.TotalProcessed++
+	r.stats.Saturated++
+}
+
+func (r *batchRun) recordExpired() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	
+	r.stats.TotalProcessed++
+	r.stats.Expired++
+}
+
+func (r *batchRun) record(providerID string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	
+	r.stats.TotalProcessed++
+	providerStats := r.stats.ProviderStats[providerID]
+	if err != nil {
+		r.stats.Failed++
+		providerStats.Failed++
+	} else {
+		r.stats.Sent++
+		providerStats.Sent++
+	}
+	if providerID != "" {
+		r.stats.ProviderStats[providerID] = providerStats
+	}
 }
 
 // processMessage processes a single message
-func (p *UnifiedProcessor) processMessage(msg *models.Message) (string, error) {
+func (p *UnifiedProcessor) processMessage(msg *models.Message, pauses *queue.PauseSet) (string, error) {
 	ctx := context.Background()
 	
 	// Apply variable replacement first (before personalization)
@@ -328,9 +610,10 @@ func (p *UnifiedProcessor) processMessage(msg *models.Message) (string, error) {
 	// Validate that all variables have been resolved
 	if err := variables.ValidateNoUnresolvedVariables(msg); err != nil {
 		log.Printf("Error: Message %s contains unresolved variables: %v", msg.ID, err)
-		p.queue.UpdateStatusWithProvider(msg.ID, models.StatusFailed, "", err)
+		p.recordAttempt(msg, "", provider.ErrorClassPermanentContent, err)
+		updateErr := p.queue.UpdateStatusWithProvider(msg.ID, models.StatusDead, "", err)
 		
-		if p.webhookClient != nil && p.shouldSendWebhook(msg) {
+		if !lostLease(msg, updateErr) && p.webhookClient != nil && p.shouldSendWebhook(msg) {
 			p.webhookClient.SendRejectEvent(ctx, msg, fmt.Sprintf("Unresolved variables: %v", err))
 		}
 		return "", fmt.Errorf("message contains unresolved variables: %w", err)
@@ -345,15 +628,24 @@ func (p *UnifiedProcessor) processMessage(msg *models.Message) (string, error) {
 		}
 	}
 	
-	// Route message to appropriate provider
-	selectedProvider, err := p.providerRouter.RouteMessage(ctx, msg)
+	// Route message to its provider, or its workspace's failover chain
+	chain, err := p.providerRouter.RouteChain(ctx, msg)
 	if err != nil {
 		log.Printf("Error: Failed to route message %s: %v", msg.ID, err)
-		updateErr := p.queue.UpdateStatusWithProvider(msg.ID, models.StatusFailed, "", err)
-		if updateErr != nil {
-			log.Printf("ERROR: Failed to update status to failed for message %s: %v", msg.ID, updateErr)
+		p.recordAttempt(msg, "", provider.ClassifyError(err).Class, err)
+		
+		// No provider may be available right now; routing is retried like a transient send failure
+		if p.scheduleRetry(ctx, msg, "", err) {
+			return "", fmt.Errorf("failed to route message: %w", err)
+		}
+		
+		updateErr := p.queue.UpdateStatusWithProvider(msg.ID, models.StatusDead, "", err)
+		if lostLease(msg, updateErr) {
+			return "", fmt.Errorf("failed to route message: %w", err)
+		} else if updateErr != nil {
+			log.Printf("ERROR: Failed to update status to dead for message %s: %v", msg.ID, updateErr)
 		} else {
-			log.Printf("DEBUG: Updated message %s status to failed due to routing error", msg.ID)
+			log.Printf("DEBUG: Updated message %s status to dead due to routing error", msg.ID)
 		}
 		
 		// Update recipient delivery status
@@ -365,43 +657,94 @@ func (p *UnifiedProcessor) processMessage(msg *models.Message) (string, error) {
 		return "", fmt.Errorf("failed to route message: %w", err)
 	}
 	
-	providerID := selectedProvider.GetID()
-	
-	// Send via selected provider
-	err = selectedProvider.SendMessage(ctx, msg)
-	if err != nil {
-		if strings.Contains(err.Error(), "authentication") || strings.Contains(err.Error(), "unauthorized") {
-			log.Printf("Authentication error for message %s via provider %s: %v", msg.ID, providerID, err)
-			p.queue.UpdateStatusWithProvider(msg.ID, models.StatusAuthError, providerID, err)
-			
-			// Update recipient delivery status
-			p.updateRecipientDeliveryStatus(msg, models.DeliveryStatusDeferred, err.Error())
-			
-			if p.webhookClient != nil && p.shouldSendWebhook(msg) {
-				p.webhookClient.SendDeferredEvent(ctx, msg, "Authentication error")
+	// Paused providers are left out of the chain; when every one is paused the message is held
+	var held *queue.Pause
+	usable := make([]provider.Provider, 0, len(chain))
+	for _, candidate := range chain {
+		if pause := pauses.Find("", candidate.GetID(), ""); pause != nil {
+			if held == nil {
+				held = pause
 			}
-		} else {
-			log.Printf("Error sending message %s via provider %s: %v", msg.ID, providerID, err)
-			p.queue.UpdateStatusWithProvider(msg.ID, models.StatusFailed, providerID, err)
-			
-			// Update recipient delivery status - determine if bounce or general failure
-			deliveryStatus := models.DeliveryStatusFailed
-			if strings.Contains(strings.ToLower(err.Error()), "bounce") ||
-				strings.Contains(strings.ToLower(err.Error()), "invalid") ||
-				strings.Contains(strings.ToLower(err.Error()), "not exist") {
-				deliveryStatus = models.DeliveryStatusBounced
+			continue
+		}
+		usable = append(usable, candidate)
+	}
+	if len(usable) == 0 {
+		p.holdPaused(msg, held)
+		return chain[0].GetID(), errPaused
+	}
+	return p.sendViaChain(ctx, msg, usable)
+}
+
+// sendViaChain sends msg through the first provider of chain that accepts it.
+// A provider that refuses the message outright hands it to the next one in the
+// same attempt. A provider that fails without a clear answer, such as a timeout,
+// may have accepted the message anyway, so it is only passed over once it
+// confirms it does not have it; otherwise the message is retried as usual.
+func (p *UnifiedProcessor) sendViaChain(ctx context.Context, msg *models.Message, chain []provider.Provider) (string, error) {
+	for i, selectedProvider := range chain {
+		providerID := selectedProvider.GetID()
+		
+		// Send via this provider if the workspace and provider have a free send slot
+		release, ok := p.pool.tryAcquireSend(msg.ProviderID, selectedProvider)
+		if !ok {
+			p.parkSaturated(msg, providerID)
+			return providerID, errSaturated
+		}
+		
+		// Record the send before the provider call, so a crash before the outcome is
+		// recorded leaves the message for reconciliation rather than a second send
+		if p.sends != nil {
+			if err := p.sends.MarkSending(msg.ID, providerID); err != nil {
+				release()
+				log.Printf("Warning: Not sending message %s: %v", msg.ID, err)
+				return providerID, err
 			}
-			p.updateRecipientDeliveryStatus(msg, deliveryStatus, err.Error())
-			
-			if p.webhookClient != nil && p.shouldSendWebhook(msg) {
-				p.webhookClient.SendBounceEvent(ctx, msg, err.Error())
+		}
+		
+		err := selectedProvider.SendMessage(ctx, msg)
+		release()
+		if err == nil {
+			p.recordSent(ctx, msg, providerID)
+			log.Printf("Successfully sent message %s via provider %s (%s)", msg.ID, providerID, selectedProvider.GetType())
+			return providerID, nil
+		}
+		
+		sendErr := provider.ClassifyError(err)
+		if i < len(chain)-1 {
+			failOver := sendErr.FailsOver()
+			if !failOver && sendErr.Ambiguous() {
+				var sent bool
+				if sent, failOver = p.checkSent(ctx, msg, selectedProvider); sent {
+					p.recordSent(ctx, msg, providerID)
+					return providerID, nil
+				}
+			}
+			if failOver {
+				log.Printf("Provider %s failed for message %s (%s), failing over to %s: %v",
+					providerID, msg.ID, sendErr.Class, chain[i+1].GetID(), err)
+				p.recordAttempt(msg, providerID, sendErr.Class, err)
+				continue
 			}
 		}
+		
+		p.handleSendFailure(ctx, msg, providerID, err)
 		return providerID, err
 	}
-	
-	// Mark as sent with provider ID
-	err = p.queue.UpdateStatusWithProvider(msg.ID, models.StatusSent, providerID, nil)
+	return "", nil // Not reached: the last provider in the chain either sends or fails
+}
+
+// recordSent marks a message the provider accepted as sent, with the provider's
+// message ID when the queue tracks sends, and reports it to the recipient
+// tracking, the rate limiter and the workspace webhook
+func (p *UnifiedProcessor) recordSent(ctx context.Context, msg *models.Message, providerID string) {
+	p.recordAttempt(msg, providerID, "", nil)
+	var err error
+	if p.sends != nil {
+		err = p.sends.MarkSent(msg.ID, providerID, msg.ProviderMessageID)
+	} else {
+		err = p.queue.UpdateStatusWithProvider(msg.ID, models.StatusSent, providerID, nil)
+	}
 	if err != nil {
 		log.Printf("Error updating message status: %v", err)
 	}
@@ -423,10 +766,108 @@ func (p *UnifiedProcessor) processMessage(msg *models.Message) (string, error) {
 			log.Printf("Error sending webhook for message %s: %v", msg.ID, err)
 		}
 	}
+}
+
+// handleSendFailure records a failed send according to the provider's error class
+// and the workspace retry policy: retryable errors are retried with backoff until
+// the policy's attempts are used up, all other classes go to the dead letters immediately
+func (p *UnifiedProcessor) handleSendFailure(ctx context.Context, msg *models.Message, providerID string, err error) {
+	sendErr := provider.ClassifyError(err)
+	p.recordAttempt(msg, providerID, sendErr.Class, err)
+	
+	if sendErr.Class == provider.ErrorClassAuth {
+		log.Printf("Authentication error for message %s via provider %s: %v", msg.ID, providerID, err)
+		if lostLease(msg, p.queue.UpdateStatusWithProvider(msg.ID, models.StatusAuthError, providerID, err)) {
+			return
+		}
+		
+		// Update recipient delivery status
+		p.updateRecipientDeliveryStatus(msg, models.DeliveryStatusDeferred, err.Error())
+		
+		if p.webhookClient != nil && p.shouldSendWebhook(msg) {
+			p.webhookClient.SendDeferredEvent(ctx, msg, "Authentication error")
+		}
+		return
+	}
+	
+	if sendErr.Retryable() && p.scheduleRetry(ctx, msg, providerID, err) {
+		return
+	}
+	
+	log.Printf("Error sending message %s via provider %s (%s), moving to dead letters: %v", msg.ID, providerID, sendErr.Class, err)
+	if lostLease(msg, p.queue.UpdateStatusWithProvider(msg.ID, models.StatusDead, providerID, err)) {
+		return
+	}
+	
+	switch sendErr.Class {
+	case provider.ErrorClassPermanentRecipient:
+		p.updateRecipientDeliveryStatus(msg, models.DeliveryStatusBounced, err.Error())
+		if p.webhookClient != nil && p.shouldSendWebhook(msg) {
+			p.webhookClient.SendBounceEvent(ctx, msg, err.Error())
+		}
+	case provider.ErrorClassPermanentContent, provider.ErrorClassConfiguration:
+		p.updateRecipientDeliveryStatus(msg, models.DeliveryStatusFailed, err.Error())
+		if p.webhookClient != nil && p.shouldSendWebhook(msg) {
+			p.webhookClient.SendRejectEvent(ctx, msg, err.Error())
+		}
+	default:
+		// Retryable error with no attempts left
+		p.updateRecipientDeliveryStatus(msg, models.DeliveryStatusFailed, err.Error())
+		if p.webhookClient != nil && p.shouldSendWebhook(msg) {
+			p.webhookClient.SendBounceEvent(ctx, msg, err.Error())
+		}
+	}
+}
+
+// scheduleRetry queues msg for another attempt with backoff. It returns false
+// when the workspace retry policy has no attempts left.
+func (p *UnifiedProcessor) scheduleRetry(ctx context.Context, msg *models.Message, providerID string, err error) bool {
+	policy := p.retryPolicyFor(msg)
+	attempt := msg.RetryCount + 1
+	nextAttempt, ok := nextAttemptTime(policy, attempt, provider.ClassifyError(err).RetryAfter, time.Now())
+	if !ok {
+		log.Printf("Giving up on message %s after %d attempts", msg.ID, attempt)
+		return false
+	}
 	
-	log.Printf("Successfully sent message %s via provider %s (%s)", msg.ID, providerID, selectedProvider.GetType())
+	// A retry after the message expires would only expire it then
+	if msg.ExpiresAt != nil && nextAttempt.After(*msg.ExpiresAt) {
+		log.Printf("Retryable error for message %s, which expires before its next attempt: %v", msg.ID, err)
+		p.expireMessage(ctx, msg)
+		return true
+	}
 	
-	return providerID, nil
+	log.Printf("Retryable error for message %s (attempt %d/%d), retrying at %s: %v",
+		msg.ID, attempt, policy.MaxAttempts, nextAttempt.Format(time.RFC3339), err)
+	if updateErr := p.queue.ScheduleRetry(msg.ID, providerID, nextAttempt, err); lostLease(msg, updateErr) {
+		return true
+	} else if updateErr != nil {
+		log.Printf("ERROR: Failed to schedule retry for message %s: %v", msg.ID, updateErr)
+	}
+	
+	p.updateRecipientDeliveryStatus(msg, models.DeliveryStatusDeferred, err.Error())
+	
+	if p.webhookClient != nil && p.shouldSendWebhook(msg) {
+		p.webhookClient.SendDeferredEvent(ctx, msg, err.Error())
+	}
+	return true
+}
+
+// recordAttempt adds the current send attempt to the message's history; class and err are empty on success
+func (p *UnifiedProcessor) recordAttempt(msg *models.Message, providerID string, class provider.ErrorClass, err error) {
+	attempt := models.Attempt{
+		Number:      msg.RetryCount + 1,
+		ProviderID:  providerID,
+		ErrorClass:  string(class),
+		AttemptedAt: time.Now(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	
+	if recordErr := p.queue.RecordAttempt(msg.ID, attempt); recordErr != nil {
+		log.Printf("Warning: Failed to record attempt for message %s: %v", msg.ID, recordErr)
+	}
 }
 
 // shouldSendWebhook checks if webhooks are enabled for this message's workspace
@@ -485,12 +926,13 @@ func (p *UnifiedProcessor) updateRecipientDeliveryStatus(msg *models.Message, st
 	}
 }
 
-// GetStatus returns the current processing status
+// GetStatus returns whether a batch is being dispatched or sent, the last run
+// time and the stats of the last completed batch
 func (p *UnifiedProcessor) GetStatus() (bool, time.Time, any) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	
-	return p.processing, p.lastRun, p.stats
+	return p.processing || p.pool.inFlight() > 0, p.lastRun, p.stats.copy()
 }
 
 // GetRateLimitStatus returns rate limiting statistics
@@ -504,8 +946,10 @@ func (p *UnifiedProcessor) GetProviderStats() map[string]interface{} {
 	defer p.mu.Unlock()
 	
 	return map[string]interface{}{
-		"provider_stats": p.stats.ProviderStats,
+		"provider_stats": p.stats.copy().ProviderStats,
 		"router_stats":   p.providerRouter.GetStats(),
+		"in_flight":      p.pool.inFlight(),
+		"workers":        cap(p.pool.workers),
 	}
 }
 
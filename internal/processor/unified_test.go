@@ -0,0 +1,52 @@
+package processor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"relay/internal/config"
+)
+
+func newTestBatchRun() *batchRun {
+	return &batchRun{stats: UnifiedProcessStats{
+		LastProcessedAt: time.Now(),
+		ProviderStats:   make(map[string]ProviderProcessStats),
+	}}
+}
+
+func TestFinishBatchAddsToTotals(t *testing.T) {
+	p := &UnifiedProcessor{pool: newSendPool(config.ConcurrencyConfig{})}
+
+	// Batches overlap, so each one's counts are added whichever finishes last
+	first, second := newTestBatchRun(), newTestBatchRun()
+	first.record("gmail-ws", nil)
+	first.record("gmail-ws", errors.New("timeout"))
+	first.recordSaturated()
+	second.record("gmail-ws", nil)
+	second.record("mailgun-ws", nil)
+	second.recordRateLimited()
+	p.finishBatch(second)
+	p.finishBatch(first)
+
+	_, _, status := p.GetStatus()
+	stats := status.(UnifiedProcessStats)
+	if stats.TotalProcessed != 6 || stats.Sent != 3 || stats.Failed != 1 || stats.RateLimited != 1 || stats.Saturated != 1 {
+		t.Errorf("totals = %+v", stats)
+	}
+	if gmail := stats.ProviderStats["gmail-ws"]; gmail.Sent != 2 || gmail.Failed != 1 {
+		t.Errorf("gmail-ws totals = %+v, want 2 sent and 1 failed", gmail)
+	}
+	if mailgun := stats.ProviderStats["mailgun-ws"]; mailgun.Sent != 1 {
+		t.Errorf("mailgun-ws totals = %+v, want 1 sent", mailgun)
+	}
+	if stats.LastProcessedAt.Before(first.stats.LastProcessedAt) {
+		t.Errorf("last processed at %v, want the later batch's", stats.LastProcessedAt)
+	}
+
+	// The returned stats are a copy the next batch does not change
+	p.finishBatch(second)
+	if stats.ProviderStats["mailgun-ws"].Sent != 1 {
+		t.Error("GetStatus returned provider stats that later batches change")
+	}
+}
@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"relay/internal/queue"
+)
+
+// registerWorker announces this replica in the worker registry so the web UI
+// can show which replica holds which messages
+func (p *UnifiedProcessor) registerWorker() {
+	hostname, _ := os.Hostname()
+	now := time.Now()
+
+	info := queue.WorkerInfo{
+		ID:                       p.workerID,
+		Hostname:                 hostname,
+		PID:                      os.Getpid(),
+		StartedAt:                now,
+		LastHeartbeatAt:          now,
+		HeartbeatIntervalSeconds: int(p.heartbeatInterval().Seconds()),
+	}
+	if err := p.registry.RegisterWorker(info); err != nil {
+		log.Printf("Warning: Failed to register worker %s: %v", p.workerID, err)
+		return
+	}
+	log.Printf("Registered worker %s", p.workerID)
+}
+
+// runHeartbeat keeps this replica's registry entry fresh until the processor stops
+func (p *UnifiedProcessor) runHeartbeat() {
+	ticker := time.NewTicker(p.heartbeatInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.registry.Heartbeat(p.workerID); err != nil {
+				log.Printf("Warning: Failed to send heartbeat for worker %s: %v", p.workerID, err)
+			}
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *UnifiedProcessor) deregisterWorker() {
+	if err := p.registry.DeregisterWorker(p.workerID); err != nil {
+		log.Printf("Warning: Failed to deregister worker %s: %v", p.workerID, err)
+	}
+}
+
+func (p *UnifiedProcessor) heartbeatInterval() time.Duration {
+	if p.config.Queue.HeartbeatInterval > 0 {
+		return p.config.Queue.HeartbeatInterval
+	}
+	return 15 * time.Second
+}
@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"relay/internal/blobstore"
+	"relay/pkg/models"
+)
+
+// attachmentReadError is returned when attachment content could not be read
+// while building the outgoing message
+type attachmentReadError struct {
+	name string
+	err  error
+}
+
+func (e *attachmentReadError) Error() string {
+	return fmt.Sprintf("failed to read attachment %s: %v", e.name, e.err)
+}
+
+func (e *attachmentReadError) Unwrap() error {
+	return e.err
+}
+
+// copyAttachment streams an attachment's content into w, reading externalized
+// content from store
+func copyAttachment(ctx context.Context, store blobstore.Store, w io.Writer, att models.Attachment) error {
+	r, err := blobstore.Open(ctx, store, att)
+	if err != nil {
+		return &attachmentReadError{name: att.Name, err: err}
+	}
+	defer r.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		return &attachmentReadError{name: att.Name, err: err}
+	}
+	return nil
+}
+
+// readAttachment returns an attachment's content for payloads that embed it whole
+func readAttachment(ctx context.Context, store blobstore.Store, att models.Attachment) ([]byte, error) {
+	content, err := blobstore.ReadAll(ctx, store, att)
+	if err != nil {
+		return nil, &attachmentReadError{name: att.Name, err: err}
+	}
+	return content, nil
+}
+
+// buildError classifies a failure to build the outgoing message. Attachment
+// content the store could not serve is retried, since the store may recover,
+// and a missing store is a configuration problem; anything else, including a
+// blob that no longer exists, is a problem with the message itself.
+func buildError(providerType ProviderType, message string, err error) *SendError {
+	var readErr *attachmentReadError
+	switch {
+	case !errors.As(err, &readErr), errors.Is(err, blobstore.ErrNotFound):
+		return NewSendError(ErrorClassPermanentContent, providerType, message, err)
+	case errors.Is(err, blobstore.ErrNoStore):
+		return NewSendError(ErrorClassConfiguration, providerType, message, err)
+	default:
+		return NewSendError(ErrorClassTransient, providerType, message, err)
+	}
+}
@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"relay/internal/blobstore"
+	"relay/pkg/models"
+)
+
+func TestBuildMultipartBodyStreamsStoredAttachments(t *testing.T) {
+	ctx := context.Background()
+	store, err := blobstore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	content := []byte(strings.Repeat("0123456789", 20))
+	msg := &models.Message{
+		Text:        "See attached",
+		Attachments: []models.Attachment{{Name: "digits.txt", ContentType: "text/plain", Content: content}},
+	}
+	if err := blobstore.Externalize(ctx, store, msg.Attachments); err != nil {
+		t.Fatalf("Externalize: %v", err)
+	}
+
+	_, body, err := buildMultipartBody(ctx, store, msg)
+	if err != nil {
+		t.Fatalf("buildMultipartBody: %v", err)
+	}
+	var want strings.Builder
+	for encoded := base64.StdEncoding.EncodeToString(content); encoded != ""; {
+		n := min(76, len(encoded))
+		want.WriteString(encoded[:n] + "\r\n")
+		encoded = encoded[n:]
+	}
+	if !strings.Contains(string(body), want.String()) {
+		t.Errorf("body does not hold the wrapped attachment:\n%s", body)
+	}
+
+	// A store that cannot serve the content makes the send retryable
+	_, _, err = buildMultipartBody(ctx, failingStore{}, msg)
+	if sendErr := buildError(ProviderTypeGmail, "failed to create Gmail message", err); !sendErr.Retryable() {
+		t.Errorf("store outage classified as %s, want retryable", sendErr.Class)
+	}
+}
+
+// failingStore is an attachment store that is down
+type failingStore struct{ blobstore.Store }
+
+func (failingStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return nil, errors.New("connection refused")
+}
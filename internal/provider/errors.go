@@ -0,0 +1,154 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorClass categorizes a failed send so callers can decide whether to retry,
+// bounce or reject without inspecting error strings
+type ErrorClass string
+
+const (
+	// ErrorClassTransient covers timeouts, network errors and provider 5xx responses
+	ErrorClassTransient ErrorClass = "transient"
+	// ErrorClassRateLimited means the provider throttled us; see SendError.RetryAfter
+	ErrorClassRateLimited ErrorClass = "rate_limited"
+	// ErrorClassAuth means credentials or delegation must be fixed before sending can succeed
+	ErrorClassAuth ErrorClass = "auth"
+	// ErrorClassPermanentRecipient means the recipient can never receive the message (hard bounce, invalid address)
+	ErrorClassPermanentRecipient ErrorClass = "permanent_recipient"
+	// ErrorClassPermanentContent means the provider rejected the message itself
+	ErrorClassPermanentContent ErrorClass = "permanent_content"
+	// ErrorClassConfiguration means the provider or workspace is misconfigured (unknown domain, unverified sender)
+	ErrorClassConfiguration ErrorClass = "configuration"
+)
+
+// SendError is returned by providers when a message could not be sent
+type SendError struct {
+	Class      ErrorClass
+	Provider   ProviderType
+	StatusCode int           // HTTP or API status code when known
+	RetryAfter time.Duration // Set for rate-limited errors when the provider says when to retry
+	Message    string
+	Cause      error
+}
+
+func (e *SendError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s %s error: %s: %v", e.Provider, e.Class, e.Message, e.Cause)
+	}
+	return fmt.Sprintf("%s %s error: %s", e.Provider, e.Class, e.Message)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Cause
+}
+
+// Retryable reports whether sending the same message again may succeed without intervention
+func (e *SendError) Retryable() bool {
+	return e.Class == ErrorClassTransient || e.Class == ErrorClassRateLimited
+}
+
+// FailsOver reports whether the next provider of a workspace's failover chain
+// should be tried: this provider explicitly refused the message with a 5xx
+// response, throttling or rejected credentials. A failure without a response
+// does not qualify, since the provider may have accepted the message; see Ambiguous.
+func (e *SendError) FailsOver() bool {
+	switch e.Class {
+	case ErrorClassRateLimited, ErrorClassAuth:
+		return true
+	case ErrorClassTransient:
+		return e.StatusCode >= 500
+	default:
+		return false
+	}
+}
+
+// Ambiguous reports whether the provider may have accepted the message despite
+// the error: a transient failure without a response, such as a timeout or a
+// dropped connection
+func (e *SendError) Ambiguous() bool {
+	return e.Class == ErrorClassTransient && e.StatusCode == 0
+}
+
+// IsPermanent reports whether the message itself or its recipient can never be delivered
+func (e *SendError) IsPermanent() bool {
+	return e.Class == ErrorClassPermanentRecipient || e.Class == ErrorClassPermanentContent
+}
+
+// NewSendError creates a SendError of the given class
+func NewSendError(class ErrorClass, providerType ProviderType, message string, cause error) *SendError {
+	return &SendError{Class: class, Provider: providerType, Message: message, Cause: cause}
+}
+
+// ClassifyError returns the SendError in err's chain. Errors that were not
+// produced by a provider (routing failures, unexpected errors) are treated as transient.
+func ClassifyError(err error) *SendError {
+	if err == nil {
+		return nil
+	}
+
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr
+	}
+
+	var authErr *GmailAuthError
+	if errors.As(err, &authErr) {
+		return &SendError{Class: ErrorClassAuth, Provider: ProviderTypeGmail, Message: authErr.Message, Cause: err}
+	}
+
+	return &SendError{Class: ErrorClassTransient, Message: "unclassified error", Cause: err}
+}
+
+// ClassForHTTPStatus maps a provider API status code to an error class.
+// 400-range codes other than auth, throttling and not-found are content rejections.
+func ClassForHTTPStatus(statusCode int) ErrorClass {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrorClassAuth
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorClassRateLimited
+	case statusCode == http.StatusNotFound:
+		return ErrorClassConfiguration
+	case statusCode == http.StatusRequestTimeout || statusCode >= 500:
+		return ErrorClassTransient
+	case statusCode >= 400:
+		return ErrorClassPermanentContent
+	default:
+		return ErrorClassTransient
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// mentionsRecipient reports whether a provider error message is about a recipient address
+func mentionsRecipient(message string) bool {
+	message = strings.ToLower(message)
+	for _, marker := range []string{"recipient", "'to'", "to header", "to address", "invalid to", "mailbox", "user unknown"} {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestClassForHTTPStatus(t *testing.T) {
+	tests := map[int]ErrorClass{
+		400: ErrorClassPermanentContent,
+		401: ErrorClassAuth,
+		403: ErrorClassAuth,
+		404: ErrorClassConfiguration,
+		408: ErrorClassTransient,
+		429: ErrorClassRateLimited,
+		500: ErrorClassTransient,
+		503: ErrorClassTransient,
+	}
+	for code, want := range tests {
+		if got := ClassForHTTPStatus(code); got != want {
+			t.Errorf("ClassForHTTPStatus(%d) = %s, want %s", code, got, want)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	wrapped := fmt.Errorf("routing: %w", NewSendError(ErrorClassPermanentRecipient, ProviderTypeMailgun, "bad address", nil))
+	if got := ClassifyError(wrapped); got.Class != ErrorClassPermanentRecipient || got.Retryable() {
+		t.Errorf("wrapped SendError classified as %s", got.Class)
+	}
+
+	if got := ClassifyError(&GmailAuthError{SenderEmail: "a@example.com"}); got.Class != ErrorClassAuth {
+		t.Errorf("GmailAuthError classified as %s", got.Class)
+	}
+
+	if got := ClassifyError(errors.New("no provider available")); got.Class != ErrorClassTransient {
+		t.Errorf("unknown error classified as %s", got.Class)
+	}
+}
+
+func TestSendErrorFailsOver(t *testing.T) {
+	tests := []struct {
+		class     ErrorClass
+		status    int
+		failsOver bool
+		ambiguous bool
+	}{
+		{ErrorClassTransient, 503, true, false},
+		{ErrorClassTransient, 408, false, false},
+		{ErrorClassTransient, 0, false, true},
+		{ErrorClassRateLimited, 429, true, false},
+		{ErrorClassRateLimited, 0, true, false},
+		{ErrorClassAuth, 401, true, false},
+		{ErrorClassAuth, 403, true, false},
+		{ErrorClassPermanentRecipient, 0, false, false},
+		{ErrorClassPermanentContent, 400, false, false},
+		{ErrorClassConfiguration, 404, false, false},
+	}
+	for _, tt := range tests {
+		err := NewSendError(tt.class, ProviderTypeGmail, "failed", nil)
+		err.StatusCode = tt.status
+		if got := err.FailsOver(); got != tt.failsOver {
+			t.Errorf("%s %d FailsOver() = %v, want %v", tt.class, tt.status, got, tt.failsOver)
+		}
+		if got := err.Ambiguous(); got != tt.ambiguous {
+			t.Errorf("%s %d Ambiguous() = %v, want %v", tt.class, tt.status, got, tt.ambiguous)
+		}
+	}
+
+	if err := ClassifyError(context.DeadlineExceeded); err.FailsOver() || !err.Ambiguous() {
+		t.Errorf("deadline exceeded: FailsOver() = %v, Ambiguous() = %v", err.FailsOver(), err.Ambiguous())
+	}
+}
+
+func TestClassifyGmailAPIError(t *testing.T) {
+	throttled := &googleapi.Error{
+		Code:   403,
+		Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}},
+		Header: http.Header{"Retry-After": []string{"120"}},
+	}
+	if got := classifyGmailAPIError(throttled); got.Class != ErrorClassRateLimited || got.RetryAfter != 2*time.Minute {
+		t.Errorf("throttled error = %s retry after %v", got.Class, got.RetryAfter)
+	}
+
+	badRecipient := &googleapi.Error{Code: 400, Message: "Invalid To header"}
+	if got := classifyGmailAPIError(badRecipient); got.Class != ErrorClassPermanentRecipient {
+		t.Errorf("invalid recipient classified as %s", got.Class)
+	}
+}
+
+func TestClassifyMandrillRejection(t *testing.T) {
+	tests := []struct {
+		status, reason string
+		want           ErrorClass
+	}{
+		{"rejected", "hard-bounce", ErrorClassPermanentRecipient},
+		{"rejected", "soft-bounce", ErrorClassTransient},
+		{"rejected", "unsigned", ErrorClassConfiguration},
+		{"rejected", "rule", ErrorClassPermanentContent},
+		{"invalid", "", ErrorClassPermanentRecipient},
+	}
+	for _, tt := range tests {
+		if got := classifyMandrillRejection(tt.status, tt.reason); got != tt.want {
+			t.Errorf("classifyMandrillRejection(%q, %q) = %s, want %s", tt.status, tt.reason, got, tt.want)
+		}
+	}
+}
@@ -1,18 +1,26 @@
 package provider
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net/mail"
+	"net/textproto"
 	"os"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"relay/internal/blobstore"
 	"relay/internal/config"
+	"relay/internal/mimeparser"
 	"relay/pkg/models"
 
 	"golang.org/x/oauth2"
@@ -38,6 +46,43 @@ func (e *GmailAuthError) Unwrap() error {
 	return e.Cause
 }
 
+// gmailRateLimitReasons are googleapi error reasons that mean the request was throttled
+var gmailRateLimitReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"dailyLimitExceeded":    true,
+	"quotaExceeded":         true,
+}
+
+// classifyGmailAPIError converts a Gmail API error into a SendError. Gmail reports
+// throttling as 403 or 429 with a rate limit reason, and bad recipients as 400.
+func classifyGmailAPIError(googleErr *googleapi.Error) *SendError {
+	sendErr := &SendError{
+		Class:      ClassForHTTPStatus(googleErr.Code),
+		Provider:   ProviderTypeGmail,
+		StatusCode: googleErr.Code,
+		Message:    fmt.Sprintf("Gmail API error (code %d): %s", googleErr.Code, googleErr.Message),
+		Cause:      googleErr,
+	}
+
+	for _, item := range googleErr.Errors {
+		if gmailRateLimitReasons[item.Reason] {
+			sendErr.Class = ErrorClassRateLimited
+		}
+	}
+
+	switch sendErr.Class {
+	case ErrorClassRateLimited:
+		sendErr.RetryAfter = parseRetryAfter(googleErr.Header.Get("Retry-After"))
+	case ErrorClassPermanentContent:
+		if mentionsRecipient(googleErr.Message) {
+			sendErr.Class = ErrorClassPermanentRecipient
+		}
+	}
+
+	return sendErr
+}
+
 // GmailProvider implements the Provider interface for Gmail/Google Workspace
 type GmailProvider struct {
 	id              string
@@ -45,6 +90,7 @@ type GmailProvider struct {
 	config          *config.WorkspaceGmailConfig
 	domains         []string
 	displayName     string
+	attachments     blobstore.Store // Serves attachment content the queue externalized
 	
 	// Service cache for different sender emails within this workspace
 	serviceCacheMu  sync.RWMutex
@@ -135,22 +181,26 @@ func NewGmailProvider(workspaceID string, domains []string, config *config.Works
 // SendMessage implements Provider.SendMessage
 func (g *GmailProvider) SendMessage(ctx context.Context, msg *models.Message) error {
 	if msg == nil {
-		return fmt.Errorf("message cannot be nil")
+		return NewSendError(ErrorClassPermanentContent, ProviderTypeGmail, "message cannot be nil", nil)
 	}
 	
 	if msg.From == "" {
-		return fmt.Errorf("sender email is required")
+		return NewSendError(ErrorClassPermanentContent, ProviderTypeGmail, "sender email is required", nil)
 	}
 	
 	if len(msg.To) == 0 {
-		return fmt.Errorf("at least one recipient is required")
+		return NewSendError(ErrorClassPermanentRecipient, ProviderTypeGmail, "at least one recipient is required", nil)
 	}
 	
 	// Validate sender email format
 	if !isValidEmail(msg.From) {
-		return fmt.Errorf("sender email format is invalid: %s", msg.From)
+		return NewSendError(ErrorClassPermanentContent, ProviderTypeGmail, fmt.Sprintf("sender email format is invalid: %s", msg.From), nil)
 	}
 	
+	// Fix the Message-ID before the sender can be substituted below, so FindSent
+	// derives the same value from the queued message
+	ensureMessageID(msg)
+	
 	originalSender := msg.From
 	var authAttempts []string
 	
@@ -167,12 +217,12 @@ func (g *GmailProvider) SendMessage(ctx context.Context, msg *models.Message) er
 			if err != nil {
 				authAttempts = append(authAttempts, fmt.Sprintf("%s (default): %v", g.config.DefaultSender, err))
 				g.setUnhealthy(err)
-				return &GmailAuthError{
+				return NewSendError(ErrorClassAuth, ProviderTypeGmail, "authentication failed", &GmailAuthError{
 					SenderEmail: originalSender,
 					ErrorType:   "authentication_failed",
 					Message:     fmt.Sprintf("Authentication failed for both original sender and default sender. Attempts: %s", strings.Join(authAttempts, "; ")),
 					Cause:       err,
-				}
+				})
 			} else {
 				// Successfully authenticated with default sender
 				msg.From = g.config.DefaultSender
@@ -186,19 +236,24 @@ func (g *GmailProvider) SendMessage(ctx context.Context, msg *models.Message) er
 			}
 		} else {
 			g.setUnhealthy(err)
-			return &GmailAuthError{
+			return NewSendError(ErrorClassAuth, ProviderTypeGmail, "authentication failed", &GmailAuthError{
 				SenderEmail: originalSender,
 				ErrorType:   "authentication_failed",
 				Message:     fmt.Sprintf("Failed to authenticate sender %s and no default sender configured. %s", originalSender, g.formatAuthenticationGuidance(err)),
 				Cause:       err,
-			}
+			})
 		}
 	}
 	
-	// Create Gmail message
-	gmailMessage, err := g.createGmailMessage(msg)
+	// Create Gmail message, submitting the original bytes when raw passthrough is enabled
+	var gmailMessage *gmail.Message
+	if g.config.RawPassthrough && len(msg.RawMessage) > 0 {
+		gmailMessage, err = g.createRawPassthroughMessage(msg)
+	} else {
+		gmailMessage, err = g.createGmailMessage(ctx, msg)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create Gmail message: %w", err)
+		return buildError(ProviderTypeGmail, "failed to create Gmail message", err)
 	}
 	
 	// Send the message
@@ -212,17 +267,18 @@ func (g *GmailProvider) SendMessage(ctx context.Context, msg *models.Message) er
 		// Provide detailed error information for send failures
 		if googleErr, ok := err.(*googleapi.Error); ok {
 			log.Printf("Gmail API error for %s (took %v): Code=%d, Message=%s", msg.From, sendDuration, googleErr.Code, googleErr.Message)
-			return fmt.Errorf("Gmail API error (code %d): %s", googleErr.Code, googleErr.Message)
+			return classifyGmailAPIError(googleErr)
 		}
 		
 		log.Printf("Gmail send failed for %s (took %v): %v", msg.From, sendDuration, err)
-		return fmt.Errorf("failed to send email via Gmail: %w", err)
+		return NewSendError(ErrorClassTransient, ProviderTypeGmail, "failed to send email via Gmail", err)
 	}
 	
 	// Update message ID with Gmail's message ID
 	if result != nil && result.Id != "" {
 		msg.Metadata = initializeMetadata(msg.Metadata)
 		msg.Metadata["gmail_message_id"] = result.Id
+		msg.ProviderMessageID = result.Id
 	}
 	
 	// Mark as healthy on successful send
@@ -274,6 +330,88 @@ func (g *GmailProvider) getServiceAccountData() ([]byte, error) {
 	return nil, fmt.Errorf("no service account credentials available")
 }
 
+// ensureMessageID adds the Message-ID returned by RFCMessageID to the message's
+// headers when it was submitted without one
+func ensureMessageID(msg *models.Message) {
+	for name := range msg.Headers {
+		if strings.EqualFold(name, "Message-ID") {
+			return
+		}
+	}
+	id := msg.RFCMessageID()
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string)
+	}
+	msg.Headers["Message-ID"] = id
+}
+
+// FindSent searches the sender's mailbox, including spam and trash, for the
+// message's Message-ID. A send that fell back to the default sender is looked
+// for in that mailbox as well. Searching needs the gmail.readonly scope in
+// addition to gmail.send in the domain-wide delegation.
+func (g *GmailProvider) FindSent(ctx context.Context, msg *models.Message) (string, bool, error) {
+	query := "rfc822msgid:" + strings.Trim(msg.RFCMessageID(), "<>")
+	senders := []string{msg.From}
+	if g.config.DefaultSender != "" && g.config.DefaultSender != msg.From {
+		senders = append(senders, g.config.DefaultSender)
+	}
+	
+	var lastErr error
+	for _, sender := range senders {
+		service, err := g.getSearchService(ctx, sender)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		result, err := service.Users.Messages.List("me").Q(query).IncludeSpamTrash(true).MaxResults(1).Context(ctx).Do()
+		if err != nil {
+			if googleErr, ok := err.(*googleapi.Error); ok {
+				lastErr = classifyGmailAPIError(googleErr)
+			} else {
+				lastErr = NewSendError(ErrorClassTransient, ProviderTypeGmail, "failed to search Gmail", err)
+			}
+			continue
+		}
+		if len(result.Messages) > 0 {
+			return result.Messages[0].Id, true, nil
+		}
+	}
+	return "", false, lastErr
+}
+
+// getSearchService creates a Gmail service that can read the sender's mailbox.
+// It is only used for reconciliation, so it is not cached.
+func (g *GmailProvider) getSearchService(ctx context.Context, senderEmail string) (*gmail.Service, error) {
+	serviceAccountData, err := g.getServiceAccountData()
+	if err != nil {
+		return nil, &GmailAuthError{
+			SenderEmail: senderEmail,
+			ErrorType:   "service_account_credentials",
+			Message:     fmt.Sprintf("unable to get service account credentials: %v", err),
+			Cause:       err,
+		}
+	}
+	
+	jwtConfig, err := google.JWTConfigFromJSON(serviceAccountData, gmail.GmailReadonlyScope)
+	if err != nil {
+		return nil, &GmailAuthError{
+			SenderEmail: senderEmail,
+			ErrorType:   "jwt_config",
+			Message:     "unable to create JWT config from service account file",
+			Cause:       err,
+		}
+	}
+	if err := g.validateSenderBeforeImpersonation(senderEmail); err != nil {
+		return nil, err
+	}
+	jwtConfig.Subject = senderEmail
+	
+	if _, err := jwtConfig.TokenSource(ctx).Token(); err != nil {
+		return nil, g.parseOAuth2Error(senderEmail, err)
+	}
+	return gmail.NewService(ctx, option.WithHTTPClient(jwtConfig.Client(ctx)))
+}
+
 func (g *GmailProvider) getServiceForSender(ctx context.Context, senderEmail string) (*gmail.Service, error) {
 	// Check validation cache first if sender validation is required
 	if g.config.RequireValidSender {
@@ -370,7 +508,7 @@ func (g *GmailProvider) getServiceForSender(ctx context.Context, senderEmail str
 }
 
 // createGmailMessage converts a models.Message to a Gmail message
-func (g *GmailProvider) createGmailMessage(msg *models.Message) (*gmail.Message, error) {
+func (g *GmailProvider) createGmailMessage(ctx context.Context, msg *models.Message) (*gmail.Message, error) {
 	var messageBuilder strings.Builder
 	
 	// Format From header
@@ -391,7 +529,7 @@ func (g *GmailProvider) createGmailMessage(msg *models.Message) (*gmail.Message,
 	}
 	
 	// Subject
-	messageBuilder.WriteString(fmt.Sprintf("Subject: %s\r\n", msg.Subject))
+	messageBuilder.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject)))
 	
 	// Add custom headers (excluding reserved ones)
 	if msg.Headers != nil {
@@ -403,17 +541,30 @@ func (g *GmailProvider) createGmailMessage(msg *models.Message) (*gmail.Message,
 	}
 	
 	// Content type and body
-	if msg.HTML != "" {
-		messageBuilder.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
-		messageBuilder.WriteString("\r\n")
-		messageBuilder.WriteString(msg.HTML)
-	} else if msg.Text != "" {
-		messageBuilder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
-		messageBuilder.WriteString("\r\n")
-		messageBuilder.WriteString(msg.Text)
-	} else {
+	if msg.HTML == "" && msg.Text == "" {
 		return nil, fmt.Errorf("message must contain either HTML or text content")
 	}
+	if len(msg.Attachments) == 0 && (msg.HTML == "" || msg.Text == "") {
+		// Single-part body
+		if msg.HTML != "" {
+			messageBuilder.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+			messageBuilder.WriteString("\r\n")
+			messageBuilder.WriteString(msg.HTML)
+		} else {
+			messageBuilder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+			messageBuilder.WriteString("\r\n")
+			messageBuilder.WriteString(msg.Text)
+		}
+	} else {
+		contentType, body, err := buildMultipartBody(ctx, g.attachments, msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build MIME body: %w", err)
+		}
+		messageBuilder.WriteString("MIME-Version: 1.0\r\n")
+		messageBuilder.WriteString(fmt.Sprintf("Content-Type: %s\r\n", contentType))
+		messageBuilder.WriteString("\r\n")
+		messageBuilder.Write(body)
+	}
 	
 	// Encode message
 	rawMessage := base64.URLEncoding.EncodeToString([]byte(messageBuilder.String()))
@@ -423,6 +574,369 @@ func (g *GmailProvider) createGmailMessage(msg *models.Message) (*gmail.Message,
 	}, nil
 }
 
+// createRawPassthroughMessage submits the original DATA bytes as the Gmail Raw payload.
+// Only the header block is touched: From follows sender rewrites, workspace header
+// rewrite rules are applied and envelope-only recipients are added as Bcc so Gmail
+// delivers to them. The body, including its MIME structure, is sent unchanged.
+func (g *GmailProvider) createRawPassthroughMessage(msg *models.Message) (*gmail.Message, error) {
+	headerBlock, body := mimeparser.SplitHeaderBody(msg.RawMessage)
+	if len(headerBlock) == 0 {
+		return nil, fmt.Errorf("raw message has no header block")
+	}
+
+	newline := "\n"
+	if bytes.Contains(headerBlock, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+
+	var rules []config.WorkspaceHeaderRewriteRule
+	if g.config.HeaderRewrite.Enabled {
+		rules = g.config.HeaderRewrite.Rules
+	}
+	appliedRules := make(map[int]bool)
+
+	var out bytes.Buffer
+	visibleRecipients := make(map[string]bool)
+	hasMessageID := false
+
+	// SplitHeaderBody drops the last header's line ending; restore it so the
+	// field keeps the message's own line endings
+	for _, field := range splitHeaderFields(string(headerBlock) + newline) {
+		name, value := field.name, field.value
+
+		if strings.EqualFold(name, "bcc") {
+			// Rebuilt below from the envelope recipients
+			continue
+		}
+
+		if strings.EqualFold(name, "from") {
+			if rewritten, changed := rewriteFromAddress(value, msg.From); changed {
+				log.Printf("DEBUG: Rewriting From header in raw message %s: %s -> %s", msg.ID, value, rewritten)
+				out.WriteString("From: " + rewritten + newline)
+				continue
+			}
+		}
+
+		ruleIndex := findHeaderRule(rules, name)
+		if ruleIndex >= 0 {
+			appliedRules[ruleIndex] = true
+			if rules[ruleIndex].NewValue == "" {
+				log.Printf("DEBUG: Removing header %s from raw message %s", name, msg.ID)
+				continue
+			}
+			value = rules[ruleIndex].NewValue
+			out.WriteString(name + ": " + value + newline)
+		} else {
+			out.WriteString(field.raw)
+		}
+
+		if strings.EqualFold(name, "message-id") {
+			hasMessageID = true
+		}
+		if strings.EqualFold(name, "to") || strings.EqualFold(name, "cc") {
+			if addresses, err := mail.ParseAddressList(mimeparser.DecodeHeaderValue(value)); err == nil {
+				for _, addr := range addresses {
+					visibleRecipients[strings.ToLower(addr.Address)] = true
+				}
+			}
+		}
+	}
+
+	// Add headers from rewrite rules that weren't present in the original message
+	for i, rule := range rules {
+		if !appliedRules[i] && rule.HeaderName != "" && rule.NewValue != "" {
+			out.WriteString(rule.HeaderName + ": " + rule.NewValue + newline)
+			hasMessageID = hasMessageID || strings.EqualFold(rule.HeaderName, "message-id")
+		}
+	}
+	if !hasMessageID {
+		out.WriteString("Message-ID: " + msg.RFCMessageID() + newline)
+	}
+
+	var hidden []string
+	for _, recipient := range msg.To {
+		if !visibleRecipients[strings.ToLower(recipient)] {
+			hidden = append(hidden, recipient)
+		}
+	}
+	if len(hidden) > 0 {
+		out.WriteString("Bcc: " + strings.Join(hidden, ", ") + newline)
+	}
+
+	out.WriteString(newline)
+	out.Write(body)
+
+	return &gmail.Message{
+		Raw: base64.URLEncoding.EncodeToString(out.Bytes()),
+	}, nil
+}
+
+// rawHeaderField is a header field as it appeared in the original message
+type rawHeaderField struct {
+	name  string
+	value string // unfolded
+	raw   string // original lines, including continuation lines and line endings
+}
+
+// splitHeaderFields splits a header block into fields, keeping folded lines with their field
+func splitHeaderFields(block string) []rawHeaderField {
+	var fields []rawHeaderField
+	for _, line := range strings.SplitAfter(block, "\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			last := &fields[len(fields)-1]
+			last.value += " " + strings.TrimSpace(trimmed)
+			last.raw += line
+			continue
+		}
+
+		parts := strings.SplitN(trimmed, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields = append(fields, rawHeaderField{
+			name:  strings.TrimSpace(parts[0]),
+			value: strings.TrimSpace(parts[1]),
+			raw:   line,
+		})
+	}
+	return fields
+}
+
+// rewriteFromAddress replaces the address in a From header value when it differs
+// from the envelope sender, keeping the display name
+func rewriteFromAddress(value, sender string) (string, bool) {
+	addr, err := mail.ParseAddress(mimeparser.DecodeHeaderValue(value))
+	if err != nil {
+		return sender, true
+	}
+	if strings.EqualFold(addr.Address, sender) {
+		return value, false
+	}
+	addr.Address = sender
+	return addr.String(), true
+}
+
+// findHeaderRule returns the index of the rewrite rule matching header, or -1
+func findHeaderRule(rules []config.WorkspaceHeaderRewriteRule, header string) int {
+	for i, rule := range rules {
+		if strings.EqualFold(rule.HeaderName, header) {
+			return i
+		}
+	}
+	return -1
+}
+
+// buildMultipartBody builds a multipart/mixed body holding the text and HTML
+// alternatives, inline parts (multipart/related) and regular attachments.
+// Attachment content is streamed from the attachment store as it is encoded.
+func buildMultipartBody(ctx context.Context, store blobstore.Store, msg *models.Message) (string, []byte, error) {
+	var inline, attached []models.Attachment
+	for _, att := range msg.Attachments {
+		if att.Type == "inline" && att.ContentID != "" {
+			inline = append(inline, att)
+		} else {
+			attached = append(attached, att)
+		}
+	}
+
+	var buf bytes.Buffer
+	mixed := multipart.NewWriter(&buf)
+
+	// Nested sections are built into their own buffers because the parent part
+	// header must carry the child boundary before any child content is written
+	section, sectionType, err := buildAlternativeSection(msg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(inline) > 0 {
+		var relatedBuf bytes.Buffer
+		related := multipart.NewWriter(&relatedBuf)
+		if err := writeSection(related, sectionType, section); err != nil {
+			return "", nil, err
+		}
+		for _, att := range inline {
+			if err := writeAttachmentPart(ctx, store, related, att); err != nil {
+				return "", nil, err
+			}
+		}
+		if err := related.Close(); err != nil {
+			return "", nil, err
+		}
+		section = relatedBuf.Bytes()
+		sectionType = fmt.Sprintf("multipart/related; boundary=%q", related.Boundary())
+	}
+
+	if err := writeSection(mixed, sectionType, section); err != nil {
+		return "", nil, err
+	}
+
+	for _, att := range attached {
+		if err := writeAttachmentPart(ctx, store, mixed, att); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if err := mixed.Close(); err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("multipart/mixed; boundary=%q", mixed.Boundary()), buf.Bytes(), nil
+}
+
+// buildAlternativeSection returns the text body section: a single text part, or
+// multipart/alternative when both text and HTML are present
+func buildAlternativeSection(msg *models.Message) ([]byte, string, error) {
+	if msg.HTML == "" || msg.Text == "" {
+		content, contentType := msg.Text, "text/plain; charset=UTF-8"
+		if msg.HTML != "" {
+			content, contentType = msg.HTML, "text/html; charset=UTF-8"
+		}
+		encoded, err := encodeQuotedPrintable(content)
+		if err != nil {
+			return nil, "", err
+		}
+		return encoded, contentType, nil
+	}
+
+	var buf bytes.Buffer
+	alternative := multipart.NewWriter(&buf)
+	for _, body := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.HTML},
+	} {
+		part, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if _, err := qp.Write([]byte(body.content)); err != nil {
+			return nil, "", err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := alternative.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), fmt.Sprintf("multipart/alternative; boundary=%q", alternative.Boundary()), nil
+}
+
+// writeSection writes a pre-encoded section as a part of w. Single text parts
+// are quoted-printable encoded by buildAlternativeSection.
+func writeSection(w *multipart.Writer, contentType string, content []byte) error {
+	header := textproto.MIMEHeader{"Content-Type": {contentType}}
+	if strings.HasPrefix(contentType, "text/") {
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+	}
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(content)
+	return err
+}
+
+// writeAttachmentPart writes an attachment as a base64 encoded part
+func writeAttachmentPart(ctx context.Context, store blobstore.Store, w *multipart.Writer, att models.Attachment) error {
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if att.Type == "inline" {
+		disposition = "inline"
+	}
+
+	header := textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": att.Name})},
+		"Content-Disposition":       {mime.FormatMediaType(disposition, map[string]string{"filename": att.Name})},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	if att.ContentID != "" {
+		header.Set("Content-ID", fmt.Sprintf("<%s>", att.ContentID))
+	}
+
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	// Wrap base64 at 76 characters per RFC 2045
+	lines := &lineWrapper{w: part}
+	encoder := base64.NewEncoder(base64.StdEncoding, lines)
+	if err := copyAttachment(ctx, store, encoder, att); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return lines.Close()
+}
+
+// lineWrapper breaks what is written to it into CRLF terminated lines of 76 bytes
+type lineWrapper struct {
+	w   io.Writer
+	col int
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := 76 - l.col
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.col += n
+		p = p[n:]
+		if l.col == 76 {
+			if _, err := l.w.Write([]byte("\r\n")); err != nil {
+				return written, err
+			}
+			l.col = 0
+		}
+	}
+	return written, nil
+}
+
+// Close terminates the last line
+func (l *lineWrapper) Close() error {
+	if l.col == 0 {
+		return nil
+	}
+	l.col = 0
+	_, err := l.w.Write([]byte("\r\n"))
+	return err
+}
+
+// encodeQuotedPrintable encodes text content as quoted-printable
+func encodeQuotedPrintable(content string) ([]byte, error) {
+	var buf bytes.Buffer
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // formatFromHeader creates a properly formatted From header
 func (g *GmailProvider) formatFromHeader(msg *models.Message) string {
 	// Check if there's already a formatted From header
@@ -454,7 +968,7 @@ func (g *GmailProvider) formatFromHeader(msg *models.Message) string {
 
 // isReservedHeader checks if a header is reserved and should not be added manually
 func (g *GmailProvider) isReservedHeader(header string) bool {
-	reserved := []string{"from", "to", "cc", "bcc", "subject", "content-type", "message-id", "date"}
+	reserved := []string{"from", "to", "cc", "bcc", "subject", "content-type", "content-transfer-encoding", "mime-version", "date"}
 	headerLower := strings.ToLower(header)
 	
 	for _, reservedHeader := range reserved {
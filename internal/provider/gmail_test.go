@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"relay/internal/config"
+	"relay/pkg/models"
+)
+
+func TestSplitHeaderFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  []rawHeaderField
+	}{
+		{
+			name:  "LF line endings",
+			block: "From: a@example.com\nSubject: Hi\n",
+			want: []rawHeaderField{
+				{name: "From", value: "a@example.com", raw: "From: a@example.com\n"},
+				{name: "Subject", value: "Hi", raw: "Subject: Hi\n"},
+			},
+		},
+		{
+			name:  "folded lines stay with their field",
+			block: "Subject: A long\r\n subject\r\n\tline\r\nTo: b@example.org\r\n",
+			want: []rawHeaderField{
+				{name: "Subject", value: "A long subject line", raw: "Subject: A long\r\n subject\r\n\tline\r\n"},
+				{name: "To", value: "b@example.org", raw: "To: b@example.org\r\n"},
+			},
+		},
+		{
+			name:  "value keeps colons and surrounding space is trimmed",
+			block: "X-Time :  12:30:00  \n",
+			want:  []rawHeaderField{{name: "X-Time", value: "12:30:00", raw: "X-Time :  12:30:00  \n"}},
+		},
+		{
+			name:  "lines that are not fields are dropped",
+			block: " stray continuation\nnot a header\nTo: b@example.org\n",
+			want:  []rawHeaderField{{name: "To", value: "b@example.org", raw: "To: b@example.org\n"}},
+		},
+		{
+			name:  "last line without a line ending",
+			block: "To: b@example.org",
+			want:  []rawHeaderField{{name: "To", value: "b@example.org", raw: "To: b@example.org\n"}},
+		},
+		{
+			name:  "empty block",
+			block: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitHeaderFields(tt.block); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitHeaderFields() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateRawPassthroughMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		from    string
+		to      []string
+		rules   []config.WorkspaceHeaderRewriteRule
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "headers and body kept as sent",
+			raw:  "From: News <news@example.com>\r\nTo: ana@example.org\r\nMessage-ID: <1@example.com>\r\n\r\nBody\r\n--x--\r\n",
+			from: "news@example.com",
+			to:   []string{"ana@example.org"},
+			want: "From: News <news@example.com>\r\nTo: ana@example.org\r\nMessage-ID: <1@example.com>\r\n\r\nBody\r\n--x--\r\n",
+		},
+		{
+			name: "From follows the envelope sender and keeps its display name",
+			raw:  "From: News <alias@example.com>\nTo: ana@example.org\nMessage-ID: <1@example.com>\n\nBody\n",
+			from: "news@example.com",
+			to:   []string{"ana@example.org"},
+			want: "From: \"News\" <news@example.com>\nTo: ana@example.org\nMessage-ID: <1@example.com>\n\nBody\n",
+		},
+		{
+			name: "envelope-only recipients replace the Bcc header",
+			raw:  "From: news@example.com\r\nTo: Ana <ana@example.org>\r\nCc: bo@example.org\r\nBcc: old@example.org\r\nMessage-ID: <1@example.com>\r\n\r\nBody",
+			from: "news@example.com",
+			to:   []string{"ANA@example.org", "bo@example.org", "cy@example.org", "di@example.org"},
+			want: "From: news@example.com\r\nTo: Ana <ana@example.org>\r\nCc: bo@example.org\r\nMessage-ID: <1@example.com>\r\nBcc: cy@example.org, di@example.org\r\n\r\nBody",
+		},
+		{
+			name: "rewrite rules replace, remove and add headers",
+			raw:  "From: news@example.com\nTo: ana@example.org\nList-Unsubscribe:\n <https://old.example.com>\nX-Mailer: old\nMessage-ID: <1@example.com>\n\nBody",
+			from: "news@example.com",
+			to:   []string{"ana@example.org"},
+			rules: []config.WorkspaceHeaderRewriteRule{
+				{HeaderName: "list-unsubscribe", NewValue: "<https://new.example.com>"},
+				{HeaderName: "X-Mailer", NewValue: ""},
+				{HeaderName: "X-Campaign", NewValue: "fall"},
+			},
+			want: "From: news@example.com\nTo: ana@example.org\nList-Unsubscribe: <https://new.example.com>\nMessage-ID: <1@example.com>\nX-Campaign: fall\n\nBody",
+		},
+		{
+			name: "a Message-ID is added when missing",
+			raw:  "From: news@example.com\r\nTo: ana@example.org\r\n\r\nBody",
+			from: "news@example.com",
+			to:   []string{"ana@example.org"},
+			want: "From: news@example.com\r\nTo: ana@example.org\r\nMessage-ID: <msg-1@example.com>\r\n\r\nBody",
+		},
+		{
+			name:    "no header block",
+			raw:     "\r\nBody only",
+			from:    "news@example.com",
+			to:      []string{"ana@example.org"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.WorkspaceGmailConfig{RawPassthrough: true}
+			if tt.rules != nil {
+				cfg.HeaderRewrite = config.WorkspaceGmailHeaderRewrite{Enabled: true, Rules: tt.rules}
+			}
+			g := &GmailProvider{config: cfg}
+			msg := &models.Message{ID: "msg-1", From: tt.from, To: tt.to, RawMessage: []byte(tt.raw)}
+
+			gmailMsg, err := g.createRawPassthroughMessage(msg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("createRawPassthroughMessage accepted a message without headers")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("createRawPassthroughMessage: %v", err)
+			}
+			got, err := base64.URLEncoding.DecodeString(gmailMsg.Raw)
+			if err != nil {
+				t.Fatalf("decode Raw: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Raw =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
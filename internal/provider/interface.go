@@ -25,6 +25,13 @@ type Provider interface {
 	GetProviderInfo() ProviderInfo
 }
 
+// SendChecker is implemented by providers that can look up a message they
+// accepted, for reconciling sends interrupted before their outcome was recorded
+type SendChecker interface {
+	// FindSent returns the provider's ID for msg and true when the provider has it
+	FindSent(ctx context.Context, msg *models.Message) (string, bool, error)
+}
+
 // ProviderType represents the type of email provider
 type ProviderType string
 
@@ -1,17 +1,21 @@
 package provider
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"strings"
 	"sync"
 	"time"
 
+	"relay/internal/blobstore"
 	"relay/internal/config"
 	"relay/pkg/models"
 )
@@ -24,6 +28,7 @@ type MailgunProvider struct {
 	domains         []string  // Domains this workspace handles (also used for Mailgun API)
 	displayName     string
 	httpClient      *http.Client
+	attachments     blobstore.Store // Serves attachment content the queue externalized
 	
 	// Health monitoring
 	mu              sync.RWMutex
@@ -103,21 +108,21 @@ func extractDomain(email string) (string, error) {
 // SendMessage implements Provider.SendMessage
 func (m *MailgunProvider) SendMessage(ctx context.Context, msg *models.Message) error {
 	if msg == nil {
-		return fmt.Errorf("message cannot be nil")
+		return NewSendError(ErrorClassPermanentContent, ProviderTypeMailgun, "message cannot be nil", nil)
 	}
 	
 	if msg.From == "" {
-		return fmt.Errorf("sender email is required")
+		return NewSendError(ErrorClassPermanentContent, ProviderTypeMailgun, "sender email is required", nil)
 	}
 	
 	if len(msg.To) == 0 {
-		return fmt.Errorf("at least one recipient is required")
+		return NewSendError(ErrorClassPermanentRecipient, ProviderTypeMailgun, "at least one recipient is required", nil)
 	}
 	
 	// Extract domain from sender's email
 	senderDomain, err := extractDomain(msg.From)
 	if err != nil {
-		return fmt.Errorf("failed to extract domain from sender email: %w", err)
+		return NewSendError(ErrorClassPermanentContent, ProviderTypeMailgun, "failed to extract domain from sender email", err)
 	}
 	
 	// Verify this domain is in our configured domains
@@ -129,7 +134,8 @@ func (m *MailgunProvider) SendMessage(ctx context.Context, msg *models.Message)
 		}
 	}
 	if !domainFound {
-		return fmt.Errorf("sender domain %s is not configured for this Mailgun provider", senderDomain)
+		return NewSendError(ErrorClassConfiguration, ProviderTypeMailgun,
+			fmt.Sprintf("sender domain %s is not configured for this Mailgun provider", senderDomain), nil)
 	}
 	
 	// Prepare form data for Mailgun API
@@ -158,7 +164,7 @@ func (m *MailgunProvider) SendMessage(ctx context.Context, msg *models.Message)
 	
 	// Ensure at least one body format is provided
 	if msg.HTML == "" && msg.Text == "" {
-		return fmt.Errorf("message must contain either HTML or text content")
+		return NewSendError(ErrorClassPermanentContent, ProviderTypeMailgun, "message must contain either HTML or text content", nil)
 	}
 	
 	// Add tracking settings
@@ -217,13 +223,13 @@ func (m *MailgunProvider) SendMessage(ctx context.Context, msg *models.Message)
 	
 	// Send the request
 	startTime := time.Now()
-	err = m.sendRequest(ctx, &form, senderDomain)
+	err = m.sendRequest(ctx, &form, senderDomain, msg)
 	sendDuration := time.Since(startTime)
 	
 	if err != nil {
 		m.setUnhealthy(err)
 		log.Printf("Mailgun send failed for %s (took %v): %v", msg.From, sendDuration, err)
-		return fmt.Errorf("failed to send email via Mailgun: %w", err)
+		return err
 	}
 	
 	// Mark as healthy on successful send
@@ -235,23 +241,35 @@ func (m *MailgunProvider) SendMessage(ctx context.Context, msg *models.Message)
 	return nil
 }
 
-// sendRequest sends the actual HTTP request to Mailgun
-func (m *MailgunProvider) sendRequest(ctx context.Context, form *url.Values, domain string) error {
+// sendRequest sends the actual HTTP request to Mailgun and records the ID Mailgun assigned to msg
+func (m *MailgunProvider) sendRequest(ctx context.Context, form *url.Values, domain string, msg *models.Message) error {
+	// Attachments require multipart/form-data; plain messages keep the url-encoded form
+	var requestBody io.Reader = strings.NewReader(form.Encode())
+	contentType := "application/x-www-form-urlencoded"
+	if len(msg.Attachments) > 0 {
+		body, multipartType, err := buildMultipartForm(ctx, m.attachments, form, msg.Attachments)
+		if err != nil {
+			return buildError(ProviderTypeMailgun, "failed to build multipart request", err)
+		}
+		requestBody = body
+		contentType = multipartType
+	}
+
 	// Create the request
 	apiURL := fmt.Sprintf("%s/%s/messages", m.config.BaseURL, domain)
-	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, strings.NewReader(form.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, requestBody)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return NewSendError(ErrorClassConfiguration, ProviderTypeMailgun, "failed to create request", err)
 	}
 	
 	// Set headers
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Type", contentType)
 	req.SetBasicAuth("api", m.config.APIKey)
 	
 	// Send the request
 	resp, err := m.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return NewSendError(ErrorClassTransient, ProviderTypeMailgun, "failed to send request", err)
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
@@ -262,7 +280,7 @@ func (m *MailgunProvider) sendRequest(ctx context.Context, form *url.Values, dom
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return NewSendError(ErrorClassTransient, ProviderTypeMailgun, "failed to read response", err)
 	}
 	
 	// Handle success response
@@ -272,16 +290,88 @@ func (m *MailgunProvider) sendRequest(ctx context.Context, form *url.Values, dom
 			// If we can't parse the response but the status is success, consider it sent
 			log.Printf("Warning: Could not parse Mailgun success response: %v", err)
 		}
+		msg.ProviderMessageID = mailgunResp.ID
 		return nil
 	}
 	
 	// Handle error response
+	message := string(body)
 	var errorResp MailgunErrorResponse
-	if err := json.Unmarshal(body, &errorResp); err != nil {
-		return fmt.Errorf("mailgun API error (status: %d): %s", resp.StatusCode, string(body))
+	if err := json.Unmarshal(body, &errorResp); err == nil {
+		message = errorResp.Message
+	}
+	
+	sendErr := &SendError{
+		Class:      ClassForHTTPStatus(resp.StatusCode),
+		Provider:   ProviderTypeMailgun,
+		StatusCode: resp.StatusCode,
+		Message:    fmt.Sprintf("mailgun API error (status: %d): %s", resp.StatusCode, message),
+	}
+	switch sendErr.Class {
+	case ErrorClassRateLimited:
+		sendErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case ErrorClassPermanentContent:
+		// Mailgun rejects bad addresses with 400 "'to' parameter is not a valid address"
+		if mentionsRecipient(message) {
+			sendErr.Class = ErrorClassPermanentRecipient
+		}
 	}
 	
-	return fmt.Errorf("mailgun API error (status: %d): %s", resp.StatusCode, errorResp.Message)
+	return sendErr
+}
+
+// buildMultipartForm encodes form fields and attachments as multipart/form-data.
+// Inline parts are sent as "inline" so Mailgun keeps them addressable via cid:.
+// Attachment content is streamed from the attachment store into the form.
+func buildMultipartForm(ctx context.Context, store blobstore.Store, form *url.Values, attachments []models.Attachment) (*bytes.Buffer, string, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	for key, values := range *form {
+		for _, value := range values {
+			if err := writer.WriteField(key, value); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	for _, att := range attachments {
+		field := "attachment"
+		filename := att.Name
+		if att.Type == "inline" {
+			field = "inline"
+			// Mailgun derives the Content-ID from the filename of inline parts
+			if att.ContentID != "" {
+				filename = att.ContentID
+			}
+		}
+
+		partHeader := make(textproto.MIMEHeader)
+		partHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, field, escapeQuotes(filename)))
+		if att.ContentType != "" {
+			partHeader.Set("Content-Type", att.ContentType)
+		} else {
+			partHeader.Set("Content-Type", "application/octet-stream")
+		}
+
+		part, err := writer.CreatePart(partHeader)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := copyAttachment(ctx, store, part, att); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return body, writer.FormDataContentType(), nil
+}
+
+// escapeQuotes escapes a filename for use in a quoted header parameter
+func escapeQuotes(s string) string {
+	return strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(s)
 }
 
 // formatFromAddress formats the from address for Mailgun
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"relay/internal/blobstore"
 	"relay/internal/config"
 	"relay/pkg/models"
 )
@@ -21,6 +22,8 @@ type MandrillProvider struct {
 	config     *config.WorkspaceMandrillConfig
 	httpClient *http.Client
 	baseURL    string
+
+	attachments blobstore.Store // Serves attachment content the queue externalized
 }
 
 // NewMandrillProvider creates a new Mandrill provider
@@ -95,11 +98,14 @@ func (m *MandrillProvider) HealthCheck(ctx context.Context) error {
 // SendEmail sends an email via Mandrill
 func (m *MandrillProvider) SendEmail(ctx context.Context, msg *models.Message, options map[string]interface{}) error {
 	if msg == nil {
-		return fmt.Errorf("message is nil")
+		return NewSendError(ErrorClassPermanentContent, ProviderTypeMandrill, "message is nil", nil)
 	}
 
 	// Build Mandrill message structure
-	mandrillMsg := m.buildMandrillMessage(msg)
+	mandrillMsg, err := m.buildMandrillMessage(ctx, msg)
+	if err != nil {
+		return buildError(ProviderTypeMandrill, "failed to build message", err)
+	}
 
 	// Apply tracking settings
 	if m.config.Tracking.Opens {
@@ -167,7 +173,7 @@ func (m *MandrillProvider) SendEmail(ctx context.Context, msg *models.Message, o
 	// Send the email
 	resp, err := m.apiCall(ctx, "/messages/send.json", payload)
 	if err != nil {
-		return fmt.Errorf("failed to send email via Mandrill: %w", err)
+		return err
 	}
 
 	// Parse response to check for success
@@ -175,24 +181,62 @@ func (m *MandrillProvider) SendEmail(ctx context.Context, msg *models.Message, o
 		if result, ok := results[0].(map[string]interface{}); ok {
 			status, _ := result["status"].(string)
 			if status == "sent" || status == "queued" || status == "scheduled" {
-				// Success - log the Mandrill message ID but don't overwrite our ID
+				// Success - keep the Mandrill message ID alongside ours
 				if msgID, ok := result["_id"].(string); ok {
 					log.Printf("Mandrill message sent successfully with Mandrill ID: %s (our ID: %s)", msgID, msg.ID)
+					msg.ProviderMessageID = msgID
 				}
 				return nil
 			}
 			
 			// Handle rejection or other errors
 			rejectReason, _ := result["reject_reason"].(string)
-			return fmt.Errorf("mandrill rejected email: status=%s, reason=%s", status, rejectReason)
+			return NewSendError(classifyMandrillRejection(status, rejectReason), ProviderTypeMandrill,
+				fmt.Sprintf("mandrill rejected email: status=%s, reason=%s", status, rejectReason), nil)
 		}
 	}
 
-	return fmt.Errorf("unexpected response format from Mandrill")
+	return NewSendError(ErrorClassTransient, ProviderTypeMandrill, "unexpected response format from Mandrill", nil)
+}
+
+// classifyMandrillRejection maps a per-recipient send result to an error class
+func classifyMandrillRejection(status, rejectReason string) ErrorClass {
+	if status == "invalid" {
+		return ErrorClassPermanentRecipient
+	}
+
+	switch rejectReason {
+	case "hard-bounce", "invalid", "unsub", "spam", "custom":
+		return ErrorClassPermanentRecipient
+	case "rule":
+		return ErrorClassPermanentContent
+	case "invalid-sender", "unsigned":
+		return ErrorClassConfiguration
+	case "test-mode-limit":
+		return ErrorClassRateLimited
+	default:
+		// soft-bounce and unknown reasons may succeed later
+		return ErrorClassTransient
+	}
+}
+
+// classifyMandrillAPIError maps a Mandrill API error name to an error class
+func classifyMandrillAPIError(statusCode int, name string) ErrorClass {
+	switch name {
+	case "Invalid_Key":
+		return ErrorClassAuth
+	case "ValidationError":
+		return ErrorClassPermanentContent
+	case "PaymentRequired", "Unknown_Subaccount", "Unknown_Template":
+		return ErrorClassConfiguration
+	case "GeneralError", "ServiceUnavailable":
+		return ErrorClassTransient
+	}
+	return ClassForHTTPStatus(statusCode)
 }
 
 // buildMandrillMessage converts our Message model to Mandrill's format
-func (m *MandrillProvider) buildMandrillMessage(msg *models.Message) map[string]interface{} {
+func (m *MandrillProvider) buildMandrillMessage(ctx context.Context, msg *models.Message) (map[string]interface{}, error) {
 	mandrillMsg := map[string]interface{}{
 		"from_email": msg.From,
 		"subject":    msg.Subject,
@@ -224,24 +268,44 @@ func (m *MandrillProvider) buildMandrillMessage(msg *models.Message) map[string]
 		mandrillMsg["text"] = msg.Text
 	}
 
-	// Add attachments if present
+	// Add attachments if present. Inline images referenced via cid: go into
+	// "images", where Mandrill uses the name as the Content-ID.
 	if len(msg.Attachments) > 0 {
 		attachments := make([]map[string]string, 0, len(msg.Attachments))
+		images := make([]map[string]string, 0)
 		for _, att := range msg.Attachments {
+			// The JSON payload embeds the content whole, so it is read in one piece
+			content, err := readAttachment(ctx, m.attachments, att)
+			if err != nil {
+				return nil, err
+			}
+			if att.Type == "inline" && att.ContentID != "" && strings.HasPrefix(att.ContentType, "image/") {
+				images = append(images, map[string]string{
+					"type":    att.ContentType,
+					"name":    att.ContentID,
+					"content": base64.StdEncoding.EncodeToString(content),
+				})
+				continue
+			}
 			attachment := map[string]string{
 				"type":    att.ContentType,
 				"name":    att.Name,
-				"content": base64.StdEncoding.EncodeToString(att.Content),
+				"content": base64.StdEncoding.EncodeToString(content),
 			}
 			attachments = append(attachments, attachment)
 		}
-		mandrillMsg["attachments"] = attachments
+		if len(attachments) > 0 {
+			mandrillMsg["attachments"] = attachments
+		}
+		if len(images) > 0 {
+			mandrillMsg["images"] = images
+		}
 	}
 
 	// Important flag for transactional emails
 	mandrillMsg["important"] = false
 
-	return mandrillMsg
+	return mandrillMsg, nil
 }
 
 // apiCall makes a call to the Mandrill API
@@ -255,7 +319,7 @@ func (m *MandrillProvider) apiCall(ctx context.Context, endpoint string, payload
 
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, NewSendError(ErrorClassConfiguration, ProviderTypeMandrill, "failed to create request", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -263,31 +327,42 @@ func (m *MandrillProvider) apiCall(ctx context.Context, endpoint string, payload
 
 	resp, err := m.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return nil, NewSendError(ErrorClassTransient, ProviderTypeMandrill, "failed to execute request", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	// Parse response
-	var result interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+		return nil, NewSendError(ErrorClassTransient, ProviderTypeMandrill, "failed to read response", err)
 	}
 
 	// Check for API errors
 	if resp.StatusCode != http.StatusOK {
-		if errMap, ok := result.(map[string]interface{}); ok {
-			if status, ok := errMap["status"].(string); ok && status == "error" {
-				message, _ := errMap["message"].(string)
-				code, _ := errMap["code"].(float64)
-				return nil, fmt.Errorf("mandrill API error: %s (code: %.0f)", message, code)
-			}
+		sendErr := &SendError{
+			Class:      ClassForHTTPStatus(resp.StatusCode),
+			Provider:   ProviderTypeMandrill,
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Message:    fmt.Sprintf("mandrill API returned status %d: %s", resp.StatusCode, string(body)),
+		}
+
+		var errResp struct {
+			Status  string  `json:"status"`
+			Code    float64 `json:"code"`
+			Name    string  `json:"name"`
+			Message string  `json:"message"`
 		}
-		return nil, fmt.Errorf("mandrill API returned status %d: %s", resp.StatusCode, string(body))
+		if json.Unmarshal(body, &errResp) == nil && errResp.Status == "error" {
+			sendErr.Class = classifyMandrillAPIError(resp.StatusCode, errResp.Name)
+			sendErr.Message = fmt.Sprintf("mandrill API error: %s (code: %.0f)", errResp.Message, errResp.Code)
+		}
+		return nil, sendErr
+	}
+
+	// Parse response
+	var result interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, NewSendError(ErrorClassTransient, ProviderTypeMandrill, "failed to parse response", err)
 	}
 
 	return result, nil
@@ -58,7 +58,7 @@ func (m *MandrillProviderWrapper) HealthCheck(ctx context.Context) error {
 	return err
 }
 
-// SendMessage sends a message via the provider
+// SendMessage sends a message via the provider. Errors are always *SendError.
 func (m *MandrillProviderWrapper) SendMessage(ctx context.Context, msg *models.Message) error {
 	err := m.provider.SendEmail(ctx, msg, nil)
 	
@@ -66,9 +66,13 @@ func (m *MandrillProviderWrapper) SendMessage(ctx context.Context, msg *models.M
 		m.mu.Lock()
 		m.lastError = err
 		m.mu.Unlock()
+		
+		sendErr := ClassifyError(err)
+		sendErr.Provider = ProviderTypeMandrill
+		return sendErr
 	}
 	
-	return err
+	return nil
 }
 
 // GetLastError returns the last error encountered
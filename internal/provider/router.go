@@ -7,6 +7,7 @@ import (
 	"strings"
 	"sync"
 
+	"relay/internal/blobstore"
 	"relay/internal/config"
 	"relay/internal/workspace"
 	"relay/pkg/models"
@@ -17,6 +18,7 @@ type Router struct {
 	workspaceManager *workspace.Manager
 	providers        map[string]Provider // keyed by provider ID
 	providersByDomain map[string][]Provider // keyed by domain
+	attachments      blobstore.Store // Handed to providers so they can read externalized attachments
 	mu               sync.RWMutex
 }
 
@@ -34,6 +36,14 @@ func NewRouter(workspaceManager *workspace.Manager) *Router {
 	}
 }
 
+// SetAttachmentStore sets the store providers created by InitializeProviders
+// read externalized attachment content from
+func (r *Router) SetAttachmentStore(store blobstore.Store) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attachments = store
+}
+
 // InitializeProviders creates and registers providers based on workspace configuration
 func (r *Router) InitializeProviders() error {
 	// Defensive programming: validate router and components
@@ -75,6 +85,7 @@ func (r *Router) InitializeProviders() error {
 				log.Printf("Warning: Failed to create Gmail provider for workspace %s: %v", workspaceID, err)
 				continue
 			}
+			provider.attachments = r.attachments
 			
 			providerID := provider.GetID()
 			r.providers[providerID] = provider
@@ -94,6 +105,7 @@ func (r *Router) InitializeProviders() error {
 				log.Printf("Warning: Failed to create Mailgun provider for workspace %s: %v", workspaceID, err)
 				continue
 			}
+			provider.attachments = r.attachments
 			
 			providerID := provider.GetID()
 			r.providers[providerID] = provider
@@ -113,6 +125,7 @@ func (r *Router) InitializeProviders() error {
 				log.Printf("Warning: Failed to create Mandrill provider for workspace %s: %v", workspaceID, err)
 				continue
 			}
+			provider.attachments = r.attachments
 			
 			// Create a wrapped provider for consistent interface
 			wrappedProvider := &MandrillProviderWrapper{
@@ -206,6 +219,17 @@ func (r *Router) RouteMessage(ctx context.Context, msg *models.Message) (Provide
 	// Set provider ID on message
 	msg.ProviderID = workspace.ID
 	
+	// A replayed dead letter may be pinned to a specific provider
+	if msg.ProviderOverride != "" {
+		for _, provider := range providers {
+			if provider != nil && provider.GetID() == msg.ProviderOverride {
+				log.Printf("Routed message from %s to override provider %s (%s)", msg.From, provider.GetID(), provider.GetType())
+				return provider, nil
+			}
+		}
+		return nil, fmt.Errorf("override provider %s does not serve domain %s", msg.ProviderOverride, domain)
+	}
+	
 	// Route based on provider preference and availability
 	provider, err := r.selectProvider(providers, workspace)
 	if err != nil {
@@ -216,6 +240,61 @@ func (r *Router) RouteMessage(ctx context.Context, msg *models.Message) (Provide
 	return provider, nil
 }
 
+// RouteChain routes a message like RouteMessage and returns the providers to try
+// in order within one send attempt. Workspaces with a failover chain get its
+// providers, healthy ones first; otherwise, and for messages pinned to a
+// provider, the chain is the routed provider alone.
+func (r *Router) RouteChain(ctx context.Context, msg *models.Message) ([]Provider, error) {
+	selected, err := r.RouteMessage(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	if msg.ProviderOverride != "" {
+		return []Provider{selected}, nil
+	}
+	
+	domain, _ := r.extractDomainFromEmail(msg.From)
+	workspace, err := r.workspaceManager.GetWorkspaceByDomain(domain)
+	if err != nil || workspace == nil || len(workspace.Failover) == 0 {
+		return []Provider{selected}, nil
+	}
+	
+	r.mu.RLock()
+	providers := r.providersByDomain[domain]
+	r.mu.RUnlock()
+	
+	chain := failoverChain(providers, workspace.Failover)
+	if len(chain) == 0 {
+		log.Printf("Warning: No provider of workspace %s matches its failover chain %v", workspace.ID, workspace.Failover)
+		return []Provider{selected}, nil
+	}
+	return chain, nil
+}
+
+// failoverChain orders providers by the failover entries they match, by type or
+// ID. Unhealthy providers move behind the healthy ones but are still tried.
+func failoverChain(providers []Provider, failover []string) []Provider {
+	var healthy, unhealthy []Provider
+	seen := make(map[string]bool)
+	for _, entry := range failover {
+		for _, provider := range providers {
+			if provider == nil || seen[provider.GetID()] {
+				continue
+			}
+			if !strings.EqualFold(entry, string(provider.GetType())) && entry != provider.GetID() {
+				continue
+			}
+			seen[provider.GetID()] = true
+			if provider.IsHealthy() {
+				healthy = append(healthy, provider)
+			} else {
+				unhealthy = append(unhealthy, provider)
+			}
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
 // selectProvider selects the best provider based on configuration and health
 func (r *Router) selectProvider(providers []Provider, workspace *config.WorkspaceConfig) (Provider, error) {
 	// Defensive programming: validate inputs
@@ -0,0 +1,48 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+// stubProvider answers only what failoverChain asks of a provider
+type stubProvider struct {
+	Provider
+	id           string
+	providerType ProviderType
+	healthy      bool
+}
+
+func (s *stubProvider) GetID() string         { return s.id }
+func (s *stubProvider) GetType() ProviderType { return s.providerType }
+func (s *stubProvider) IsHealthy() bool       { return s.healthy }
+
+func chainIDs(chain []Provider) string {
+	ids := make([]string, len(chain))
+	for i, p := range chain {
+		ids[i] = p.GetID()
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestFailoverChain(t *testing.T) {
+	gmail := &stubProvider{id: "gmail-acme", providerType: ProviderTypeGmail, healthy: true}
+	mailgun := &stubProvider{id: "mailgun-acme", providerType: ProviderTypeMailgun, healthy: true}
+	sick := &stubProvider{id: "mandrill_acme", providerType: ProviderTypeMandrill}
+	providers := []Provider{gmail, mailgun, sick}
+
+	tests := []struct {
+		failover []string
+		want     string
+	}{
+		{[]string{"mailgun", "gmail"}, "mailgun-acme,gmail-acme"},
+		{[]string{"mandrill_acme", "Gmail"}, "gmail-acme,mandrill_acme"},
+		{[]string{"gmail", "gmail-acme", "mailgun"}, "gmail-acme,mailgun-acme"},
+		{[]string{"gmail-other"}, ""},
+	}
+	for _, tt := range tests {
+		if got := chainIDs(failoverChain(providers, tt.failover)); got != tt.want {
+			t.Errorf("failoverChain(%v) = %q, want %q", tt.failover, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+
+	"relay/internal/blobstore"
+	"relay/pkg/models"
+)
+
+// attachmentBlobs moves attachment content into a store as messages are
+// enqueued. Blobs are shared by every message with the same content, so a blob
+// one message released may be in use by another, or about to be used by a
+// message being enqueued. Released blobs are only deleted by CollectBlobs,
+// once they have stayed unreferenced for a grace period.
+//
+// The SQL and Redis queues count references next to the messages, where every
+// replica sees them: a message takes its references before its content is
+// written and gives them up in the same update that deletes it or strips its
+// attachments. The collector holds a blob's count while deleting it, so a
+// message taking a new reference waits and then writes the content again.
+//
+// The memory and file queues belong to one process and check references
+// against the messages they hold. Storing holds mu for reading until the
+// message is saved and collecting holds it for writing, so the check never
+// runs in between.
+type attachmentBlobs struct {
+	mu    sync.RWMutex
+	store blobstore.Store
+}
+
+// externalize moves the attachment content into the store and saves the
+// message before any collection can run
+func (b *attachmentBlobs) externalize(attachments []models.Attachment, save func() error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := blobstore.Externalize(context.Background(), b.store, attachments); err != nil {
+		return err
+	}
+	return save()
+}
+
+// collect deletes the blobs among keys that referenced does not report as
+// still in use and returns how many it deleted. Failures are logged and the
+// blobs kept, since a leftover blob only wastes space.
+func (b *attachmentBlobs) collect(keys []string, referenced func(keys []string) (map[string]bool, error)) int {
+	if b.store == nil || len(keys) == 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	inUse, err := referenced(keys)
+	if err != nil {
+		log.Printf("Warning: Failed to check attachment references, keeping %d blobs: %v", len(keys), err)
+		return 0
+	}
+	deleted := 0
+	for _, key := range keys {
+		if inUse[key] {
+			continue
+		}
+		if err := b.store.Delete(context.Background(), key); err != nil {
+			log.Printf("Warning: Failed to delete attachment blob %s: %v", key, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted
+}
+
+// blobKeys appends the store keys of attachments to keys, skipping inline content
+func blobKeys(keys []string, attachments []models.Attachment) []string {
+	for _, att := range attachments {
+		if att.BlobKey != "" {
+			keys = append(keys, att.BlobKey)
+		}
+	}
+	return keys
+}
+
+// storedKeys returns the keys attachments will reference once their content is
+// in the store, before it is written
+func storedKeys(attachments []models.Attachment) []string {
+	var keys []string
+	for _, att := range attachments {
+		switch {
+		case att.BlobKey != "":
+			keys = append(keys, att.BlobKey)
+		case len(att.Content) > 0:
+			keys = append(keys, blobstore.Key(att.Content))
+		}
+	}
+	return keys
+}
+
+// countKeys returns the distinct keys in order, so concurrent updates of the
+// same counts take their locks in the same order, and how often each occurs
+func countKeys(keys []string) ([]string, map[string]int) {
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		counts[key]++
+	}
+	distinct := make([]string, 0, len(counts))
+	for key := range counts {
+		distinct = append(distinct, key)
+	}
+	sort.Strings(distinct)
+	return distinct, counts
+}
+
+// markReferenced sets inUse for each key in want that attachments reference
+func markReferenced(inUse map[string]bool, want map[string]bool, attachments []models.Attachment) {
+	for _, att := range attachments {
+		if want[att.BlobKey] {
+			inUse[att.BlobKey] = true
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"relay/pkg/models"
+)
+
+// bulkActionStatuses lists the statuses each bulk action applies to. Messages
+// being sent are never included: the send is already in flight.
+var bulkActionStatuses = map[BulkAction][]models.MessageStatus{
+	BulkCancel:     {models.StatusQueued, models.StatusFailed, models.StatusAuthError},
+	BulkRequeue:    {models.StatusFailed, models.StatusAuthError, models.StatusDead, models.StatusCancelled},
+	BulkReschedule: {models.StatusQueued, models.StatusFailed},
+	BulkDelete: {models.StatusQueued, models.StatusFailed, models.StatusAuthError, models.StatusDead,
+		models.StatusCancelled, models.StatusExpired, models.StatusSent},
+}
+
+// bulkStatuses returns the statuses action applies to, narrowed to the
+// filter's statuses when it has any
+func bulkStatuses(action BulkAction, filter BulkFilter) ([]models.MessageStatus, error) {
+	allowed, ok := bulkActionStatuses[action]
+	if !ok {
+		return nil, fmt.Errorf("unknown bulk action %q", action)
+	}
+	if len(filter.Statuses) == 0 {
+		return allowed, nil
+	}
+
+	var statuses []models.MessageStatus
+	for _, status := range filter.Statuses {
+		if !containsStatus(allowed, status) {
+			return nil, fmt.Errorf("%s does not apply to %s messages", action, status)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func containsStatus(statuses []models.MessageStatus, status models.MessageStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// bulkSubjectLike turns a subject pattern into a lower-case LIKE pattern
+func bulkSubjectLike(pattern string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(pattern))
+	return strings.ReplaceAll(escaped, "*", "%")
+}
+
+// bulkMatcher evaluates a BulkFilter against messages held in memory
+type bulkMatcher struct {
+	filter   BulkFilter
+	statuses []models.MessageStatus
+	subject  *regexp.Regexp
+}
+
+func newBulkMatcher(action BulkAction, filter BulkFilter) (*bulkMatcher, error) {
+	statuses, err := bulkStatuses(action, filter)
+	if err != nil {
+		return nil, err
+	}
+	m := &bulkMatcher{filter: filter, statuses: statuses}
+	if filter.Subject != "" {
+		parts := strings.Split(filter.Subject, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		m.subject = regexp.MustCompile("(?is)^" + strings.Join(parts, ".*") + "$")
+	}
+	return m, nil
+}
+
+func (m *bulkMatcher) matches(msg *models.Message) bool {
+	f := m.filter
+	switch {
+	case !containsStatus(m.statuses, msg.Status),
+		f.WorkspaceID != "" && msg.ProviderID != f.WorkspaceID, // ProviderID is the workspace even after a send attempt
+		f.From != "" && !strings.EqualFold(msg.From, f.From),
+		f.EmailType != "" && msg.EmailType != f.EmailType,
+		f.InvitationDispatchID != "" && msg.InvitationDispatchID != f.InvitationDispatchID,
+		f.Tag != "" && !hasTag(msg, f.Tag),
+		m.subject != nil && !m.subject.MatchString(msg.Subject),
+		f.QueuedAfter != nil && msg.QueuedAt.Before(*f.QueuedAfter),
+		f.QueuedBefore != nil && !msg.QueuedAt.Before(*f.QueuedBefore):
+		return false
+	}
+	return true
+}
+
+// hasTag reports whether the message's metadata carries tag among its X-MC-Tags
+func hasTag(msg *models.Message, tag string) bool {
+	switch tags := msg.Metadata["tags"].(type) {
+	case []string:
+		for _, t := range tags {
+			if t == tag {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, t := range tags {
+			if s, ok := t.(string); ok && s == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyBulk changes a matched message in place for every action but delete
+func applyBulk(msg *models.Message, action BulkAction, sendAt, now time.Time) {
+	switch action {
+	case BulkCancel:
+		msg.Status = models.StatusCancelled
+		msg.NextAttemptAt = nil
+		msg.ProcessedAt = &now
+		msg.Error = "cancelled"
+	case BulkRequeue:
+		msg.Status = models.StatusQueued
+		msg.RetryCount = 0
+		msg.NextAttemptAt = nil
+		msg.DeferredAt = nil
+		msg.ProcessedAt = nil
+		msg.Error = ""
+	case BulkReschedule:
+		msg.Status = models.StatusQueued
+		msg.SendAt = &sendAt
+		msg.NextAttemptAt = nil
+	}
+}
@@ -0,0 +1,22 @@
+package queue
+
+import (
+	"time"
+
+	"relay/pkg/models"
+)
+
+// expirable reports whether ExpireDue may expire msg: a queued or failed
+// message whose expires_at has passed
+func expirable(msg *models.Message, now time.Time) bool {
+	return (msg.Status == models.StatusQueued || msg.Status == models.StatusFailed) && msg.Expired(now)
+}
+
+// expire moves a waiting message to expired
+func expire(msg *models.Message, now time.Time) {
+	msg.Status = models.StatusExpired
+	msg.Error = msg.ExpiryError().Error()
+	msg.NextAttemptAt = nil
+	msg.ProcessedAt = &now
+	releaseLease(msg)
+}
@@ -0,0 +1,207 @@
+package queue
+
+import (
+	"sort"
+	"sync"
+
+	"relay/pkg/models"
+)
+
+// smoothWRR is smooth weighted round-robin: each pick adds every candidate's
+// weight to its credit, takes the candidate with the most credit and charges it
+// the total. Credit carries over between calls, so picks interleave even when
+// only one slot is handed out at a time.
+type smoothWRR struct {
+	credit map[string]int
+}
+
+func newSmoothWRR() *smoothWRR {
+	return &smoothWRR{credit: make(map[string]int)}
+}
+
+// allocate hands out n slots among the keys in available, never giving a key more
+// slots than it has available. Credit for keys that are not candidates is dropped.
+func (w *smoothWRR) allocate(n int, available map[string]int, weight func(key string) int) map[string]int {
+	keys := make([]string, 0, len(available))
+	for key := range available {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for key := range w.credit {
+		if _, ok := available[key]; !ok {
+			delete(w.credit, key)
+		}
+	}
+
+	quotas := make(map[string]int, len(keys))
+	for i := 0; i < n; i++ {
+		total := 0
+		next := -1
+		for j, key := range keys {
+			if quotas[key] >= available[key] {
+				continue
+			}
+			w.credit[key] += weight(key)
+			total += weight(key)
+			if next < 0 || w.credit[key] > w.credit[keys[next]] {
+				next = j
+			}
+		}
+		if next < 0 {
+			break
+		}
+		w.credit[keys[next]] -= total
+		quotas[keys[next]]++
+	}
+	return quotas
+}
+
+// dueGroup counts the due messages of one sender in one workspace
+type dueGroup struct {
+	WorkspaceID string
+	Sender      string
+	Count       int
+}
+
+// fairFetch is one slice of a fair-share batch: up to Limit of the oldest due
+// messages of a workspace, either from one Sender or from every sender except
+// the Excluded ones
+type fairFetch struct {
+	WorkspaceID string
+	Sender      string
+	Excluded    []string
+	Limit       int
+}
+
+// matches reports whether msg belongs to this slice of the batch
+func (f fairFetch) matches(msg *models.Message) bool {
+	return f.takes(msg.ProviderID, msg.From)
+}
+
+// takes reports whether messages of sender in the workspace belong to this
+// slice of the batch
+func (f fairFetch) takes(workspaceID, sender string) bool {
+	if workspaceID != f.WorkspaceID {
+		return false
+	}
+	if f.Sender != "" {
+		return sender == f.Sender
+	}
+	for _, excluded := range f.Excluded {
+		if sender == excluded {
+			return false
+		}
+	}
+	return true
+}
+
+// fairShare splits each dequeue batch between workspaces, and optionally between
+// the senders of each workspace, so one large campaign cannot fill every batch
+type fairShare struct {
+	mu         sync.Mutex
+	config     FairShare
+	workspaces *smoothWRR
+	senders    map[string]*smoothWRR // keyed by workspace ID
+}
+
+func newFairShare() *fairShare {
+	return &fairShare{
+		workspaces: newSmoothWRR(),
+		senders:    make(map[string]*smoothWRR),
+	}
+}
+
+func (f *fairShare) set(config FairShare) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.config = config
+}
+
+// plan shares limit slots between the due groups. Workspaces and senders that
+// are paused, or that the throttle reports as rate limited, get no slots, so the
+// batch is not spent on messages that would go straight back to the queue.
+func (f *fairShare) plan(limit int, due []dueGroup, paused *PauseSet) []fairFetch {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rateLimited := f.config.Throttled
+	if rateLimited == nil {
+		rateLimited = func(workspaceID, sender string) bool { return false }
+	}
+	throttled := func(workspaceID, sender string) bool {
+		return paused.holds(workspaceID, sender) || rateLimited(workspaceID, sender)
+	}
+
+	available := make(map[string]int)
+	senders := make(map[string]map[string]int)
+	excluded := make(map[string][]string)
+	workspaceThrottled := make(map[string]bool)
+
+	for _, group := range due {
+		ws := group.WorkspaceID
+		limited, checked := workspaceThrottled[ws]
+		if !checked {
+			limited = throttled(ws, "")
+			workspaceThrottled[ws] = limited
+		}
+		if limited {
+			continue
+		}
+		if throttled(ws, group.Sender) {
+			excluded[ws] = append(excluded[ws], group.Sender)
+			continue
+		}
+		available[ws] += group.Count
+		if senders[ws] == nil {
+			senders[ws] = make(map[string]int)
+		}
+		senders[ws][group.Sender] += group.Count
+	}
+
+	quotas := f.workspaces.allocate(limit, available, func(ws string) int {
+		if weight := f.config.Weights[ws]; weight > 0 {
+			return weight
+		}
+		return 1
+	})
+
+	workspaceIDs := make([]string, 0, len(quotas))
+	for ws := range quotas {
+		workspaceIDs = append(workspaceIDs, ws)
+	}
+	sort.Strings(workspaceIDs)
+
+	var fetches []fairFetch
+	for _, ws := range workspaceIDs {
+		if !f.config.BySender {
+			fetches = append(fetches, fairFetch{WorkspaceID: ws, Excluded: excluded[ws], Limit: quotas[ws]})
+			continue
+		}
+
+		wrr, exists := f.senders[ws]
+		if !exists {
+			wrr = newSmoothWRR()
+			f.senders[ws] = wrr
+		}
+		senderQuotas := wrr.allocate(quotas[ws], senders[ws], func(string) int { return 1 })
+
+		senderEmails := make([]string, 0, len(senderQuotas))
+		for sender := range senderQuotas {
+			senderEmails = append(senderEmails, sender)
+		}
+		sort.Strings(senderEmails)
+		for _, sender := range senderEmails {
+			fetches = append(fetches, fairFetch{WorkspaceID: ws, Sender: sender, Limit: senderQuotas[sender]})
+		}
+	}
+
+	// Forget sender rotation for workspaces with nothing due
+	for ws := range f.senders {
+		if _, ok := senders[ws]; !ok {
+			delete(f.senders, ws)
+		}
+	}
+
+	return fetches
+}
@@ -0,0 +1,808 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"relay/pkg/models"
+)
+
+const (
+	fileQueueSnapshot = "queue.snapshot"
+	fileQueueJournal  = "queue.journal"
+
+	// fileQueueCompactMin is the fewest journal records written before the
+	// journal is folded into a new snapshot
+	fileQueueCompactMin = 1000
+)
+
+// journalRecord is one line of the snapshot or journal: the full state of a
+// message after a change, the removal of a message, an idempotency key claimed
+// by a message, or a pause being added or resumed
+type journalRecord struct {
+	Op        string           `json:"op"` // put, remove, key, pause or resume
+	ID        string           `json:"id"`
+	Message   *models.Message  `json:"message,omitempty"`
+	Raw       []byte           `json:"raw,omitempty"` // Message.RawMessage, which the message does not serialize
+	Attempts  []models.Attempt `json:"attempts,omitempty"`
+	Key       string           `json:"key,omitempty"`
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"`
+	Pause     *Pause           `json:"pause,omitempty"`
+}
+
+// FileQueue is a MemoryQueue that survives restarts. Every change is appended
+// to a journal and fsynced before the call returns, and the journal is
+// periodically folded into a snapshot. On open the snapshot and journal are
+// replayed, so messages that were processing when the process stopped come
+// back with their lease and are reaped once it expires.
+//
+// A change is made in memory while wmu is held and only kept once its journal
+// record is written. If encoding or writing fails, the journal is cut back to
+// its last whole record and memory is replayed from the files before wmu is
+// released, so a caller that gets the error can rely on the change not having
+// happened, and no later change builds on it.
+//
+// The files belong to a single relay process; replicas sharing a queue need MySQL.
+type FileQueue struct {
+	*MemoryQueue
+
+	dir       string
+	wmu       sync.Mutex // Serializes each change with its journal record
+	journal   *os.File
+	size      int64 // Journal length after the last whole record
+	torn      bool  // The journal may end in part of a record that could not be cut off
+	written   int   // Journal records since the last snapshot
+	compactAt int
+}
+
+// NewFileQueue opens or creates a file queue in dir
+func NewFileQueue(dir string) (*FileQueue, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file queue storage path is empty")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	fq := &FileQueue{
+		MemoryQueue: NewMemoryQueue(),
+		dir:         dir,
+	}
+
+	fq.mu.Lock()
+	err := fq.load(-1)
+	fq.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	journal, err := os.OpenFile(filepath.Join(dir, fileQueueJournal), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open queue journal: %w", err)
+	}
+	fq.journal = journal
+
+	// Start from a fresh snapshot, which also drops a torn final journal record
+	if err := fq.compact(); err != nil {
+		journal.Close()
+		return nil, err
+	}
+
+	log.Printf("File queue opened at %s with %d messages", dir, len(fq.order))
+	return fq, nil
+}
+
+// load replays the snapshot and then the journal, reading no more than
+// journalSize bytes of it unless journalSize is negative; callers must hold
+// fq.mu or have exclusive access
+func (fq *FileQueue) load(journalSize int64) error {
+	if err := fq.replay(filepath.Join(fq.dir, fileQueueSnapshot), -1); err != nil {
+		return err
+	}
+	return fq.replay(filepath.Join(fq.dir, fileQueueJournal), journalSize)
+}
+
+// replay applies the records in path, up to size bytes unless size is
+// negative. Records cut short by a crash or a failed append are skipped, and a
+// whole record written after one on the same line is still applied.
+func (fq *FileQueue) replay(path string, size int64) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if size >= 0 {
+		r = io.LimitReader(f, size)
+	}
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(data)) > 0 {
+				log.Printf("Warning: Ignoring incomplete record at %s:%d", path, line)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		var record journalRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			// Records start with their op, which never appears unescaped inside one
+			start := bytes.LastIndex(data, []byte(`{"op":`))
+			if start <= 0 || json.Unmarshal(data[start:], &record) != nil {
+				log.Printf("Warning: Ignoring unreadable record at %s:%d: %v", path, line, err)
+				continue
+			}
+			log.Printf("Warning: Ignoring incomplete record at %s:%d", path, line)
+		}
+		fq.apply(record)
+	}
+}
+
+// rollback puts memory back to the state the files hold, dropping a change
+// whose journal record was not written; callers must hold fq.wmu
+func (fq *FileQueue) rollback() {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+
+	fq.messages = make(map[string]*models.Message)
+	fq.order = make([]string, 0)
+	fq.attempts = make(map[string][]models.Attempt)
+	fq.keys = make(map[string]idempotencyEntry)
+	fq.pauses = make(map[string]Pause)
+	if err := fq.load(fq.size); err != nil {
+		log.Printf("Warning: Failed to reload file queue after a failed change: %v", err)
+	}
+}
+
+// abort takes back the change that could not be journaled and returns err;
+// callers must hold fq.wmu
+func (fq *FileQueue) abort(err error) error {
+	fq.rollback()
+	return err
+}
+
+// apply replays one record; callers must hold fq.mu
+func (fq *FileQueue) apply(record journalRecord) {
+	switch record.Op {
+	case "put":
+		if record.Message == nil {
+			return
+		}
+		if _, exists := fq.messages[record.ID]; !exists {
+			fq.order = append(fq.order, record.ID)
+		}
+		record.Message.RawMessage = record.Raw
+		fq.messages[record.ID] = record.Message
+		if len(record.Attempts) > 0 {
+			fq.attempts[record.ID] = record.Attempts
+		} else {
+			delete(fq.attempts, record.ID)
+		}
+	case "remove":
+		fq.removeLocked(record.ID)
+	case "key":
+		if record.ExpiresAt != nil && time.Now().Before(*record.ExpiresAt) {
+			fq.keys[record.Key] = idempotencyEntry{MessageID: record.ID, ExpiresAt: *record.ExpiresAt}
+		}
+	case "pause":
+		if record.Pause != nil {
+			fq.pauses[record.ID] = *record.Pause
+		}
+	case "resume":
+		delete(fq.pauses, record.ID)
+	}
+}
+
+// encode writes the current state of each message to buf; callers must hold fq.mu
+func (fq *FileQueue) encode(buf *bytes.Buffer, ids []string) error {
+	enc := json.NewEncoder(buf)
+	for _, id := range ids {
+		record := journalRecord{Op: "remove", ID: id}
+		if msg, exists := fq.messages[id]; exists {
+			record.Op = "put"
+			record.Message = msg
+			record.Raw = msg.RawMessage
+			record.Attempts = fq.attempts[id]
+		}
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("failed to encode message %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// encodeKeys writes the idempotency keys that have not expired to buf; callers must hold fq.mu
+func (fq *FileQueue) encodeKeys(buf *bytes.Buffer, now time.Time) error {
+	enc := json.NewEncoder(buf)
+	for key, entry := range fq.keys {
+		if !now.Before(entry.ExpiresAt) {
+			continue
+		}
+		expiresAt := entry.ExpiresAt
+		if err := enc.Encode(journalRecord{Op: "key", ID: entry.MessageID, Key: key, ExpiresAt: &expiresAt}); err != nil {
+			return fmt.Errorf("failed to encode idempotency key: %w", err)
+		}
+	}
+	return nil
+}
+
+// encodePauses writes the pauses still in effect to buf; callers must hold fq.mu
+func (fq *FileQueue) encodePauses(buf *bytes.Buffer, now time.Time) error {
+	enc := json.NewEncoder(buf)
+	for _, pause := range activePauses(fq.pauses, now) {
+		pause := pause
+		if err := enc.Encode(journalRecord{Op: "pause", ID: pauseID(pause.Scope, pause.Key), Pause: &pause}); err != nil {
+			return fmt.Errorf("failed to encode pause: %w", err)
+		}
+	}
+	return nil
+}
+
+// persist journals the current state of the given messages; callers must hold fq.wmu
+func (fq *FileQueue) persist(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fq.mu.RLock()
+	err := fq.encode(&buf, ids)
+	fq.mu.RUnlock()
+	if err != nil {
+		return fq.abort(err)
+	}
+	return fq.write(buf.Bytes(), len(ids))
+}
+
+// write appends encoded records to the journal and syncs it. If that fails,
+// the change they record is taken back; callers must hold fq.wmu.
+func (fq *FileQueue) write(data []byte, records int) error {
+	if err := fq.append(data); err != nil {
+		return fq.abort(err)
+	}
+	fq.recorded(records)
+	return nil
+}
+
+// recorded counts records appended to the journal and folds the journal into
+// a new snapshot once it has grown enough; callers must hold fq.wmu
+func (fq *FileQueue) recorded(records int) {
+	fq.written += records
+	if fq.written >= fq.compactAt {
+		if err := fq.compact(); err != nil {
+			// The journal still holds every change, so the next compaction catches up
+			log.Printf("Warning: Failed to compact file queue: %v", err)
+		}
+	}
+}
+
+// append writes data to the end of the journal and syncs it. A failed append
+// is cut off again, and until that succeeds the journal is replaced by a new
+// snapshot before anything else is appended to it.
+func (fq *FileQueue) append(data []byte) error {
+	if fq.journal == nil {
+		return fmt.Errorf("file queue is closed")
+	}
+	if fq.torn {
+		if err := fq.compact(); err != nil {
+			return fmt.Errorf("queue journal ends in a partial record: %w", err)
+		}
+	}
+
+	_, err := fq.journal.Write(data)
+	if err != nil {
+		err = fmt.Errorf("failed to write queue journal: %w", err)
+	} else if err = fq.journal.Sync(); err != nil {
+		err = fmt.Errorf("failed to sync queue journal: %w", err)
+	}
+	if err != nil {
+		if cutErr := fq.cut(); cutErr != nil {
+			log.Printf("Warning: Failed to cut the queue journal back to %d bytes: %v", fq.size, cutErr)
+			fq.torn = true
+		}
+		return err
+	}
+
+	fq.size += int64(len(data))
+	return nil
+}
+
+// cut truncates the journal to its last whole record
+func (fq *FileQueue) cut() error {
+	if err := fq.journal.Truncate(fq.size); err != nil {
+		return err
+	}
+	return fq.journal.Sync()
+}
+
+// compact writes every message to a new snapshot and empties the journal;
+// callers must hold fq.wmu or have exclusive access
+func (fq *FileQueue) compact() error {
+	var buf bytes.Buffer
+	fq.mu.RLock()
+	err := fq.encode(&buf, fq.order)
+	if err == nil {
+		err = fq.encodeKeys(&buf, time.Now())
+	}
+	if err == nil {
+		err = fq.encodePauses(&buf, time.Now())
+	}
+	count := len(fq.order)
+	fq.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(fq.dir, fileQueueSnapshot)
+	tmp, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create queue snapshot: %w", err)
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write queue snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync queue snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close queue snapshot: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to replace queue snapshot: %w", err)
+	}
+	if dir, err := os.Open(fq.dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	// Replaying the old journal over the new snapshot is harmless, so a crash
+	// before the truncate loses nothing
+	if err := fq.journal.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate queue journal: %w", err)
+	}
+	if err := fq.journal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync queue journal: %w", err)
+	}
+
+	fq.size = 0
+	fq.torn = false
+	fq.written = 0
+	fq.compactAt = fileQueueCompactMin
+	if 2*count > fq.compactAt {
+		fq.compactAt = 2 * count
+	}
+	return nil
+}
+
+// Enqueue journals the message and only then adds it to memory
+func (fq *FileQueue) Enqueue(message *models.Message) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.add(message); err != nil {
+		return err
+	}
+	fq.recorded(1)
+	return nil
+}
+
+// EnqueueOnce journals the message together with the idempotency key it claims
+func (fq *FileQueue) EnqueueOnce(message *models.Message, key string, window time.Duration) (string, bool, error) {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	// Keys are only claimed under wmu, so the key is still free after add
+	now := time.Now()
+	fq.mu.RLock()
+	entry, exists := fq.keys[key]
+	fq.mu.RUnlock()
+	if exists && now.Before(entry.ExpiresAt) {
+		return entry.MessageID, true, nil
+	}
+
+	expiresAt := now.Add(window)
+	if err := fq.add(message, journalRecord{Op: "key", ID: message.ID, Key: key, ExpiresAt: &expiresAt}); err != nil {
+		return "", false, err
+	}
+	fq.mu.Lock()
+	fq.claimKeyLocked(key, message.ID, now, window)
+	fq.mu.Unlock()
+	fq.recorded(2)
+	return message.ID, false, nil
+}
+
+// add moves the attachment content of a new message into the store, journals
+// the message followed by extra records and then adds it to memory; callers
+// must hold fq.wmu
+func (fq *FileQueue) add(message *models.Message, extra ...journalRecord) error {
+	return fq.blobs.externalize(message.Attachments, func() error {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		records := append([]journalRecord{{Op: "put", ID: message.ID, Message: message, Raw: message.RawMessage}}, extra...)
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return fmt.Errorf("failed to encode message %s: %w", message.ID, err)
+			}
+		}
+		if err := fq.append(buf.Bytes()); err != nil {
+			return err
+		}
+
+		fq.mu.Lock()
+		fq.addLocked(message)
+		fq.mu.Unlock()
+		return nil
+	})
+}
+
+func (fq *FileQueue) Dequeue(batchSize int) ([]*models.Message, error) {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	messages, err := fq.MemoryQueue.Dequeue(batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+	if err := fq.persist(ids...); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (fq *FileQueue) UpdateStatus(id string, status models.MessageStatus, err error) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.UpdateStatus(id, status, err); err != nil {
+		return err
+	}
+	return fq.persist(id)
+}
+
+func (fq *FileQueue) UpdateStatusWithProvider(id string, status models.MessageStatus, providerID string, err error) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.UpdateStatusWithProvider(id, status, providerID, err); err != nil {
+		return err
+	}
+	return fq.persist(id)
+}
+
+// ScheduleRetry records a failed attempt and retries the message at nextAttemptAt
+func (fq *FileQueue) ScheduleRetry(id string, providerID string, nextAttemptAt time.Time, err error) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.ScheduleRetry(id, providerID, nextAttemptAt, err); err != nil {
+		return err
+	}
+	return fq.persist(id)
+}
+
+// Defer parks a message in the queue until the given time
+func (fq *FileQueue) Defer(id string, until time.Time, reason error) (bool, error) {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	first, err := fq.MemoryQueue.Defer(id, until, reason)
+	if err != nil {
+		return false, err
+	}
+	return first, fq.persist(id)
+}
+
+// Hold parks a paused message without marking it deferred
+func (fq *FileQueue) Hold(id string, until time.Time, reason error) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.Hold(id, until, reason); err != nil {
+		return err
+	}
+	return fq.persist(id)
+}
+
+// RecordAttempt appends to the message's attempt history
+func (fq *FileQueue) RecordAttempt(id string, attempt models.Attempt) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.RecordAttempt(id, attempt); err != nil {
+		return err
+	}
+	return fq.persist(id)
+}
+
+func (fq *FileQueue) Remove(id string) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.Remove(id); err != nil {
+		return err
+	}
+	return fq.persist(id)
+}
+
+// RenewLease extends this worker's lease on a processing message
+func (fq *FileQueue) RenewLease(id string) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.RenewLease(id); err != nil {
+		return err
+	}
+	return fq.persist(id)
+}
+
+// ReapExpiredLeases returns processing messages whose lease has expired to the queue
+func (fq *FileQueue) ReapExpiredLeases() (int, error) {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	var expired []string
+	now := time.Now()
+	fq.mu.RLock()
+	for _, id := range fq.order {
+		msg := fq.messages[id]
+		if msg != nil && msg.Status == models.StatusProcessing && msg.LeaseExpiresAt != nil && msg.LeaseExpiresAt.Before(now) {
+			expired = append(expired, id)
+		}
+	}
+	fq.mu.RUnlock()
+
+	reaped, err := fq.MemoryQueue.ReapExpiredLeases()
+	if err != nil {
+		return 0, err
+	}
+	return reaped, fq.persist(expired...)
+}
+
+// MarkSending records that this worker is handing the message to providerID
+func (fq *FileQueue) MarkSending(id, providerID string) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.MarkSending(id, providerID); err != nil {
+		return err
+	}
+	return fq.persist(id)
+}
+
+// MarkSent records the message as sent together with the provider's message ID
+func (fq *FileQueue) MarkSent(id, providerID, providerMessageID string) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.MarkSent(id, providerID, providerMessageID); err != nil {
+		return err
+	}
+	return fq.persist(id)
+}
+
+// ClaimUnconfirmedSends leases sending messages whose lease expired to this worker
+func (fq *FileQueue) ClaimUnconfirmedSends(limit int) ([]*models.Message, error) {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	claimed, err := fq.MemoryQueue.ClaimUnconfirmedSends(limit)
+	if err != nil || len(claimed) == 0 {
+		return claimed, err
+	}
+	ids := make([]string, len(claimed))
+	for i, msg := range claimed {
+		ids[i] = msg.ID
+	}
+	return claimed, fq.persist(ids...)
+}
+
+// ExpireDue moves up to limit waiting messages whose expires_at has passed to expired
+func (fq *FileQueue) ExpireDue(limit int) ([]*models.Message, error) {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	expired, err := fq.MemoryQueue.ExpireDue(limit)
+	if err != nil || len(expired) == 0 {
+		return expired, err
+	}
+	ids := make([]string, len(expired))
+	for i, msg := range expired {
+		ids[i] = msg.ID
+	}
+	return expired, fq.persist(ids...)
+}
+
+// Pause adds p, replacing the pause with the same scope and key
+func (fq *FileQueue) Pause(p Pause) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := p.Normalize(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(journalRecord{Op: "pause", ID: pauseID(p.Scope, p.Key), Pause: &p})
+	if err != nil {
+		return fmt.Errorf("failed to encode pause: %w", err)
+	}
+	if err := fq.MemoryQueue.Pause(p); err != nil {
+		return err
+	}
+	return fq.write(append(data, '\n'), 1)
+}
+
+// Resume removes the pause with scope and key and reports whether there was one
+func (fq *FileQueue) Resume(scope PauseScope, key string) (bool, error) {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	resumed, err := fq.MemoryQueue.Resume(scope, key)
+	if err != nil || !resumed {
+		return resumed, err
+	}
+	data, err := json.Marshal(journalRecord{Op: "resume", ID: pauseID(scope, key)})
+	if err != nil {
+		return false, fq.abort(fmt.Errorf("failed to encode resume: %w", err))
+	}
+	if err := fq.write(append(data, '\n'), 1); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Reschedule moves a scheduled message to a new send_at
+func (fq *FileQueue) Reschedule(id string, sendAt time.Time) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.Reschedule(id, sendAt); err != nil {
+		return err
+	}
+	return fq.persist(id)
+}
+
+// CancelScheduled removes a message that has not reached its send_at yet
+func (fq *FileQueue) CancelScheduled(id string) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.CancelScheduled(id); err != nil {
+		return err
+	}
+	return fq.persist(id)
+}
+
+// Replay moves dead messages back to queued with a fresh retry budget
+func (fq *FileQueue) Replay(ids []string, providerOverride string) (int, error) {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	replayed, err := fq.MemoryQueue.Replay(ids, providerOverride)
+	if err != nil {
+		return 0, err
+	}
+	return replayed, fq.persist(fq.existing(ids)...)
+}
+
+// Discard deletes dead messages and their attempt history
+func (fq *FileQueue) Discard(ids []string) (int, error) {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	fq.mu.RLock()
+	var dead []string
+	for _, id := range ids {
+		if msg, exists := fq.messages[id]; exists && msg.Status == models.StatusDead {
+			dead = append(dead, id)
+		}
+	}
+	fq.mu.RUnlock()
+
+	discarded, err := fq.MemoryQueue.Discard(ids)
+	if err != nil {
+		return 0, err
+	}
+	return discarded, fq.persist(dead...)
+}
+
+// MarkArchived records when the messages were written to an archive
+func (fq *FileQueue) MarkArchived(ids []string, at time.Time) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.MarkArchived(ids, at); err != nil {
+		return err
+	}
+	return fq.persist(fq.existing(ids)...)
+}
+
+// Purge removes scope from up to filter.Limit archived messages
+func (fq *FileQueue) Purge(scope PurgeScope, filter RetentionFilter) (int, error) {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	fq.mu.Lock()
+	purged, err := fq.purgeLocked(scope, filter)
+	fq.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return len(purged), fq.persist(purged...)
+}
+
+// Restore replaces what is left of an archived message with its archived copy
+func (fq *FileQueue) Restore(msg *models.Message, attempts []models.Attempt) error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if err := fq.MemoryQueue.Restore(msg, attempts); err != nil {
+		return err
+	}
+	return fq.persist(msg.ID)
+}
+
+// ApplyBulk applies action to every message matching filter
+func (fq *FileQueue) ApplyBulk(action BulkAction, filter BulkFilter, sendAt time.Time) (int, error) {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	fq.mu.Lock()
+	changed, err := fq.applyBulkLocked(action, filter, sendAt)
+	fq.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return len(changed), fq.persist(changed...)
+}
+
+// existing filters ids down to messages still in the queue
+func (fq *FileQueue) existing(ids []string) []string {
+	fq.mu.RLock()
+	defer fq.mu.RUnlock()
+
+	var found []string
+	for _, id := range ids {
+		if _, exists := fq.messages[id]; exists {
+			found = append(found, id)
+		}
+	}
+	return found
+}
+
+// Close writes a final snapshot and closes the journal
+func (fq *FileQueue) Close() error {
+	fq.wmu.Lock()
+	defer fq.wmu.Unlock()
+
+	if fq.journal == nil {
+		return nil
+	}
+	if err := fq.compact(); err != nil {
+		log.Printf("Warning: Failed to write final file queue snapshot: %v", err)
+	}
+	err := fq.journal.Close()
+	fq.journal = nil
+	return err
+}
@@ -0,0 +1,268 @@
+package queue
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"relay/internal/blobstore"
+	"relay/pkg/models"
+)
+
+func TestFileQueueSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("NewFileQueue: %v", err)
+	}
+
+	sendAt := time.Now().Add(time.Hour)
+	q.Enqueue(&models.Message{ID: "sent-1", ProviderID: "ws", From: "a@example.com", Status: models.StatusQueued, QueuedAt: time.Now()})
+	q.Enqueue(&models.Message{ID: "retry-1", ProviderID: "ws", From: "a@example.com", Status: models.StatusQueued, QueuedAt: time.Now(), RawMessage: []byte("Subject: hi\r\n\r\nbody")})
+	q.Enqueue(&models.Message{ID: "scheduled-1", Status: models.StatusQueued, QueuedAt: time.Now(), SendAt: &sendAt})
+	q.Enqueue(&models.Message{ID: "removed-1", Status: models.StatusQueued, QueuedAt: time.Now()})
+
+	q.Dequeue(3)
+	q.UpdateStatusWithProvider("sent-1", models.StatusSent, "ws", nil)
+	q.RecordAttempt("retry-1", models.Attempt{Number: 1, ProviderID: "ws", Error: "timeout"})
+	q.ScheduleRetry("retry-1", "ws", time.Now().Add(time.Minute), fmt.Errorf("timeout"))
+	q.Remove("removed-1")
+	if err := q.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	q, err = NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer q.Close()
+
+	if _, err := q.Get("removed-1"); err == nil {
+		t.Error("removed message came back after restart")
+	}
+	retry, err := q.Get("retry-1")
+	if err != nil {
+		t.Fatalf("retry-1 was lost: %v", err)
+	}
+	if retry.Status != models.StatusFailed || retry.RetryCount != 1 || retry.NextAttemptAt == nil || string(retry.RawMessage) != "Subject: hi\r\n\r\nbody" {
+		t.Errorf("retry-1 = %s, retry_count %d, raw %q", retry.Status, retry.RetryCount, retry.RawMessage)
+	}
+	if attempts, _ := q.GetAttempts("retry-1"); len(attempts) != 1 {
+		t.Errorf("retry-1 has %d attempts after restart, want 1", len(attempts))
+	}
+	if scheduled, _ := q.ListScheduled("", 10); len(scheduled) != 1 || scheduled[0].ID != "scheduled-1" {
+		t.Errorf("scheduled messages after restart = %v", scheduled)
+	}
+	if counts, _ := q.GetSentCountsByWorkspaceAndSender(); counts["ws"]["a@example.com"] != 1 {
+		t.Errorf("sent counts after restart = %v", counts)
+	}
+}
+
+func TestFileQueueReplaysJournalWithoutClose(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("NewFileQueue: %v", err)
+	}
+	q.Enqueue(&models.Message{ID: "msg-1", Status: models.StatusQueued, QueuedAt: time.Now()})
+	q.Dequeue(1)
+
+	// Simulate a crash part way through writing a record
+	f, _ := os.OpenFile(filepath.Join(dir, fileQueueJournal), os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"op":"put","id":"msg-2","mess`)
+	f.Close()
+
+	q, err = NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("reopening after crash: %v", err)
+	}
+	defer q.Close()
+
+	msg, err := q.Get("msg-1")
+	if err != nil || msg.Status != models.StatusProcessing || msg.LeaseExpiresAt == nil {
+		t.Fatalf("msg-1 should still be leased after the crash, got %+v, %v", msg, err)
+	}
+	if _, err := q.Get("msg-2"); err == nil {
+		t.Error("incomplete record was replayed")
+	}
+}
+
+func TestFileQueueReplaysRecordsAfterATornOne(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("NewFileQueue: %v", err)
+	}
+	defer q.Close()
+	journal := filepath.Join(dir, fileQueueJournal)
+
+	// A failed append leaves part of a record that the next one runs into
+	q.Enqueue(&models.Message{ID: "msg-1", Status: models.StatusQueued, QueuedAt: time.Now()})
+	f, _ := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"op":"put","id":"msg-2","mess`)
+	q.Enqueue(&models.Message{ID: "msg-3", Status: models.StatusQueued, QueuedAt: time.Now()})
+	f.WriteString("garbage\n")
+	f.Close()
+	q.Enqueue(&models.Message{ID: "msg-4", Status: models.StatusQueued, QueuedAt: time.Now()})
+
+	reopened, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer reopened.Close()
+
+	for _, id := range []string{"msg-1", "msg-3", "msg-4"} {
+		if _, err := reopened.Get(id); err != nil {
+			t.Errorf("%s was lost: %v", id, err)
+		}
+	}
+	if _, err := reopened.Get("msg-2"); err == nil {
+		t.Error("incomplete record was replayed")
+	}
+}
+
+func TestFileQueueDropsChangesItCannotJournal(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("NewFileQueue: %v", err)
+	}
+	defer q.Close()
+	q.Enqueue(&models.Message{ID: "msg-1", Status: models.StatusQueued, QueuedAt: time.Now()})
+
+	// Every append to a read-only journal fails, and so does cutting it back
+	journal := q.journal
+	q.journal, _ = os.Open(filepath.Join(dir, fileQueueJournal))
+
+	if err := q.Enqueue(&models.Message{ID: "msg-2", Status: models.StatusQueued, QueuedAt: time.Now()}); err == nil {
+		t.Fatal("Enqueue succeeded without writing the journal")
+	}
+	if _, err := q.Get("msg-2"); err == nil {
+		t.Error("message that was not journaled is in the queue")
+	}
+	if batch, err := q.Dequeue(1); err == nil || len(batch) != 0 {
+		t.Errorf("Dequeue = %d messages, %v; want an error", len(batch), err)
+	}
+	if msg, _ := q.Get("msg-1"); msg == nil || msg.Status != models.StatusQueued || msg.LeaseExpiresAt != nil {
+		t.Errorf("msg-1 after a failed dequeue = %+v, want queued", msg)
+	}
+
+	q.journal.Close()
+	q.journal = journal
+	if err := q.Enqueue(&models.Message{ID: "msg-3", Status: models.StatusQueued, QueuedAt: time.Now()}); err != nil {
+		t.Fatalf("Enqueue after the journal recovered: %v", err)
+	}
+
+	reopened, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer reopened.Close()
+	for _, id := range []string{"msg-1", "msg-3"} {
+		if _, err := reopened.Get(id); err != nil {
+			t.Errorf("%s was lost: %v", id, err)
+		}
+	}
+	if _, err := reopened.Get("msg-2"); err == nil {
+		t.Error("message that was not journaled came back after restart")
+	}
+}
+
+func TestFileQueueKeepsIdempotencyKeysAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("NewFileQueue: %v", err)
+	}
+	q.EnqueueOnce(&models.Message{ID: "original", Status: models.StatusQueued, QueuedAt: time.Now()}, "kept", time.Hour)
+	q.EnqueueOnce(&models.Message{ID: "expiring", Status: models.StatusQueued, QueuedAt: time.Now()}, "expired", time.Millisecond)
+	q.Close()
+	time.Sleep(5 * time.Millisecond)
+
+	q, err = NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer q.Close()
+
+	if id, duplicate, _ := q.EnqueueOnce(&models.Message{ID: "retry", Status: models.StatusQueued, QueuedAt: time.Now()}, "kept", time.Hour); !duplicate || id != "original" {
+		t.Errorf("retry after restart = %s, duplicate %v; want original", id, duplicate)
+	}
+	if _, duplicate, _ := q.EnqueueOnce(&models.Message{ID: "late", Status: models.StatusQueued, QueuedAt: time.Now()}, "expired", time.Hour); duplicate {
+		t.Error("expired key still blocked a message after restart")
+	}
+}
+
+func TestFileQueueJournalsAttachmentReferences(t *testing.T) {
+	dir := t.TempDir()
+	store, err := blobstore.NewFileStore(filepath.Join(dir, "attachments"))
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	q, err := NewFileQueue(filepath.Join(dir, "queue"))
+	if err != nil {
+		t.Fatalf("NewFileQueue: %v", err)
+	}
+	q.SetAttachmentStore(store)
+
+	report := []byte("%PDF-1.4 quarterly report")
+	for _, id := range []string{"msg-1", "msg-2"} {
+		q.Enqueue(&models.Message{ID: id, Status: models.StatusQueued, QueuedAt: time.Now(), Attachments: []models.Attachment{
+			{Name: "report.pdf", ContentType: "application/pdf", Content: report},
+		}})
+	}
+	q.Close()
+
+	snapshot, _ := os.ReadFile(filepath.Join(dir, "queue", fileQueueSnapshot))
+	journal, _ := os.ReadFile(filepath.Join(dir, "queue", fileQueueJournal))
+	stored := string(snapshot) + string(journal)
+	if !strings.Contains(stored, blobstore.Key(report)) || strings.Contains(stored, base64.StdEncoding.EncodeToString(report)) {
+		t.Error("queue files should hold the attachment reference and not its content")
+	}
+	if blobs, _ := filepath.Glob(filepath.Join(dir, "attachments", "*", "*")); len(blobs) != 1 {
+		t.Errorf("attachment stored %d times, want once", len(blobs))
+	}
+
+	q, err = NewFileQueue(filepath.Join(dir, "queue"))
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer q.Close()
+	msg, err := q.Get("msg-2")
+	if err != nil {
+		t.Fatalf("msg-2 was lost: %v", err)
+	}
+	content, err := blobstore.ReadAll(context.Background(), store, msg.Attachments[0])
+	if err != nil || string(content) != string(report) {
+		t.Errorf("attachment content = %q, %v", content, err)
+	}
+}
+
+func TestFileQueueKeepsPausesAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("NewFileQueue: %v", err)
+	}
+	resumeAt := time.Now().Add(time.Hour)
+	q.Pause(Pause{Scope: PauseProvider, Key: "gmail_ws", Reason: "incident", PausedAt: time.Now(), ResumeAt: &resumeAt})
+	q.Pause(Pause{Scope: PauseWorkspace, Key: "ws-resumed", PausedAt: time.Now()})
+	q.Resume(PauseWorkspace, "ws-resumed")
+	q.Close()
+
+	q, err = NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer q.Close()
+
+	pauses, _ := q.ListPauses()
+	if len(pauses) != 1 || pauses[0].Key != "gmail_ws" || pauses[0].ResumeAt == nil || pauses[0].Reason != "incident" {
+		t.Errorf("pauses after restart = %+v, want only gmail_ws", pauses)
+	}
+}
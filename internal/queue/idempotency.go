@@ -0,0 +1,38 @@
+package queue
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	"relay/pkg/models"
+)
+
+// IdempotencyKey derives a fixed-length deduplication key from its parts
+func IdempotencyKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnqueueDeduplicated enqueues message through q's Deduplicator when key is
+// set and the window is positive, and with a plain Enqueue otherwise. It
+// returns the ID the caller should report for the message.
+func EnqueueDeduplicated(q Queue, message *models.Message, key string, window time.Duration) (string, bool, error) {
+	if key == "" || window <= 0 {
+		return message.ID, false, q.Enqueue(message)
+	}
+	dedup, ok := q.(Deduplicator)
+	if !ok {
+		log.Printf("Warning: Queue cannot deduplicate messages - message %s queued without its idempotency key", message.ID)
+		return message.ID, false, q.Enqueue(message)
+	}
+	return dedup.EnqueueOnce(message, key, window)
+}
+
+// idempotencyEntry is a key held by a message in the memory queue
+type idempotencyEntry struct {
+	MessageID string
+	ExpiresAt time.Time
+}
@@ -1,16 +1,258 @@
 package queue
 
 import (
+	"errors"
+	"time"
+
+	"relay/internal/blobstore"
 	"relay/pkg/models"
 )
 
+// Queue is the message queue. The status transitions after Dequeue (UpdateStatus,
+// UpdateStatusWithProvider, ScheduleRetry and Defer) only apply to a message this
+// worker holds the lease on and return ErrLeaseLost otherwise.
 type Queue interface {
 	Enqueue(message *models.Message) error
 	Dequeue(batchSize int) ([]*models.Message, error)
 	UpdateStatus(id string, status models.MessageStatus, err error) error
 	UpdateStatusWithProvider(id string, status models.MessageStatus, providerID string, err error) error
+	// ScheduleRetry records a failed attempt and retries the message at nextAttemptAt
+	ScheduleRetry(id string, providerID string, nextAttemptAt time.Time, err error) error
+	// Defer parks a queued message until the given time without counting a send
+	// attempt, e.g. while its sender is rate limited. It reports whether this was
+	// the message's first deferral.
+	Defer(id string, until time.Time, reason error) (bool, error)
+	// RecordAttempt appends to the message's attempt history
+	RecordAttempt(id string, attempt models.Attempt) error
 	Get(id string) (*models.Message, error)
 	Remove(id string) error
 	Close() error
 	GetSentCountsByWorkspaceAndSender() (map[string]map[string]int, error)
 }
+
+// ErrNotScheduled is returned when a message is not queued for a future send_at
+var ErrNotScheduled = errors.New("message is not scheduled")
+
+// Scheduler manages queued messages whose send_at is still in the future
+type Scheduler interface {
+	// ListScheduled returns scheduled messages ordered by send_at, optionally filtered by recipient
+	ListScheduled(to string, limit int) ([]*models.Message, error)
+	Reschedule(id string, sendAt time.Time) error
+	CancelScheduled(id string) error
+}
+
+// PriorityLanes is implemented by queues that dequeue across priority lanes
+type PriorityLanes interface {
+	// SetLaneWeights sets each lane's relative share of a dequeue batch; lanes
+	// missing from weights keep their DefaultLaneWeights share
+	SetLaneWeights(weights map[models.MessagePriority]int)
+}
+
+// FairShare configures how Dequeue shares a batch between workspaces
+type FairShare struct {
+	// BySender also shares each workspace's slots evenly between its senders
+	BySender bool
+	// Weights sets each workspace's relative share; unlisted workspaces weigh 1
+	Weights map[string]int
+	// Throttled reports whether messages for a workspace (sender is empty) or for
+	// one of its senders should stay queued, e.g. because they are rate limited
+	Throttled func(workspaceID, sender string) bool
+}
+
+// FairScheduler is implemented by queues that share dequeue batches between workspaces
+type FairScheduler interface {
+	SetFairShare(share FairShare)
+}
+
+// AttachmentStorer is implemented by queues that move attachment content into a
+// blob store on Enqueue, so the stored message keeps only references
+type AttachmentStorer interface {
+	SetAttachmentStore(store blobstore.Store)
+	// CollectBlobs deletes up to limit blobs that no message has referenced
+	// since releasedBefore and returns how many it deleted
+	CollectBlobs(releasedBefore time.Time, limit int) (int, error)
+}
+
+// ErrLeaseLost is returned when renewing, or recording the outcome of, a message
+// whose lease this worker no longer holds, e.g. because it was reaped and the
+// message handed to another worker
+var ErrLeaseLost = errors.New("lease lost")
+
+// Leaser is implemented by queues that lease dequeued messages to a worker.
+// A message whose lease expires is returned to the queue by ReapExpiredLeases,
+// so messages held by a crashed worker are picked up by another one.
+type Leaser interface {
+	// SetLeaseDuration sets how long Dequeue and RenewLease hold a message
+	SetLeaseDuration(d time.Duration)
+	// SetWorkerID sets the locked_by value for messages this worker dequeues
+	SetWorkerID(id string)
+	// RenewLease extends this worker's lease on a processing message
+	RenewLease(id string) error
+	// ReapExpiredLeases returns processing messages with an expired lease to the queue
+	ReapExpiredLeases() (int, error)
+}
+
+// WorkerInfo describes a relay replica in the worker registry
+type WorkerInfo struct {
+	ID                       string     `json:"id"`
+	Hostname                 string     `json:"hostname"`
+	PID                      int        `json:"pid"`
+	StartedAt                time.Time  `json:"started_at"`
+	LastHeartbeatAt          time.Time  `json:"last_heartbeat_at"`
+	HeartbeatIntervalSeconds int        `json:"heartbeat_interval_seconds"`
+	StoppedAt                *time.Time `json:"stopped_at,omitempty"`
+	Status                   string     `json:"status"`        // active, unresponsive or stopped; set by ListWorkers
+	HeldMessages             []string   `json:"held_messages"` // IDs of processing messages leased to this worker
+}
+
+// WorkerRegistry tracks the replicas processing a shared queue
+type WorkerRegistry interface {
+	RegisterWorker(info WorkerInfo) error
+	Heartbeat(id string) error
+	DeregisterWorker(id string) error
+	// ListWorkers returns running workers and those stopped in the last day, with the messages they hold
+	ListWorkers() ([]WorkerInfo, error)
+}
+
+// DeadLetterFilter narrows ListDead; empty fields match everything
+type DeadLetterFilter struct {
+	ProviderID string
+	From       string
+	Limit      int
+	Offset     int
+}
+
+// DeadLetterQueue manages messages that reached the dead status
+type DeadLetterQueue interface {
+	// ListDead returns dead messages, most recently failed first, and the total matching the filter
+	ListDead(filter DeadLetterFilter) ([]*models.Message, int, error)
+	GetAttempts(id string) ([]models.Attempt, error)
+	// Replay puts dead messages back in the queue with a fresh retry budget,
+	// optionally pinned to providerOverride. It returns the number replayed.
+	Replay(ids []string, providerOverride string) (int, error)
+	// Discard deletes dead messages and their attempt history. It returns the number deleted.
+	Discard(ids []string) (int, error)
+}
+
+// RetentionFilter selects finished (sent, dead, cancelled or expired) messages of one
+// workspace that finished before Before. A restored message counts from when it
+// was restored.
+type RetentionFilter struct {
+	WorkspaceID string
+	Before      time.Time
+	Limit       int // Batch size of ClaimUnarchived and Purge
+}
+
+// PurgeScope is what Purge removes from archived messages
+type PurgeScope string
+
+const (
+	PurgeBodies      PurgeScope = "bodies"      // HTML, text and raw bodies
+	PurgeAttachments PurgeScope = "attachments" // Attachment content or references
+	PurgeRows        PurgeScope = "rows"        // The message and its attempt history
+)
+
+// Archiver is implemented by queues whose finished messages can be archived
+// and purged by a retention policy
+type Archiver interface {
+	// ClaimUnarchived returns messages matching filter that have not been
+	// archived, oldest first, and claims them until until. Messages another
+	// replica has claimed are skipped until their claim runs out, so each
+	// message is archived once.
+	ClaimUnarchived(filter RetentionFilter, until time.Time) ([]*models.Message, error)
+	GetAttempts(id string) ([]models.Attempt, error)
+	// MarkArchived records that the messages were written to an archive and
+	// releases their claim
+	MarkArchived(ids []string, at time.Time) error
+	// Purge removes scope from archived messages matching filter. It returns the
+	// number of messages changed; messages already purged are not counted.
+	Purge(scope PurgeScope, filter RetentionFilter) (int, error)
+	// Restore puts an archived message back with its attempt history, replacing
+	// what is left of it, and restarts its retention clock
+	Restore(msg *models.Message, attempts []models.Attempt) error
+}
+
+// BulkAction is an operation applied to every message matching a BulkFilter
+type BulkAction string
+
+const (
+	BulkCancel     BulkAction = "cancel"     // Pending messages become cancelled and are never sent
+	BulkRequeue    BulkAction = "requeue"    // Failed, dead or cancelled messages are queued again with a fresh retry budget
+	BulkReschedule BulkAction = "reschedule" // Pending messages are queued to send at a new time
+	BulkDelete     BulkAction = "delete"     // Messages are removed with their attempt history
+)
+
+// BulkFilter selects messages for a bulk operation. Empty fields match every
+// message; messages being sent are never matched.
+type BulkFilter struct {
+	Statuses             []models.MessageStatus // Narrows the statuses the action applies to
+	WorkspaceID          string
+	From                 string
+	EmailType            string
+	InvitationDispatchID string
+	Tag                  string     // One of the message's X-MC-Tags
+	Subject              string     // Case-insensitive; * matches any run of characters
+	QueuedAfter          *time.Time // Inclusive
+	QueuedBefore         *time.Time // Exclusive
+}
+
+// BulkManager is implemented by queues that can change messages in bulk
+type BulkManager interface {
+	// CountBulk returns how many messages ApplyBulk would change
+	CountBulk(action BulkAction, filter BulkFilter) (int, error)
+	// ApplyBulk applies action to every message matching filter and returns the
+	// number changed. sendAt is used by BulkReschedule only.
+	ApplyBulk(action BulkAction, filter BulkFilter, sendAt time.Time) (int, error)
+}
+
+// Deduplicator is implemented by queues that can refuse a second copy of a
+// message. Keys are built with IdempotencyKey and are remembered for window
+// after the message that claimed them was queued.
+type Deduplicator interface {
+	// EnqueueOnce enqueues message unless another message claimed key within
+	// window. It returns the ID of the message holding the key, which is
+	// message.ID unless duplicate is true.
+	EnqueueOnce(message *models.Message, key string, window time.Duration) (id string, duplicate bool, err error)
+}
+
+// SendTracker is implemented by leasing queues that record a send before the
+// provider is called, so a send interrupted by a crash is checked with the
+// provider instead of being repeated.
+type SendTracker interface {
+	// MarkSending moves a processing message held by this worker to sending via
+	// providerID, which is recorded in SentVia. A message already sending moves
+	// to the next provider of its failover chain once the previous one refused it.
+	MarkSending(id, providerID string) error
+	// MarkSent records a sent message together with the ID the provider assigned it
+	MarkSent(id, providerID, providerMessageID string) error
+	// ClaimUnconfirmedSends leases up to limit sending messages whose lease
+	// expired to this worker, leaving them in sending
+	ClaimUnconfirmedSends(limit int) ([]*models.Message, error)
+}
+
+// Expirer is implemented by queues that can expire waiting messages without
+// dequeueing them, so a message held by a pause or a rate limit expires on time
+type Expirer interface {
+	// ExpireDue moves up to limit queued or failed messages whose expires_at
+	// has passed to expired and returns them. Leased messages are left to the
+	// worker holding them.
+	ExpireDue(limit int) ([]*models.Message, error)
+}
+
+// Pauser is implemented by queues that store pauses alongside the messages, so
+// a pause survives restarts and holds every replica. Dequeue leaves messages of
+// paused workspaces and senders queued; provider pauses are applied by the
+// processor once a message is routed.
+type Pauser interface {
+	// Pause adds p, replacing the pause with the same scope and key
+	Pause(p Pause) error
+	// Resume removes the pause with scope and key and reports whether there was one
+	Resume(scope PauseScope, key string) (bool, error)
+	// ListPauses returns the pauses in effect, oldest first. Pauses whose
+	// ResumeAt has passed are dropped.
+	ListPauses() ([]Pause, error)
+	// Hold parks a paused message until the given time. Unlike Defer it leaves
+	// the deferral time alone, so the message's first rate limit deferral is
+	// still reported as the first.
+	Hold(id string, until time.Time, reason error) error
+}
@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"sync"
+
+	"relay/pkg/models"
+)
+
+// DefaultLaneWeights gives high priority mail six of every ten dequeue slots,
+// normal three and low one while all lanes have mail waiting
+var DefaultLaneWeights = map[models.MessagePriority]int{
+	models.PriorityHigh:   6,
+	models.PriorityNormal: 3,
+	models.PriorityLow:    1,
+}
+
+// laneFetch claims up to limit due messages from one lane, skipping the IDs in taken
+type laneFetch func(lane models.MessagePriority, limit int, taken []string) ([]*models.Message, error)
+
+// laneScheduler splits each dequeue batch across the priority lanes by weight, so
+// a backlog of bulk mail cannot hold up transactional mail and bulk mail still
+// moves while transactional traffic is heavy
+type laneScheduler struct {
+	mu      sync.Mutex
+	weights map[models.MessagePriority]int
+	wrr     *smoothWRR
+}
+
+func newLaneScheduler() *laneScheduler {
+	s := &laneScheduler{}
+	s.setWeights(nil)
+	return s
+}
+
+// setWeights overrides the default weight of the lanes in weights. A lane with
+// weight zero is only served when the other lanes are empty.
+func (s *laneScheduler) setWeights(weights map[models.MessagePriority]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.weights = make(map[models.MessagePriority]int, len(models.Priorities))
+	for _, lane := range models.Priorities {
+		s.weights[lane] = DefaultLaneWeights[lane]
+		if weight, ok := weights[lane]; ok && weight >= 0 {
+			s.weights[lane] = weight
+		}
+	}
+	s.wrr = newSmoothWRR()
+}
+
+// plan assigns n slots to the lanes with a non-zero weight
+func (s *laneScheduler) plan(n int) map[models.MessagePriority]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	available := make(map[string]int, len(models.Priorities))
+	for _, lane := range models.Priorities {
+		if s.weights[lane] > 0 {
+			available[string(lane)] = n
+		}
+	}
+
+	quotas := make(map[models.MessagePriority]int, len(models.Priorities))
+	for lane, quota := range s.wrr.allocate(n, available, func(lane string) int {
+		return s.weights[models.MessagePriority(lane)]
+	}) {
+		quotas[models.MessagePriority(lane)] = quota
+	}
+	return quotas
+}
+
+// dequeue claims up to n messages, first by each lane's planned share and then,
+// when lanes run short, by filling the remaining slots from the other lanes in
+// priority order
+func (s *laneScheduler) dequeue(n int, fetch laneFetch) ([]*models.Message, error) {
+	quotas := s.plan(n)
+
+	var result []*models.Message
+	var taken []string
+	mayHaveMore := make(map[models.MessagePriority]bool, len(models.Priorities))
+
+	for _, lane := range models.Priorities {
+		quota := quotas[lane]
+		if quota == 0 {
+			mayHaveMore[lane] = true
+			continue
+		}
+		messages, err := fetch(lane, quota, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			result = append(result, msg)
+			taken = append(taken, msg.ID)
+		}
+		mayHaveMore[lane] = len(messages) == quota
+	}
+
+	for _, lane := range models.Priorities {
+		remaining := n - len(result)
+		if remaining <= 0 {
+			break
+		}
+		if !mayHaveMore[lane] {
+			continue
+		}
+		messages, err := fetch(lane, remaining, taken)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			result = append(result, msg)
+			taken = append(taken, msg.ID)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,41 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultLeaseDuration is used until SetLeaseDuration is called
+const DefaultLeaseDuration = 5 * time.Minute
+
+// DefaultWorkerID identifies this process in locked_by when no worker ID is configured
+func DefaultWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "relay"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Worker statuses reported by ListWorkers
+const (
+	WorkerStatusActive       = "active"
+	WorkerStatusUnresponsive = "unresponsive"
+	WorkerStatusStopped      = "stopped"
+)
+
+// workerStatus treats a worker as unresponsive after three missed heartbeats
+func workerStatus(info WorkerInfo, now time.Time) string {
+	if info.StoppedAt != nil {
+		return WorkerStatusStopped
+	}
+	interval := time.Duration(info.HeartbeatIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	if now.Sub(info.LastHeartbeatAt) > 3*interval {
+		return WorkerStatusUnresponsive
+	}
+	return WorkerStatusActive
+}
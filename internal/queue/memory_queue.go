@@ -2,9 +2,12 @@ package queue
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"relay/internal/blobstore"
 	"relay/pkg/models"
 )
 
@@ -12,60 +15,244 @@ type MemoryQueue struct {
 	mu       sync.RWMutex
 	messages map[string]*models.Message
 	order    []string
+	attempts map[string][]models.Attempt
+
+	workerID      string
+	leaseDuration time.Duration
+	workers       map[string]*WorkerInfo
+	lanes         *laneScheduler
+	fair          *fairShare
+	blobs         attachmentBlobs
+	released      map[string]time.Time // Blob keys of removed attachments and when they were last released
+	archiveClaims map[string]time.Time // Messages being archived and until when
+
+	keys         map[string]idempotencyEntry // Idempotency keys claimed by EnqueueOnce
+	keysPrunedAt time.Time
+	pauses       map[string]Pause // Keyed by pauseID
 }
 
 func NewMemoryQueue() *MemoryQueue {
 	return &MemoryQueue{
 		messages: make(map[string]*models.Message),
 		order:    make([]string, 0),
+		attempts: make(map[string][]models.Attempt),
+
+		workerID:      DefaultWorkerID(),
+		leaseDuration: DefaultLeaseDuration,
+		workers:       make(map[string]*WorkerInfo),
+		lanes:         newLaneScheduler(),
+		fair:          newFairShare(),
+		released:      make(map[string]time.Time),
+		archiveClaims: make(map[string]time.Time),
+		keys:          make(map[string]idempotencyEntry),
+		pauses:        make(map[string]Pause),
 	}
 }
 
 func (q *MemoryQueue) Enqueue(message *models.Message) error {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	return q.blobs.externalize(message.Attachments, func() error {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		q.addLocked(message)
+		return nil
+	})
+}
 
-	q.messages[message.ID] = message
+// addLocked stores a copy of a new message; callers must hold q.mu
+func (q *MemoryQueue) addLocked(message *models.Message) {
+	q.messages[message.ID] = cloneMessage(message)
 	q.order = append(q.order, message.ID)
-	return nil
+}
+
+// EnqueueOnce claims key for the message before enqueueing it, so a copy
+// arriving while the first is still being stored is reported as a duplicate
+func (q *MemoryQueue) EnqueueOnce(message *models.Message, key string, window time.Duration) (string, bool, error) {
+	q.mu.Lock()
+	id, claimed := q.claimKeyLocked(key, message.ID, time.Now(), window)
+	q.mu.Unlock()
+	if !claimed {
+		return id, true, nil
+	}
+
+	if err := q.Enqueue(message); err != nil {
+		q.mu.Lock()
+		q.releaseKeyLocked(key, message.ID)
+		q.mu.Unlock()
+		return "", false, err
+	}
+	return message.ID, false, nil
+}
+
+// claimKeyLocked gives key to id unless another message holds it, returning
+// the holder; callers must hold q.mu
+func (q *MemoryQueue) claimKeyLocked(key, id string, now time.Time, window time.Duration) (string, bool) {
+	if now.Sub(q.keysPrunedAt) > time.Minute {
+		for k, entry := range q.keys {
+			if !now.Before(entry.ExpiresAt) {
+				delete(q.keys, k)
+			}
+		}
+		q.keysPrunedAt = now
+	}
+
+	if entry, exists := q.keys[key]; exists && now.Before(entry.ExpiresAt) {
+		return entry.MessageID, false
+	}
+	q.keys[key] = idempotencyEntry{MessageID: id, ExpiresAt: now.Add(window)}
+	return id, true
+}
+
+// releaseKeyLocked frees key if id still holds it; callers must hold q.mu
+func (q *MemoryQueue) releaseKeyLocked(key, id string) {
+	if entry, exists := q.keys[key]; exists && entry.MessageID == id {
+		delete(q.keys, key)
+	}
 }
 
 func (q *MemoryQueue) Dequeue(batchSize int) ([]*models.Message, error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	var result []*models.Message
-	var newOrder []string
+	now := time.Now()
+	leaseExpiresAt := now.Add(q.leaseDuration)
+	paused := NewPauseSet(activePauses(q.pauses, now), now)
+	if paused.Global() != nil {
+		return nil, nil
+	}
+
+	// Claimed messages leave the due set, so taken never needs checking here
+	return q.lanes.dequeue(batchSize, func(lane models.MessagePriority, limit int, taken []string) ([]*models.Message, error) {
+		var due []dueGroup
+		index := make(map[[2]string]int)
+		for _, id := range q.order {
+			msg, exists := q.messages[id]
+			if !exists || msg.Priority.Lane() != lane || !isDue(msg, now) {
+				continue
+			}
+			key := [2]string{msg.ProviderID, msg.From}
+			if i, ok := index[key]; ok {
+				due[i].Count++
+				continue
+			}
+			index[key] = len(due)
+			due = append(due, dueGroup{WorkspaceID: msg.ProviderID, Sender: msg.From, Count: 1})
+		}
 
-	count := 0
-	for _, id := range q.order {
-		msg, exists := q.messages[id]
-		if !exists {
-			continue
+		var result []*models.Message
+		for _, fetch := range q.fair.plan(limit, due, paused) {
+			claimed := 0
+			for _, id := range q.order {
+				if claimed >= fetch.Limit {
+					break
+				}
+				msg, exists := q.messages[id]
+				if !exists || msg.Priority.Lane() != lane || !isDue(msg, now) || !fetch.matches(msg) {
+					continue
+				}
+				msg.Status = models.StatusProcessing
+				msg.LockedBy = q.workerID
+				msg.LeaseExpiresAt = &leaseExpiresAt
+				result = append(result, cloneMessage(msg))
+				claimed++
+			}
 		}
+		return result, nil
+	})
+}
 
-		if (msg.Status == models.StatusQueued || msg.Status == models.StatusFailed || msg.Status == models.StatusAuthError) && count < batchSize {
-			msg.Status = models.StatusProcessing
-			result = append(result, msg)
-			count++
+// SetLaneWeights sets each priority lane's share of a dequeue batch
+func (q *MemoryQueue) SetLaneWeights(weights map[models.MessagePriority]int) {
+	q.lanes.setWeights(weights)
+}
+
+// SetFairShare sets how dequeue batches are shared between workspaces
+func (q *MemoryQueue) SetFairShare(share FairShare) {
+	q.fair.set(share)
+}
+
+// SetAttachmentStore moves the attachment content of enqueued messages into
+// store; for the file queue this keeps the journal small
+func (q *MemoryQueue) SetAttachmentStore(store blobstore.Store) {
+	q.blobs.store = store
+}
+
+// releaseLocked marks the blobs of attachments a message no longer holds for
+// collection; callers must hold q.mu
+func (q *MemoryQueue) releaseLocked(attachments []models.Attachment) {
+	if q.blobs.store == nil {
+		return
+	}
+	now := time.Now()
+	for _, key := range blobKeys(nil, attachments) {
+		q.released[key] = now
+	}
+}
+
+// CollectBlobs deletes up to limit blobs released before releasedBefore that
+// no message references any more
+func (q *MemoryQueue) CollectBlobs(releasedBefore time.Time, limit int) (int, error) {
+	q.mu.Lock()
+	var keys []string
+	for key, at := range q.released {
+		if len(keys) >= limit {
+			break
+		}
+		if at.Before(releasedBefore) {
+			keys = append(keys, key)
+			delete(q.released, key)
 		}
-		newOrder = append(newOrder, id)
 	}
+	q.mu.Unlock()
 
-	q.order = newOrder
-	return result, nil
+	return q.blobs.collect(keys, q.referencedBlobs), nil
+}
+
+// referencedBlobs reports which of keys a message in the queue references
+func (q *MemoryQueue) referencedBlobs(keys []string) (map[string]bool, error) {
+	want := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		want[key] = true
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	inUse := make(map[string]bool)
+	for _, msg := range q.messages {
+		markReferenced(inUse, want, msg.Attachments)
+	}
+	return inUse, nil
+}
+
+// isDue reports whether Dequeue may hand out msg: queued messages once their
+// send_at has passed, failed messages once their retry is due
+func isDue(msg *models.Message, now time.Time) bool {
+	switch msg.Status {
+	case models.StatusQueued:
+		if msg.NextAttemptAt != nil && msg.NextAttemptAt.After(now) {
+			return false // Deferred
+		}
+		return msg.SendAt == nil || !msg.SendAt.After(now)
+	case models.StatusFailed:
+		return msg.NextAttemptAt != nil && !msg.NextAttemptAt.After(now)
+	}
+	return false
 }
 
 func (q *MemoryQueue) UpdateStatus(id string, status models.MessageStatus, err error) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	msg, exists := q.messages[id]
-	if !exists {
-		return fmt.Errorf("message %s not found", id)
+	msg, leaseErr := q.heldMessage(id)
+	if leaseErr != nil {
+		return leaseErr
 	}
 
 	msg.Status = status
+	msg.RetryCount += attemptIncrement(status)
+	msg.NextAttemptAt = nil
+	releaseLease(msg)
 	now := time.Now()
 	msg.ProcessedAt = &now
 
@@ -80,13 +267,45 @@ func (q *MemoryQueue) UpdateStatusWithProvider(id string, status models.MessageS
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	msg, exists := q.messages[id]
-	if !exists {
-		return fmt.Errorf("message %s not found", id)
+	msg, leaseErr := q.heldMessage(id)
+	if leaseErr != nil {
+		return leaseErr
 	}
 
 	msg.Status = status
-	// Provider ID is only stored in the database, not in memory
+	msg.RetryCount += attemptIncrement(status)
+	msg.NextAttemptAt = nil
+	releaseLease(msg)
+	if providerID != "" {
+		msg.SentVia = providerID
+	}
+	now := time.Now()
+	msg.ProcessedAt = &now
+
+	if err != nil {
+		msg.Error = err.Error()
+	}
+
+	return nil
+}
+
+// ScheduleRetry records a failed attempt and retries the message at nextAttemptAt
+func (q *MemoryQueue) ScheduleRetry(id string, providerID string, nextAttemptAt time.Time, err error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg, leaseErr := q.heldMessage(id)
+	if leaseErr != nil {
+		return leaseErr
+	}
+
+	msg.Status = models.StatusFailed
+	msg.RetryCount++
+	msg.NextAttemptAt = &nextAttemptAt
+	releaseLease(msg)
+	if providerID != "" {
+		msg.SentVia = providerID
+	}
 	now := time.Now()
 	msg.ProcessedAt = &now
 
@@ -97,6 +316,319 @@ func (q *MemoryQueue) UpdateStatusWithProvider(id string, status models.MessageS
 	return nil
 }
 
+// Defer parks a message in the queue until the given time
+func (q *MemoryQueue) Defer(id string, until time.Time, reason error) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg, err := q.heldMessage(id)
+	if err != nil {
+		return false, err
+	}
+
+	first := msg.DeferredAt == nil
+	if first {
+		now := time.Now()
+		msg.DeferredAt = &now
+	}
+	parkMessage(msg, until, reason)
+
+	return first, nil
+}
+
+// Hold parks a paused message until the given time without marking it deferred
+func (q *MemoryQueue) Hold(id string, until time.Time, reason error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg, err := q.heldMessage(id)
+	if err != nil {
+		return err
+	}
+	parkMessage(msg, until, reason)
+	return nil
+}
+
+// heldMessage returns a message this worker holds the lease on. A message
+// whose lease was reaped, and possibly claimed by another worker, is ErrLeaseLost.
+func (q *MemoryQueue) heldMessage(id string) (*models.Message, error) {
+	msg, exists := q.messages[id]
+	if !exists || !isLeased(msg) || msg.LockedBy != q.workerID {
+		return nil, ErrLeaseLost
+	}
+	return msg, nil
+}
+
+// parkMessage puts a message back in the queue, due at until
+func parkMessage(msg *models.Message, until time.Time, reason error) {
+	msg.Status = models.StatusQueued
+	msg.NextAttemptAt = &until
+	releaseLease(msg)
+	if reason != nil {
+		msg.Error = reason.Error()
+	}
+}
+
+// SetLeaseDuration sets how long Dequeue and RenewLease hold a message
+func (q *MemoryQueue) SetLeaseDuration(d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if d > 0 {
+		q.leaseDuration = d
+	}
+}
+
+// SetWorkerID sets the locked_by value for messages this worker dequeues
+func (q *MemoryQueue) SetWorkerID(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if id != "" {
+		q.workerID = id
+	}
+}
+
+// RenewLease extends this worker's lease on a processing message
+func (q *MemoryQueue) RenewLease(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg, exists := q.messages[id]
+	if !exists || !isLeased(msg) || msg.LockedBy != q.workerID {
+		return ErrLeaseLost
+	}
+
+	leaseExpiresAt := time.Now().Add(q.leaseDuration)
+	msg.LeaseExpiresAt = &leaseExpiresAt
+	return nil
+}
+
+// ReapExpiredLeases returns processing messages whose lease has expired to the queue
+func (q *MemoryQueue) ReapExpiredLeases() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	reaped := 0
+	for _, msg := range q.messages {
+		if msg.Status == models.StatusProcessing && msg.LeaseExpiresAt != nil && msg.LeaseExpiresAt.Before(now) {
+			msg.Status = models.StatusQueued
+			releaseLease(msg)
+			reaped++
+		}
+	}
+
+	return reaped, nil
+}
+
+// MarkSending records that this worker is handing the message to providerID
+func (q *MemoryQueue) MarkSending(id, providerID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg, exists := q.messages[id]
+	if !exists || !isLeased(msg) || msg.LockedBy != q.workerID {
+		return ErrLeaseLost
+	}
+
+	msg.Status = models.StatusSending
+	msg.SentVia = providerID
+	return nil
+}
+
+// MarkSent records the message as sent together with the provider's message ID
+func (q *MemoryQueue) MarkSent(id, providerID, providerMessageID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg, err := q.heldMessage(id)
+	if err != nil {
+		return err
+	}
+
+	markSent(msg, providerID, providerMessageID, time.Now())
+	return nil
+}
+
+// ClaimUnconfirmedSends leases sending messages whose lease expired to this worker
+func (q *MemoryQueue) ClaimUnconfirmedSends(limit int) ([]*models.Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var claimed []*models.Message
+	for _, id := range q.order {
+		if len(claimed) >= limit {
+			break
+		}
+		msg := q.messages[id]
+		if msg == nil || !unconfirmed(msg, now) {
+			continue
+		}
+		leaseExpiresAt := now.Add(q.leaseDuration)
+		msg.LockedBy = q.workerID
+		msg.LeaseExpiresAt = &leaseExpiresAt
+		claimed = append(claimed, cloneMessage(msg))
+	}
+	return claimed, nil
+}
+
+// ExpireDue moves up to limit waiting messages whose expires_at has passed to expired
+func (q *MemoryQueue) ExpireDue(limit int) ([]*models.Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var expired []*models.Message
+	for _, id := range q.order {
+		if len(expired) >= limit {
+			break
+		}
+		msg := q.messages[id]
+		if msg == nil || !expirable(msg, now) {
+			continue
+		}
+		expire(msg, now)
+		expired = append(expired, cloneMessage(msg))
+	}
+	return expired, nil
+}
+
+// Pause adds p, replacing the pause with the same scope and key
+func (q *MemoryQueue) Pause(p Pause) error {
+	if err := p.Normalize(); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pauses[pauseID(p.Scope, p.Key)] = p
+	return nil
+}
+
+// Resume removes the pause with scope and key and reports whether there was one
+func (q *MemoryQueue) Resume(scope PauseScope, key string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := pauseID(scope, key)
+	_, exists := q.pauses[id]
+	delete(q.pauses, id)
+	return exists, nil
+}
+
+// ListPauses returns the pauses in effect, oldest first
+func (q *MemoryQueue) ListPauses() ([]Pause, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return activePauses(q.pauses, time.Now()), nil
+}
+
+func (q *MemoryQueue) RegisterWorker(info WorkerInfo) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	info.StoppedAt = nil
+	q.workers[info.ID] = &info
+	return nil
+}
+
+func (q *MemoryQueue) Heartbeat(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if worker, exists := q.workers[id]; exists {
+		worker.LastHeartbeatAt = time.Now()
+	}
+	return nil
+}
+
+func (q *MemoryQueue) DeregisterWorker(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if worker, exists := q.workers[id]; exists {
+		now := time.Now()
+		worker.StoppedAt = &now
+	}
+	return nil
+}
+
+// ListWorkers returns registered workers with the messages they hold
+func (q *MemoryQueue) ListWorkers() ([]WorkerInfo, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	now := time.Now()
+	workers := make([]WorkerInfo, 0, len(q.workers))
+	for _, worker := range q.workers {
+		info := *worker
+		info.HeldMessages = []string{}
+		for _, id := range q.order {
+			if msg := q.messages[id]; msg != nil && isLeased(msg) && msg.LockedBy == info.ID {
+				info.HeldMessages = append(info.HeldMessages, id)
+			}
+		}
+		info.Status = workerStatus(info, now)
+		workers = append(workers, info)
+	}
+
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].StartedAt.After(workers[j].StartedAt)
+	})
+	return workers, nil
+}
+
+// isLeased reports whether the message is held by a worker
+func isLeased(msg *models.Message) bool {
+	return msg.Status == models.StatusProcessing || msg.Status == models.StatusSending
+}
+
+// cloneMessage copies msg for a caller outside q.mu. The processor and providers
+// change the messages they are handed, e.g. personalizing bodies and adding a
+// Message-ID header, which must not race with the queue's own updates.
+func cloneMessage(msg *models.Message) *models.Message {
+	clone := *msg
+	clone.To = append([]string(nil), msg.To...)
+	clone.CC = append([]string(nil), msg.CC...)
+	clone.BCC = append([]string(nil), msg.BCC...)
+	clone.Attachments = append([]models.Attachment(nil), msg.Attachments...)
+	if msg.Headers != nil {
+		clone.Headers = make(map[string]string, len(msg.Headers))
+		for name, value := range msg.Headers {
+			clone.Headers[name] = value
+		}
+	}
+	if msg.Metadata != nil {
+		clone.Metadata = make(map[string]interface{}, len(msg.Metadata))
+		for key, value := range msg.Metadata {
+			clone.Metadata[key] = value
+		}
+	}
+	return &clone
+}
+
+// releaseLease clears the lease on a message leaving the processing status
+func releaseLease(msg *models.Message) {
+	msg.LockedBy = ""
+	msg.LeaseExpiresAt = nil
+}
+
+// RecordAttempt appends to the message's attempt history
+func (q *MemoryQueue) RecordAttempt(id string, attempt models.Attempt) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, exists := q.messages[id]; !exists {
+		return fmt.Errorf("message %s not found", id)
+	}
+
+	q.attempts[id] = append(q.attempts[id], attempt)
+	return nil
+}
+
 func (q *MemoryQueue) Get(id string) (*models.Message, error) {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
@@ -106,14 +638,24 @@ func (q *MemoryQueue) Get(id string) (*models.Message, error) {
 		return nil, fmt.Errorf("message %s not found", id)
 	}
 
-	return msg, nil
+	return cloneMessage(msg), nil
 }
 
 func (q *MemoryQueue) Remove(id string) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
+	q.removeLocked(id)
+	return nil
+}
+
+// removeLocked deletes a message; callers must hold q.mu
+func (q *MemoryQueue) removeLocked(id string) {
+	if msg, exists := q.messages[id]; exists {
+		q.releaseLocked(msg.Attachments)
+	}
 	delete(q.messages, id)
+	delete(q.attempts, id)
 
 	newOrder := make([]string, 0)
 	for _, msgID := range q.order {
@@ -122,10 +664,344 @@ func (q *MemoryQueue) Remove(id string) error {
 		}
 	}
 	q.order = newOrder
+}
+
+// ListScheduled returns queued messages with a future send_at
+func (q *MemoryQueue) ListScheduled(to string, limit int) ([]*models.Message, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	var scheduled []*models.Message
+	for _, id := range q.order {
+		msg := q.messages[id]
+		if msg != nil && isScheduled(msg) && (to == "" || containsAddress(msg.To, to)) {
+			scheduled = append(scheduled, cloneMessage(msg))
+		}
+	}
+
+	sort.Slice(scheduled, func(i, j int) bool {
+		return scheduled[i].SendAt.Before(*scheduled[j].SendAt)
+	})
+	if len(scheduled) > limit {
+		scheduled = scheduled[:limit]
+	}
+
+	return scheduled, nil
+}
+
+// Reschedule moves a scheduled message to a new send_at
+func (q *MemoryQueue) Reschedule(id string, sendAt time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg, exists := q.messages[id]
+	if !exists || !isScheduled(msg) {
+		return ErrNotScheduled
+	}
 
+	msg.SendAt = &sendAt
 	return nil
 }
 
+// CancelScheduled removes a message that has not reached its send_at yet
+func (q *MemoryQueue) CancelScheduled(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg, exists := q.messages[id]
+	if !exists || !isScheduled(msg) {
+		return ErrNotScheduled
+	}
+
+	q.removeLocked(id)
+	return nil
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if strings.EqualFold(a, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// isScheduled reports whether msg is queued for a future send_at
+func isScheduled(msg *models.Message) bool {
+	return msg.Status == models.StatusQueued && msg.SendAt != nil && msg.SendAt.After(time.Now())
+}
+
+// ListDead returns dead messages, most recently failed first
+func (q *MemoryQueue) ListDead(filter DeadLetterFilter) ([]*models.Message, int, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	var dead []*models.Message
+	for _, id := range q.order {
+		msg := q.messages[id]
+		if msg == nil || msg.Status != models.StatusDead {
+			continue
+		}
+		if (filter.ProviderID != "" && msg.ProviderID != filter.ProviderID) || (filter.From != "" && msg.From != filter.From) {
+			continue
+		}
+		dead = append(dead, cloneMessage(msg))
+	}
+
+	sort.SliceStable(dead, func(i, j int) bool {
+		return processedAfter(dead[i], dead[j])
+	})
+
+	total := len(dead)
+	if filter.Offset >= total {
+		return []*models.Message{}, total, nil
+	}
+	end := total
+	if filter.Limit > 0 && filter.Offset+filter.Limit < end {
+		end = filter.Offset + filter.Limit
+	}
+
+	return dead[filter.Offset:end], total, nil
+}
+
+// GetAttempts returns a message's attempt history, oldest first
+func (q *MemoryQueue) GetAttempts(id string) ([]models.Attempt, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if _, exists := q.messages[id]; !exists {
+		return nil, fmt.Errorf("message %s not found", id)
+	}
+
+	return append([]models.Attempt(nil), q.attempts[id]...), nil
+}
+
+// Replay moves dead messages back to queued with a fresh retry budget
+func (q *MemoryQueue) Replay(ids []string, providerOverride string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	replayed := 0
+	for _, id := range ids {
+		msg, exists := q.messages[id]
+		if !exists || msg.Status != models.StatusDead {
+			continue
+		}
+
+		msg.Status = models.StatusQueued
+		msg.RetryCount = 0
+		msg.NextAttemptAt = nil
+		msg.ProcessedAt = nil
+		msg.Error = ""
+		msg.ProviderOverride = providerOverride
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// Discard deletes dead messages and their attempt history
+func (q *MemoryQueue) Discard(ids []string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	discarded := 0
+	for _, id := range ids {
+		if msg, exists := q.messages[id]; exists && msg.Status == models.StatusDead {
+			q.removeLocked(id)
+			discarded++
+		}
+	}
+
+	return discarded, nil
+}
+
+// retentionStatuses are the finished statuses retention applies to
+var retentionStatuses = []models.MessageStatus{models.StatusSent, models.StatusDead, models.StatusCancelled, models.StatusExpired}
+
+// retentionDue reports whether msg is a finished message of the filter's
+// workspace that finished, or was restored, before the cutoff
+func retentionDue(msg *models.Message, filter RetentionFilter) bool {
+	if msg.ProviderID != filter.WorkspaceID || !containsStatus(retentionStatuses, msg.Status) {
+		return false
+	}
+	finished := msg.ProcessedAt
+	if msg.RestoredAt != nil {
+		finished = msg.RestoredAt
+	}
+	return finished != nil && finished.Before(filter.Before)
+}
+
+// ClaimUnarchived returns finished messages that are due for archiving and not
+// claimed by another run, oldest first, and claims them until until
+func (q *MemoryQueue) ClaimUnarchived(filter RetentionFilter, until time.Time) ([]*models.Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var due []*models.Message
+	for _, id := range q.order {
+		msg := q.messages[id]
+		if msg == nil || msg.ArchivedAt != nil || !retentionDue(msg, filter) || q.archiveClaims[id].After(now) {
+			continue
+		}
+		due = append(due, cloneMessage(msg))
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return processedAfter(due[j], due[i])
+	})
+	if filter.Limit > 0 && len(due) > filter.Limit {
+		due = due[:filter.Limit]
+	}
+	for _, msg := range due {
+		q.archiveClaims[msg.ID] = until
+	}
+	return due, nil
+}
+
+// MarkArchived records when the messages were written to an archive
+func (q *MemoryQueue) MarkArchived(ids []string, at time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, id := range ids {
+		delete(q.archiveClaims, id)
+		if msg, exists := q.messages[id]; exists {
+			archivedAt := at
+			msg.ArchivedAt = &archivedAt
+		}
+	}
+	return nil
+}
+
+// Purge removes scope from up to filter.Limit archived messages
+func (q *MemoryQueue) Purge(scope PurgeScope, filter RetentionFilter) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	purged, err := q.purgeLocked(scope, filter)
+	return len(purged), err
+}
+
+// purgeLocked applies Purge and returns the IDs it changed; callers must hold q.mu
+func (q *MemoryQueue) purgeLocked(scope PurgeScope, filter RetentionFilter) ([]string, error) {
+	var purged []string
+	for _, id := range append([]string(nil), q.order...) {
+		if filter.Limit > 0 && len(purged) >= filter.Limit {
+			break
+		}
+		msg := q.messages[id]
+		if msg == nil || msg.ArchivedAt == nil || !retentionDue(msg, filter) {
+			continue
+		}
+
+		switch scope {
+		case PurgeBodies:
+			if msg.HTML == "" && msg.Text == "" && msg.RawMessage == nil {
+				continue
+			}
+			msg.HTML, msg.Text, msg.RawMessage = "", "", nil
+		case PurgeAttachments:
+			if len(msg.Attachments) == 0 {
+				continue
+			}
+			q.releaseLocked(msg.Attachments)
+			msg.Attachments = nil
+		case PurgeRows:
+			q.removeLocked(id)
+		default:
+			return nil, fmt.Errorf("unknown purge scope %q", scope)
+		}
+		purged = append(purged, id)
+	}
+	return purged, nil
+}
+
+// Restore replaces what is left of an archived message with its archived copy
+func (q *MemoryQueue) Restore(msg *models.Message, attempts []models.Attempt) error {
+	return q.blobs.externalize(msg.Attachments, func() error {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		now := time.Now()
+		msg.RestoredAt = &now
+		if existing, exists := q.messages[msg.ID]; exists {
+			q.releaseLocked(existing.Attachments)
+		} else {
+			q.order = append(q.order, msg.ID)
+		}
+		q.messages[msg.ID] = cloneMessage(msg)
+		q.attempts[msg.ID] = append([]models.Attempt(nil), attempts...)
+		return nil
+	})
+}
+
+// CountBulk returns how many messages ApplyBulk would change
+func (q *MemoryQueue) CountBulk(action BulkAction, filter BulkFilter) (int, error) {
+	matcher, err := newBulkMatcher(action, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	count := 0
+	for _, id := range q.order {
+		if msg := q.messages[id]; msg != nil && matcher.matches(msg) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ApplyBulk applies action to every message matching filter
+func (q *MemoryQueue) ApplyBulk(action BulkAction, filter BulkFilter, sendAt time.Time) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	changed, err := q.applyBulkLocked(action, filter, sendAt)
+	return len(changed), err
+}
+
+// applyBulkLocked applies ApplyBulk and returns the IDs it changed; callers must hold q.mu
+func (q *MemoryQueue) applyBulkLocked(action BulkAction, filter BulkFilter, sendAt time.Time) ([]string, error) {
+	matcher, err := newBulkMatcher(action, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var changed []string
+	order := make([]string, 0, len(q.order))
+	for _, id := range q.order {
+		msg := q.messages[id]
+		if msg == nil || !matcher.matches(msg) {
+			order = append(order, id)
+			continue
+		}
+		changed = append(changed, id)
+		if action == BulkDelete {
+			q.releaseLocked(msg.Attachments)
+			delete(q.messages, id)
+			delete(q.attempts, id)
+			continue
+		}
+		applyBulk(msg, action, sendAt, now)
+		order = append(order, id)
+	}
+	q.order = order
+	return changed, nil
+}
+
+// processedAfter orders messages by processed_at, newest first, with unprocessed messages last
+func processedAfter(a, b *models.Message) bool {
+	if a.ProcessedAt == nil || b.ProcessedAt == nil {
+		return a.ProcessedAt != nil && b.ProcessedAt == nil
+	}
+	return a.ProcessedAt.After(*b.ProcessedAt)
+}
+
 func (q *MemoryQueue) Close() error {
 	return nil
 }
@@ -150,6 +1026,25 @@ func (q *MemoryQueue) GetStats() (map[string]interface{}, error) {
 	}
 
 	stats["statusCounts"] = counts
+
+	// Depth of each priority lane: messages waiting to be sent, including pending retries
+	laneDepths := make(map[models.MessagePriority]int)
+	for _, msg := range q.messages {
+		if msg.Status == models.StatusQueued || (msg.Status == models.StatusFailed && msg.NextAttemptAt != nil) {
+			laneDepths[msg.Priority.Lane()]++
+		}
+	}
+
+	var laneCounts []map[string]interface{}
+	for _, lane := range models.Priorities {
+		if laneDepths[lane] > 0 {
+			laneCounts = append(laneCounts, map[string]interface{}{
+				"Priority": string(lane),
+				"Count":    laneDepths[lane],
+			})
+		}
+	}
+	stats["laneCounts"] = laneCounts
 	stats["total"] = len(q.messages)
 
 	return stats, nil
@@ -164,7 +1059,7 @@ func (q *MemoryQueue) GetMessages(limit, offset int, status string) ([]*models.M
 	for i := len(q.order) - 1; i >= 0; i-- {
 		msg := q.messages[q.order[i]]
 		if status == "" || status == "all" || string(msg.Status) == status {
-			filtered = append(filtered, msg)
+			filtered = append(filtered, cloneMessage(msg))
 		}
 	}
 
@@ -0,0 +1,187 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"relay/pkg/models"
+)
+
+func TestMemoryQueueDeadLetterReplay(t *testing.T) {
+	q := NewMemoryQueue()
+	q.Enqueue(&models.Message{ID: "dead-1", From: "a@example.com", Status: models.StatusQueued, QueuedAt: time.Now()})
+	q.Enqueue(&models.Message{ID: "queued-1", From: "a@example.com", Status: models.StatusQueued, QueuedAt: time.Now()})
+
+	q.Dequeue(2)
+	q.RecordAttempt("dead-1", models.Attempt{Number: 1, ProviderID: "gmail_ws", ErrorClass: "permanent_recipient", Error: "bad address"})
+	q.UpdateStatusWithProvider("dead-1", models.StatusDead, "gmail_ws", nil)
+
+	dead, total, _ := q.ListDead(DeadLetterFilter{Limit: 10})
+	if total != 1 || len(dead) != 1 || dead[0].ID != "dead-1" {
+		t.Fatalf("ListDead returned %d of %d, want only dead-1", len(dead), total)
+	}
+
+	if replayed, _ := q.Replay([]string{"dead-1", "queued-1"}, "mailgun_ws"); replayed != 1 {
+		t.Fatalf("replayed %d messages, want 1", replayed)
+	}
+
+	msg, _ := q.Get("dead-1")
+	if msg.Status != models.StatusQueued || msg.RetryCount != 0 || msg.ProviderOverride != "mailgun_ws" {
+		t.Errorf("replayed message = %s, retry_count %d, override %q", msg.Status, msg.RetryCount, msg.ProviderOverride)
+	}
+	if attempts, _ := q.GetAttempts("dead-1"); len(attempts) != 1 {
+		t.Errorf("replay should keep the attempt history, got %d attempts", len(attempts))
+	}
+
+	if discarded, _ := q.Discard([]string{"dead-1"}); discarded != 0 {
+		t.Errorf("discarded %d messages that are no longer dead", discarded)
+	}
+}
+
+func TestMemoryQueueReapsExpiredLeases(t *testing.T) {
+	q := NewMemoryQueue()
+	q.SetLeaseDuration(time.Minute)
+	q.Enqueue(&models.Message{ID: "msg-1", Status: models.StatusQueued, QueuedAt: time.Now()})
+
+	batch, _ := q.Dequeue(10)
+	if len(batch) != 1 || batch[0].LockedBy == "" || batch[0].LeaseExpiresAt == nil {
+		t.Fatalf("dequeued message should carry a lease, got %+v", batch)
+	}
+	if err := q.RenewLease("msg-1"); err != nil {
+		t.Fatalf("RenewLease: %v", err)
+	}
+	if reaped, _ := q.ReapExpiredLeases(); reaped != 0 {
+		t.Fatalf("reaped %d messages with a live lease", reaped)
+	}
+
+	expired := time.Now().Add(-time.Second)
+	q.messages["msg-1"].LeaseExpiresAt = &expired
+	if reaped, _ := q.ReapExpiredLeases(); reaped != 1 {
+		t.Fatalf("reaped %d messages, want 1", reaped)
+	}
+	if err := q.RenewLease("msg-1"); err != ErrLeaseLost {
+		t.Errorf("RenewLease after reaping = %v, want ErrLeaseLost", err)
+	}
+	if batch, _ := q.Dequeue(10); len(batch) != 1 {
+		t.Errorf("reaped message should be dequeued again, got %d", len(batch))
+	}
+}
+
+func TestMemoryQueueDequeueSharesBatchAcrossLanes(t *testing.T) {
+	q := NewMemoryQueue()
+	for i := 0; i < 20; i++ {
+		q.Enqueue(&models.Message{ID: fmt.Sprintf("bulk-%d", i), Priority: models.PriorityLow, Status: models.StatusQueued, QueuedAt: time.Now()})
+	}
+	for i := 0; i < 20; i++ {
+		q.Enqueue(&models.Message{ID: fmt.Sprintf("reset-%d", i), Priority: models.PriorityHigh, Status: models.StatusQueued, QueuedAt: time.Now()})
+	}
+
+	// Default weights 6/3/1: the empty normal lane's share goes to high, low keeps its slot
+	batch, _ := q.Dequeue(10)
+	lanes := map[models.MessagePriority]int{}
+	for _, msg := range batch {
+		lanes[msg.Priority]++
+	}
+	if lanes[models.PriorityHigh] != 9 || lanes[models.PriorityLow] != 1 {
+		t.Errorf("batch took %d high and %d low messages, want 9 and 1", lanes[models.PriorityHigh], lanes[models.PriorityLow])
+	}
+
+	// Once high priority mail is drained, bulk mail fills whole batches
+	q.Dequeue(20)
+	if batch, _ := q.Dequeue(10); len(batch) != 10 || batch[0].Priority != models.PriorityLow {
+		t.Errorf("expected a full batch of bulk mail, got %d messages", len(batch))
+	}
+}
+
+func TestMemoryQueueDequeueSharesBatchAcrossWorkspaces(t *testing.T) {
+	q := NewMemoryQueue()
+	for i := 0; i < 20; i++ {
+		q.Enqueue(&models.Message{ID: fmt.Sprintf("campaign-%d", i), ProviderID: "ws-a", From: "news@a.com", Status: models.StatusQueued, QueuedAt: time.Now()})
+	}
+	q.Enqueue(&models.Message{ID: "invite-1", ProviderID: "ws-b", From: "team@b.com", Status: models.StatusQueued, QueuedAt: time.Now()})
+	q.Enqueue(&models.Message{ID: "invite-2", ProviderID: "ws-b", From: "team@b.com", Status: models.StatusQueued, QueuedAt: time.Now()})
+
+	batch, _ := q.Dequeue(4)
+	workspaces := map[string]int{}
+	for _, msg := range batch {
+		workspaces[msg.ProviderID]++
+	}
+	if workspaces["ws-a"] != 2 || workspaces["ws-b"] != 2 {
+		t.Errorf("batch took %v, want 2 messages from each workspace", workspaces)
+	}
+
+	// A rate limited workspace is left queued instead of filling the batch
+	q.SetFairShare(FairShare{Throttled: func(workspaceID, sender string) bool { return workspaceID == "ws-a" }})
+	if batch, _ := q.Dequeue(4); len(batch) != 0 {
+		t.Errorf("dequeued %d messages from a rate limited workspace", len(batch))
+	}
+}
+
+func TestMemoryQueueDeferParksMessageUntilReset(t *testing.T) {
+	q := NewMemoryQueue()
+	q.Enqueue(&models.Message{ID: "msg-1", Status: models.StatusQueued, QueuedAt: time.Now()})
+	q.Dequeue(1)
+
+	reset := time.Now().Add(time.Hour)
+	if first, err := q.Defer("msg-1", reset, fmt.Errorf("rate limit exceeded")); err != nil || !first {
+		t.Fatalf("first Defer = %v, %v; want true", first, err)
+	}
+	if batch, _ := q.Dequeue(1); len(batch) != 0 {
+		t.Fatal("deferred message was dequeued before its limit reset")
+	}
+	if first, _ := q.Defer("msg-1", reset, nil); first {
+		t.Error("second Defer reported a first deferral")
+	}
+
+	msg, _ := q.Get("msg-1")
+	if msg.Status != models.StatusQueued || msg.RetryCount != 0 {
+		t.Errorf("deferred message = %s with %d attempts, want queued with none", msg.Status, msg.RetryCount)
+	}
+
+	past := time.Now().Add(-time.Second)
+	q.messages["msg-1"].NextAttemptAt = &past
+	if batch, _ := q.Dequeue(1); len(batch) != 1 {
+		t.Error("deferred message was not dequeued once its limit reset")
+	}
+}
+
+func TestMemoryQueueDequeueSkipsPausedWorkspacesAndSenders(t *testing.T) {
+	q := NewMemoryQueue()
+	q.Enqueue(&models.Message{ID: "a-1", ProviderID: "ws-a", From: "news@a.com", Status: models.StatusQueued, QueuedAt: time.Now()})
+	q.Enqueue(&models.Message{ID: "b-news", ProviderID: "ws-b", From: "news@b.com", Status: models.StatusQueued, QueuedAt: time.Now()})
+	q.Enqueue(&models.Message{ID: "b-team", ProviderID: "ws-b", From: "team@b.com", Status: models.StatusQueued, QueuedAt: time.Now()})
+
+	q.Pause(Pause{Scope: PauseWorkspace, Key: "ws-a", PausedAt: time.Now()})
+	q.Pause(Pause{Scope: PauseSender, Key: "News@B.com", PausedAt: time.Now()})
+
+	batch, _ := q.Dequeue(10)
+	if len(batch) != 1 || batch[0].ID != "b-team" {
+		t.Fatalf("dequeued %v, want only b-team", batch)
+	}
+
+	q.Resume(PauseWorkspace, "ws-a")
+	if batch, _ := q.Dequeue(10); len(batch) != 1 || batch[0].ID != "a-1" {
+		t.Errorf("dequeued %v after resuming ws-a, want a-1", batch)
+	}
+}
+
+func TestMemoryQueueHandsOutCopies(t *testing.T) {
+	q := NewMemoryQueue()
+	q.Enqueue(&models.Message{ID: "msg-1", ProviderID: "ws", Headers: map[string]string{"X-Campaign": "spring"}, Status: models.StatusQueued, QueuedAt: time.Now()})
+
+	batch, _ := q.Dequeue(1)
+	batch[0].Headers["Message-ID"] = "<msg-1@example.com>"
+	batch[0].HTML = "<p>Hello Ada</p>"
+	if err := q.MarkSending("msg-1", "gmail-ws"); err != nil {
+		t.Fatalf("MarkSending: %v", err)
+	}
+
+	if batch[0].Status != models.StatusProcessing || batch[0].SentVia != "" {
+		t.Errorf("MarkSending changed the dequeued copy: %s via %q", batch[0].Status, batch[0].SentVia)
+	}
+	stored, _ := q.Get("msg-1")
+	if len(stored.Headers) != 1 || stored.HTML != "" || stored.ProviderID != "ws" || stored.SentVia != "gmail-ws" {
+		t.Errorf("stored message = headers %v, html %q, workspace %q via %q", stored.Headers, stored.HTML, stored.ProviderID, stored.SentVia)
+	}
+}
@@ -1,22 +1,36 @@
 package queue
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"relay/internal/blobstore"
 	"relay/internal/config"
+	"relay/internal/database"
 	"relay/pkg/models"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// MySQLQueue keeps the queue in the messages table. Despite the name it also
+// runs on PostgreSQL (see NewSQLQueue); the statements that differ between the
+// two are built with the database dialect.
 type MySQLQueue struct {
-	db *sql.DB
+	db            *sql.DB
+	dialect       database.Dialect
+	workerID      string
+	leaseDuration time.Duration
+	lanes         *laneScheduler
+	fair          *fairShare
+	blobs         attachmentBlobs // Attachment content goes here when a store is set, leaving references in the row
+	keysPrunedAt  atomic.Int64    // Unix time expired idempotency keys were last deleted
 }
 
 func NewMySQLQueue(cfg *config.MySQLConfig) (*MySQLQueue, error) {
@@ -28,6 +42,13 @@ func NewMySQLQueue(cfg *config.MySQLConfig) (*MySQLQueue, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
+	return NewSQLQueue(db)
+}
+
+// NewSQLQueue runs the queue on an already opened database, such as the
+// PostgreSQL one returned by database.Open. Dequeue relies on FOR UPDATE SKIP
+// LOCKED, which needs MySQL 8.0+ or PostgreSQL 9.5+.
+func NewSQLQueue(db *sql.DB) (*MySQLQueue, error) {
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
@@ -36,10 +57,138 @@ func NewMySQLQueue(cfg *config.MySQLConfig) (*MySQLQueue, error) {
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	return &MySQLQueue{db: db}, nil
+	return &MySQLQueue{db: db, dialect: database.For(db), workerID: DefaultWorkerID(), leaseDuration: DefaultLeaseDuration, lanes: newLaneScheduler(), fair: newFairShare()}, nil
+}
+
+// messageColumns lists the columns read by scanMessage, in scan order
+const messageColumns = `id, from_email, to_emails, cc_emails, bcc_emails,
+			subject, html_body, text_body, headers, attachments, raw_message,
+			metadata, invitation_id, email_type, invitation_dispatch_id, provider_id, status, priority, queued_at, send_at, processed_at, error,
+			retry_count, next_attempt_at, deferred_at, provider_override, locked_by, lease_expires_at,
+			archived_at, restored_at, provider_message_id, expires_at, sent_via`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMessage reads a full message row selected with messageColumns
+func scanMessage(row rowScanner) (*models.Message, error) {
+	msg := &models.Message{}
+	var toEmails, ccEmails, bccEmails, headers, attachments, metadata string
+	var sendAt, processedAt, nextAttemptAt, deferredAt, leaseExpiresAt, archivedAt, restoredAt, expiresAt sql.NullTime
+	var providerID, sentVia, errorMsg, providerOverride, lockedBy, providerMessageID sql.NullString
+
+	err := row.Scan(
+		&msg.ID,
+		&msg.From,
+		&toEmails,
+		&ccEmails,
+		&bccEmails,
+		&msg.Subject,
+		&msg.HTML,
+		&msg.Text,
+		&headers,
+		&attachments,
+		&msg.RawMessage,
+		&metadata,
+		&msg.InvitationID,
+		&msg.EmailType,
+		&msg.InvitationDispatchID,
+		&providerID,
+		&msg.Status,
+		&msg.Priority,
+		&msg.QueuedAt,
+		&sendAt,
+		&processedAt,
+		&errorMsg,
+		&msg.RetryCount,
+		&nextAttemptAt,
+		&deferredAt,
+		&providerOverride,
+		&lockedBy,
+		&leaseExpiresAt,
+		&archivedAt,
+		&restoredAt,
+		&providerMessageID,
+		&expiresAt,
+		&sentVia,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	json.Unmarshal([]byte(toEmails), &msg.To)
+	json.Unmarshal([]byte(ccEmails), &msg.CC)
+	json.Unmarshal([]byte(bccEmails), &msg.BCC)
+	json.Unmarshal([]byte(headers), &msg.Headers)
+	json.Unmarshal([]byte(attachments), &msg.Attachments)
+	json.Unmarshal([]byte(metadata), &msg.Metadata)
+
+	msg.ProviderID = providerID.String
+	msg.SentVia = sentVia.String
+	if sendAt.Valid {
+		msg.SendAt = &sendAt.Time
+	}
+	if processedAt.Valid {
+		msg.ProcessedAt = &processedAt.Time
+	}
+	if errorMsg.Valid {
+		msg.Error = errorMsg.String
+	}
+	if nextAttemptAt.Valid {
+		msg.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deferredAt.Valid {
+		msg.DeferredAt = &deferredAt.Time
+	}
+	if providerOverride.Valid {
+		msg.ProviderOverride = providerOverride.String
+	}
+	if lockedBy.Valid {
+		msg.LockedBy = lockedBy.String
+	}
+	if leaseExpiresAt.Valid {
+		msg.LeaseExpiresAt = &leaseExpiresAt.Time
+	}
+	if archivedAt.Valid {
+		msg.ArchivedAt = &archivedAt.Time
+	}
+	if restoredAt.Valid {
+		msg.RestoredAt = &restoredAt.Time
+	}
+	if providerMessageID.Valid {
+		msg.ProviderMessageID = providerMessageID.String
+	}
+	if expiresAt.Valid {
+		msg.ExpiresAt = &expiresAt.Time
+	}
+
+	return msg, nil
 }
 
 func (q *MySQLQueue) Enqueue(message *models.Message) error {
+	if q.blobs.store == nil {
+		return insertMessage(q.db, message)
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := q.storeBlobs(tx, message.Attachments); err != nil {
+		return err
+	}
+	if err := insertMessage(tx, message); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertMessage inserts a new message row through the database or a transaction
+func insertMessage(db database.Inserter, message *models.Message) error {
 	toEmails, _ := json.Marshal(message.To)
 	ccEmails, _ := json.Marshal(message.CC)
 	bccEmails, _ := json.Marshal(message.BCC)
@@ -50,12 +199,23 @@ func (q *MySQLQueue) Enqueue(message *models.Message) error {
 	query := `
 		INSERT INTO messages (
 			id, from_email, to_emails, cc_emails, bcc_emails, 
-			subject, html_body, text_body, headers, attachments, 
-			metadata, invitation_id, email_type, invitation_dispatch_id, provider_id, status, queued_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			subject, html_body, text_body, headers, attachments, raw_message,
+			metadata, invitation_id, email_type, invitation_dispatch_id, provider_id, status, priority, queued_at, send_at,
+			expires_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := q.db.Exec(query,
+	var sendAt, expiresAt sql.NullTime
+	if message.SendAt != nil {
+		sendAt.Valid = true
+		sendAt.Time = *message.SendAt
+	}
+	if message.ExpiresAt != nil {
+		expiresAt.Valid = true
+		expiresAt.Time = *message.ExpiresAt
+	}
+
+	_, err := db.Exec(query,
 		message.ID,
 		message.From,
 		string(toEmails),
@@ -66,257 +226,1475 @@ func (q *MySQLQueue) Enqueue(message *models.Message) error {
 		message.Text,
 		string(headers),
 		string(attachments),
+		message.RawMessage,
 		string(metadata),
 		message.InvitationID,
 		message.EmailType,
 		message.InvitationDispatchID,
 		message.ProviderID,
 		message.Status,
+		message.Priority.Lane(),
 		message.QueuedAt,
+		sendAt,
+		expiresAt,
 	)
 
-	return err
+	return err
+}
+
+// EnqueueOnce claims key in idempotency_keys and inserts the message in the
+// same transaction. A concurrent claim of the same key waits on the first one
+// and then finds the key taken.
+func (q *MySQLQueue) EnqueueOnce(message *models.Message, key string, window time.Duration) (id string, duplicate bool, err error) {
+	// A retry is answered from its key alone, without storing its attachments
+	if id, held, err := q.keyHolder(key, time.Now()); err != nil || held {
+		return id, held, err
+	}
+
+	return q.insertOnce(message, key, window)
+}
+
+// keyHolder returns the message holding an unexpired idempotency key
+func (q *MySQLQueue) keyHolder(key string, now time.Time) (string, bool, error) {
+	var id string
+	err := q.db.QueryRow("SELECT message_id FROM idempotency_keys WHERE idempotency_key = ? AND expires_at > ?", key, now).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+	return id, true, nil
+}
+
+// insertOnce claims key and inserts the message unless the key is taken
+func (q *MySQLQueue) insertOnce(message *models.Message, key string, window time.Duration) (string, bool, error) {
+	now := time.Now()
+	q.pruneKeys(now)
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM idempotency_keys WHERE idempotency_key = ? AND expires_at <= ?", key, now); err != nil {
+		return "", false, fmt.Errorf("failed to expire idempotency key: %w", err)
+	}
+	result, err := tx.Exec(`
+		INSERT INTO idempotency_keys (idempotency_key, message_id, expires_at)
+		VALUES (?, ?, ?)
+		`+q.dialect.OnConflictIgnore("idempotency_key"), key, message.ID, now.Add(window))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if claimed, _ := result.RowsAffected(); claimed == 0 {
+		var id string
+		if err := tx.QueryRow("SELECT message_id FROM idempotency_keys WHERE idempotency_key = ?", key).Scan(&id); err != nil {
+			return "", false, fmt.Errorf("failed to read idempotency key: %w", err)
+		}
+		return id, true, nil
+	}
+
+	// Attachments are stored only once the key is ours
+	if err := q.storeBlobs(tx, message.Attachments); err != nil {
+		return "", false, err
+	}
+	if err := insertMessage(tx, message); err != nil {
+		return "", false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", false, fmt.Errorf("failed to commit message: %w", err)
+	}
+	return message.ID, false, nil
+}
+
+// pruneKeys deletes expired idempotency keys, at most once a minute
+func (q *MySQLQueue) pruneKeys(now time.Time) {
+	last := q.keysPrunedAt.Load()
+	if now.Unix()-last < 60 || !q.keysPrunedAt.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+	if _, err := q.db.Exec("DELETE FROM idempotency_keys WHERE expires_at <= ?", now); err != nil {
+		log.Printf("Warning: Failed to delete expired idempotency keys: %v", err)
+	}
+}
+
+// Dequeue claims up to batchSize due messages for this worker, split across the
+// priority lanes by weight. SKIP LOCKED lets replicas sharing the database claim
+// disjoint batches instead of waiting on each other.
+func (q *MySQLQueue) Dequeue(batchSize int) ([]*models.Message, error) {
+	now := time.Now()
+	pauses, err := q.ListPauses()
+	if err != nil {
+		// Without the table no pause can have been stored
+		log.Printf("Warning: Dequeueing without pauses: %v", err)
+	}
+	paused := NewPauseSet(pauses, now)
+	if paused.Global() != nil {
+		return nil, nil
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	messages, err := q.lanes.dequeue(batchSize, func(lane models.MessagePriority, limit int, taken []string) ([]*models.Message, error) {
+		return q.dequeueLane(tx, lane, limit, taken, now, paused)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+
+	if len(ids) > 0 {
+		// Validate IDs to ensure they are valid UUIDs (defense in depth)
+		for _, id := range ids {
+			if len(id) != 36 || !isValidUUID(id) {
+				return nil, fmt.Errorf("invalid message ID format: %s", id)
+			}
+		}
+		
+		// Build parameterized query safely
+		placeholders := make([]string, len(ids))
+		args := make([]interface{}, len(ids))
+		for i, id := range ids {
+			placeholders[i] = "?"
+			args[i] = id
+		}
+		
+		updateQuery := fmt.Sprintf(
+			"UPDATE messages SET status = 'processing', locked_by = ?, lease_expires_at = ? WHERE id IN (%s)",
+			strings.Join(placeholders, ","),
+		)
+
+		leaseExpiresAt := now.Add(q.leaseDuration)
+		_, err = tx.Exec(updateQuery, append([]interface{}{q.workerID, leaseExpiresAt}, args...)...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update message status: %w", err)
+		}
+
+		for _, msg := range messages {
+			msg.LockedBy = q.workerID
+			msg.LeaseExpiresAt = &leaseExpiresAt
+		}
+	}
+
+	return messages, tx.Commit()
+}
+
+// dueInLane is the condition for messages Dequeue may claim from one lane
+const dueInLane = `((status = 'queued' AND (send_at IS NULL OR send_at <= ?) AND (next_attempt_at IS NULL OR next_attempt_at <= ?))
+		   OR (status = 'failed' AND next_attempt_at <= ?))
+		  AND priority = ?`
+
+// dequeueLane selects and locks the oldest due messages in one priority lane,
+// shared fairly between workspaces and skipping rows already claimed by this
+// transaction and messages of paused workspaces and senders
+func (q *MySQLQueue) dequeueLane(tx *sql.Tx, lane models.MessagePriority, limit int, taken []string, now time.Time, paused *PauseSet) ([]*models.Message, error) {
+	var exclude string
+	var excludeArgs []interface{}
+	if len(taken) > 0 {
+		placeholders, takenArgs := inClause(taken)
+		exclude = " AND id NOT IN (" + placeholders + ")"
+		excludeArgs = takenArgs
+	}
+
+	// Count what each sender of each workspace has due, without locking, to plan
+	// the shares. Rows without a workspace, such as routing failures retried
+	// before migration 034, are shared as the workspace "".
+	rows, err := tx.Query(`
+		SELECT provider_id, from_email, COUNT(*)
+		FROM messages
+		WHERE `+dueInLane+exclude+`
+		GROUP BY provider_id, from_email`,
+		append([]interface{}{now, now, now, lane}, excludeArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	var due []dueGroup
+	for rows.Next() {
+		var group dueGroup
+		var workspaceID sql.NullString
+		if err := rows.Scan(&workspaceID, &group.Sender, &group.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		group.WorkspaceID = workspaceID.String
+		due = append(due, group)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var messages []*models.Message
+	for _, fetch := range q.fair.plan(limit, due, paused) {
+		query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE ` + dueInLane + exclude
+		args := append([]interface{}{now, now, now, lane}, excludeArgs...)
+		if fetch.WorkspaceID == "" {
+			query += `
+		  AND (provider_id IS NULL OR provider_id = '')`
+		} else {
+			query += `
+		  AND provider_id = ?`
+			args = append(args, fetch.WorkspaceID)
+		}
+
+		if fetch.Sender != "" {
+			query += " AND from_email = ?"
+			args = append(args, fetch.Sender)
+		} else if len(fetch.Excluded) > 0 {
+			placeholders, senderArgs := inClause(fetch.Excluded)
+			query += " AND from_email NOT IN (" + placeholders + ")"
+			args = append(args, senderArgs...)
+		}
+
+		query += `
+		ORDER BY queued_at ASC
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED`
+		args = append(args, fetch.Limit)
+
+		claimed, err := queryMessages(tx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, claimed...)
+	}
+	return messages, nil
+}
+
+// queryMessages runs a query selecting messageColumns
+func queryMessages(tx *sql.Tx, query string, args ...interface{}) ([]*models.Message, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// SetLaneWeights sets each priority lane's share of a dequeue batch
+func (q *MySQLQueue) SetLaneWeights(weights map[models.MessagePriority]int) {
+	q.lanes.setWeights(weights)
+}
+
+// SetFairShare sets how dequeue batches are shared between workspaces
+func (q *MySQLQueue) SetFairShare(share FairShare) {
+	q.fair.set(share)
+}
+
+// SetAttachmentStore moves the attachment content of enqueued messages into store
+func (q *MySQLQueue) SetAttachmentStore(store blobstore.Store) {
+	q.blobs.store = store
+}
+
+// storeBlobs counts the references the attachments take and then writes their
+// content to the store, inside the transaction that saves the message. A
+// collector deleting one of the blobs holds its row, so the count waits for it
+// and the content is written again afterwards.
+func (q *MySQLQueue) storeBlobs(tx *sql.Tx, attachments []models.Attachment) error {
+	if q.blobs.store == nil {
+		return nil
+	}
+	keys, counts := countKeys(storedKeys(attachments))
+	for _, key := range keys {
+		_, err := tx.Exec(`
+			INSERT INTO attachment_blobs (blob_key, refcount) VALUES (?, ?)
+			`+q.dialect.Upsert([]string{"blob_key"}, "released_at")+`,
+			refcount = attachment_blobs.refcount + `+q.dialect.Excluded("refcount"), key, counts[key])
+		if err != nil {
+			return fmt.Errorf("failed to count attachment references: %w", err)
+		}
+	}
+	return blobstore.Externalize(context.Background(), q.blobs.store, attachments)
+}
+
+// releaseBlobs gives up one reference to a blob for each time its key appears.
+// A blob nothing references any more is left for CollectBlobs, which deletes it
+// once the grace period has passed.
+func (q *MySQLQueue) releaseBlobs(tx *sql.Tx, keys []string) error {
+	if q.blobs.store == nil {
+		return nil
+	}
+	distinct, counts := countKeys(keys)
+	now := time.Now()
+	for _, key := range distinct {
+		// released_at is set from the count before this update
+		_, err := tx.Exec(`
+			UPDATE attachment_blobs
+			SET released_at = CASE WHEN refcount <= ? THEN ? ELSE released_at END, refcount = refcount - ?
+			WHERE blob_key = ?
+		`, counts[key], now, counts[key], key)
+		if err != nil {
+			return fmt.Errorf("failed to release attachment references: %w", err)
+		}
+	}
+	return nil
+}
+
+// execReleasing runs statement, which deletes the messages matching where or
+// strips their attachments, and gives up their blob references in the same
+// transaction. The rows are locked first and changed by ID, so the references
+// released are exactly those of the rows changed.
+func (q *MySQLQueue) execReleasing(statement, where string, args ...interface{}) (int, error) {
+	if q.blobs.store == nil {
+		result, err := q.db.Exec(statement+" WHERE "+where, args...)
+		if err != nil {
+			return 0, err
+		}
+		rows, _ := result.RowsAffected()
+		return int(rows), nil
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	ids, keys, err := lockBlobs(tx, where, args...)
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	for start := 0; start < len(ids); start += 500 {
+		placeholders, idArgs := inClause(ids[start:min(start+500, len(ids))])
+		result, err := tx.Exec(statement+" WHERE id IN ("+placeholders+")", idArgs...)
+		if err != nil {
+			return 0, err
+		}
+		rows, _ := result.RowsAffected()
+		changed += int(rows)
+	}
+	if err := q.releaseBlobs(tx, keys); err != nil {
+		return 0, err
+	}
+	return changed, tx.Commit()
+}
+
+// lockBlobs locks the messages matching where and returns their IDs and the
+// blob keys their attachments reference
+func lockBlobs(tx *sql.Tx, where string, args ...interface{}) ([]string, []string, error) {
+	rows, err := tx.Query("SELECT id, attachments FROM messages WHERE "+where+" FOR UPDATE", args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read attachment references: %w", err)
+	}
+	defer rows.Close()
+
+	var ids, keys []string
+	for rows.Next() {
+		var id string
+		var attachments sql.NullString
+		if err := rows.Scan(&id, &attachments); err != nil {
+			return nil, nil, fmt.Errorf("failed to read attachment references: %w", err)
+		}
+		ids = append(ids, id)
+		if attachments.Valid {
+			var parsed []models.Attachment
+			json.Unmarshal([]byte(attachments.String), &parsed)
+			keys = blobKeys(keys, parsed)
+		}
+	}
+	return ids, keys, rows.Err()
+}
+
+// CollectBlobs deletes up to limit blobs no message has referenced since
+// releasedBefore. Their rows stay locked until the blobs are gone, so a message
+// taking a new reference waits; rows another replica is collecting are skipped.
+// A blob that cannot be deleted keeps its row and is tried again next time.
+func (q *MySQLQueue) CollectBlobs(releasedBefore time.Time, limit int) (int, error) {
+	if q.blobs.store == nil {
+		return 0, nil
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT blob_key FROM attachment_blobs
+		WHERE refcount <= 0 AND released_at < ?
+		ORDER BY released_at
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED
+	`, releasedBefore, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query released blobs: %w", err)
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan released blob: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query released blobs: %w", err)
+	}
+
+	var deleted []string
+	for _, key := range keys {
+		if err := q.blobs.store.Delete(context.Background(), key); err != nil {
+			log.Printf("Warning: Failed to delete attachment blob %s: %v", key, err)
+			continue
+		}
+		deleted = append(deleted, key)
+	}
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+
+	placeholders, args := inClause(deleted)
+	if _, err := tx.Exec("DELETE FROM attachment_blobs WHERE blob_key IN ("+placeholders+")", args...); err != nil {
+		return 0, fmt.Errorf("failed to delete collected blobs: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit collected blobs: %w", err)
+	}
+	return len(deleted), nil
+}
+
+func (q *MySQLQueue) UpdateStatus(id string, status models.MessageStatus, err error) error {
+	query := `
+		UPDATE messages 
+		SET status = ?, processed_at = ?, error = ?, retry_count = retry_count + ?, next_attempt_at = NULL,
+		    locked_by = NULL, lease_expires_at = NULL
+		WHERE id = ? AND status IN ('processing', 'sending') AND locked_by = ?
+	`
+
+	var errorMsg sql.NullString
+	if err != nil {
+		errorMsg.Valid = true
+		errorMsg.String = err.Error()
+	}
+
+	result, dbErr := q.db.Exec(query, status, time.Now(), errorMsg, attemptIncrement(status), id, q.workerID)
+	if dbErr != nil {
+		return dbErr
+	}
+	return leaseHeld(result)
+}
+
+// ScheduleRetry marks a failed attempt and makes the message eligible for Dequeue
+// again at nextAttemptAt. providerID is recorded in sent_via; provider_id keeps
+// the workspace.
+func (q *MySQLQueue) ScheduleRetry(id string, providerID string, nextAttemptAt time.Time, err error) error {
+	query := `
+		UPDATE messages
+		SET status = 'failed', processed_at = ?, sent_via = COALESCE(?, sent_via), error = ?,
+		    retry_count = retry_count + 1, next_attempt_at = ?, locked_by = NULL, lease_expires_at = NULL
+		WHERE id = ? AND status IN ('processing', 'sending') AND locked_by = ?
+	`
+
+	var errorMsg sql.NullString
+	if err != nil {
+		errorMsg.Valid = true
+		errorMsg.String = err.Error()
+	}
+
+	var provider sql.NullString
+	if providerID != "" {
+		provider.Valid = true
+		provider.String = providerID
+	}
+
+	result, dbErr := q.db.Exec(query, time.Now(), provider, errorMsg, nextAttemptAt, id, q.workerID)
+	if dbErr != nil {
+		return fmt.Errorf("failed to schedule retry: %w", dbErr)
+	}
+	return leaseHeld(result)
+}
+
+// Defer parks a message in the queue until the given time. deferred_at keeps the
+// time of the first deferral, so callers can notify only once per message.
+func (q *MySQLQueue) Defer(id string, until time.Time, reason error) (bool, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		UPDATE messages SET deferred_at = ?
+		WHERE id = ? AND deferred_at IS NULL AND status IN ('processing', 'sending') AND locked_by = ?
+	`, now, id, q.workerID)
+	if err != nil {
+		return false, fmt.Errorf("failed to defer message: %w", err)
+	}
+	first, _ := result.RowsAffected()
+
+	if err := parkRow(tx, id, q.workerID, until, reason); err != nil {
+		if err == ErrLeaseLost {
+			return false, err
+		}
+		return false, fmt.Errorf("failed to defer message: %w", err)
+	}
+
+	return first > 0, tx.Commit()
+}
+
+// Hold parks a paused message until the given time, leaving deferred_at alone
+func (q *MySQLQueue) Hold(id string, until time.Time, reason error) error {
+	if err := parkRow(q.db, id, q.workerID, until, reason); err != nil {
+		if err == ErrLeaseLost {
+			return err
+		}
+		return fmt.Errorf("failed to hold message: %w", err)
+	}
+	return nil
+}
+
+// parkRow puts a message workerID holds back in the queue, due at until, through
+// the database or a transaction
+func parkRow(db database.Inserter, id, workerID string, until time.Time, reason error) error {
+	var errorMsg sql.NullString
+	if reason != nil {
+		errorMsg = nullString(reason.Error())
+	}
+	result, err := db.Exec(`
+		UPDATE messages
+		SET status = 'queued', next_attempt_at = ?, error = ?, locked_by = NULL, lease_expires_at = NULL
+		WHERE id = ? AND status IN ('processing', 'sending') AND locked_by = ?
+	`, until, errorMsg, id, workerID)
+	if err != nil {
+		return err
+	}
+	return leaseHeld(result)
+}
+
+// leaseHeld returns ErrLeaseLost when an update guarded by this worker's lease
+// matched no row: the lease was reaped and the message may belong to another worker
+func leaseHeld(result sql.Result) error {
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// attemptIncrement returns how much a status update adds to retry_count.
+// Putting a message back in the queue is a deferral and expiring it is not a
+// send attempt either.
+func attemptIncrement(status models.MessageStatus) int {
+	if status == models.StatusQueued || status == models.StatusExpired {
+		return 0
+	}
+	return 1
+}
+
+func (q *MySQLQueue) UpdateStatusWithProvider(id string, status models.MessageStatus, providerID string, err error) error {
+	log.Printf("DEBUG: UpdateStatusWithProvider called - id=%s, status=%s, provider=%s, hasError=%v", id, status, providerID, err != nil)
+	
+	// For sent messages, also update sent_at
+	query := `
+		UPDATE messages 
+		SET status = ?, processed_at = ?, sent_at = ?, sent_via = COALESCE(?, sent_via), error = ?,
+		    retry_count = retry_count + ?, next_attempt_at = NULL, locked_by = NULL, lease_expires_at = NULL
+		WHERE id = ? AND status IN ('processing', 'sending') AND locked_by = ?
+	`
+
+	var errorMsg sql.NullString
+	if err != nil {
+		errorMsg.Valid = true
+		errorMsg.String = err.Error()
+	}
+
+	var provider sql.NullString
+	if providerID != "" {
+		provider.Valid = true
+		provider.String = providerID
+		log.Printf("DEBUG: Setting sent_via to '%s'", providerID)
+	} else {
+		log.Printf("DEBUG: Provider ID is empty, keeping sent_via")
+	}
+
+	now := time.Now()
+	var sentAt sql.NullTime
+	if status == models.StatusSent {
+		sentAt.Valid = true
+		sentAt.Time = now
+	}
+
+	log.Printf("DEBUG: Executing SQL with params - status=%s, processed_at=%v, sent_at=%v, provider=%v, error=%v, id=%s", 
+		status, now, sentAt, provider, errorMsg, id)
+
+	result, dbErr := q.db.Exec(query, status, now, sentAt, provider, errorMsg, attemptIncrement(status), id, q.workerID)
+	if dbErr != nil {
+		log.Printf("ERROR: UpdateStatusWithProvider failed - %v", dbErr)
+		return dbErr
+	}
+	
+	rows, _ := result.RowsAffected()
+	log.Printf("DEBUG: UpdateStatusWithProvider updated %d rows for message %s", rows, id)
+	if rows == 0 {
+		return ErrLeaseLost
+	}
+	
+	// Verify the update
+	var checkProvider sql.NullString
+	checkErr := q.db.QueryRow("SELECT sent_via FROM messages WHERE id = ?", id).Scan(&checkProvider)
+	if checkErr == nil {
+		log.Printf("DEBUG: After update, sent_via in DB is: %v (valid=%v)", checkProvider.String, checkProvider.Valid)
+	}
+	
+	return nil
+}
+
+// SetLeaseDuration sets how long Dequeue and RenewLease hold a message
+func (q *MySQLQueue) SetLeaseDuration(d time.Duration) {
+	if d > 0 {
+		q.leaseDuration = d
+	}
+}
+
+// SetWorkerID sets the locked_by value for messages this worker dequeues
+func (q *MySQLQueue) SetWorkerID(id string) {
+	if id != "" {
+		q.workerID = id
+	}
+}
+
+// RenewLease extends this worker's lease on a processing or sending message
+func (q *MySQLQueue) RenewLease(id string) error {
+	result, err := q.db.Exec(`
+		UPDATE messages
+		SET lease_expires_at = ?
+		WHERE id = ? AND status IN ('processing', 'sending') AND locked_by = ?
+	`, time.Now().Add(q.leaseDuration), id, q.workerID)
+	if err != nil {
+		return fmt.Errorf("failed to renew lease: %w", err)
+	}
+	return leaseHeld(result)
+}
+
+// ReapExpiredLeases returns processing messages whose lease has expired to the queue.
+// The interrupted attempt is not counted against the retry budget.
+func (q *MySQLQueue) ReapExpiredLeases() (int, error) {
+	result, err := q.db.Exec(`
+		UPDATE messages
+		SET status = 'queued', locked_by = NULL, lease_expires_at = NULL
+		WHERE status = 'processing' AND lease_expires_at < ?
+	`, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to reap expired leases: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	return int(rows), nil
+}
+
+// MarkSending records that this worker is handing the message to providerID.
+// The row is committed before the provider is called.
+func (q *MySQLQueue) MarkSending(id, providerID string) error {
+	result, err := q.db.Exec(`
+		UPDATE messages
+		SET status = 'sending', sent_via = ?
+		WHERE id = ? AND status IN ('processing', 'sending') AND locked_by = ?
+	`, providerID, id, q.workerID)
+	if err != nil {
+		return fmt.Errorf("failed to mark message sending: %w", err)
+	}
+	return leaseHeld(result)
+}
+
+// MarkSent records the message as sent together with the provider's message ID
+// in a single update
+func (q *MySQLQueue) MarkSent(id, providerID, providerMessageID string) error {
+	now := time.Now()
+	result, err := q.db.Exec(`
+		UPDATE messages
+		SET status = 'sent', processed_at = ?, sent_at = ?, sent_via = COALESCE(?, sent_via),
+		    provider_message_id = ?, retry_count = retry_count + 1, next_attempt_at = NULL,
+		    locked_by = NULL, lease_expires_at = NULL
+		WHERE id = ? AND status IN ('processing', 'sending') AND locked_by = ?
+	`, now, now, nullString(providerID), nullString(providerMessageID), id, q.workerID)
+	if err != nil {
+		return fmt.Errorf("failed to mark message sent: %w", err)
+	}
+	return leaseHeld(result)
+}
+
+// ClaimUnconfirmedSends leases sending messages whose lease expired to this
+// worker. Rows another replica is claiming are skipped, as in Dequeue.
+func (q *MySQLQueue) ClaimUnconfirmedSends(limit int) ([]*models.Message, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rows, err := tx.Query(fmt.Sprintf(`
+		SELECT %s FROM messages
+		WHERE status = 'sending' AND (lease_expires_at IS NULL OR lease_expires_at < ?)
+		ORDER BY queued_at
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED
+	`, messageColumns), now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unconfirmed sends: %w", err)
+	}
+
+	var messages []*models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read unconfirmed sends: %w", err)
+	}
+
+	leaseExpiresAt := now.Add(q.leaseDuration)
+	for _, msg := range messages {
+		if _, err := tx.Exec("UPDATE messages SET locked_by = ?, lease_expires_at = ? WHERE id = ?", q.workerID, leaseExpiresAt, msg.ID); err != nil {
+			return nil, fmt.Errorf("failed to claim message %s: %w", msg.ID, err)
+		}
+		msg.LockedBy = q.workerID
+		msg.LeaseExpiresAt = &leaseExpiresAt
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return messages, nil
+}
+
+// ExpireDue moves up to limit queued or failed messages whose expires_at has
+// passed to expired. Rows another replica is dequeueing are skipped, as in Dequeue.
+func (q *MySQLQueue) ExpireDue(limit int) ([]*models.Message, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rows, err := tx.Query(fmt.Sprintf(`
+		SELECT %s FROM messages
+		WHERE status IN ('queued', 'failed') AND expires_at IS NOT NULL AND expires_at <= ?
+		ORDER BY expires_at
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED
+	`, messageColumns), now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired messages: %w", err)
+	}
+
+	var messages []*models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read expired messages: %w", err)
+	}
+
+	for _, msg := range messages {
+		expire(msg, now)
+		_, err := tx.Exec(`
+			UPDATE messages
+			SET status = 'expired', processed_at = ?, error = ?, next_attempt_at = NULL, locked_by = NULL, lease_expires_at = NULL
+			WHERE id = ?
+		`, now, msg.Error, msg.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expire message %s: %w", msg.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return messages, nil
+}
+
+// Pause adds p, replacing the pause with the same scope and key
+func (q *MySQLQueue) Pause(p Pause) error {
+	if err := p.Normalize(); err != nil {
+		return err
+	}
+	_, err := q.db.Exec(`
+		INSERT INTO queue_pauses (scope, pause_key, reason, paused_by, paused_at, resume_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		`+q.dialect.Upsert([]string{"scope", "pause_key"}, "reason", "paused_by", "paused_at", "resume_at")+`
+	`, string(p.Scope), p.Key, nullString(p.Reason), nullString(p.PausedBy), p.PausedAt, p.ResumeAt)
+	if err != nil {
+		return fmt.Errorf("failed to store pause: %w", err)
+	}
+	return nil
+}
+
+// Resume removes the pause with scope and key and reports whether there was one
+func (q *MySQLQueue) Resume(scope PauseScope, key string) (bool, error) {
+	if scope == PauseSender {
+		key = strings.ToLower(key)
+	}
+	result, err := q.db.Exec("DELETE FROM queue_pauses WHERE scope = ? AND pause_key = ?", string(scope), key)
+	if err != nil {
+		return false, fmt.Errorf("failed to resume: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// ListPauses returns the pauses in effect, oldest first, deleting those whose resume_at has passed
+func (q *MySQLQueue) ListPauses() ([]Pause, error) {
+	now := time.Now()
+	if _, err := q.db.Exec("DELETE FROM queue_pauses WHERE resume_at <= ?", now); err != nil {
+		return nil, fmt.Errorf("failed to delete expired pauses: %w", err)
+	}
+
+	rows, err := q.db.Query(`
+		SELECT scope, pause_key, reason, paused_by, paused_at, resume_at
+		FROM queue_pauses
+		ORDER BY paused_at
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pauses: %w", err)
+	}
+	defer rows.Close()
+
+	pauses := []Pause{}
+	for rows.Next() {
+		var p Pause
+		var reason, pausedBy sql.NullString
+		var resumeAt sql.NullTime
+		if err := rows.Scan(&p.Scope, &p.Key, &reason, &pausedBy, &p.PausedAt, &resumeAt); err != nil {
+			return nil, fmt.Errorf("failed to scan pause: %w", err)
+		}
+		p.Reason = reason.String
+		p.PausedBy = pausedBy.String
+		if resumeAt.Valid {
+			p.ResumeAt = &resumeAt.Time
+		}
+		pauses = append(pauses, p)
+	}
+	return pauses, rows.Err()
+}
+
+// RegisterWorker adds this replica to the worker registry, replacing a previous
+// registration under the same ID (e.g. a restarted pod)
+func (q *MySQLQueue) RegisterWorker(info WorkerInfo) error {
+	_, err := q.db.Exec(`
+		INSERT INTO workers (id, hostname, pid, started_at, last_heartbeat_at, heartbeat_interval_seconds, stopped_at)
+		VALUES (?, ?, ?, ?, ?, ?, NULL)
+		`+q.dialect.Upsert([]string{"id"}, "hostname", "pid", "started_at", "last_heartbeat_at", "heartbeat_interval_seconds")+`,
+			stopped_at = NULL
+	`, info.ID, info.Hostname, info.PID, info.StartedAt, info.LastHeartbeatAt, info.HeartbeatIntervalSeconds)
+	if err != nil {
+		return fmt.Errorf("failed to register worker: %w", err)
+	}
+	return nil
+}
+
+func (q *MySQLQueue) Heartbeat(id string) error {
+	if _, err := q.db.Exec("UPDATE workers SET last_heartbeat_at = ? WHERE id = ?", time.Now(), id); err != nil {
+		return fmt.Errorf("failed to record heartbeat: %w", err)
+	}
+	return nil
+}
+
+func (q *MySQLQueue) DeregisterWorker(id string) error {
+	if _, err := q.db.Exec("UPDATE workers SET stopped_at = ? WHERE id = ?", time.Now(), id); err != nil {
+		return fmt.Errorf("failed to deregister worker: %w", err)
+	}
+	return nil
+}
+
+// ListWorkers returns running workers and those stopped in the last day, with the messages they hold
+func (q *MySQLQueue) ListWorkers() ([]WorkerInfo, error) {
+	rows, err := q.db.Query(`
+		SELECT id, hostname, pid, started_at, last_heartbeat_at, heartbeat_interval_seconds, stopped_at
+		FROM workers
+		WHERE stopped_at IS NULL OR stopped_at > ?
+		ORDER BY started_at DESC
+	`, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workers: %w", err)
+	}
+	defer rows.Close()
+
+	var workers []WorkerInfo
+	index := make(map[string]int)
+	for rows.Next() {
+		var info WorkerInfo
+		var stoppedAt sql.NullTime
+		if err := rows.Scan(&info.ID, &info.Hostname, &info.PID, &info.StartedAt, &info.LastHeartbeatAt,
+			&info.HeartbeatIntervalSeconds, &stoppedAt); err != nil {
+			return nil, err
+		}
+		if stoppedAt.Valid {
+			info.StoppedAt = &stoppedAt.Time
+		}
+		info.HeldMessages = []string{}
+		index[info.ID] = len(workers)
+		workers = append(workers, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	held, err := q.db.Query("SELECT id, locked_by FROM messages WHERE status IN ('processing', 'sending') AND locked_by IS NOT NULL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list held messages: %w", err)
+	}
+	defer held.Close()
+
+	for held.Next() {
+		var id, lockedBy string
+		if err := held.Scan(&id, &lockedBy); err != nil {
+			return nil, err
+		}
+		if i, ok := index[lockedBy]; ok {
+			workers[i].HeldMessages = append(workers[i].HeldMessages, id)
+		}
+	}
+
+	now := time.Now()
+	for i := range workers {
+		workers[i].Status = workerStatus(workers[i], now)
+	}
+
+	return workers, held.Err()
+}
+
+// RecordAttempt appends to the message's attempt history
+func (q *MySQLQueue) RecordAttempt(id string, attempt models.Attempt) error {
+	_, err := q.db.Exec(`
+		INSERT INTO message_attempts (message_id, attempt, provider_id, error_class, error, attempted_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, id, attempt.Number, nullString(attempt.ProviderID), nullString(attempt.ErrorClass),
+		nullString(attempt.Error), attempt.AttemptedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record attempt: %w", err)
+	}
+	return nil
+}
+
+func (q *MySQLQueue) Get(id string) (*models.Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE id = ?
+	`
+
+	return scanMessage(q.db.QueryRow(query, id))
+}
+
+func (q *MySQLQueue) Remove(id string) error {
+	_, err := q.execReleasing("DELETE FROM messages", "id = ?", id)
+	return err
+}
+
+// ListScheduled returns queued messages with a future send_at
+func (q *MySQLQueue) ListScheduled(to string, limit int) ([]*models.Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE status = 'queued' AND send_at > ?
+	`
+	args := []interface{}{time.Now()}
+
+	if to != "" {
+		query += " AND " + q.dialect.JSONContains("to_emails")
+		args = append(args, to)
+	}
+
+	query += " ORDER BY send_at ASC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := q.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// Reschedule moves a scheduled message to a new send_at
+func (q *MySQLQueue) Reschedule(id string, sendAt time.Time) error {
+	result, err := q.db.Exec(`
+		UPDATE messages
+		SET send_at = ?
+		WHERE id = ? AND status = 'queued' AND send_at > ?
+	`, sendAt, id, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to reschedule message: %w", err)
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotScheduled
+	}
+	return nil
 }
 
-func (q *MySQLQueue) Dequeue(batchSize int) ([]*models.Message, error) {
-	tx, err := q.db.Begin()
+// CancelScheduled removes a message that has not reached its send_at yet
+func (q *MySQLQueue) CancelScheduled(id string) error {
+	rows, err := q.execReleasing("DELETE FROM messages", "id = ? AND status = 'queued' AND send_at > ?", id, time.Now())
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to cancel scheduled message: %w", err)
 	}
-	defer tx.Rollback()
 
-	query := `
-		SELECT id, from_email, to_emails, cc_emails, bcc_emails,
-			subject, html_body, text_body, headers, attachments,
-			metadata, invitation_id, email_type, invitation_dispatch_id, provider_id, status, queued_at, processed_at, error, retry_count
-		FROM messages
-		WHERE status = 'queued' OR (status = 'failed' AND retry_count < 3)
-		ORDER BY queued_at ASC
-		LIMIT ?
-		FOR UPDATE
-	`
+	if rows == 0 {
+		return ErrNotScheduled
+	}
+	return nil
+}
 
-	rows, err := tx.Query(query, batchSize)
+// ListDead returns dead messages, most recently failed first
+func (q *MySQLQueue) ListDead(filter DeadLetterFilter) ([]*models.Message, int, error) {
+	where := " WHERE status = 'dead'"
+	var args []interface{}
+	if filter.ProviderID != "" {
+		where += " AND provider_id = ?"
+		args = append(args, filter.ProviderID)
+	}
+	if filter.From != "" {
+		where += " AND from_email = ?"
+		args = append(args, filter.From)
+	}
+
+	var total int
+	if err := q.db.QueryRow("SELECT COUNT(*) FROM messages"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count dead letters: %w", err)
+	}
+
+	query := "SELECT " + messageColumns + " FROM messages" + where + " ORDER BY processed_at DESC LIMIT ? OFFSET ?"
+	rows, err := q.db.Query(query, append(args, filter.Limit, filter.Offset)...)
 	if err != nil {
-		return nil, err
+		return nil, 0, fmt.Errorf("failed to list dead letters: %w", err)
 	}
 	defer rows.Close()
 
 	var messages []*models.Message
-	var ids []string
-
 	for rows.Next() {
-		msg := &models.Message{}
-		var toEmails, ccEmails, bccEmails, headers, attachments, metadata string
-		var processedAt sql.NullTime
-		var errorMsg sql.NullString
-		var retryCount int
-
-		err := rows.Scan(
-			&msg.ID,
-			&msg.From,
-			&toEmails,
-			&ccEmails,
-			&bccEmails,
-			&msg.Subject,
-			&msg.HTML,
-			&msg.Text,
-			&headers,
-			&attachments,
-			&metadata,
-			&msg.InvitationID,
-			&msg.EmailType,
-			&msg.InvitationDispatchID,
-			&msg.ProviderID,
-			&msg.Status,
-			&msg.QueuedAt,
-			&processedAt,
-			&errorMsg,
-			&retryCount,
-		)
+		msg, err := scanMessage(rows)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
+		messages = append(messages, msg)
+	}
 
-		json.Unmarshal([]byte(toEmails), &msg.To)
-		json.Unmarshal([]byte(ccEmails), &msg.CC)
-		json.Unmarshal([]byte(bccEmails), &msg.BCC)
-		json.Unmarshal([]byte(headers), &msg.Headers)
-		json.Unmarshal([]byte(attachments), &msg.Attachments)
-		json.Unmarshal([]byte(metadata), &msg.Metadata)
+	return messages, total, rows.Err()
+}
 
-		if processedAt.Valid {
-			msg.ProcessedAt = &processedAt.Time
-		}
-		if errorMsg.Valid {
-			msg.Error = errorMsg.String
+// GetAttempts returns a message's attempt history, oldest first
+func (q *MySQLQueue) GetAttempts(id string) ([]models.Attempt, error) {
+	rows, err := q.db.Query(`
+		SELECT attempt, provider_id, error_class, error, attempted_at
+		FROM message_attempts
+		WHERE message_id = ?
+		ORDER BY attempted_at ASC, id ASC
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []models.Attempt
+	for rows.Next() {
+		var attempt models.Attempt
+		var providerID, errorClass, errorMsg sql.NullString
+		if err := rows.Scan(&attempt.Number, &providerID, &errorClass, &errorMsg, &attempt.AttemptedAt); err != nil {
+			return nil, err
 		}
+		attempt.ProviderID = providerID.String
+		attempt.ErrorClass = errorClass.String
+		attempt.Error = errorMsg.String
+		attempts = append(attempts, attempt)
+	}
 
-		messages = append(messages, msg)
-		ids = append(ids, msg.ID)
+	return attempts, rows.Err()
+}
+
+// Replay moves dead messages back to queued with a fresh retry budget.
+// The attempt history is kept so a replayed message shows every attempt.
+func (q *MySQLQueue) Replay(ids []string, providerOverride string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
 	}
 
-	if len(ids) > 0 {
-		// Validate IDs to ensure they are valid UUIDs (defense in depth)
-		for _, id := range ids {
-			if len(id) != 36 || !isValidUUID(id) {
-				return nil, fmt.Errorf("invalid message ID format: %s", id)
-			}
-		}
-		
-		// Build parameterized query safely
-		placeholders := make([]string, len(ids))
-		args := make([]interface{}, len(ids))
-		for i, id := range ids {
-			placeholders[i] = "?"
-			args[i] = id
-		}
-		
-		updateQuery := fmt.Sprintf(
-			"UPDATE messages SET status = 'processing' WHERE id IN (%s)",
-			strings.Join(placeholders, ","),
-		)
+	placeholders, args := inClause(ids)
+	query := fmt.Sprintf(`
+		UPDATE messages
+		SET status = 'queued', retry_count = 0, next_attempt_at = NULL, error = NULL,
+		    processed_at = NULL, provider_override = ?
+		WHERE status = 'dead' AND id IN (%s)
+	`, placeholders)
 
-		_, err = tx.Exec(updateQuery, args...)
-		if err != nil {
-			return nil, fmt.Errorf("failed to update message status: %w", err)
+	result, err := q.db.Exec(query, append([]interface{}{nullString(providerOverride)}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to replay dead letters: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	return int(rows), nil
+}
+
+// Discard deletes dead messages; their attempts are removed by the foreign key cascade
+func (q *MySQLQueue) Discard(ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	placeholders, args := inClause(ids)
+	where := fmt.Sprintf("status = 'dead' AND id IN (%s)", placeholders)
+	rows, err := q.execReleasing("DELETE FROM messages", where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to discard dead letters: %w", err)
+	}
+	return rows, nil
+}
+
+// bulkWhere builds the WHERE clause selecting the messages a bulk action applies to
+func (q *MySQLQueue) bulkWhere(action BulkAction, filter BulkFilter) (string, []interface{}, error) {
+	statuses, err := bulkStatuses(action, filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	placeholders := make([]string, len(statuses))
+	args := make([]interface{}, len(statuses))
+	for i, status := range statuses {
+		placeholders[i] = "?"
+		args[i] = string(status)
+	}
+	where := []string{"status IN (" + strings.Join(placeholders, ",") + ")"}
+
+	for _, cond := range []struct {
+		column string
+		value  string
+	}{
+		{"provider_id", filter.WorkspaceID}, // The workspace; attempts record their provider in sent_via
+		{"email_type", filter.EmailType},
+		{"invitation_dispatch_id", filter.InvitationDispatchID},
+	} {
+		if cond.value != "" {
+			where = append(where, cond.column+" = ?")
+			args = append(args, cond.value)
 		}
 	}
+	if filter.From != "" {
+		where = append(where, "LOWER(from_email) = ?")
+		args = append(args, strings.ToLower(filter.From))
+	}
+	if filter.Tag != "" {
+		where = append(where, q.dialect.JSONContains(q.dialect.JSONValue("metadata", "tags")))
+		args = append(args, filter.Tag)
+	}
+	if filter.Subject != "" {
+		where = append(where, "LOWER(subject) LIKE ?")
+		args = append(args, bulkSubjectLike(filter.Subject))
+	}
+	if filter.QueuedAfter != nil {
+		where = append(where, "queued_at >= ?")
+		args = append(args, *filter.QueuedAfter)
+	}
+	if filter.QueuedBefore != nil {
+		where = append(where, "queued_at < ?")
+		args = append(args, *filter.QueuedBefore)
+	}
 
-	return messages, tx.Commit()
+	return " WHERE " + strings.Join(where, " AND "), args, nil
 }
 
-func (q *MySQLQueue) UpdateStatus(id string, status models.MessageStatus, err error) error {
-	query := `
-		UPDATE messages 
-		SET status = ?, processed_at = ?, error = ?, retry_count = retry_count + 1
-		WHERE id = ?
-	`
+// CountBulk returns how many messages ApplyBulk would change
+func (q *MySQLQueue) CountBulk(action BulkAction, filter BulkFilter) (int, error) {
+	where, args, err := q.bulkWhere(action, filter)
+	if err != nil {
+		return 0, err
+	}
 
-	var errorMsg sql.NullString
+	var count int
+	if err := q.db.QueryRow("SELECT COUNT(*) FROM messages"+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+	return count, nil
+}
+
+// ApplyBulk applies action to every message matching filter in one statement.
+// Deleted messages lose their attempts through the foreign key cascade.
+func (q *MySQLQueue) ApplyBulk(action BulkAction, filter BulkFilter, sendAt time.Time) (int, error) {
+	where, args, err := q.bulkWhere(action, filter)
 	if err != nil {
-		errorMsg.Valid = true
-		errorMsg.String = err.Error()
+		return 0, err
+	}
+
+	var statement string
+	var setArgs []interface{}
+	switch action {
+	case BulkCancel:
+		statement = "UPDATE messages SET status = 'cancelled', next_attempt_at = NULL, processed_at = ?, error = 'cancelled'"
+		setArgs = []interface{}{time.Now()}
+	case BulkRequeue:
+		statement = `UPDATE messages SET status = 'queued', retry_count = 0, next_attempt_at = NULL, deferred_at = NULL,
+		    processed_at = NULL, error = NULL`
+	case BulkReschedule:
+		statement = "UPDATE messages SET status = 'queued', send_at = ?, next_attempt_at = NULL"
+		setArgs = []interface{}{sendAt}
+	case BulkDelete:
+		statement = "DELETE FROM messages"
+	}
+
+	if action == BulkDelete {
+		rows, err := q.execReleasing(statement, strings.TrimPrefix(where, " WHERE "), args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to %s messages: %w", action, err)
+		}
+		return rows, nil
+	}
+	result, err := q.db.Exec(statement+where, append(setArgs, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to %s messages: %w", action, err)
 	}
 
-	_, dbErr := q.db.Exec(query, status, time.Now(), errorMsg, id)
-	return dbErr
+	rows, _ := result.RowsAffected()
+	return int(rows), nil
 }
 
-func (q *MySQLQueue) UpdateStatusWithProvider(id string, status models.MessageStatus, providerID string, err error) error {
-	log.Printf("DEBUG: UpdateStatusWithProvider called - id=%s, status=%s, provider=%s, hasError=%v", id, status, providerID, err != nil)
-	
-	// For sent messages, also update sent_at
-	query := `
-		UPDATE messages 
-		SET status = ?, processed_at = ?, sent_at = ?, provider_id = ?, error = ?, retry_count = retry_count + 1
-		WHERE id = ?
-	`
+// retentionWhere selects finished messages of the filter's workspace that
+// finished, or were restored, before the filter's cutoff
+const retentionWhere = `provider_id = ? AND status IN ('sent', 'dead', 'cancelled', 'expired')
+		  AND COALESCE(restored_at, processed_at) < ?`
 
-	var errorMsg sql.NullString
+// ClaimUnarchived returns finished messages that are due for archiving, oldest
+// first, and claims them until until. SKIP LOCKED and the claim keep replicas
+// running retention at the same time from archiving the same messages.
+func (q *MySQLQueue) ClaimUnarchived(filter RetentionFilter, until time.Time) ([]*models.Message, error) {
+	tx, err := q.db.Begin()
 	if err != nil {
-		errorMsg.Valid = true
-		errorMsg.String = err.Error()
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	var provider sql.NullString
-	if providerID != "" {
-		provider.Valid = true
-		provider.String = providerID
-		log.Printf("DEBUG: Setting provider_id to '%s'", providerID)
-	} else {
-		log.Printf("DEBUG: Provider ID is empty, setting NULL")
+	rows, err := tx.Query(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE `+retentionWhere+` AND archived_at IS NULL
+		  AND (archive_claimed_until IS NULL OR archive_claimed_until < ?)
+		ORDER BY processed_at ASC
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED
+	`, filter.WorkspaceID, filter.Before, time.Now(), filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages to archive: %w", err)
 	}
 
-	now := time.Now()
-	var sentAt sql.NullTime
-	if status == models.StatusSent {
-		sentAt.Valid = true
-		sentAt.Time = now
+	var messages []*models.Message
+	var ids []string
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		messages = append(messages, msg)
+		ids = append(ids, msg.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read messages to archive: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
 	}
 
-	log.Printf("DEBUG: Executing SQL with params - status=%s, processed_at=%v, sent_at=%v, provider=%v, error=%v, id=%s", 
-		status, now, sentAt, provider, errorMsg, id)
+	placeholders, args := inClause(ids)
+	if _, err := tx.Exec("UPDATE messages SET archive_claimed_until = ? WHERE id IN ("+placeholders+")", append([]interface{}{until}, args...)...); err != nil {
+		return nil, fmt.Errorf("failed to claim messages to archive: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return messages, nil
+}
 
-	result, dbErr := q.db.Exec(query, status, now, sentAt, provider, errorMsg, id)
-	if dbErr != nil {
-		log.Printf("ERROR: UpdateStatusWithProvider failed - %v", dbErr)
-		return dbErr
+// MarkArchived records when the messages were written to an archive
+func (q *MySQLQueue) MarkArchived(ids []string, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
 	}
-	
-	rows, _ := result.RowsAffected()
-	log.Printf("DEBUG: UpdateStatusWithProvider updated %d rows for message %s", rows, id)
-	
-	// Verify the update
-	var checkProvider sql.NullString
-	checkErr := q.db.QueryRow("SELECT provider_id FROM messages WHERE id = ?", id).Scan(&checkProvider)
-	if checkErr == nil {
-		log.Printf("DEBUG: After update, provider_id in DB is: %v (valid=%v)", checkProvider.String, checkProvider.Valid)
+	placeholders, args := inClause(ids)
+	_, err := q.db.Exec("UPDATE messages SET archived_at = ?, archive_claimed_until = NULL WHERE id IN ("+placeholders+")", append([]interface{}{at}, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to mark messages archived: %w", err)
 	}
-	
 	return nil
 }
 
-func (q *MySQLQueue) Get(id string) (*models.Message, error) {
-	query := `
-		SELECT id, from_email, to_emails, cc_emails, bcc_emails,
-			subject, html_body, text_body, headers, attachments,
-			metadata, invitation_id, email_type, invitation_dispatch_id, provider_id, status, queued_at, processed_at, error
-		FROM messages
-		WHERE id = ?
-	`
+// Purge removes scope from archived messages. Rows are changed in batches of
+// filter.Limit, through a derived table so the same statement runs on MySQL and
+// PostgreSQL; callers repeat until fewer than Limit are changed.
+func (q *MySQLQueue) Purge(scope PurgeScope, filter RetentionFilter) (int, error) {
+	var statement, pending string
+	switch scope {
+	case PurgeBodies:
+		statement = "UPDATE messages SET html_body = '', text_body = '', raw_message = NULL"
+		pending = " AND (COALESCE(html_body, '') <> '' OR COALESCE(text_body, '') <> '' OR raw_message IS NOT NULL)"
+	case PurgeAttachments:
+		statement = "UPDATE messages SET attachments = 'null'"
+		pending = " AND attachments NOT IN ('null', '[]')"
+	case PurgeRows:
+		statement = "DELETE FROM messages"
+	default:
+		return 0, fmt.Errorf("unknown purge scope %q", scope)
+	}
 
-	msg := &models.Message{}
-	var toEmails, ccEmails, bccEmails, headers, attachments, metadata string
-	var processedAt sql.NullTime
-	var errorMsg sql.NullString
+	batch := `
+		id IN (
+			SELECT id FROM (
+				SELECT id FROM messages
+				WHERE ` + retentionWhere + ` AND archived_at IS NOT NULL` + pending + `
+				ORDER BY id
+				LIMIT ?
+			) AS batch
+		)`
+	args := []interface{}{filter.WorkspaceID, filter.Before, filter.Limit}
+
+	if scope != PurgeBodies {
+		rows, err := q.execReleasing(statement, batch, args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to purge %s: %w", scope, err)
+		}
+		return rows, nil
+	}
+	result, err := q.db.Exec(statement+" WHERE"+batch, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge %s: %w", scope, err)
+	}
 
-	err := q.db.QueryRow(query, id).Scan(
-		&msg.ID,
-		&msg.From,
-		&toEmails,
-		&ccEmails,
-		&bccEmails,
-		&msg.Subject,
-		&msg.HTML,
-		&msg.Text,
-		&headers,
-		&attachments,
-		&metadata,
-		&msg.InvitationID,
-		&msg.EmailType,
-		&msg.InvitationDispatchID,
-		&msg.ProviderID,
-		&msg.Status,
-		&msg.QueuedAt,
-		&processedAt,
-		&errorMsg,
-	)
+	rows, _ := result.RowsAffected()
+	return int(rows), nil
+}
 
+// Restore replaces what is left of an archived message with its archived copy.
+// Attachment content is moved to the attachment store again when one is set.
+// The archived copy and its attempts are written in one transaction, which also
+// moves the references from what is left of the row to the restored attachments.
+func (q *MySQLQueue) Restore(msg *models.Message, attempts []models.Attempt) error {
+	tx, err := q.db.Begin()
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	json.Unmarshal([]byte(toEmails), &msg.To)
-	json.Unmarshal([]byte(ccEmails), &msg.CC)
-	json.Unmarshal([]byte(bccEmails), &msg.BCC)
-	json.Unmarshal([]byte(headers), &msg.Headers)
-	json.Unmarshal([]byte(attachments), &msg.Attachments)
-	json.Unmarshal([]byte(metadata), &msg.Metadata)
+	// A row that was only stripped gets its content back in place, keeping the
+	// recipient tracking rows that reference it; a deleted row is inserted again
+	ids, released, err := lockBlobs(tx, "id = ?", msg.ID)
+	if err != nil {
+		return fmt.Errorf("failed to check message: %w", err)
+	}
+	if err := q.releaseBlobs(tx, released); err != nil {
+		return err
+	}
+	if err := q.storeBlobs(tx, msg.Attachments); err != nil {
+		return err
+	}
 
-	if processedAt.Valid {
-		msg.ProcessedAt = &processedAt.Time
+	toEmails, _ := json.Marshal(msg.To)
+	ccEmails, _ := json.Marshal(msg.CC)
+	bccEmails, _ := json.Marshal(msg.BCC)
+	headers, _ := json.Marshal(msg.Headers)
+	attachments, _ := json.Marshal(msg.Attachments)
+	metadata, _ := json.Marshal(msg.Metadata)
+	now := time.Now()
+	msg.RestoredAt = &now
+
+	if len(ids) > 0 {
+		_, err = tx.Exec(`
+			UPDATE messages SET html_body = ?, text_body = ?, attachments = ?, raw_message = ?, restored_at = ?
+			WHERE id = ?
+		`, msg.HTML, msg.Text, string(attachments), msg.RawMessage, msg.RestoredAt, msg.ID)
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM message_attempts WHERE message_id = ?", msg.ID)
+		}
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO messages (`+messageColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, msg.ID, msg.From, string(toEmails), string(ccEmails), string(bccEmails),
+			msg.Subject, msg.HTML, msg.Text, string(headers), string(attachments), msg.RawMessage,
+			string(metadata), msg.InvitationID, msg.EmailType, msg.InvitationDispatchID, msg.ProviderID,
+			msg.Status, msg.Priority.Lane(), msg.QueuedAt, msg.SendAt, msg.ProcessedAt, nullString(msg.Error),
+			msg.RetryCount, msg.NextAttemptAt, msg.DeferredAt, nullString(msg.ProviderOverride),
+			nullString(msg.LockedBy), msg.LeaseExpiresAt, msg.ArchivedAt, msg.RestoredAt, nullString(msg.ProviderMessageID),
+			msg.ExpiresAt, nullString(msg.SentVia))
 	}
-	if errorMsg.Valid {
-		msg.Error = errorMsg.String
+	if err != nil {
+		return fmt.Errorf("failed to restore message: %w", err)
+	}
+	for _, attempt := range attempts {
+		_, err := tx.Exec(`
+			INSERT INTO message_attempts (message_id, attempt, provider_id, error_class, error, attempted_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, msg.ID, attempt.Number, nullString(attempt.ProviderID), nullString(attempt.ErrorClass),
+			nullString(attempt.Error), attempt.AttemptedAt)
+		if err != nil {
+			return fmt.Errorf("failed to restore attempt: %w", err)
+		}
 	}
+	return tx.Commit()
+}
 
-	return msg, nil
+// inClause returns "?,?,..." and the matching arguments for an IN list
+func inClause(ids []string) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return strings.Join(placeholders, ","), args
 }
 
-func (q *MySQLQueue) Remove(id string) error {
-	_, err := q.db.Exec("DELETE FROM messages WHERE id = ?", id)
-	return err
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
 }
 
 func (q *MySQLQueue) Close() error {
@@ -356,6 +1734,32 @@ func (q *MySQLQueue) GetStats() (map[string]interface{}, error) {
 
 	stats["statusCounts"] = counts
 
+	// Depth of each priority lane: messages waiting to be sent, including pending retries
+	laneRows, err := q.db.Query(`
+		SELECT priority, COUNT(*) as count
+		FROM messages
+		WHERE status = 'queued' OR (status = 'failed' AND next_attempt_at IS NOT NULL)
+		GROUP BY priority
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer laneRows.Close()
+
+	var laneCounts []map[string]interface{}
+	for laneRows.Next() {
+		var priority string
+		var count int
+		if err := laneRows.Scan(&priority, &count); err != nil {
+			return nil, err
+		}
+		laneCounts = append(laneCounts, map[string]interface{}{
+			"Priority": priority,
+			"Count":    count,
+		})
+	}
+	stats["laneCounts"] = laneCounts
+
 	var total int
 	q.db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&total)
 	stats["total"] = total
@@ -428,11 +1832,11 @@ func (q *MySQLQueue) GetSentCountsByWorkspaceAndSender() (map[string]map[string]
 		SELECT provider_id, from_email, COUNT(*) as count
 		FROM messages
 		WHERE status = 'sent' 
-		  AND processed_at >= DATE_SUB(NOW(), INTERVAL 24 HOUR)
+		  AND processed_at >= ?
 		GROUP BY provider_id, from_email
 	`
 
-	rows, err := q.db.Query(query)
+	rows, err := q.db.Query(query, time.Now().Add(-24*time.Hour))
 	if err != nil {
 		return nil, err
 	}
@@ -442,12 +1846,14 @@ func (q *MySQLQueue) GetSentCountsByWorkspaceAndSender() (map[string]map[string]
 	counts := make(map[string]map[string]int)
 
 	for rows.Next() {
-		var providerID, fromEmail string
+		var workspaceID sql.NullString
+		var fromEmail string
 		var count int
 
-		if err := rows.Scan(&providerID, &fromEmail, &count); err != nil {
+		if err := rows.Scan(&workspaceID, &fromEmail, &count); err != nil {
 			return nil, err
 		}
+		providerID := workspaceID.String
 
 		log.Printf("DEBUG: Found DB record: provider='%s', from='%s', count=%d", providerID, fromEmail, count)
 
@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PauseScope is what a pause stops sending for
+type PauseScope string
+
+const (
+	PauseGlobal    PauseScope = "global"    // Every message
+	PauseWorkspace PauseScope = "workspace" // Messages of one workspace
+	PauseProvider  PauseScope = "provider"  // Messages routed to one provider, e.g. a workspace's Gmail
+	PauseSender    PauseScope = "sender"    // Messages from one sender address
+)
+
+// Pause keeps the messages in its scope queued until it is resumed or ResumeAt passes
+type Pause struct {
+	Scope    PauseScope `json:"scope"`
+	Key      string     `json:"key,omitempty"` // Workspace ID, provider ID or sender address; empty for global
+	Reason   string     `json:"reason,omitempty"`
+	PausedBy string     `json:"paused_by,omitempty"`
+	PausedAt time.Time  `json:"paused_at"`
+	ResumeAt *time.Time `json:"resume_at,omitempty"` // Resumed automatically at this time when set
+}
+
+// Normalize validates a pause and lower-cases sender addresses so lookups are case-insensitive
+func (p *Pause) Normalize() error {
+	switch p.Scope {
+	case PauseGlobal:
+		p.Key = ""
+	case PauseWorkspace, PauseProvider:
+		if p.Key == "" {
+			return fmt.Errorf("a %s pause needs a key", p.Scope)
+		}
+	case PauseSender:
+		if p.Key == "" {
+			return fmt.Errorf("a %s pause needs a key", p.Scope)
+		}
+		p.Key = strings.ToLower(p.Key)
+	default:
+		return fmt.Errorf("unknown pause scope %q", p.Scope)
+	}
+	return nil
+}
+
+// Active reports whether the pause is still in effect at now
+func (p Pause) Active(now time.Time) bool {
+	return p.ResumeAt == nil || p.ResumeAt.After(now)
+}
+
+func (p Pause) String() string {
+	if p.Scope == PauseGlobal {
+		return "sending is paused"
+	}
+	return fmt.Sprintf("%s %s is paused", p.Scope, p.Key)
+}
+
+// pauseID identifies a pause within the backend that stores it
+func pauseID(scope PauseScope, key string) string {
+	if scope == PauseSender {
+		key = strings.ToLower(key)
+	}
+	return string(scope) + ":" + key
+}
+
+// activePauses returns the pauses still in effect at now, oldest first
+func activePauses(pauses map[string]Pause, now time.Time) []Pause {
+	active := make([]Pause, 0, len(pauses))
+	for _, pause := range pauses {
+		if pause.Active(now) {
+			active = append(active, pause)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].PausedAt.Before(active[j].PausedAt)
+	})
+	return active
+}
+
+// PauseSet answers whether a message is paused, from the pauses in effect at one point in time.
+// A nil PauseSet pauses nothing.
+type PauseSet struct {
+	pauses map[string]Pause
+}
+
+// NewPauseSet keeps the pauses that are still in effect at now
+func NewPauseSet(pauses []Pause, now time.Time) *PauseSet {
+	s := &PauseSet{pauses: make(map[string]Pause, len(pauses))}
+	for _, pause := range pauses {
+		if pause.Active(now) {
+			s.pauses[pauseID(pause.Scope, pause.Key)] = pause
+		}
+	}
+	return s
+}
+
+func (s *PauseSet) lookup(scope PauseScope, key string) *Pause {
+	if s == nil {
+		return nil
+	}
+	if pause, ok := s.pauses[pauseID(scope, key)]; ok {
+		return &pause
+	}
+	return nil
+}
+
+// Global returns the global pause, if any
+func (s *PauseSet) Global() *Pause {
+	return s.lookup(PauseGlobal, "")
+}
+
+// Find returns the pause holding a message of workspaceID from sender routed to
+// providerID, checking the broadest scope first. Empty arguments are not checked.
+func (s *PauseSet) Find(workspaceID, providerID, sender string) *Pause {
+	if pause := s.Global(); pause != nil {
+		return pause
+	}
+	if workspaceID != "" {
+		if pause := s.lookup(PauseWorkspace, workspaceID); pause != nil {
+			return pause
+		}
+	}
+	if providerID != "" {
+		if pause := s.lookup(PauseProvider, providerID); pause != nil {
+			return pause
+		}
+	}
+	if sender != "" {
+		return s.lookup(PauseSender, sender)
+	}
+	return nil
+}
+
+// holds reports whether a workspace (sender is empty) or one of its senders is
+// paused, in the form FairShare.Throttled takes
+func (s *PauseSet) holds(workspaceID, sender string) bool {
+	if sender == "" {
+		return s.Find(workspaceID, "", "") != nil
+	}
+	return s.lookup(PauseSender, sender) != nil
+}
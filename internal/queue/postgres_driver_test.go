@@ -0,0 +1,6 @@
+//go:build postgres
+
+package queue
+
+// Links pgx so QUEUE_TEST_POSTGRES can run the behavior tests on PostgreSQL
+import _ "github.com/jackc/pgx/v5/stdlib"
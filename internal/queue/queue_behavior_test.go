@@ -0,0 +1,1084 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"relay/internal/blobstore"
+	"relay/internal/config"
+	"relay/internal/database"
+	"relay/pkg/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+)
+
+// The behavior tests run against every backend. MemoryQueue, FileQueue and
+// RedisQueue on an in-process miniredis always run; the SQL queues and a real
+// Redis run when a test server is configured:
+//
+//	QUEUE_TEST_MYSQL=1 (with the MYSQL_* variables) runs against an otherwise empty database
+//	QUEUE_TEST_POSTGRES=1 (with the POSTGRES_* variables and -tags postgres) does the same on PostgreSQL;
+//	.github/workflows/queue_postgres.yaml runs it against a fresh database
+//	QUEUE_TEST_REDIS_ADDR=localhost:6379 runs against a local redis-server
+func queueBackends() map[string]func(t *testing.T) Queue {
+	backends := map[string]func(t *testing.T) Queue{
+		"memory": func(t *testing.T) Queue { return NewMemoryQueue() },
+		"file": func(t *testing.T) Queue {
+			q, err := NewFileQueue(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewFileQueue: %v", err)
+			}
+			t.Cleanup(func() { q.Close() })
+			return q
+		},
+	}
+
+	if os.Getenv("QUEUE_TEST_MYSQL") != "" {
+		backends["mysql"] = func(t *testing.T) Queue {
+			port, _ := strconv.Atoi(envOr("MYSQL_PORT", "3306"))
+			q, err := NewMySQLQueue(&config.MySQLConfig{
+				Host:     envOr("MYSQL_HOST", "localhost"),
+				Port:     port,
+				User:     envOr("MYSQL_USER", "root"),
+				Password: os.Getenv("MYSQL_PASSWORD"),
+				Database: envOr("MYSQL_DATABASE", "relay_test"),
+			})
+			if err != nil {
+				t.Fatalf("NewMySQLQueue: %v", err)
+			}
+			t.Cleanup(func() {
+				q.db.Exec("DELETE FROM messages")
+				q.db.Exec("DELETE FROM attachment_blobs")
+				q.Close()
+			})
+			return q
+		}
+	}
+
+	if os.Getenv("QUEUE_TEST_POSTGRES") != "" {
+		backends["postgres"] = func(t *testing.T) Queue {
+			port, _ := strconv.Atoi(envOr("POSTGRES_PORT", "5432"))
+			db, err := database.OpenPostgres(&config.PostgresConfig{
+				Host:     envOr("POSTGRES_HOST", "localhost"),
+				Port:     port,
+				User:     envOr("POSTGRES_USER", "postgres"),
+				Password: os.Getenv("POSTGRES_PASSWORD"),
+				Database: envOr("POSTGRES_DATABASE", "relay_test"),
+				SSLMode:  envOr("POSTGRES_SSLMODE", "disable"),
+			})
+			if err != nil {
+				t.Fatalf("OpenPostgres: %v", err)
+			}
+			q, err := NewSQLQueue(db)
+			if err != nil {
+				t.Fatalf("NewSQLQueue: %v", err)
+			}
+			t.Cleanup(func() {
+				q.db.Exec("DELETE FROM messages")
+				q.db.Exec("DELETE FROM attachment_blobs")
+				q.Close()
+			})
+			return q
+		}
+	}
+
+	backends["miniredis"] = func(t *testing.T) Queue {
+		server := miniredis.RunT(t)
+		q, err := NewRedisQueue(&config.RedisConfig{Addr: server.Addr(), KeyPrefix: "relay-test:"})
+		if err != nil {
+			t.Fatalf("NewRedisQueue: %v", err)
+		}
+		t.Cleanup(func() { q.Close() })
+		return q
+	}
+
+	if addr := os.Getenv("QUEUE_TEST_REDIS_ADDR"); addr != "" {
+		backends["redis"] = func(t *testing.T) Queue {
+			prefix := fmt.Sprintf("relay-test:%d:", time.Now().UnixNano())
+			q, err := NewRedisQueue(&config.RedisConfig{Addr: addr, KeyPrefix: prefix})
+			if err != nil {
+				t.Fatalf("NewRedisQueue: %v", err)
+			}
+			t.Cleanup(func() {
+				if keys, err := redisStrings(q.pool.do("KEYS", prefix+"*")); err == nil && len(keys) > 0 {
+					args := []interface{}{"DEL"}
+					for _, key := range keys {
+						args = append(args, key)
+					}
+					q.pool.do(args...)
+				}
+				q.Close()
+			})
+			return q
+		}
+	}
+
+	return backends
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func newTestMessage(workspaceID string) *models.Message {
+	return &models.Message{
+		ID:         uuid.New().String(),
+		From:       "sender@example.com",
+		To:         []string{"user@example.com"},
+		Subject:    "Hello",
+		ProviderID: workspaceID,
+		Status:     models.StatusQueued,
+		QueuedAt:   time.Now(),
+	}
+}
+
+func TestQueueBehavior(t *testing.T) {
+	for name, open := range queueBackends() {
+		t.Run(name, func(t *testing.T) {
+			t.Run("DequeueLeasesDueMessages", func(t *testing.T) {
+				q := open(t)
+				later := time.Now().Add(time.Hour)
+				scheduled := newTestMessage("ws")
+				scheduled.SendAt = &later
+				first, second := newTestMessage("ws"), newTestMessage("ws")
+				for _, msg := range []*models.Message{first, second, scheduled} {
+					if err := q.Enqueue(msg); err != nil {
+						t.Fatalf("Enqueue: %v", err)
+					}
+				}
+
+				batch, err := q.Dequeue(10)
+				if err != nil || len(batch) != 2 {
+					t.Fatalf("Dequeue = %d messages, %v; want the 2 due ones", len(batch), err)
+				}
+				for _, msg := range batch {
+					if msg.ID == scheduled.ID || msg.LockedBy == "" || msg.LeaseExpiresAt == nil {
+						t.Errorf("dequeued %s without a lease or before its send_at", msg.ID)
+					}
+				}
+				if batch, _ := q.Dequeue(10); len(batch) != 0 {
+					t.Errorf("leased messages were dequeued again: %d", len(batch))
+				}
+			})
+
+			t.Run("SentMessagesAreCounted", func(t *testing.T) {
+				q := open(t)
+				msg := newTestMessage("ws-sent")
+				q.Enqueue(msg)
+				q.Dequeue(1)
+				if err := q.UpdateStatusWithProvider(msg.ID, models.StatusSent, "gmail-ws-sent", nil); err != nil {
+					t.Fatalf("UpdateStatusWithProvider: %v", err)
+				}
+
+				stored, err := q.Get(msg.ID)
+				if err != nil || stored.Status != models.StatusSent || stored.RetryCount != 1 || stored.SentVia != "gmail-ws-sent" {
+					t.Fatalf("Get = %+v, %v; want sent after one attempt", stored, err)
+				}
+				counts, _ := q.GetSentCountsByWorkspaceAndSender()
+				if counts["ws-sent"]["sender@example.com"] != 1 {
+					t.Errorf("sent counts = %v", counts)
+				}
+			})
+
+			t.Run("RetryWaitsUntilDue", func(t *testing.T) {
+				q := open(t)
+				msg := newTestMessage("ws")
+				q.Enqueue(msg)
+				q.Dequeue(1)
+				if err := q.ScheduleRetry(msg.ID, "ws", time.Now().Add(time.Hour), fmt.Errorf("timeout")); err != nil {
+					t.Fatalf("ScheduleRetry: %v", err)
+				}
+				if batch, _ := q.Dequeue(1); len(batch) != 0 {
+					t.Fatal("retry was dequeued before it was due")
+				}
+
+				stored, _ := q.Get(msg.ID)
+				if stored.Status != models.StatusFailed || stored.RetryCount != 1 || stored.NextAttemptAt == nil || stored.Error != "timeout" {
+					t.Errorf("retrying message = %s, retry_count %d, error %q", stored.Status, stored.RetryCount, stored.Error)
+				}
+			})
+
+			t.Run("RetriesKeepTheirWorkspace", func(t *testing.T) {
+				q := open(t)
+				msg := newTestMessage("ws")
+				q.Enqueue(msg)
+				q.Dequeue(1)
+
+				past := time.Now().Add(-time.Second)
+				if err := q.ScheduleRetry(msg.ID, "gmail-ws", past, fmt.Errorf("timeout")); err != nil {
+					t.Fatalf("ScheduleRetry: %v", err)
+				}
+				batch, err := q.Dequeue(1)
+				if err != nil || len(batch) != 1 || batch[0].ProviderID != "ws" || batch[0].SentVia != "gmail-ws" {
+					t.Fatalf("Dequeue after a failed send = %+v, %v; want workspace ws sent via gmail-ws", batch, err)
+				}
+
+				// A routing failure has no provider to record
+				if err := q.ScheduleRetry(msg.ID, "", past, fmt.Errorf("no provider available")); err != nil {
+					t.Fatalf("ScheduleRetry: %v", err)
+				}
+				batch, err = q.Dequeue(1)
+				if err != nil || len(batch) != 1 || batch[0].ProviderID != "ws" || batch[0].SentVia != "gmail-ws" {
+					t.Fatalf("Dequeue after a routing failure = %+v, %v; want workspace ws sent via gmail-ws", batch, err)
+				}
+			})
+
+			t.Run("RetriesStayInTheirWorkspaceShare", func(t *testing.T) {
+				q := open(t)
+				fair := q.(FairScheduler)
+				msg := newTestMessage("ws-limited")
+				q.Enqueue(msg)
+				q.Dequeue(1)
+				if tracker, ok := q.(SendTracker); ok {
+					tracker.MarkSending(msg.ID, "gmail-ws-limited")
+				}
+				q.ScheduleRetry(msg.ID, "gmail-ws-limited", time.Now().Add(-time.Second), fmt.Errorf("timeout"))
+				other := newTestMessage("ws-open")
+				q.Enqueue(other)
+
+				// The throttle only knows workspaces, never provider IDs
+				fair.SetFairShare(FairShare{Throttled: func(workspaceID, sender string) bool {
+					return workspaceID != "ws-open"
+				}})
+				batch, err := q.Dequeue(10)
+				if err != nil || len(batch) != 1 || batch[0].ID != other.ID {
+					t.Fatalf("Dequeue = %d messages, %v; want only the message of the open workspace", len(batch), err)
+				}
+			})
+
+			t.Run("BatchesAreSharedBetweenWorkspaces", func(t *testing.T) {
+				q := open(t)
+				for i := 0; i < 10; i++ {
+					q.Enqueue(newTestMessage("ws-campaign"))
+				}
+				q.Enqueue(newTestMessage("ws-invites"))
+				q.Enqueue(newTestMessage("ws-invites"))
+
+				batch, err := q.Dequeue(4)
+				workspaces := map[string]int{}
+				for _, msg := range batch {
+					workspaces[msg.ProviderID]++
+				}
+				if err != nil || workspaces["ws-campaign"] != 2 || workspaces["ws-invites"] != 2 {
+					t.Errorf("Dequeue(4) took %v, %v; want 2 messages from each workspace", workspaces, err)
+				}
+			})
+
+			t.Run("WorkspaceShareTakesOldestAcrossSenders", func(t *testing.T) {
+				q := open(t)
+				var messages []*models.Message
+				for _, from := range []string{"news@example.com", "team@example.com", "news@example.com"} {
+					msg := newTestMessage("ws")
+					msg.From = from
+					q.Enqueue(msg)
+					messages = append(messages, msg)
+					time.Sleep(2 * time.Millisecond) // Keep the order visible at millisecond precision
+				}
+
+				batch, err := q.Dequeue(2)
+				if err != nil || len(batch) != 2 {
+					t.Fatalf("Dequeue(2) = %d messages, %v; want 2", len(batch), err)
+				}
+				taken := map[string]bool{batch[0].ID: true, batch[1].ID: true}
+				if !taken[messages[0].ID] || !taken[messages[1].ID] {
+					t.Errorf("Dequeue(2) skipped an older message of another sender")
+				}
+
+				pauser, ok := q.(Pauser)
+				if !ok {
+					return
+				}
+				later := newTestMessage("ws")
+				later.From = "team@example.com"
+				q.Enqueue(later)
+				pauser.Pause(Pause{Scope: PauseSender, Key: "news@example.com", PausedAt: time.Now()})
+				if batch, _ := q.Dequeue(10); len(batch) != 1 || batch[0].ID != later.ID {
+					t.Errorf("Dequeue = %d messages, want only the message of the sender that is not paused", len(batch))
+				}
+			})
+
+			t.Run("DeferReportsFirstDeferral", func(t *testing.T) {
+				q := open(t)
+				msg := newTestMessage("ws")
+				q.Enqueue(msg)
+				q.Dequeue(1)
+
+				reset := time.Now().Add(time.Hour)
+				if first, err := q.Defer(msg.ID, reset, fmt.Errorf("rate limit exceeded")); err != nil || !first {
+					t.Fatalf("first Defer = %v, %v; want true", first, err)
+				}
+				if batch, _ := q.Dequeue(1); len(batch) != 0 {
+					t.Fatal("deferred message was dequeued before its limit reset")
+				}
+				if first, _ := q.Defer(msg.ID, reset, nil); first {
+					t.Error("second Defer reported a first deferral")
+				}
+				if stored, _ := q.Get(msg.ID); stored.Status != models.StatusQueued || stored.RetryCount != 0 {
+					t.Errorf("deferred message = %s with %d attempts, want queued with none", stored.Status, stored.RetryCount)
+				}
+			})
+
+			t.Run("HoldIsNotADeferral", func(t *testing.T) {
+				q := open(t)
+				pauser, ok := q.(Pauser)
+				if !ok {
+					t.Skip("backend does not store pauses")
+				}
+				msg := newTestMessage("ws")
+				q.Enqueue(msg)
+				q.Dequeue(1)
+
+				if err := pauser.Hold(msg.ID, time.Now().Add(-time.Second), fmt.Errorf("workspace ws paused")); err != nil {
+					t.Fatalf("Hold: %v", err)
+				}
+				if stored, _ := q.Get(msg.ID); stored.Status != models.StatusQueued || stored.DeferredAt != nil {
+					t.Errorf("held message = %s deferred at %v, want queued and never deferred", stored.Status, stored.DeferredAt)
+				}
+				if batch, _ := q.Dequeue(1); len(batch) != 1 {
+					t.Fatal("held message was not dequeued once the hold ended")
+				}
+				if first, err := q.Defer(msg.ID, time.Now().Add(time.Hour), fmt.Errorf("rate limit exceeded")); err != nil || !first {
+					t.Errorf("Defer after a hold = %v, %v; want the first deferral", first, err)
+				}
+			})
+
+			t.Run("DeadLettersKeepAttempts", func(t *testing.T) {
+				q := open(t)
+				dlq, ok := q.(DeadLetterQueue)
+				if !ok {
+					t.Skip("backend has no dead letter queue")
+				}
+				msg := newTestMessage("ws")
+				q.Enqueue(msg)
+				q.Dequeue(1)
+				q.RecordAttempt(msg.ID, models.Attempt{Number: 1, ProviderID: "ws", Error: "bad address", AttemptedAt: time.Now()})
+				q.UpdateStatusWithProvider(msg.ID, models.StatusDead, "ws", fmt.Errorf("bad address"))
+
+				if dead, total, _ := dlq.ListDead(DeadLetterFilter{Limit: 10}); total != 1 || dead[0].ID != msg.ID {
+					t.Fatalf("ListDead returned %d messages, want %s", total, msg.ID)
+				}
+				if replayed, _ := dlq.Replay([]string{msg.ID}, ""); replayed != 1 {
+					t.Fatalf("replayed %d messages, want 1", replayed)
+				}
+				if attempts, _ := dlq.GetAttempts(msg.ID); len(attempts) != 1 {
+					t.Errorf("replay should keep the attempt history, got %d attempts", len(attempts))
+				}
+				if batch, _ := q.Dequeue(1); len(batch) != 1 {
+					t.Error("replayed message was not dequeued")
+				}
+			})
+
+			t.Run("DiscardDeletesUnsharedBlobs", func(t *testing.T) {
+				q := open(t)
+				dlq, ok := q.(DeadLetterQueue)
+				storer, stores := q.(AttachmentStorer)
+				if !ok || !stores {
+					t.Skip("backend has no dead letter queue or attachment store")
+				}
+				store, err := blobstore.NewFileStore(t.TempDir())
+				if err != nil {
+					t.Fatalf("NewFileStore: %v", err)
+				}
+				storer.SetAttachmentStore(store)
+
+				logo, invoice := []byte("shared logo"), []byte("invoice for one customer")
+				dead, live := newTestMessage("ws"), newTestMessage("ws")
+				dead.Attachments = []models.Attachment{{Name: "logo.png", Content: logo}, {Name: "invoice.pdf", Content: invoice}}
+				live.Attachments = []models.Attachment{{Name: "logo.png", Content: logo}}
+				q.Enqueue(dead)
+				q.Enqueue(live)
+				q.Dequeue(2)
+				q.UpdateStatusWithProvider(dead.ID, models.StatusDead, "ws", fmt.Errorf("bad address"))
+
+				if discarded, err := dlq.Discard([]string{dead.ID}); err != nil || discarded != 1 {
+					t.Fatalf("Discard = %d, %v; want 1", discarded, err)
+				}
+				if collected, err := storer.CollectBlobs(time.Now().Add(-time.Hour), 100); err != nil || collected != 0 {
+					t.Errorf("CollectBlobs within the grace period = %d, %v; want 0", collected, err)
+				}
+				if collected, err := storer.CollectBlobs(time.Now().Add(time.Minute), 100); err != nil || collected != 1 {
+					t.Errorf("CollectBlobs = %d, %v; want 1", collected, err)
+				}
+				if _, err := store.Open(context.Background(), blobstore.Key(invoice)); err != blobstore.ErrNotFound {
+					t.Errorf("blob only the discarded message used was kept: %v", err)
+				}
+				r, err := store.Open(context.Background(), blobstore.Key(logo))
+				if err != nil {
+					t.Fatalf("blob another message still uses was deleted: %v", err)
+				}
+				r.Close()
+			})
+
+			t.Run("CollectSkipsBlobsReferencedAgain", func(t *testing.T) {
+				q := open(t)
+				storer, ok := q.(AttachmentStorer)
+				if !ok {
+					t.Skip("backend has no attachment store")
+				}
+				store, err := blobstore.NewFileStore(t.TempDir())
+				if err != nil {
+					t.Fatalf("NewFileStore: %v", err)
+				}
+				storer.SetAttachmentStore(store)
+
+				invoice := []byte("invoice sent twice")
+				first, second := newTestMessage("ws"), newTestMessage("ws")
+				first.Attachments = []models.Attachment{{Name: "invoice.pdf", Content: invoice}}
+				second.Attachments = []models.Attachment{{Name: "invoice.pdf", Content: invoice}}
+				q.Enqueue(first)
+				if err := q.Remove(first.ID); err != nil {
+					t.Fatalf("Remove: %v", err)
+				}
+				q.Enqueue(second)
+
+				if collected, err := storer.CollectBlobs(time.Now().Add(time.Minute), 100); err != nil || collected != 0 {
+					t.Errorf("CollectBlobs = %d, %v; want 0", collected, err)
+				}
+				r, err := store.Open(context.Background(), blobstore.Key(invoice))
+				if err != nil {
+					t.Fatalf("blob a new message references was deleted: %v", err)
+				}
+				r.Close()
+
+				if err := q.Remove(second.ID); err != nil {
+					t.Fatalf("Remove: %v", err)
+				}
+				if collected, err := storer.CollectBlobs(time.Now().Add(time.Minute), 100); err != nil || collected != 1 {
+					t.Errorf("CollectBlobs after the last reference went = %d, %v; want 1", collected, err)
+				}
+			})
+
+			t.Run("RetentionFindsSentMessages", func(t *testing.T) {
+				q := open(t)
+				archiver, ok := q.(Archiver)
+				if !ok {
+					t.Skip("backend does not support retention")
+				}
+				msg := newTestMessage("ws")
+				q.Enqueue(msg)
+				q.Dequeue(1)
+				if err := q.(SendTracker).MarkSending(msg.ID, "gmail-ws"); err != nil {
+					t.Fatalf("MarkSending: %v", err)
+				}
+				q.(SendTracker).MarkSent(msg.ID, "gmail-ws", "provider-1")
+
+				filter := RetentionFilter{WorkspaceID: "ws", Before: time.Now().Add(time.Hour), Limit: 10}
+				due, err := archiver.ClaimUnarchived(filter, time.Now().Add(time.Minute))
+				if err != nil || len(due) != 1 || due[0].ID != msg.ID {
+					t.Fatalf("ClaimUnarchived = %d messages, %v; want the sent message", len(due), err)
+				}
+				if due, _ := archiver.ClaimUnarchived(filter, time.Now().Add(time.Minute)); len(due) != 0 {
+					t.Errorf("message claimed by another run was claimed again")
+				}
+				if err := archiver.MarkArchived([]string{msg.ID}, time.Now()); err != nil {
+					t.Fatalf("MarkArchived: %v", err)
+				}
+				if due, _ := archiver.ClaimUnarchived(filter, time.Now().Add(time.Minute)); len(due) != 0 {
+					t.Errorf("archived message is still listed")
+				}
+				if purged, err := archiver.Purge(PurgeRows, filter); err != nil || purged != 1 {
+					t.Fatalf("Purge = %d, %v; want 1", purged, err)
+				}
+				if _, err := q.Get(msg.ID); err == nil {
+					t.Error("purged message is still stored")
+				}
+			})
+
+			t.Run("ScheduledMessagesCanBeRescheduled", func(t *testing.T) {
+				q := open(t)
+				scheduler, ok := q.(Scheduler)
+				if !ok {
+					t.Skip("backend has no scheduler")
+				}
+				later := time.Now().Add(time.Hour)
+				msg := newTestMessage("ws")
+				msg.SendAt = &later
+				q.Enqueue(msg)
+
+				if scheduled, _ := scheduler.ListScheduled("user@example.com", 10); len(scheduled) != 1 {
+					t.Fatalf("ListScheduled returned %d messages, want 1", len(scheduled))
+				}
+				if err := scheduler.Reschedule(msg.ID, time.Now().Add(-time.Second)); err != nil {
+					t.Fatalf("Reschedule: %v", err)
+				}
+				if batch, _ := q.Dequeue(1); len(batch) != 1 {
+					t.Fatal("message moved to a past send_at was not dequeued")
+				}
+				if err := scheduler.CancelScheduled(msg.ID); err != ErrNotScheduled {
+					t.Errorf("CancelScheduled on a dequeued message = %v, want ErrNotScheduled", err)
+				}
+			})
+
+			t.Run("ScheduledMessagesAreListedBySendAt", func(t *testing.T) {
+				q := open(t)
+				scheduler, ok := q.(Scheduler)
+				if !ok {
+					t.Skip("backend has no scheduler")
+				}
+				now := time.Now()
+				at := func(d time.Duration) *time.Time {
+					sendAt := now.Add(d)
+					return &sendAt
+				}
+				last, first, middle, due := newTestMessage("ws"), newTestMessage("ws"), newTestMessage("ws"), newTestMessage("ws")
+				last.SendAt, first.SendAt, middle.SendAt = at(3*time.Hour), at(time.Hour), at(2*time.Hour)
+				middle.To = []string{"other@example.com"}
+				for _, msg := range []*models.Message{last, first, middle, due} {
+					q.Enqueue(msg)
+				}
+
+				ids := func(messages []*models.Message) []string {
+					var ids []string
+					for _, msg := range messages {
+						ids = append(ids, msg.ID)
+					}
+					return ids
+				}
+				check := func(to string, limit int, want ...*models.Message) {
+					t.Helper()
+					scheduled, err := scheduler.ListScheduled(to, limit)
+					if err != nil {
+						t.Fatalf("ListScheduled(%q, %d): %v", to, limit, err)
+					}
+					if got, want := ids(scheduled), ids(want); !reflect.DeepEqual(got, want) {
+						t.Errorf("ListScheduled(%q, %d) = %v, want %v", to, limit, got, want)
+					}
+				}
+				check("", 10, first, middle, last)
+				check("", 2, first, middle)
+				check("user@example.com", 10, first, last)
+				check("other@example.com", 10, middle)
+				check("nobody@example.com", 10)
+
+				// Rescheduling reorders the list but leaves a due message alone
+				if err := scheduler.Reschedule(first.ID, *at(4 * time.Hour)); err != nil {
+					t.Fatalf("Reschedule: %v", err)
+				}
+				check("", 10, middle, last, first)
+				if err := scheduler.Reschedule(due.ID, *at(time.Hour)); err != ErrNotScheduled {
+					t.Errorf("Reschedule of a due message = %v, want ErrNotScheduled", err)
+				}
+				if err := scheduler.Reschedule("missing", *at(time.Hour)); err != ErrNotScheduled {
+					t.Errorf("Reschedule of an unknown message = %v, want ErrNotScheduled", err)
+				}
+				stored, _ := q.Get(first.ID)
+				if stored.SendAt == nil || !stored.SendAt.Round(time.Second).Equal(at(4*time.Hour).Round(time.Second)) {
+					t.Errorf("rescheduled send_at = %v, want %v", stored.SendAt, at(4*time.Hour))
+				}
+			})
+
+			t.Run("CancelledScheduledMessagesAreRemoved", func(t *testing.T) {
+				q := open(t)
+				scheduler, ok := q.(Scheduler)
+				if !ok {
+					t.Skip("backend has no scheduler")
+				}
+				soon := time.Now().Add(time.Second)
+				cancelled, kept := newTestMessage("ws"), newTestMessage("ws")
+				cancelled.SendAt, kept.SendAt = &soon, &soon
+				q.Enqueue(cancelled)
+				q.Enqueue(kept)
+
+				if err := scheduler.CancelScheduled(cancelled.ID); err != nil {
+					t.Fatalf("CancelScheduled: %v", err)
+				}
+				if _, err := q.Get(cancelled.ID); err == nil {
+					t.Error("cancelled message is still stored")
+				}
+				if err := scheduler.CancelScheduled(cancelled.ID); err != ErrNotScheduled {
+					t.Errorf("second CancelScheduled = %v, want ErrNotScheduled", err)
+				}
+				if err := scheduler.CancelScheduled("missing"); err != ErrNotScheduled {
+					t.Errorf("CancelScheduled of an unknown message = %v, want ErrNotScheduled", err)
+				}
+				if scheduled, _ := scheduler.ListScheduled("", 10); len(scheduled) != 1 || scheduled[0].ID != kept.ID {
+					t.Errorf("ListScheduled after cancelling = %d messages, want only the one kept", len(scheduled))
+				}
+
+				time.Sleep(1500 * time.Millisecond)
+				batch, _ := q.Dequeue(10)
+				if len(batch) != 1 || batch[0].ID != kept.ID {
+					t.Errorf("dequeued %d messages once due, want only the one kept", len(batch))
+				}
+			})
+
+			t.Run("ExpiredLeasesAreReaped", func(t *testing.T) {
+				q := open(t)
+				leaser, ok := q.(Leaser)
+				if !ok {
+					t.Skip("backend does not lease messages")
+				}
+				leaser.SetLeaseDuration(500 * time.Millisecond)
+				msg := newTestMessage("ws")
+				q.Enqueue(msg)
+				q.Dequeue(1)
+
+				if reaped, _ := leaser.ReapExpiredLeases(); reaped != 0 {
+					t.Fatalf("reaped %d messages with a live lease", reaped)
+				}
+				time.Sleep(1500 * time.Millisecond)
+				if reaped, err := leaser.ReapExpiredLeases(); reaped != 1 {
+					t.Fatalf("reaped %d messages, %v; want 1", reaped, err)
+				}
+				if err := leaser.RenewLease(msg.ID); err != ErrLeaseLost {
+					t.Errorf("RenewLease after reaping = %v, want ErrLeaseLost", err)
+				}
+				if batch, _ := q.Dequeue(1); len(batch) != 1 {
+					t.Error("reaped message was not dequeued again")
+				}
+			})
+
+			t.Run("TransitionsNeedTheLease", func(t *testing.T) {
+				q := open(t)
+				leaser, ok := q.(Leaser)
+				if !ok {
+					t.Skip("backend does not lease messages")
+				}
+				leaser.SetLeaseDuration(500 * time.Millisecond)
+				msg := newTestMessage("ws")
+				q.Enqueue(msg)
+
+				// Worker A's lease is reaped and worker B dequeues the message again
+				leaser.SetWorkerID("worker-a")
+				q.Dequeue(1)
+				time.Sleep(1500 * time.Millisecond)
+				if reaped, err := leaser.ReapExpiredLeases(); reaped != 1 {
+					t.Fatalf("reaped %d messages, %v; want 1", reaped, err)
+				}
+				leaser.SetWorkerID("worker-b")
+				if batch, _ := q.Dequeue(1); len(batch) != 1 {
+					t.Fatal("reaped message was not dequeued again")
+				}
+
+				leaser.SetWorkerID("worker-a")
+				later := time.Now().Add(time.Hour)
+				transitions := map[string]func() error{
+					"UpdateStatus": func() error { return q.UpdateStatus(msg.ID, models.StatusExpired, nil) },
+					"UpdateStatusWithProvider": func() error {
+						return q.UpdateStatusWithProvider(msg.ID, models.StatusDead, "ws", fmt.Errorf("bad address"))
+					},
+					"ScheduleRetry": func() error { return q.ScheduleRetry(msg.ID, "ws", later, fmt.Errorf("timeout")) },
+					"Defer": func() error {
+						_, err := q.Defer(msg.ID, later, fmt.Errorf("rate limited"))
+						return err
+					},
+				}
+				if pauser, ok := q.(Pauser); ok {
+					transitions["Hold"] = func() error { return pauser.Hold(msg.ID, later, fmt.Errorf("paused")) }
+				}
+				if tracker, ok := q.(SendTracker); ok {
+					transitions["MarkSending"] = func() error { return tracker.MarkSending(msg.ID, "ws-gmail") }
+					transitions["MarkSent"] = func() error { return tracker.MarkSent(msg.ID, "ws-gmail", "provider-1") }
+				}
+				for name, transition := range transitions {
+					if err := transition(); !errors.Is(err, ErrLeaseLost) {
+						t.Errorf("%s by the worker whose lease was reaped = %v, want ErrLeaseLost", name, err)
+					}
+				}
+
+				stored, err := q.Get(msg.ID)
+				if err != nil {
+					t.Fatalf("Get: %v", err)
+				}
+				if stored.Status != models.StatusProcessing || stored.LockedBy != "worker-b" || stored.RetryCount != 0 || stored.DeferredAt != nil {
+					t.Errorf("message after rejected transitions = %s, locked by %q, retry_count %d; want worker-b's lease untouched",
+						stored.Status, stored.LockedBy, stored.RetryCount)
+				}
+
+				leaser.SetWorkerID("worker-b")
+				if err := q.UpdateStatusWithProvider(msg.ID, models.StatusSent, "ws", nil); err != nil {
+					t.Errorf("UpdateStatusWithProvider by the worker holding the lease: %v", err)
+				}
+			})
+
+			t.Run("InterruptedSendsAreClaimedNotReaped", func(t *testing.T) {
+				q := open(t)
+				tracker, ok := q.(SendTracker)
+				if !ok {
+					t.Skip("backend does not track sends")
+				}
+				leaser := q.(Leaser)
+				leaser.SetLeaseDuration(500 * time.Millisecond)
+				sending, processing := newTestMessage("ws"), newTestMessage("ws")
+				q.Enqueue(sending)
+				q.Enqueue(processing)
+				q.Dequeue(2)
+
+				if err := tracker.MarkSending(sending.ID, "ws-gmail"); err != nil {
+					t.Fatalf("MarkSending: %v", err)
+				}
+				if err := tracker.MarkSending(sending.ID, "ws-mailgun"); err != nil {
+					t.Fatalf("MarkSending on failover: %v", err)
+				}
+				if err := tracker.MarkSending(processing.ID+"-missing", "ws-gmail"); err != ErrLeaseLost {
+					t.Errorf("MarkSending on a missing message = %v, want ErrLeaseLost", err)
+				}
+				if err := leaser.RenewLease(sending.ID); err != nil {
+					t.Errorf("RenewLease while sending: %v", err)
+				}
+				if claimed, _ := tracker.ClaimUnconfirmedSends(10); len(claimed) != 0 {
+					t.Fatalf("claimed %d sends with a live lease", len(claimed))
+				}
+
+				time.Sleep(1500 * time.Millisecond)
+				if reaped, err := leaser.ReapExpiredLeases(); reaped != 1 {
+					t.Fatalf("reaped %d messages, %v; want only the one not yet sending", reaped, err)
+				}
+				claimed, err := tracker.ClaimUnconfirmedSends(10)
+				if err != nil || len(claimed) != 1 || claimed[0].ID != sending.ID || claimed[0].ProviderID != "ws" || claimed[0].SentVia != "ws-mailgun" {
+					t.Fatalf("ClaimUnconfirmedSends = %v, %v; want the sending message", claimed, err)
+				}
+				if again, _ := tracker.ClaimUnconfirmedSends(10); len(again) != 0 {
+					t.Error("a claimed send was claimed again")
+				}
+
+				if err := tracker.MarkSent(sending.ID, "ws-mailgun", "18c2f0a1b2"); err != nil {
+					t.Fatalf("MarkSent: %v", err)
+				}
+				msg, _ := q.Get(sending.ID)
+				if msg.Status != models.StatusSent || msg.ProviderMessageID != "18c2f0a1b2" || msg.LockedBy != "" || msg.RetryCount != 1 {
+					t.Errorf("after MarkSent: status %s, provider message ID %q, locked by %q, retry count %d",
+						msg.Status, msg.ProviderMessageID, msg.LockedBy, msg.RetryCount)
+				}
+			})
+
+			t.Run("WorkersReportStatusAndHeldMessages", func(t *testing.T) {
+				q := open(t)
+				registry, ok := q.(WorkerRegistry)
+				leaser, leases := q.(Leaser)
+				if !ok || !leases {
+					t.Skip("backend does not track workers")
+				}
+				now := time.Now()
+				worker := func(startedAt, heartbeatAt time.Time) WorkerInfo {
+					return WorkerInfo{
+						ID:                       uuid.New().String(),
+						Hostname:                 "relay-test",
+						PID:                      4242,
+						StartedAt:                startedAt,
+						LastHeartbeatAt:          heartbeatAt,
+						HeartbeatIntervalSeconds: 15,
+					}
+				}
+				active := worker(now.Add(-time.Minute), now)
+				silent := worker(now.Add(-2*time.Hour), now.Add(-time.Hour))
+				stopped := worker(now.Add(-3*time.Hour), now)
+				for _, info := range []WorkerInfo{active, silent, stopped} {
+					if err := registry.RegisterWorker(info); err != nil {
+						t.Fatalf("RegisterWorker: %v", err)
+					}
+				}
+				if err := registry.DeregisterWorker(stopped.ID); err != nil {
+					t.Fatalf("DeregisterWorker: %v", err)
+				}
+
+				leaser.SetWorkerID(active.ID)
+				msg := newTestMessage("ws")
+				q.Enqueue(msg)
+				q.Dequeue(1)
+
+				// Other workers may share a database-backed registry, so only ours are checked
+				listed := func() []WorkerInfo {
+					t.Helper()
+					workers, err := registry.ListWorkers()
+					if err != nil {
+						t.Fatalf("ListWorkers: %v", err)
+					}
+					var ours []WorkerInfo
+					for _, info := range workers {
+						if info.ID == active.ID || info.ID == silent.ID || info.ID == stopped.ID {
+							ours = append(ours, info)
+						}
+					}
+					return ours
+				}
+
+				workers := listed()
+				if len(workers) != 3 || workers[0].ID != active.ID || workers[1].ID != silent.ID || workers[2].ID != stopped.ID {
+					t.Fatalf("ListWorkers = %+v, want the 3 workers newest first", workers)
+				}
+				for i, want := range []string{WorkerStatusActive, WorkerStatusUnresponsive, WorkerStatusStopped} {
+					if workers[i].Status != want {
+						t.Errorf("worker %d status = %s, want %s", i, workers[i].Status, want)
+					}
+				}
+				if held := workers[0].HeldMessages; len(held) != 1 || held[0] != msg.ID {
+					t.Errorf("active worker holds %v, want [%s]", held, msg.ID)
+				}
+				if held := workers[1].HeldMessages; held == nil || len(held) != 0 {
+					t.Errorf("unresponsive worker holds %v, want an empty list", held)
+				}
+				if workers[2].StoppedAt == nil || workers[0].Hostname != "relay-test" || workers[0].PID != 4242 {
+					t.Errorf("stopped at %v, hostname %q, pid %d", workers[2].StoppedAt, workers[0].Hostname, workers[0].PID)
+				}
+
+				// A heartbeat revives a silent worker and registering again restarts a stopped one
+				if err := registry.Heartbeat(silent.ID); err != nil {
+					t.Fatalf("Heartbeat: %v", err)
+				}
+				stopped.LastHeartbeatAt = time.Now()
+				if err := registry.RegisterWorker(stopped); err != nil {
+					t.Fatalf("RegisterWorker again: %v", err)
+				}
+				q.UpdateStatus(msg.ID, models.StatusSent, nil)
+				for _, info := range listed() {
+					if info.Status != WorkerStatusActive || len(info.HeldMessages) != 0 {
+						t.Errorf("worker %s = %s holding %v, want active holding nothing", info.ID, info.Status, info.HeldMessages)
+					}
+				}
+			})
+
+			t.Run("EnqueueOnceReturnsTheOriginal", func(t *testing.T) {
+				q := open(t)
+				dedup, ok := q.(Deduplicator)
+				if !ok {
+					t.Skip("backend does not deduplicate")
+				}
+				key := IdempotencyKey("key", "sender@example.com", fmt.Sprintf("retry-%d", time.Now().UnixNano()))
+				first, retry, other := newTestMessage("ws"), newTestMessage("ws"), newTestMessage("ws")
+
+				if id, duplicate, err := dedup.EnqueueOnce(first, key, time.Hour); err != nil || duplicate || id != first.ID {
+					t.Fatalf("first EnqueueOnce = %s, %v, %v", id, duplicate, err)
+				}
+				if id, duplicate, err := dedup.EnqueueOnce(retry, key, time.Hour); err != nil || !duplicate || id != first.ID {
+					t.Fatalf("retry EnqueueOnce = %s, %v, %v; want the first message's ID", id, duplicate, err)
+				}
+				if _, err := q.Get(retry.ID); err == nil {
+					t.Error("retry was queued")
+				}
+				if _, duplicate, _ := dedup.EnqueueOnce(other, key+"-other", time.Hour); duplicate {
+					t.Error("a different key was treated as a duplicate")
+				}
+			})
+
+			t.Run("EnqueueOnceStoresNoBlobsForRetries", func(t *testing.T) {
+				q := open(t)
+				dedup, ok := q.(Deduplicator)
+				storer, stores := q.(AttachmentStorer)
+				if !ok || !stores {
+					t.Skip("backend does not deduplicate or has no attachment store")
+				}
+				store, err := blobstore.NewFileStore(t.TempDir())
+				if err != nil {
+					t.Fatalf("NewFileStore: %v", err)
+				}
+				storer.SetAttachmentStore(store)
+
+				key := IdempotencyKey("key", "sender@example.com", fmt.Sprintf("retry-%d", time.Now().UnixNano()))
+				first, retry := newTestMessage("ws"), newTestMessage("ws")
+				first.Attachments = []models.Attachment{{Name: "a.txt", Content: []byte("first copy")}}
+				retry.Attachments = []models.Attachment{{Name: "a.txt", Content: []byte("edited retry")}}
+
+				if _, duplicate, err := dedup.EnqueueOnce(first, key, time.Hour); err != nil || duplicate {
+					t.Fatalf("first EnqueueOnce = %v, %v", duplicate, err)
+				}
+				if _, duplicate, err := dedup.EnqueueOnce(retry, key, time.Hour); err != nil || !duplicate {
+					t.Fatalf("retry EnqueueOnce = %v, %v", duplicate, err)
+				}
+				if _, err := store.Open(context.Background(), blobstore.Key([]byte("edited retry"))); err != blobstore.ErrNotFound {
+					t.Errorf("the retry's attachment was stored: %v", err)
+				}
+			})
+
+			t.Run("BulkCancelCatchesRetries", func(t *testing.T) {
+				q := open(t)
+				bulk, ok := q.(BulkManager)
+				if !ok {
+					t.Skip("backend has no bulk operations")
+				}
+				retrying, queued := newTestMessage("ws-bulk"), newTestMessage("ws-bulk")
+				q.Enqueue(retrying)
+				q.Dequeue(1)
+				if tracker, ok := q.(SendTracker); ok {
+					tracker.MarkSending(retrying.ID, "gmail-ws-bulk")
+				}
+				q.ScheduleRetry(retrying.ID, "gmail-ws-bulk", time.Now().Add(time.Hour), fmt.Errorf("timeout"))
+				q.Enqueue(queued)
+
+				if n, err := bulk.ApplyBulk(BulkCancel, BulkFilter{WorkspaceID: "ws-bulk"}, time.Time{}); n != 2 {
+					t.Fatalf("cancel by workspace = %d, %v; want the queued message and the one in retry backoff", n, err)
+				}
+				if stored, _ := q.Get(retrying.ID); stored.Status != models.StatusCancelled {
+					t.Errorf("message in retry backoff = %s, want cancelled", stored.Status)
+				}
+			})
+
+			t.Run("BulkOperationsFollowTheFilter", func(t *testing.T) {
+				q := open(t)
+				bulk, ok := q.(BulkManager)
+				if !ok {
+					t.Skip("backend has no bulk operations")
+				}
+				tagged, untagged, elsewhere := newTestMessage("ws-bulk"), newTestMessage("ws-bulk"), newTestMessage("ws-other")
+				tagged.Subject, untagged.Subject, elsewhere.Subject = "Spring campaign", "Spring update", "Spring campaign"
+				tagged.Metadata = map[string]interface{}{"tags": []string{"spring"}}
+				elsewhere.Metadata = map[string]interface{}{"tags": []string{"spring"}}
+				for _, msg := range []*models.Message{tagged, untagged, elsewhere} {
+					q.Enqueue(msg)
+				}
+
+				if n, err := bulk.CountBulk(BulkCancel, BulkFilter{WorkspaceID: "ws-bulk", Subject: "spring*"}); n != 2 {
+					t.Errorf("CountBulk by subject = %d, %v; want 2", n, err)
+				}
+				if _, err := bulk.CountBulk(BulkCancel, BulkFilter{Statuses: []models.MessageStatus{models.StatusSent}}); err == nil {
+					t.Error("cancel accepted sent messages")
+				}
+
+				if n, err := bulk.ApplyBulk(BulkCancel, BulkFilter{WorkspaceID: "ws-bulk", Tag: "spring"}, time.Time{}); n != 1 {
+					t.Fatalf("cancel by tag = %d, %v; want 1", n, err)
+				}
+				if stored, _ := q.Get(tagged.ID); stored.Status != models.StatusCancelled {
+					t.Errorf("cancelled message = %s", stored.Status)
+				}
+				if batch, _ := q.Dequeue(10); len(batch) != 2 {
+					t.Fatalf("dequeued %d messages, want the 2 not cancelled", len(batch))
+				}
+
+				cancelled := BulkFilter{WorkspaceID: "ws-bulk", Statuses: []models.MessageStatus{models.StatusCancelled}}
+				if n, _ := bulk.ApplyBulk(BulkRequeue, cancelled, time.Time{}); n != 1 {
+					t.Fatalf("requeued %d messages, want 1", n)
+				}
+				if n, _ := bulk.ApplyBulk(BulkReschedule, BulkFilter{WorkspaceID: "ws-bulk"}, time.Now().Add(time.Hour)); n != 1 {
+					t.Fatalf("rescheduled %d messages, want 1", n)
+				}
+				if batch, _ := q.Dequeue(10); len(batch) != 0 {
+					t.Fatal("rescheduled message was dequeued before its send_at")
+				}
+
+				// The other messages are being sent and are left alone
+				if n, _ := bulk.ApplyBulk(BulkDelete, BulkFilter{Subject: "Spring*"}, time.Time{}); n != 1 {
+					t.Fatalf("deleted %d messages, want 1", n)
+				}
+				if _, err := q.Get(tagged.ID); err == nil {
+					t.Error("deleted message is still stored")
+				}
+			})
+
+			t.Run("PausesHoldMessagesQueued", func(t *testing.T) {
+				q := open(t)
+				pauser, ok := q.(Pauser)
+				if !ok {
+					t.Skip("backend has no pauses")
+				}
+				msg := newTestMessage("ws-paused")
+				q.Enqueue(msg)
+
+				past := time.Now().Add(-time.Minute)
+				expired := Pause{Scope: PauseSender, Key: "Other@Example.com", PausedAt: past.Add(-time.Hour), ResumeAt: &past}
+				if err := pauser.Pause(expired); err != nil {
+					t.Fatalf("Pause: %v", err)
+				}
+				if err := pauser.Pause(Pause{Scope: PauseGlobal, Reason: "incident", PausedBy: "test", PausedAt: time.Now()}); err != nil {
+					t.Fatalf("Pause: %v", err)
+				}
+				defer pauser.Resume(PauseGlobal, "")
+
+				if pauses, err := pauser.ListPauses(); err != nil || len(pauses) != 1 || pauses[0].Scope != PauseGlobal || pauses[0].Reason != "incident" {
+					t.Fatalf("ListPauses = %+v, %v; want only the global pause", pauses, err)
+				}
+				if batch, _ := q.Dequeue(10); len(batch) != 0 {
+					t.Fatalf("dequeued %d messages while paused", len(batch))
+				}
+				if stored, _ := q.Get(msg.ID); stored.Status != models.StatusQueued {
+					t.Errorf("paused message = %s, want queued", stored.Status)
+				}
+
+				if resumed, err := pauser.Resume(PauseGlobal, ""); err != nil || !resumed {
+					t.Fatalf("Resume = %v, %v", resumed, err)
+				}
+				if resumed, _ := pauser.Resume(PauseGlobal, ""); resumed {
+					t.Error("resumed a pause twice")
+				}
+				if batch, _ := q.Dequeue(10); len(batch) != 1 {
+					t.Fatalf("dequeued %d messages after resuming, want 1", len(batch))
+				}
+			})
+
+			t.Run("ExpiredMessagesAreSweptWithoutDequeue", func(t *testing.T) {
+				q := open(t)
+				expirer, ok := q.(Expirer)
+				if !ok {
+					t.Skip("backend does not expire messages")
+				}
+				past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+
+				leased, retried := newTestMessage("ws"), newTestMessage("ws")
+				for _, msg := range []*models.Message{leased, retried} {
+					msg.ExpiresAt = &past
+					q.Enqueue(msg)
+				}
+				if batch, _ := q.Dequeue(10); len(batch) != 2 {
+					t.Fatalf("dequeued %d messages, want 2", len(batch))
+				}
+				if err := q.ScheduleRetry(retried.ID, "ws", future, fmt.Errorf("timeout")); err != nil {
+					t.Fatalf("ScheduleRetry: %v", err)
+				}
+
+				expired, waiting, scheduled := newTestMessage("ws"), newTestMessage("ws"), newTestMessage("ws")
+				expired.ExpiresAt = &past
+				waiting.ExpiresAt = &future
+				scheduled.SendAt = &future
+				scheduled.ExpiresAt = &past
+				for _, msg := range []*models.Message{expired, waiting, scheduled} {
+					q.Enqueue(msg)
+				}
+
+				swept, err := expirer.ExpireDue(10)
+				if err != nil {
+					t.Fatalf("ExpireDue: %v", err)
+				}
+				got := make(map[string]bool)
+				for _, msg := range swept {
+					if msg.Status != models.StatusExpired {
+						t.Errorf("swept message %s has status %s", msg.ID, msg.Status)
+					}
+					got[msg.ID] = true
+				}
+				for _, msg := range []*models.Message{expired, retried, scheduled} {
+					if !got[msg.ID] {
+						t.Errorf("message %s past its expires_at was not swept", msg.ID)
+					}
+				}
+				if got[leased.ID] || got[waiting.ID] {
+					t.Error("swept a leased message or one not yet expired")
+				}
+
+				stored, _ := q.Get(retried.ID)
+				if stored.Status != models.StatusExpired || stored.NextAttemptAt != nil || stored.ProcessedAt == nil || stored.Error != stored.ExpiryError().Error() {
+					t.Errorf("swept retry = %s, next attempt %v, error %q", stored.Status, stored.NextAttemptAt, stored.Error)
+				}
+				if stored, _ := q.Get(leased.ID); stored.Status != models.StatusProcessing {
+					t.Errorf("leased message = %s, want it left processing", stored.Status)
+				}
+				if again, _ := expirer.ExpireDue(10); len(again) != 0 {
+					t.Errorf("swept %d messages twice", len(again))
+				}
+			})
+
+			t.Run("RemovedMessagesAreGone", func(t *testing.T) {
+				q := open(t)
+				msg := newTestMessage("ws")
+				q.Enqueue(msg)
+				if err := q.Remove(msg.ID); err != nil {
+					t.Fatalf("Remove: %v", err)
+				}
+				if _, err := q.Get(msg.ID); err == nil {
+					t.Error("removed message is still stored")
+				}
+				if batch, _ := q.Dequeue(1); len(batch) != 0 {
+					t.Error("removed message was dequeued")
+				}
+			})
+		})
+	}
+}
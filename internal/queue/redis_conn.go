@@ -0,0 +1,275 @@
+package queue
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisError is an error reply from the server, such as BUSYGROUP or WRONGTYPE
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// errRedisNil is returned by helpers that expect a value when the reply is nil
+var errRedisNil = errors.New("redis: nil reply")
+
+// redisConn is one connection speaking RESP2. Replies decode to string (status),
+// []byte (bulk), int64, []interface{} or nil; error replies become redisError.
+type redisConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+	broken  bool
+}
+
+// do sends one command and reads its reply
+func (c *redisConn) do(args ...interface{}) (interface{}, error) {
+	replies, err := c.pipeline([][]interface{}{args})
+	if err != nil {
+		return nil, err
+	}
+	if replyErr, ok := replies[0].(redisError); ok {
+		return nil, replyErr
+	}
+	return replies[0], nil
+}
+
+// pipeline sends every command before reading the replies. Error replies are
+// returned in place; only connection failures fail the call.
+func (c *redisConn) pipeline(cmds [][]interface{}) ([]interface{}, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	for _, args := range cmds {
+		if err := c.write(args); err != nil {
+			c.broken = true
+			return nil, err
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		c.broken = true
+		return nil, fmt.Errorf("redis write failed: %w", err)
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, err := c.read()
+		if err != nil {
+			c.broken = true
+			return nil, fmt.Errorf("redis read failed: %w", err)
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+func (c *redisConn) write(args []interface{}) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var b []byte
+		switch v := arg.(type) {
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		case int:
+			b = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			b = strconv.AppendInt(nil, v, 10)
+		case float64:
+			b = strconv.AppendFloat(nil, v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("redis: unsupported argument type %T", arg)
+		}
+		fmt.Fprintf(c.w, "$%d\r\n", len(b))
+		c.w.Write(b)
+		c.w.WriteString("\r\n")
+	}
+	return nil
+}
+
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+}
+
+// redisPool hands out connections to one server, keeping up to size idle ones
+type redisPool struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	idle     chan *redisConn
+}
+
+func newRedisPool(addr, password string, db, size int) *redisPool {
+	if size <= 0 {
+		size = 10
+	}
+	return &redisPool{
+		addr:     addr,
+		password: password,
+		db:       db,
+		timeout:  10 * time.Second,
+		idle:     make(chan *redisConn, size),
+	}
+}
+
+func (p *redisPool) get() (*redisConn, error) {
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+
+	netConn, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", p.addr, err)
+	}
+	c := &redisConn{conn: netConn, r: bufio.NewReader(netConn), w: bufio.NewWriter(netConn), timeout: p.timeout}
+
+	if p.password != "" {
+		if _, err := c.do("AUTH", p.password); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("redis AUTH failed: %w", err)
+		}
+	}
+	if p.db != 0 {
+		if _, err := c.do("SELECT", p.db); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("redis SELECT failed: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// put returns a connection to the pool, closing it if it failed or the pool is full
+func (p *redisPool) put(c *redisConn) {
+	if c.broken {
+		c.conn.Close()
+		return
+	}
+	select {
+	case p.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// do runs one command on a pooled connection
+func (p *redisPool) do(args ...interface{}) (interface{}, error) {
+	c, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	defer p.put(c)
+	return c.do(args...)
+}
+
+// pipeline runs commands on one pooled connection
+func (p *redisPool) pipeline(cmds [][]interface{}) ([]interface{}, error) {
+	c, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	defer p.put(c)
+	return c.pipeline(cmds)
+}
+
+func (p *redisPool) close() {
+	for {
+		select {
+		case c := <-p.idle:
+			c.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// redisInt converts an integer reply
+func redisInt(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	case nil:
+		return 0, errRedisNil
+	}
+	return 0, fmt.Errorf("redis: unexpected integer reply %T", reply)
+}
+
+// redisStrings converts an array reply of bulk strings; nil elements become ""
+func redisStrings(reply interface{}, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		if reply == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("redis: unexpected array reply %T", reply)
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		switch v := item.(type) {
+		case []byte:
+			values[i] = string(v)
+		case string:
+			values[i] = v
+		case int64:
+			values[i] = strconv.FormatInt(v, 10)
+		}
+	}
+	return values, nil
+}
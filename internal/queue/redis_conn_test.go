@@ -0,0 +1,158 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// respConn returns a redisConn whose server side is served by handle
+func respConn(t *testing.T, handle func(r *bufio.Reader, w io.Writer)) *redisConn {
+	t.Helper()
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		handle(bufio.NewReader(server), server)
+	}()
+	t.Cleanup(func() { client.Close() })
+	return &redisConn{conn: client, r: bufio.NewReader(client), w: bufio.NewWriter(client), timeout: time.Second}
+}
+
+// readCommand reads one command as the client writes it: an array of bulk strings
+func readCommand(r *bufio.Reader) []string {
+	c := &redisConn{r: r}
+	reply, err := c.read()
+	if err != nil {
+		return nil
+	}
+	args, _ := redisStrings(reply, nil)
+	return args
+}
+
+func TestRedisConnWrite(t *testing.T) {
+	var buf bytes.Buffer
+	c := &redisConn{w: bufio.NewWriter(&buf)}
+	if err := c.write([]interface{}{"SET", []byte("k\r\n"), 42, int64(-7), 1.5}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	c.w.Flush()
+
+	want := "*5\r\n$3\r\nSET\r\n$3\r\nk\r\n\r\n$2\r\n42\r\n$2\r\n-7\r\n$3\r\n1.5\r\n"
+	if buf.String() != want {
+		t.Errorf("write = %q, want %q", buf.String(), want)
+	}
+
+	if err := c.write([]interface{}{"SET", true}); err == nil {
+		t.Error("write accepted an unsupported argument type")
+	}
+}
+
+func TestRedisConnRead(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  interface{}
+	}{
+		{"+OK\r\n", "OK"},
+		{"-WRONGTYPE bad key\r\n", redisError("WRONGTYPE bad key")},
+		{":-12\r\n", int64(-12)},
+		{"$5\r\nhe\r\nl\r\n", []byte("he\r\nl")},
+		{"$0\r\n\r\n", []byte{}},
+		{"$-1\r\n", nil},
+		{"*-1\r\n", nil},
+		{"*0\r\n", []interface{}{}},
+		{"*3\r\n$1\r\na\r\n:1\r\n*1\r\n$-1\r\n", []interface{}{[]byte("a"), int64(1), []interface{}{nil}}},
+	}
+	for _, tt := range tests {
+		c := &redisConn{r: bufio.NewReader(strings.NewReader(tt.reply))}
+		got, err := c.read()
+		if err != nil {
+			t.Errorf("read(%q): %v", tt.reply, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("read(%q) = %#v, want %#v", tt.reply, got, tt.want)
+		}
+	}
+
+	for _, malformed := range []string{"\r\n", "?what\r\n", ":abc\r\n", "$5\r\nab\r\n", "*2\r\n+OK\r\n"} {
+		c := &redisConn{r: bufio.NewReader(strings.NewReader(malformed))}
+		if _, err := c.read(); err == nil {
+			t.Errorf("read(%q) accepted a malformed reply", malformed)
+		}
+	}
+}
+
+func TestRedisConnPipeline(t *testing.T) {
+	c := respConn(t, func(r *bufio.Reader, w io.Writer) {
+		// Both commands arrive before any reply is written
+		for i := 0; i < 2; i++ {
+			readCommand(r)
+		}
+		io.WriteString(w, "+PONG\r\n-ERR unknown command\r\n")
+	})
+
+	replies, err := c.pipeline([][]interface{}{{"PING"}, {"NOPE", "x"}})
+	if err != nil {
+		t.Fatalf("pipeline: %v", err)
+	}
+	if replies[0] != "PONG" {
+		t.Errorf("first reply = %#v, want PONG", replies[0])
+	}
+	if replies[1] != redisError("ERR unknown command") {
+		t.Errorf("second reply = %#v, want the error in place", replies[1])
+	}
+	if c.broken {
+		t.Error("an error reply marked the connection broken")
+	}
+}
+
+func TestRedisConnDo(t *testing.T) {
+	c := respConn(t, func(r *bufio.Reader, w io.Writer) {
+		readCommand(r)
+		io.WriteString(w, "-BUSYGROUP exists\r\n")
+	})
+	_, err := c.do("XGROUP")
+	var replyErr redisError
+	if !errors.As(err, &replyErr) || !strings.HasPrefix(string(replyErr), "BUSYGROUP") {
+		t.Errorf("do error = %v, want the BUSYGROUP reply", err)
+	}
+
+	closed := respConn(t, func(r *bufio.Reader, w io.Writer) {
+		readCommand(r)
+	})
+	if _, err := closed.do("PING"); err == nil || !closed.broken {
+		t.Errorf("do on a closed connection = %v, broken %v", err, closed.broken)
+	}
+}
+
+func TestRedisReplyConversions(t *testing.T) {
+	if n, err := redisInt(int64(3), nil); n != 3 || err != nil {
+		t.Errorf("redisInt(3) = %d, %v", n, err)
+	}
+	if n, err := redisInt([]byte("17"), nil); n != 17 || err != nil {
+		t.Errorf("redisInt(\"17\") = %d, %v", n, err)
+	}
+	if _, err := redisInt(nil, nil); err != errRedisNil {
+		t.Errorf("redisInt(nil) error = %v, want errRedisNil", err)
+	}
+	if _, err := redisInt("OK", nil); err == nil {
+		t.Error("redisInt accepted a status reply")
+	}
+
+	got, err := redisStrings([]interface{}{[]byte("a"), "b", int64(4), nil}, nil)
+	if err != nil || !reflect.DeepEqual(got, []string{"a", "b", "4", ""}) {
+		t.Errorf("redisStrings = %q, %v", got, err)
+	}
+	if got, err := redisStrings(nil, nil); got != nil || err != nil {
+		t.Errorf("redisStrings(nil) = %q, %v", got, err)
+	}
+	if _, err := redisStrings(int64(1), nil); err == nil {
+		t.Error("redisStrings accepted an integer reply")
+	}
+}
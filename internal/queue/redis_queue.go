@@ -0,0 +1,1949 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"relay/internal/blobstore"
+	"relay/internal/config"
+	"relay/pkg/models"
+)
+
+// redisGroup is the consumer group every relay replica reads the lane streams with
+const redisGroup = "relay"
+
+// pushLua defines push, which appends a message to the stream of its lane,
+// workspace and sender. A new stream is created with its consumer group and
+// added to the lane's stream index. The stream and entry ID are recorded on the
+// message, so entries left behind by an earlier delivery can be told apart.
+const pushLua = `
+local function push(stream, streams, key, id, owner)
+  if redis.call('EXISTS', stream) == 0 then
+    redis.call('XGROUP', 'CREATE', stream, '` + redisGroup + `', '0', 'MKSTREAM')
+  end
+  local entry = redis.call('XADD', stream, '*', 'id', id)
+  redis.call('HSET', key, 'entry', entry, 'stream', stream)
+  redis.call('HSET', streams, stream, owner)
+  return entry
+end
+`
+
+// pushScript puts a due message in line. KEYS are its stream, the lane's stream
+// index and the message hash; ARGV are the message ID and its stream's owner.
+const pushScript = pushLua + `return push(KEYS[1], KEYS[2], KEYS[3], ARGV[1], ARGV[2])`
+
+// promoteScript moves messages whose time has come from a lane's delayed set to
+// their streams, named from ARGV[4] and the workspace and sender on the message.
+// ZREM and XADD happen together, so each message is promoted once even when
+// several replicas promote at the same time.
+const promoteScript = pushLua + `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+  redis.call('ZREM', KEYS[1], id)
+  local key = ARGV[3] .. id
+  local owner = redis.call('HMGET', key, 'workspace', 'from')
+  if owner[1] then
+    local from = owner[2] or ''
+    push(ARGV[4] .. owner[1] .. ':' .. from, KEYS[2], key, id, owner[1] .. '\n' .. from)
+  end
+end
+return #ids`
+
+// dropStreamScript deletes a stream that has been emptied and takes it out of
+// the lane's stream index; a push in the meantime keeps it
+const dropStreamScript = `
+if redis.call('XLEN', KEYS[1]) == 0 then
+  redis.call('DEL', KEYS[1])
+  redis.call('HDEL', KEYS[2], KEYS[1])
+  return 1
+end
+return 0`
+
+// releaseKeyScript deletes an idempotency key only while it still names the
+// message that failed to enqueue
+const releaseKeyScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0`
+
+// retainBlobsScript counts references to blobs, unless the collector is deleting
+// one of them. KEYS are the reference counts, the blobs being collected and the
+// released blobs; ARGV is the time in milliseconds, then blob keys and counts.
+const retainBlobsScript = `
+for i = 2, #ARGV, 2 do
+  local busy = redis.call('HGET', KEYS[2], ARGV[i])
+  if busy and tonumber(busy) > tonumber(ARGV[1]) then
+    return 0
+  end
+end
+for i = 2, #ARGV, 2 do
+  redis.call('HINCRBY', KEYS[1], ARGV[i], ARGV[i + 1])
+  redis.call('ZREM', KEYS[3], ARGV[i])
+end
+return 1`
+
+// claimBlobScript marks an unreferenced blob as being collected until ARGV[3],
+// which makes retainBlobsScript wait. A blob referenced again is dropped from
+// the released set instead.
+const claimBlobScript = `
+if tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0') > 0 then
+  redis.call('ZREM', KEYS[3], ARGV[1])
+  return 0
+end
+local busy = redis.call('HGET', KEYS[2], ARGV[1])
+if busy and tonumber(busy) > tonumber(ARGV[2]) then
+  return 0
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+return 1`
+
+// forgetBlobScript drops a collected blob's count and ends its collection
+const forgetBlobScript = `
+if tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0') <= 0 then
+  redis.call('HDEL', KEYS[1], ARGV[1])
+  redis.call('ZREM', KEYS[3], ARGV[1])
+end
+return redis.call('HDEL', KEYS[2], ARGV[1])`
+
+// blobClaim is how long a blob stays marked as being collected; a collector
+// that dies part way holds up new references to the blob for at most this long
+const blobClaim = time.Minute
+
+var (
+	errMessageNotFound = errors.New("message not found")
+	errRedisConflict   = errors.New("message changed during update")
+	errStaleEntry      = errors.New("stream entry is no longer current")
+	errSkipMessage     = errors.New("message skipped")
+)
+
+// redisRecord is a message as stored in its hash: the message itself and its
+// current stream and entry ID, empty while it waits in the delayed set or has
+// left the queue
+type redisRecord struct {
+	msg     *models.Message
+	stream  string
+	entry   string
+	deleted bool
+	blobs   []string // Blob keys the stored message references
+}
+
+// RedisQueue keeps messages on Redis Streams, taking queue traffic off MySQL.
+//
+// Each priority lane has a stream per workspace and sender, read by the "relay"
+// consumer group and listed in the lane's stream index. A message is a hash
+// holding its JSON; messages waiting for send_at, a retry or a deferral sit in
+// the lane's delayed sorted set, scored by when they are due, and are moved to
+// their stream by Dequeue. An entry stays pending until the message is sent,
+// rescheduled or removed, and ReapExpiredLeases reclaims the pending entries of
+// crashed consumers once their lease has run out.
+//
+// Batches are shared between lanes by weight. Within a lane Dequeue counts what
+// each stream has due and shares the lane's slots between workspaces and
+// senders like the SQL and memory queues, then reads each share oldest first.
+type RedisQueue struct {
+	pool          *redisPool
+	prefix        string
+	workerID      string
+	leaseDuration time.Duration
+	lanes         *laneScheduler
+	fair          *fairShare
+	blobs         attachmentBlobs
+}
+
+// NewRedisQueue connects to Redis. Streams and their consumer group are created
+// as messages are pushed to them.
+func NewRedisQueue(cfg *config.RedisConfig) (*RedisQueue, error) {
+	if cfg == nil || cfg.Addr == "" {
+		return nil, fmt.Errorf("redis address is not configured")
+	}
+
+	q := &RedisQueue{
+		pool:          newRedisPool(cfg.Addr, cfg.Password, cfg.DB, cfg.PoolSize),
+		prefix:        cfg.KeyPrefix,
+		workerID:      DefaultWorkerID(),
+		leaseDuration: DefaultLeaseDuration,
+		lanes:         newLaneScheduler(),
+		fair:          newFairShare(),
+	}
+
+	if _, err := q.pool.do("PING"); err != nil {
+		q.pool.close()
+		return nil, fmt.Errorf("failed to ping redis: %w", err)
+	}
+
+	log.Printf("Redis queue connected to %s", cfg.Addr)
+	return q, nil
+}
+
+func (q *RedisQueue) messageKey(id string) string  { return q.prefix + "msg:" + id }
+func (q *RedisQueue) attemptsKey(id string) string { return q.prefix + "attempts:" + id }
+func (q *RedisQueue) statusKey(status models.MessageStatus) string {
+	return q.prefix + "status:" + string(status)
+}
+
+// streamKey names the stream of a lane's messages from one sender in one
+// workspace; promoteScript builds the same name from streamPrefix
+func (q *RedisQueue) streamKey(lane models.MessagePriority, workspaceID, sender string) string {
+	return q.streamPrefix(lane) + workspaceID + ":" + sender
+}
+func (q *RedisQueue) streamPrefix(lane models.MessagePriority) string {
+	return q.prefix + "stream:" + string(lane.Lane()) + ":"
+}
+
+// streamsKey is the lane's stream index, mapping each stream to its owner
+func (q *RedisQueue) streamsKey(lane models.MessagePriority) string {
+	return q.prefix + "streams:" + string(lane.Lane())
+}
+func (q *RedisQueue) delayedKey(lane models.MessagePriority) string {
+	return q.prefix + "delayed:" + string(lane.Lane())
+}
+func (q *RedisQueue) indexKey() string          { return q.prefix + "messages" }
+func (q *RedisQueue) sentKey() string           { return q.prefix + "sent" }
+func (q *RedisQueue) expiresKey() string        { return q.prefix + "expires" }
+func (q *RedisQueue) workersKey() string        { return q.prefix + "workers" }
+func (q *RedisQueue) idemKey(key string) string { return q.prefix + "idem:" + key }
+func (q *RedisQueue) pausesKey() string         { return q.prefix + "pauses" }
+func (q *RedisQueue) blobKeys() []interface{} {
+	return []interface{}{q.prefix + "blobs:refs", q.prefix + "blobs:collecting", q.prefix + "blobs:released"}
+}
+func (q *RedisQueue) archiveClaimKey(id string) string {
+	return q.prefix + "archiving:" + id
+}
+
+// score orders sorted sets by time in milliseconds
+func score(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+// load reads a message hash on c
+func (q *RedisQueue) load(c *redisConn, id string) (*redisRecord, error) {
+	values, err := redisStrings(c.do("HMGET", q.messageKey(id), "message", "raw", "stream", "entry"))
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 4 || values[0] == "" {
+		return nil, fmt.Errorf("%w: %s", errMessageNotFound, id)
+	}
+
+	msg := &models.Message{}
+	if err := json.Unmarshal([]byte(values[0]), msg); err != nil {
+		return nil, fmt.Errorf("failed to decode message %s: %w", id, err)
+	}
+	if values[1] != "" {
+		msg.RawMessage = []byte(values[1])
+	}
+	return &redisRecord{msg: msg, stream: values[2], entry: values[3], blobs: blobKeys(nil, msg.Attachments)}, nil
+}
+
+// saveCmds writes rec back and moves it between the status and sent indexes
+func (q *RedisQueue) saveCmds(rec *redisRecord, previous models.MessageStatus) ([][]interface{}, error) {
+	msg := rec.msg
+	if rec.deleted {
+		cmds := [][]interface{}{
+			{"DEL", q.messageKey(msg.ID), q.attemptsKey(msg.ID)},
+			{"ZREM", q.indexKey(), msg.ID},
+			{"ZREM", q.statusKey(previous), msg.ID},
+			{"ZREM", q.sentKey(), msg.ID},
+			{"ZREM", q.delayedKey(msg.Priority), msg.ID},
+			{"ZREM", q.expiresKey(), msg.ID},
+		}
+		cmds = append(cmds, q.releaseCmds(rec.blobs)...)
+		return append(cmds, q.ackCmds(rec)...), nil
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message %s: %w", msg.ID, err)
+	}
+	cmds := [][]interface{}{
+		{"HSET", q.messageKey(msg.ID), "message", data, "raw", msg.RawMessage, "stream", rec.stream, "entry", rec.entry,
+			"workspace", msg.ProviderID, "from", msg.From},
+		{"ZADD", q.indexKey(), score(msg.QueuedAt), msg.ID},
+	}
+	if previous != msg.Status {
+		if previous != "" {
+			cmds = append(cmds, []interface{}{"ZREM", q.statusKey(previous), msg.ID})
+		}
+		cmds = append(cmds, []interface{}{"ZADD", q.statusKey(msg.Status), score(msg.QueuedAt), msg.ID})
+	}
+	if msg.Status == models.StatusSent && msg.ProcessedAt != nil {
+		cmds = append(cmds, []interface{}{"ZADD", q.sentKey(), score(*msg.ProcessedAt), msg.ID})
+	}
+	if msg.ExpiresAt != nil && (msg.Status == models.StatusQueued || msg.Status == models.StatusFailed) {
+		cmds = append(cmds, []interface{}{"ZADD", q.expiresKey(), score(*msg.ExpiresAt), msg.ID})
+	} else if msg.ExpiresAt != nil {
+		cmds = append(cmds, []interface{}{"ZREM", q.expiresKey(), msg.ID})
+	}
+	return append(cmds, q.releaseCmds(droppedKeys(rec.blobs, blobKeys(nil, msg.Attachments)))...), nil
+}
+
+// ackCmds acknowledges and deletes the message's stream entry
+func (q *RedisQueue) ackCmds(rec *redisRecord) [][]interface{} {
+	if rec.entry == "" {
+		return nil
+	}
+	cmds := [][]interface{}{
+		{"XACK", rec.stream, redisGroup, rec.entry},
+		{"XDEL", rec.stream, rec.entry},
+	}
+	rec.stream, rec.entry = "", ""
+	return cmds
+}
+
+// waitCmds puts a waiting message back in line: on the stream when it is due
+// and in the delayed set until then
+func (q *RedisQueue) waitCmds(rec *redisRecord, now time.Time) [][]interface{} {
+	msg := rec.msg
+	var dueAt time.Time
+	if msg.SendAt != nil && msg.Status == models.StatusQueued {
+		dueAt = *msg.SendAt
+	}
+	if msg.NextAttemptAt != nil && msg.NextAttemptAt.After(dueAt) {
+		dueAt = *msg.NextAttemptAt
+	}
+
+	if dueAt.After(now) {
+		return [][]interface{}{{"ZADD", q.delayedKey(msg.Priority), score(dueAt), msg.ID}}
+	}
+	return [][]interface{}{
+		{"ZREM", q.delayedKey(msg.Priority), msg.ID},
+		{"EVAL", pushScript, 3, q.streamKey(msg.Priority, msg.ProviderID, msg.From), q.streamsKey(msg.Priority), q.messageKey(msg.ID),
+			msg.ID, msg.ProviderID + "\n" + msg.From},
+	}
+}
+
+// update applies fn to a message and writes the result, along with the commands
+// fn returns, in one transaction. WATCH makes the write fail if another client
+// changed the message in between, in which case fn runs again on the new state.
+func (q *RedisQueue) update(id string, fn func(rec *redisRecord) ([][]interface{}, error)) error {
+	c, err := q.pool.get()
+	if err != nil {
+		return err
+	}
+	defer q.pool.put(c)
+
+	for try := 0; try < 10; try++ {
+		if _, err := c.do("WATCH", q.messageKey(id)); err != nil {
+			return err
+		}
+
+		rec, err := q.load(c, id)
+		if err != nil {
+			c.do("UNWATCH")
+			return err
+		}
+		previous := rec.msg.Status
+
+		extra, err := fn(rec)
+		if err != nil {
+			c.do("UNWATCH")
+			return err
+		}
+		cmds, err := q.saveCmds(rec, previous)
+		if err != nil {
+			c.do("UNWATCH")
+			return err
+		}
+
+		err = execTx(c, append(cmds, extra...))
+		if err == errRedisConflict {
+			continue // Changed by another client; try again
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update message %s: %w", id, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("message %s: %w", id, errRedisConflict)
+}
+
+// updateHeld is update for a message this worker holds the lease on. A message
+// whose lease was reaped, and possibly claimed by another worker, is ErrLeaseLost.
+func (q *RedisQueue) updateHeld(id string, fn func(rec *redisRecord) ([][]interface{}, error)) error {
+	err := q.update(id, func(rec *redisRecord) ([][]interface{}, error) {
+		if !isLeased(rec.msg) || rec.msg.LockedBy != q.workerID {
+			return nil, ErrLeaseLost
+		}
+		return fn(rec)
+	})
+	if err != nil && errors.Is(err, errMessageNotFound) {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// execTx runs cmds in a MULTI/EXEC transaction on c. It returns errRedisConflict
+// when a key watched on c changed before EXEC.
+func execTx(c *redisConn, cmds [][]interface{}) error {
+	tx := append([][]interface{}{{"MULTI"}}, cmds...)
+	replies, err := c.pipeline(append(tx, []interface{}{"EXEC"}))
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies[:len(replies)-1] {
+		if replyErr, ok := reply.(redisError); ok {
+			return replyErr
+		}
+	}
+
+	switch exec := replies[len(replies)-1].(type) {
+	case nil:
+		return errRedisConflict
+	case redisError:
+		return exec
+	case []interface{}:
+		for _, reply := range exec {
+			if replyErr, ok := reply.(redisError); ok {
+				return replyErr
+			}
+		}
+	}
+	return nil
+}
+
+func (q *RedisQueue) Enqueue(message *models.Message) error {
+	return q.storeBlobs(message.Attachments, func() error {
+		return q.insert(message)
+	})
+}
+
+// insert saves a new message, along with extra commands, and puts it in line
+// when it is due
+func (q *RedisQueue) insert(message *models.Message, extra ...[]interface{}) error {
+	rec := &redisRecord{msg: message}
+	cmds, err := q.saveCmds(rec, "")
+	if err != nil {
+		return err
+	}
+	cmds = append(cmds, extra...)
+	if message.Status == models.StatusQueued || message.Status == models.StatusFailed {
+		cmds = append(cmds, q.waitCmds(rec, time.Now())...)
+	}
+
+	c, err := q.pool.get()
+	if err != nil {
+		return err
+	}
+	defer q.pool.put(c)
+
+	if err := execTx(c, cmds); err != nil {
+		return fmt.Errorf("failed to enqueue message: %w", err)
+	}
+	return nil
+}
+
+// EnqueueOnce claims key with SET NX, which expires it after window, before
+// enqueueing the message
+func (q *RedisQueue) EnqueueOnce(message *models.Message, key string, window time.Duration) (string, bool, error) {
+	idemKey := q.idemKey(key)
+	for {
+		reply, err := q.pool.do("SET", idemKey, message.ID, "NX", "PX", window.Milliseconds())
+		if err != nil {
+			return "", false, fmt.Errorf("failed to claim idempotency key: %w", err)
+		}
+		if reply != nil {
+			break
+		}
+		holder, err := q.pool.do("GET", idemKey)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read idempotency key: %w", err)
+		}
+		if id, ok := holder.([]byte); ok {
+			return string(id), true, nil
+		}
+		// The key expired between the two calls
+	}
+
+	if err := q.Enqueue(message); err != nil {
+		if _, releaseErr := q.pool.do("EVAL", releaseKeyScript, 1, idemKey, message.ID); releaseErr != nil {
+			log.Printf("Warning: Failed to release idempotency key of message %s: %v", message.ID, releaseErr)
+		}
+		return "", false, err
+	}
+	return message.ID, false, nil
+}
+
+// Dequeue claims up to batchSize due messages for this worker, split across the
+// priority lanes by weight. The consumer group hands each stream entry to one
+// replica only. Nothing is claimed under a global pause; messages of paused
+// workspaces and senders are claimed and parked by the processor.
+func (q *RedisQueue) Dequeue(batchSize int) ([]*models.Message, error) {
+	now := time.Now()
+	pauses, err := q.ListPauses()
+	if err != nil {
+		return nil, err
+	}
+	paused := NewPauseSet(pauses, now)
+	if paused.Global() != nil {
+		return nil, nil
+	}
+	for _, lane := range models.Priorities {
+		if err := q.promote(lane, now); err != nil {
+			return nil, err
+		}
+	}
+
+	// Claimed entries are delivered, so taken never needs checking here
+	return q.lanes.dequeue(batchSize, func(lane models.MessagePriority, limit int, taken []string) ([]*models.Message, error) {
+		return q.dequeueLane(lane, limit, paused)
+	})
+}
+
+// promote moves due messages from a lane's delayed set to its stream
+func (q *RedisQueue) promote(lane models.MessagePriority, now time.Time) error {
+	for {
+		moved, err := redisInt(q.pool.do("EVAL", promoteScript, 2, q.delayedKey(lane), q.streamsKey(lane),
+			score(now), 500, q.prefix+"msg:", q.streamPrefix(lane)))
+		if err != nil {
+			return fmt.Errorf("failed to promote delayed messages: %w", err)
+		}
+		if moved < 500 {
+			return nil
+		}
+	}
+}
+
+// streamEntry is one entry read from a lane stream
+type streamEntry struct {
+	Stream    string
+	ID        string
+	MessageID string
+}
+
+// parseStreamEntries reads the entries of stream from an XREADGROUP, XRANGE or
+// XAUTOCLAIM reply
+func parseStreamEntries(stream string, reply interface{}) []streamEntry {
+	items, _ := reply.([]interface{})
+	entries := make([]streamEntry, 0, len(items))
+	for _, item := range items {
+		pair, ok := item.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		id, _ := pair[0].([]byte)
+		fields, _ := redisStrings(pair[1], nil)
+		entry := streamEntry{Stream: stream, ID: string(id)}
+		for i := 0; i+1 < len(fields); i += 2 {
+			if fields[i] == "id" {
+				entry.MessageID = fields[i+1]
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// parseReadGroup reads the entries of the single stream an XREADGROUP read
+func parseReadGroup(stream string, reply interface{}) []streamEntry {
+	if streams, ok := reply.([]interface{}); ok && len(streams) > 0 {
+		if pair, ok := streams[0].([]interface{}); ok && len(pair) == 2 {
+			return parseStreamEntries(stream, pair[1])
+		}
+	}
+	return nil
+}
+
+// streamReplyErr returns an error reply other than NOGROUP, which only means
+// the stream was emptied and dropped since it was listed
+func streamReplyErr(reply interface{}) error {
+	if err, ok := reply.(redisError); ok && !strings.HasPrefix(string(err), "NOGROUP") {
+		return err
+	}
+	return nil
+}
+
+// laneStream is one stream of a lane and what it has due: entries that have not
+// been delivered to any consumer yet
+type laneStream struct {
+	Key string
+	Due dueGroup
+}
+
+// laneStreams lists the streams of a lane with what each has due. Streams left
+// empty are dropped from the index on the way.
+func (q *RedisQueue) laneStreams(lane models.MessagePriority) ([]laneStream, error) {
+	index, err := redisStrings(q.pool.do("HGETALL", q.streamsKey(lane)))
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []laneStream
+	var cmds [][]interface{}
+	for i := 0; i+1 < len(index); i += 2 {
+		workspaceID, sender, _ := strings.Cut(index[i+1], "\n")
+		streams = append(streams, laneStream{Key: index[i], Due: dueGroup{WorkspaceID: workspaceID, Sender: sender}})
+		cmds = append(cmds, []interface{}{"XLEN", index[i]}, []interface{}{"XPENDING", index[i], redisGroup})
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	replies, err := q.pool.pipeline(cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := streams[:0]
+	for i, stream := range streams {
+		length, _ := redisInt(replies[2*i], nil)
+		if length == 0 {
+			if _, err := q.pool.do("EVAL", dropStreamScript, 2, stream.Key, q.streamsKey(lane)); err != nil {
+				log.Printf("Warning: Failed to drop empty stream %s: %v", stream.Key, err)
+			}
+			continue
+		}
+		var pending int64
+		if summary, ok := replies[2*i+1].([]interface{}); ok && len(summary) > 0 {
+			pending, _ = redisInt(summary[0], nil)
+		}
+		stream.Due.Count = int(length - pending)
+		listed = append(listed, stream)
+	}
+	return listed, nil
+}
+
+// dequeueLane shares limit slots between the workspaces and senders with
+// entries due in one lane, skipping paused and throttled ones, and leases the
+// messages of the entries it reads
+func (q *RedisQueue) dequeueLane(lane models.MessagePriority, limit int, paused *PauseSet) ([]*models.Message, error) {
+	streams, err := q.laneStreams(lane)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s lane streams: %w", lane, err)
+	}
+	var due []dueGroup
+	for _, stream := range streams {
+		if stream.Due.Count > 0 {
+			due = append(due, stream.Due)
+		}
+	}
+
+	var messages []*models.Message
+	for _, fetch := range q.fair.plan(limit, due, paused) {
+		var keys []string
+		for _, stream := range streams {
+			if stream.Due.Count > 0 && fetch.takes(stream.Due.WorkspaceID, stream.Due.Sender) {
+				keys = append(keys, stream.Key)
+			}
+		}
+		entries, err := q.readOldest(keys, fetch.Limit)
+		if err != nil {
+			return messages, fmt.Errorf("failed to read %s lane: %w", lane, err)
+		}
+		messages = append(messages, q.claimEntries(entries)...)
+	}
+	return messages, nil
+}
+
+// readOldest delivers up to limit of the oldest undelivered entries of streams
+// to this consumer. Entry IDs are timestamps, so when there are several streams
+// their next entries are peeked at and compared first.
+func (q *RedisQueue) readOldest(streams []string, limit int) ([]streamEntry, error) {
+	counts := map[string]int{}
+	if len(streams) == 1 {
+		counts[streams[0]] = limit
+	} else if len(streams) > 1 {
+		peeked, err := q.peek(streams, limit)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(peeked, func(i, j int) bool { return entryBefore(peeked[i].ID, peeked[j].ID) })
+		if len(peeked) > limit {
+			peeked = peeked[:limit]
+		}
+		for _, entry := range peeked {
+			counts[entry.Stream]++
+		}
+	}
+
+	var keys []string
+	var cmds [][]interface{}
+	for _, stream := range streams {
+		if counts[stream] > 0 {
+			keys = append(keys, stream)
+			cmds = append(cmds, []interface{}{"XREADGROUP", "GROUP", redisGroup, q.workerID, "COUNT", counts[stream], "STREAMS", stream, ">"})
+		}
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	replies, err := q.pool.pipeline(cmds)
+	if err != nil {
+		return nil, err
+	}
+	var entries []streamEntry
+	for i, reply := range replies {
+		if err := streamReplyErr(reply); err != nil {
+			return entries, err
+		}
+		entries = append(entries, parseReadGroup(keys[i], reply)...)
+	}
+	return entries, nil
+}
+
+// peek returns up to limit of the next undelivered entries of each stream
+// without delivering them
+func (q *RedisQueue) peek(streams []string, limit int) ([]streamEntry, error) {
+	cmds := make([][]interface{}, len(streams))
+	for i, stream := range streams {
+		cmds[i] = []interface{}{"XINFO", "GROUPS", stream}
+	}
+	replies, err := q.pool.pipeline(cmds)
+	if err != nil {
+		return nil, err
+	}
+	for i, stream := range streams {
+		if err := streamReplyErr(replies[i]); err != nil {
+			return nil, err
+		}
+		cmds[i] = []interface{}{"XRANGE", stream, "(" + lastDelivered(replies[i]), "+", "COUNT", limit}
+	}
+
+	if replies, err = q.pool.pipeline(cmds); err != nil {
+		return nil, err
+	}
+	var entries []streamEntry
+	for i, stream := range streams {
+		if err := streamReplyErr(replies[i]); err != nil {
+			return nil, err
+		}
+		entries = append(entries, parseStreamEntries(stream, replies[i])...)
+	}
+	return entries, nil
+}
+
+// lastDelivered finds the last entry delivered to the consumer group in an
+// XINFO GROUPS reply
+func lastDelivered(reply interface{}) string {
+	groups, _ := reply.([]interface{})
+	for _, group := range groups {
+		fields, _ := group.([]interface{})
+		var name, last string
+		for i := 0; i+1 < len(fields); i += 2 {
+			key, _ := fields[i].([]byte)
+			value, _ := fields[i+1].([]byte)
+			switch string(key) {
+			case "name":
+				name = string(value)
+			case "last-delivered-id":
+				last = string(value)
+			}
+		}
+		if name == redisGroup && last != "" {
+			return last
+		}
+	}
+	return "0-0"
+}
+
+// entryBefore orders stream entry IDs, which are <milliseconds>-<sequence>
+func entryBefore(a, b string) bool {
+	aMs, aSeq, _ := strings.Cut(a, "-")
+	bMs, bSeq, _ := strings.Cut(b, "-")
+	if aMs != bMs {
+		x, _ := strconv.ParseUint(aMs, 10, 64)
+		y, _ := strconv.ParseUint(bMs, 10, 64)
+		return x < y
+	}
+	x, _ := strconv.ParseUint(aSeq, 10, 64)
+	y, _ := strconv.ParseUint(bSeq, 10, 64)
+	return x < y
+}
+
+// claimEntries leases the messages of entries delivered to this consumer
+func (q *RedisQueue) claimEntries(entries []streamEntry) []*models.Message {
+	var messages []*models.Message
+	for _, entry := range entries {
+		var claimed *models.Message
+		err := q.update(entry.MessageID, func(rec *redisRecord) ([][]interface{}, error) {
+			if rec.stream != entry.Stream || rec.entry != entry.ID || (rec.msg.Status != models.StatusQueued && rec.msg.Status != models.StatusFailed) {
+				return nil, errStaleEntry
+			}
+			leaseExpiresAt := time.Now().Add(q.leaseDuration)
+			rec.msg.Status = models.StatusProcessing
+			rec.msg.LockedBy = q.workerID
+			rec.msg.LeaseExpiresAt = &leaseExpiresAt
+			claimed = rec.msg
+			return nil, nil
+		})
+		if err != nil {
+			if err == errStaleEntry || errors.Is(err, errMessageNotFound) {
+				q.pool.pipeline([][]interface{}{{"XACK", entry.Stream, redisGroup, entry.ID}, {"XDEL", entry.Stream, entry.ID}})
+				continue
+			}
+			// The entry stays pending and is reclaimed by ReapExpiredLeases
+			log.Printf("Warning: Failed to claim message %s: %v", entry.MessageID, err)
+			continue
+		}
+		messages = append(messages, claimed)
+	}
+	return messages
+}
+
+// SetLaneWeights sets each priority lane's share of a dequeue batch
+func (q *RedisQueue) SetLaneWeights(weights map[models.MessagePriority]int) {
+	q.lanes.setWeights(weights)
+}
+
+// SetFairShare sets how dequeue batches are shared between workspaces
+func (q *RedisQueue) SetFairShare(share FairShare) {
+	q.fair.set(share)
+}
+
+// SetAttachmentStore moves the attachment content of enqueued messages into store
+func (q *RedisQueue) SetAttachmentStore(store blobstore.Store) {
+	q.blobs.store = store
+}
+
+// storeBlobs counts the references the attachments take, writes their content
+// to the store and then saves the message. The references are given up again
+// when the message is not saved.
+func (q *RedisQueue) storeBlobs(attachments []models.Attachment, save func() error) error {
+	if q.blobs.store == nil {
+		return save()
+	}
+
+	keys := storedKeys(attachments)
+	if err := q.retainBlobs(keys); err != nil {
+		return err
+	}
+	err := blobstore.Externalize(context.Background(), q.blobs.store, attachments)
+	if err == nil {
+		err = save()
+	}
+	if err != nil {
+		if _, releaseErr := q.pool.pipeline(q.releaseCmds(keys)); releaseErr != nil {
+			log.Printf("Warning: Failed to release attachment references: %v", releaseErr)
+		}
+	}
+	return err
+}
+
+// retainBlobs counts a reference for each time a key appears, waiting while the
+// collector deletes one of the blobs so its content is written again afterwards
+func (q *RedisQueue) retainBlobs(keys []string) error {
+	distinct, counts := countKeys(keys)
+	if len(distinct) == 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(blobClaim)
+	for {
+		args := append([]interface{}{"EVAL", retainBlobsScript, 3}, q.blobKeys()...)
+		args = append(args, time.Now().UnixMilli())
+		for _, key := range distinct {
+			args = append(args, key, counts[key])
+		}
+		retained, err := q.pool.do(args...)
+		if err != nil {
+			return fmt.Errorf("failed to count attachment references: %w", err)
+		}
+		if n, _ := retained.(int64); n == 1 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("failed to count attachment references: blobs are still being collected")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// releaseCmds give up one reference to a blob for each time its key appears and
+// leave the blobs for CollectBlobs, which deletes those still unreferenced once
+// the grace period has passed
+func (q *RedisQueue) releaseCmds(keys []string) [][]interface{} {
+	if q.blobs.store == nil || len(keys) == 0 {
+		return nil
+	}
+	distinct, counts := countKeys(keys)
+	refs, released := q.blobKeys()[0], q.blobKeys()[2]
+	now := score(time.Now())
+	var cmds [][]interface{}
+	for _, key := range distinct {
+		cmds = append(cmds,
+			[]interface{}{"HINCRBY", refs, key, -counts[key]},
+			[]interface{}{"ZADD", released, now, key})
+	}
+	return cmds
+}
+
+// droppedKeys returns the keys in before that after no longer has, counting repeats
+func droppedKeys(before, after []string) []string {
+	kept := make(map[string]int, len(after))
+	for _, key := range after {
+		kept[key]++
+	}
+	var dropped []string
+	for _, key := range before {
+		if kept[key] > 0 {
+			kept[key]--
+			continue
+		}
+		dropped = append(dropped, key)
+	}
+	return dropped
+}
+
+// CollectBlobs deletes up to limit blobs no message has referenced since
+// releasedBefore. Each blob is claimed first, so replicas collecting at the
+// same time take different blobs and new references wait until it is gone. A
+// blob that cannot be deleted stays released and is tried again next time.
+func (q *RedisQueue) CollectBlobs(releasedBefore time.Time, limit int) (int, error) {
+	if q.blobs.store == nil {
+		return 0, nil
+	}
+
+	keys, err := redisStrings(q.pool.do("ZRANGE", q.blobKeys()[2], "-inf", score(releasedBefore), "BYSCORE", "LIMIT", 0, limit))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list released blobs: %w", err)
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		now := time.Now()
+		args := append([]interface{}{"EVAL", claimBlobScript, 3}, q.blobKeys()...)
+		claimed, err := q.pool.do(append(args, key, now.UnixMilli(), now.Add(blobClaim).UnixMilli())...)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to claim blob %s: %w", key, err)
+		}
+		if n, _ := claimed.(int64); n != 1 {
+			continue
+		}
+
+		script := forgetBlobScript
+		if err := q.blobs.store.Delete(context.Background(), key); err != nil {
+			log.Printf("Warning: Failed to delete attachment blob %s: %v", key, err)
+			script = `return redis.call('HDEL', KEYS[2], ARGV[1])`
+		} else {
+			deleted++
+		}
+		args = append([]interface{}{"EVAL", script, 3}, q.blobKeys()...)
+		if _, err := q.pool.do(append(args, key)...); err != nil {
+			log.Printf("Warning: Failed to finish collecting blob %s: %v", key, err)
+		}
+	}
+	return deleted, nil
+}
+
+// finish moves a message out of processing, acknowledging its stream entry
+func (q *RedisQueue) finish(id string, status models.MessageStatus, providerID string, err error) error {
+	return q.updateHeld(id, func(rec *redisRecord) ([][]interface{}, error) {
+		msg := rec.msg
+		now := time.Now()
+		msg.Status = status
+		msg.RetryCount += attemptIncrement(status)
+		msg.NextAttemptAt = nil
+		msg.ProcessedAt = &now
+		releaseLease(msg)
+		if providerID != "" {
+			msg.SentVia = providerID
+		}
+		if err != nil {
+			msg.Error = err.Error()
+		}
+
+		cmds := q.ackCmds(rec)
+		if status == models.StatusQueued {
+			cmds = append(cmds, q.waitCmds(rec, now)...)
+		}
+		return cmds, nil
+	})
+}
+
+func (q *RedisQueue) UpdateStatus(id string, status models.MessageStatus, err error) error {
+	return q.finish(id, status, "", err)
+}
+
+func (q *RedisQueue) UpdateStatusWithProvider(id string, status models.MessageStatus, providerID string, err error) error {
+	return q.finish(id, status, providerID, err)
+}
+
+// ScheduleRetry records a failed attempt and retries the message at nextAttemptAt
+func (q *RedisQueue) ScheduleRetry(id string, providerID string, nextAttemptAt time.Time, err error) error {
+	updateErr := q.updateHeld(id, func(rec *redisRecord) ([][]interface{}, error) {
+		msg := rec.msg
+		now := time.Now()
+		msg.Status = models.StatusFailed
+		msg.RetryCount++
+		msg.NextAttemptAt = &nextAttemptAt
+		msg.ProcessedAt = &now
+		releaseLease(msg)
+		if providerID != "" {
+			msg.SentVia = providerID
+		}
+		if err != nil {
+			msg.Error = err.Error()
+		}
+		return append(q.ackCmds(rec), q.waitCmds(rec, now)...), nil
+	})
+	if updateErr != nil {
+		return fmt.Errorf("failed to schedule retry: %w", updateErr)
+	}
+	return nil
+}
+
+// Defer parks a message in the delayed set until the given time
+func (q *RedisQueue) Defer(id string, until time.Time, reason error) (bool, error) {
+	var first bool
+	err := q.updateHeld(id, func(rec *redisRecord) ([][]interface{}, error) {
+		msg := rec.msg
+		now := time.Now()
+		first = msg.DeferredAt == nil
+		if first {
+			msg.DeferredAt = &now
+		}
+		parkMessage(msg, until, reason)
+		return append(q.ackCmds(rec), q.waitCmds(rec, now)...), nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to defer message: %w", err)
+	}
+	return first, nil
+}
+
+// Hold parks a paused message in the delayed set without marking it deferred
+func (q *RedisQueue) Hold(id string, until time.Time, reason error) error {
+	err := q.updateHeld(id, func(rec *redisRecord) ([][]interface{}, error) {
+		parkMessage(rec.msg, until, reason)
+		return append(q.ackCmds(rec), q.waitCmds(rec, time.Now())...), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to hold message: %w", err)
+	}
+	return nil
+}
+
+// SetLeaseDuration sets how long Dequeue and RenewLease hold a message
+func (q *RedisQueue) SetLeaseDuration(d time.Duration) {
+	if d > 0 {
+		q.leaseDuration = d
+	}
+}
+
+// SetWorkerID sets the locked_by value and consumer name for messages this worker dequeues
+func (q *RedisQueue) SetWorkerID(id string) {
+	if id != "" {
+		q.workerID = id
+	}
+}
+
+// RenewLease extends this worker's lease on a processing or sending message. Claiming the
+// entry again resets its idle time, so the reaper leaves it alone.
+func (q *RedisQueue) RenewLease(id string) error {
+	return q.updateHeld(id, func(rec *redisRecord) ([][]interface{}, error) {
+		msg := rec.msg
+		leaseExpiresAt := time.Now().Add(q.leaseDuration)
+		msg.LeaseExpiresAt = &leaseExpiresAt
+		if rec.entry == "" {
+			return nil, nil
+		}
+		return [][]interface{}{{"XCLAIM", rec.stream, redisGroup, q.workerID, 0, rec.entry, "JUSTID"}}, nil
+	})
+}
+
+// ReapExpiredLeases reclaims stream entries left pending longer than the lease
+// and returns their messages to the queue when the lease has expired. The
+// interrupted attempt is not counted against the retry budget.
+func (q *RedisQueue) ReapExpiredLeases() (int, error) {
+	reaped := 0
+	for _, lane := range models.Priorities {
+		streams, err := redisStrings(q.pool.do("HKEYS", q.streamsKey(lane)))
+		if err != nil {
+			return reaped, fmt.Errorf("failed to list %s lane streams: %w", lane, err)
+		}
+		for _, stream := range streams {
+			n, err := q.reapStream(stream)
+			reaped += n
+			if err != nil {
+				return reaped, err
+			}
+		}
+	}
+	return reaped, nil
+}
+
+// reapStream reclaims the entries of one stream left pending longer than the lease
+func (q *RedisQueue) reapStream(stream string) (int, error) {
+	reaped := 0
+	cursor := "0-0"
+	for {
+		replies, err := q.pool.pipeline([][]interface{}{{"XAUTOCLAIM", stream, redisGroup, q.workerID, q.leaseDuration.Milliseconds(), cursor, "COUNT", 100}})
+		if err != nil {
+			return reaped, fmt.Errorf("failed to reclaim pending entries: %w", err)
+		}
+		if err := streamReplyErr(replies[0]); err != nil {
+			return reaped, fmt.Errorf("failed to reclaim pending entries: %w", err)
+		}
+		parts, ok := replies[0].([]interface{})
+		if !ok || len(parts) < 2 {
+			return reaped, nil
+		}
+		next, _ := parts[0].([]byte)
+		cursor = string(next)
+
+		for _, entry := range parseStreamEntries(stream, parts[1]) {
+			requeued, err := q.reclaim(entry)
+			if err != nil {
+				log.Printf("Warning: Failed to reclaim message %s: %v", entry.MessageID, err)
+				continue
+			}
+			if requeued {
+				reaped++
+			}
+		}
+		if cursor == "0-0" || cursor == "" {
+			return reaped, nil
+		}
+	}
+}
+
+// reclaim handles one pending entry taken over from another consumer
+func (q *RedisQueue) reclaim(entry streamEntry) (bool, error) {
+	requeued := false
+	err := q.update(entry.MessageID, func(rec *redisRecord) ([][]interface{}, error) {
+		msg := rec.msg
+		if rec.stream != entry.Stream || rec.entry != entry.ID {
+			return nil, errStaleEntry
+		}
+
+		now := time.Now()
+		switch msg.Status {
+		case models.StatusProcessing:
+			if msg.LeaseExpiresAt != nil && msg.LeaseExpiresAt.After(now) {
+				return nil, errSkipMessage // Lease still held
+			}
+			msg.Status = models.StatusQueued
+			releaseLease(msg)
+		case models.StatusSending:
+			return nil, errSkipMessage // Left pending for ClaimUnconfirmedSends
+		case models.StatusQueued, models.StatusFailed:
+			// Delivered but never leased, e.g. the claim failed part way
+		default:
+			return q.ackCmds(rec), nil
+		}
+		requeued = true
+		return append(q.ackCmds(rec), q.waitCmds(rec, now)...), nil
+	})
+
+	switch {
+	case err == errSkipMessage:
+		return false, nil
+	case err == errStaleEntry || (err != nil && errors.Is(err, errMessageNotFound)):
+		_, err = q.pool.pipeline([][]interface{}{{"XACK", entry.Stream, redisGroup, entry.ID}, {"XDEL", entry.Stream, entry.ID}})
+		return false, err
+	}
+	return requeued, err
+}
+
+// MarkSending records that this worker is handing the message to providerID.
+// The stream entry stays pending until the outcome is recorded.
+func (q *RedisQueue) MarkSending(id, providerID string) error {
+	return q.updateHeld(id, func(rec *redisRecord) ([][]interface{}, error) {
+		msg := rec.msg
+		msg.Status = models.StatusSending
+		msg.SentVia = providerID
+		return nil, nil
+	})
+}
+
+// MarkSent records the message as sent together with the provider's message ID
+func (q *RedisQueue) MarkSent(id, providerID, providerMessageID string) error {
+	return q.updateHeld(id, func(rec *redisRecord) ([][]interface{}, error) {
+		markSent(rec.msg, providerID, providerMessageID, time.Now())
+		return q.ackCmds(rec), nil
+	})
+}
+
+// ClaimUnconfirmedSends leases sending messages whose lease expired to this worker
+func (q *RedisQueue) ClaimUnconfirmedSends(limit int) ([]*models.Message, error) {
+	ids, err := redisStrings(q.pool.do("ZRANGE", q.statusKey(models.StatusSending), 0, -1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unconfirmed sends: %w", err)
+	}
+
+	var claimed []*models.Message
+	for _, id := range ids {
+		if len(claimed) >= limit {
+			break
+		}
+		var msg *models.Message
+		err := q.update(id, func(rec *redisRecord) ([][]interface{}, error) {
+			now := time.Now()
+			if !unconfirmed(rec.msg, now) {
+				return nil, errSkipMessage
+			}
+			leaseExpiresAt := now.Add(q.leaseDuration)
+			rec.msg.LockedBy = q.workerID
+			rec.msg.LeaseExpiresAt = &leaseExpiresAt
+			msg = rec.msg
+			return nil, nil
+		})
+		if err == errSkipMessage || (err != nil && errors.Is(err, errMessageNotFound)) {
+			continue
+		}
+		if err != nil {
+			return claimed, fmt.Errorf("failed to claim message %s: %w", id, err)
+		}
+		claimed = append(claimed, msg)
+	}
+	return claimed, nil
+}
+
+// ExpireDue moves up to limit waiting messages whose expires_at has passed to
+// expired, taking them out of line. Waiting messages with an expires_at are
+// kept in a sorted set scored by it.
+func (q *RedisQueue) ExpireDue(limit int) ([]*models.Message, error) {
+	ids, err := redisStrings(q.pool.do("ZRANGE", q.expiresKey(), "-inf", score(time.Now()), "BYSCORE", "LIMIT", 0, limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired messages: %w", err)
+	}
+
+	var expired []*models.Message
+	for _, id := range ids {
+		var msg *models.Message
+		err := q.update(id, func(rec *redisRecord) ([][]interface{}, error) {
+			now := time.Now()
+			if !expirable(rec.msg, now) {
+				return nil, errSkipMessage
+			}
+			expire(rec.msg, now)
+			msg = rec.msg
+			return append(q.ackCmds(rec), []interface{}{"ZREM", q.delayedKey(rec.msg.Priority), rec.msg.ID}), nil
+		})
+		if err != nil && errors.Is(err, errMessageNotFound) {
+			q.pool.do("ZREM", q.expiresKey(), id)
+			continue
+		}
+		if err == errSkipMessage {
+			continue
+		}
+		if err != nil {
+			return expired, fmt.Errorf("failed to expire message %s: %w", id, err)
+		}
+		expired = append(expired, msg)
+	}
+	return expired, nil
+}
+
+// Pause adds p, replacing the pause with the same scope and key
+func (q *RedisQueue) Pause(p Pause) error {
+	if err := p.Normalize(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to encode pause: %w", err)
+	}
+	if _, err := q.pool.do("HSET", q.pausesKey(), pauseID(p.Scope, p.Key), data); err != nil {
+		return fmt.Errorf("failed to store pause: %w", err)
+	}
+	return nil
+}
+
+// Resume removes the pause with scope and key and reports whether there was one
+func (q *RedisQueue) Resume(scope PauseScope, key string) (bool, error) {
+	removed, err := redisInt(q.pool.do("HDEL", q.pausesKey(), pauseID(scope, key)))
+	if err != nil {
+		return false, fmt.Errorf("failed to resume: %w", err)
+	}
+	return removed > 0, nil
+}
+
+// ListPauses returns the pauses in effect, oldest first, deleting those whose ResumeAt has passed
+func (q *RedisQueue) ListPauses() ([]Pause, error) {
+	values, err := redisStrings(q.pool.do("HVALS", q.pausesKey()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pauses: %w", err)
+	}
+
+	now := time.Now()
+	pauses := make(map[string]Pause, len(values))
+	for _, value := range values {
+		var p Pause
+		if err := json.Unmarshal([]byte(value), &p); err != nil {
+			log.Printf("Warning: Skipping unreadable pause: %v", err)
+			continue
+		}
+		id := pauseID(p.Scope, p.Key)
+		if !p.Active(now) {
+			q.pool.do("HDEL", q.pausesKey(), id)
+			continue
+		}
+		pauses[id] = p
+	}
+	return activePauses(pauses, now), nil
+}
+
+// RegisterWorker adds this replica to the worker registry, replacing a previous
+// registration under the same ID
+func (q *RedisQueue) RegisterWorker(info WorkerInfo) error {
+	info.StoppedAt = nil
+	info.Status = ""
+	info.HeldMessages = nil
+	return q.saveWorker(info)
+}
+
+func (q *RedisQueue) saveWorker(info WorkerInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if _, err := q.pool.do("HSET", q.workersKey(), info.ID, data); err != nil {
+		return fmt.Errorf("failed to save worker: %w", err)
+	}
+	return nil
+}
+
+func (q *RedisQueue) loadWorker(id string) (*WorkerInfo, error) {
+	reply, err := q.pool.do("HGET", q.workersKey(), id)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	data, _ := reply.([]byte)
+	var info WorkerInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode worker %s: %w", id, err)
+	}
+	return &info, nil
+}
+
+func (q *RedisQueue) Heartbeat(id string) error {
+	info, err := q.loadWorker(id)
+	if err != nil || info == nil {
+		return err
+	}
+	info.LastHeartbeatAt = time.Now()
+	return q.saveWorker(*info)
+}
+
+func (q *RedisQueue) DeregisterWorker(id string) error {
+	info, err := q.loadWorker(id)
+	if err != nil || info == nil {
+		return err
+	}
+	now := time.Now()
+	info.StoppedAt = &now
+	return q.saveWorker(*info)
+}
+
+// ListWorkers returns running workers and those stopped in the last day, with the messages they hold
+func (q *RedisQueue) ListWorkers() ([]WorkerInfo, error) {
+	values, err := redisStrings(q.pool.do("HVALS", q.workersKey()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workers: %w", err)
+	}
+
+	now := time.Now()
+	var workers []WorkerInfo
+	index := make(map[string]int)
+	for _, value := range values {
+		var info WorkerInfo
+		if err := json.Unmarshal([]byte(value), &info); err != nil {
+			continue
+		}
+		if info.StoppedAt != nil && info.StoppedAt.Before(now.Add(-24*time.Hour)) {
+			continue
+		}
+		info.HeldMessages = []string{}
+		workers = append(workers, info)
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].StartedAt.After(workers[j].StartedAt)
+	})
+	for i := range workers {
+		index[workers[i].ID] = i
+	}
+
+	processing, err := q.messagesIn(q.statusKey(models.StatusProcessing), 0, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list held messages: %w", err)
+	}
+	sending, err := q.messagesIn(q.statusKey(models.StatusSending), 0, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list held messages: %w", err)
+	}
+	for _, msg := range append(processing, sending...) {
+		if i, ok := index[msg.LockedBy]; ok {
+			workers[i].HeldMessages = append(workers[i].HeldMessages, msg.ID)
+		}
+	}
+	for i := range workers {
+		workers[i].Status = workerStatus(workers[i], now)
+	}
+	return workers, nil
+}
+
+// RecordAttempt appends to the message's attempt history
+func (q *RedisQueue) RecordAttempt(id string, attempt models.Attempt) error {
+	exists, err := redisInt(q.pool.do("EXISTS", q.messageKey(id)))
+	if err != nil {
+		return fmt.Errorf("failed to record attempt: %w", err)
+	}
+	if exists == 0 {
+		return fmt.Errorf("%w: %s", errMessageNotFound, id)
+	}
+
+	data, err := json.Marshal(attempt)
+	if err != nil {
+		return err
+	}
+	if _, err := q.pool.do("RPUSH", q.attemptsKey(id), data); err != nil {
+		return fmt.Errorf("failed to record attempt: %w", err)
+	}
+	return nil
+}
+
+func (q *RedisQueue) Get(id string) (*models.Message, error) {
+	c, err := q.pool.get()
+	if err != nil {
+		return nil, err
+	}
+	defer q.pool.put(c)
+
+	rec, err := q.load(c, id)
+	if err != nil {
+		return nil, err
+	}
+	return rec.msg, nil
+}
+
+func (q *RedisQueue) Remove(id string) error {
+	err := q.update(id, func(rec *redisRecord) ([][]interface{}, error) {
+		rec.deleted = true
+		return nil, nil
+	})
+	if err != nil && errors.Is(err, errMessageNotFound) {
+		return nil
+	}
+	return err
+}
+
+// messagesIn loads the messages of a sorted set index, highest score first
+func (q *RedisQueue) messagesIn(key string, start, stop int) ([]*models.Message, error) {
+	ids, err := redisStrings(q.pool.do("ZREVRANGE", key, start, stop))
+	if err != nil {
+		return nil, err
+	}
+	return q.getMany(ids)
+}
+
+// getMany loads messages in one round trip, skipping any removed meanwhile
+func (q *RedisQueue) getMany(ids []string) ([]*models.Message, error) {
+	if len(ids) == 0 {
+		return []*models.Message{}, nil
+	}
+
+	cmds := make([][]interface{}, len(ids))
+	for i, id := range ids {
+		cmds[i] = []interface{}{"HMGET", q.messageKey(id), "message", "raw"}
+	}
+	replies, err := q.pool.pipeline(cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*models.Message, 0, len(ids))
+	for _, reply := range replies {
+		values, err := redisStrings(reply, nil)
+		if err != nil || len(values) != 2 || values[0] == "" {
+			continue
+		}
+		msg := &models.Message{}
+		if err := json.Unmarshal([]byte(values[0]), msg); err != nil {
+			continue
+		}
+		if values[1] != "" {
+			msg.RawMessage = []byte(values[1])
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// ListScheduled returns queued messages with a future send_at
+func (q *RedisQueue) ListScheduled(to string, limit int) ([]*models.Message, error) {
+	var scheduled []*models.Message
+	for _, lane := range models.Priorities {
+		ids, err := redisStrings(q.pool.do("ZRANGEBYSCORE", q.delayedKey(lane), score(time.Now()), "+inf"))
+		if err != nil {
+			return nil, err
+		}
+		messages, err := q.getMany(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			if isScheduled(msg) && (to == "" || containsAddress(msg.To, to)) {
+				scheduled = append(scheduled, msg)
+			}
+		}
+	}
+
+	sort.Slice(scheduled, func(i, j int) bool {
+		return scheduled[i].SendAt.Before(*scheduled[j].SendAt)
+	})
+	if len(scheduled) > limit {
+		scheduled = scheduled[:limit]
+	}
+	return scheduled, nil
+}
+
+// Reschedule moves a scheduled message to a new send_at
+func (q *RedisQueue) Reschedule(id string, sendAt time.Time) error {
+	err := q.update(id, func(rec *redisRecord) ([][]interface{}, error) {
+		if !isScheduled(rec.msg) {
+			return nil, ErrNotScheduled
+		}
+		rec.msg.SendAt = &sendAt
+		return q.waitCmds(rec, time.Now()), nil
+	})
+	if err != nil && errors.Is(err, errMessageNotFound) {
+		return ErrNotScheduled
+	}
+	return err
+}
+
+// CancelScheduled removes a message that has not reached its send_at yet
+func (q *RedisQueue) CancelScheduled(id string) error {
+	err := q.update(id, func(rec *redisRecord) ([][]interface{}, error) {
+		if !isScheduled(rec.msg) {
+			return nil, ErrNotScheduled
+		}
+		rec.deleted = true
+		return nil, nil
+	})
+	if err != nil && errors.Is(err, errMessageNotFound) {
+		return ErrNotScheduled
+	}
+	return err
+}
+
+// ListDead returns dead messages, most recently failed first
+func (q *RedisQueue) ListDead(filter DeadLetterFilter) ([]*models.Message, int, error) {
+	messages, err := q.messagesIn(q.statusKey(models.StatusDead), 0, -1)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+
+	var dead []*models.Message
+	for _, msg := range messages {
+		if msg.Status != models.StatusDead {
+			continue
+		}
+		if (filter.ProviderID != "" && msg.ProviderID != filter.ProviderID) || (filter.From != "" && msg.From != filter.From) {
+			continue
+		}
+		dead = append(dead, msg)
+	}
+	sort.SliceStable(dead, func(i, j int) bool {
+		return processedAfter(dead[i], dead[j])
+	})
+
+	total := len(dead)
+	if filter.Offset >= total {
+		return []*models.Message{}, total, nil
+	}
+	end := total
+	if filter.Limit > 0 && filter.Offset+filter.Limit < end {
+		end = filter.Offset + filter.Limit
+	}
+	return dead[filter.Offset:end], total, nil
+}
+
+// GetAttempts returns a message's attempt history, oldest first
+func (q *RedisQueue) GetAttempts(id string) ([]models.Attempt, error) {
+	values, err := redisStrings(q.pool.do("LRANGE", q.attemptsKey(id), 0, -1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attempts: %w", err)
+	}
+
+	var attempts []models.Attempt
+	for _, value := range values {
+		var attempt models.Attempt
+		if err := json.Unmarshal([]byte(value), &attempt); err != nil {
+			return nil, fmt.Errorf("failed to decode attempt: %w", err)
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, nil
+}
+
+// Replay moves dead messages back to queued with a fresh retry budget.
+// The attempt history is kept so a replayed message shows every attempt.
+func (q *RedisQueue) Replay(ids []string, providerOverride string) (int, error) {
+	replayed := 0
+	for _, id := range ids {
+		err := q.update(id, func(rec *redisRecord) ([][]interface{}, error) {
+			msg := rec.msg
+			if msg.Status != models.StatusDead {
+				return nil, errSkipMessage
+			}
+			msg.Status = models.StatusQueued
+			msg.RetryCount = 0
+			msg.NextAttemptAt = nil
+			msg.ProcessedAt = nil
+			msg.Error = ""
+			msg.ProviderOverride = providerOverride
+			return q.waitCmds(rec, time.Now()), nil
+		})
+		if err == nil {
+			replayed++
+		} else if err != errSkipMessage && !errors.Is(err, errMessageNotFound) {
+			return replayed, fmt.Errorf("failed to replay dead letters: %w", err)
+		}
+	}
+	return replayed, nil
+}
+
+// Discard deletes dead messages and their attempt history
+func (q *RedisQueue) Discard(ids []string) (int, error) {
+	discarded := 0
+	for _, id := range ids {
+		err := q.update(id, func(rec *redisRecord) ([][]interface{}, error) {
+			if rec.msg.Status != models.StatusDead {
+				return nil, errSkipMessage
+			}
+			rec.deleted = true
+			return nil, nil
+		})
+		if err == nil {
+			discarded++
+		} else if err != errSkipMessage && !errors.Is(err, errMessageNotFound) {
+			return discarded, fmt.Errorf("failed to discard dead letters: %w", err)
+		}
+	}
+	return discarded, nil
+}
+
+// bulkCandidates loads the messages in the status indexes a bulk action applies to
+func (q *RedisQueue) bulkCandidates(matcher *bulkMatcher) ([]*models.Message, error) {
+	var matched []*models.Message
+	for _, status := range matcher.statuses {
+		messages, err := q.messagesIn(q.statusKey(status), 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			if matcher.matches(msg) {
+				matched = append(matched, msg)
+			}
+		}
+	}
+	return matched, nil
+}
+
+// CountBulk returns how many messages ApplyBulk would change
+func (q *RedisQueue) CountBulk(action BulkAction, filter BulkFilter) (int, error) {
+	matcher, err := newBulkMatcher(action, filter)
+	if err != nil {
+		return 0, err
+	}
+	matched, err := q.bulkCandidates(matcher)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+	return len(matched), nil
+}
+
+// ApplyBulk applies action to every message matching filter, one message per
+// transaction. A message that changed in between is checked against the filter again.
+func (q *RedisQueue) ApplyBulk(action BulkAction, filter BulkFilter, sendAt time.Time) (int, error) {
+	matcher, err := newBulkMatcher(action, filter)
+	if err != nil {
+		return 0, err
+	}
+	matched, err := q.bulkCandidates(matcher)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	changed := 0
+	for _, candidate := range matched {
+		err := q.update(candidate.ID, func(rec *redisRecord) ([][]interface{}, error) {
+			if !matcher.matches(rec.msg) {
+				return nil, errSkipMessage
+			}
+			if action == BulkDelete {
+				rec.deleted = true
+				return nil, nil
+			}
+
+			// Take the message out of line before putting it back where it now belongs
+			now := time.Now()
+			applyBulk(rec.msg, action, sendAt, now)
+			cmds := append(q.ackCmds(rec), []interface{}{"ZREM", q.delayedKey(rec.msg.Priority), rec.msg.ID})
+			if rec.msg.Status == models.StatusQueued {
+				cmds = append(cmds, q.waitCmds(rec, now)...)
+			}
+			return cmds, nil
+		})
+		if err == nil {
+			changed++
+		} else if err != errSkipMessage && !errors.Is(err, errMessageNotFound) {
+			return changed, fmt.Errorf("failed to %s messages: %w", action, err)
+		}
+	}
+	return changed, nil
+}
+
+// retentionCandidates loads the finished messages retention applies to under
+// filter, archived or not, oldest first. Finished messages are only indexed by
+// status, so every one of them is read.
+func (q *RedisQueue) retentionCandidates(filter RetentionFilter, archived bool) ([]*models.Message, error) {
+	var due []*models.Message
+	for _, status := range retentionStatuses {
+		messages, err := q.messagesIn(q.statusKey(status), 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			if (msg.ArchivedAt != nil) == archived && retentionDue(msg, filter) {
+				due = append(due, msg)
+			}
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return processedAfter(due[j], due[i])
+	})
+	return due, nil
+}
+
+// ClaimUnarchived returns finished messages that are due for archiving, oldest
+// first. Each is claimed with a key that expires at until, so a message another
+// replica is archiving is skipped. MarkArchived leaves the key to expire, which
+// also covers a message archived after it was read here.
+func (q *RedisQueue) ClaimUnarchived(filter RetentionFilter, until time.Time) ([]*models.Message, error) {
+	candidates, err := q.retentionCandidates(filter, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages to archive: %w", err)
+	}
+
+	ttl := time.Until(until).Milliseconds()
+	if ttl <= 0 {
+		ttl = 1
+	}
+	var due []*models.Message
+	for _, msg := range candidates {
+		if filter.Limit > 0 && len(due) >= filter.Limit {
+			break
+		}
+		claimed, err := q.pool.do("SET", q.archiveClaimKey(msg.ID), q.workerID, "NX", "PX", ttl)
+		if err != nil {
+			return due, fmt.Errorf("failed to claim message %s for archiving: %w", msg.ID, err)
+		}
+		if claimed != nil {
+			due = append(due, msg)
+		}
+	}
+	return due, nil
+}
+
+// MarkArchived records when the messages were written to an archive
+func (q *RedisQueue) MarkArchived(ids []string, at time.Time) error {
+	for _, id := range ids {
+		err := q.update(id, func(rec *redisRecord) ([][]interface{}, error) {
+			archivedAt := at
+			rec.msg.ArchivedAt = &archivedAt
+			return nil, nil
+		})
+		if err != nil && !errors.Is(err, errMessageNotFound) {
+			return fmt.Errorf("failed to mark messages archived: %w", err)
+		}
+	}
+	return nil
+}
+
+// Purge removes scope from up to filter.Limit archived messages, one message
+// per transaction
+func (q *RedisQueue) Purge(scope PurgeScope, filter RetentionFilter) (int, error) {
+	switch scope {
+	case PurgeBodies, PurgeAttachments, PurgeRows:
+	default:
+		return 0, fmt.Errorf("unknown purge scope %q", scope)
+	}
+	candidates, err := q.retentionCandidates(filter, true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge %s: %w", scope, err)
+	}
+
+	purged := 0
+	for _, candidate := range candidates {
+		if filter.Limit > 0 && purged >= filter.Limit {
+			break
+		}
+		err := q.update(candidate.ID, func(rec *redisRecord) ([][]interface{}, error) {
+			msg := rec.msg
+			if msg.ArchivedAt == nil || !retentionDue(msg, filter) {
+				return nil, errSkipMessage
+			}
+			switch scope {
+			case PurgeBodies:
+				if msg.HTML == "" && msg.Text == "" && msg.RawMessage == nil {
+					return nil, errSkipMessage
+				}
+				msg.HTML, msg.Text, msg.RawMessage = "", "", nil
+			case PurgeAttachments:
+				if len(msg.Attachments) == 0 {
+					return nil, errSkipMessage
+				}
+				msg.Attachments = nil
+			case PurgeRows:
+				rec.deleted = true
+			}
+			return nil, nil
+		})
+		if err == nil {
+			purged++
+		} else if err != errSkipMessage && !errors.Is(err, errMessageNotFound) {
+			return purged, fmt.Errorf("failed to purge %s: %w", scope, err)
+		}
+	}
+	return purged, nil
+}
+
+// Restore replaces what is left of an archived message with its archived copy.
+// A message that was only stripped gets its content back in place; a deleted
+// one is saved again.
+func (q *RedisQueue) Restore(msg *models.Message, attempts []models.Attempt) error {
+	return q.storeBlobs(msg.Attachments, func() error {
+		now := time.Now()
+		msg.RestoredAt = &now
+
+		attemptCmds := [][]interface{}{{"DEL", q.attemptsKey(msg.ID)}}
+		for _, attempt := range attempts {
+			data, err := json.Marshal(attempt)
+			if err != nil {
+				return err
+			}
+			attemptCmds = append(attemptCmds, []interface{}{"RPUSH", q.attemptsKey(msg.ID), data})
+		}
+
+		err := q.update(msg.ID, func(rec *redisRecord) ([][]interface{}, error) {
+			rec.msg.HTML, rec.msg.Text, rec.msg.RawMessage = msg.HTML, msg.Text, msg.RawMessage
+			rec.msg.Attachments = msg.Attachments
+			rec.msg.RestoredAt = msg.RestoredAt
+			// The archived copy took its own references, so the old ones all go
+			cmds := append(q.releaseCmds(rec.blobs), attemptCmds...)
+			rec.blobs = blobKeys(nil, msg.Attachments)
+			return cmds, nil
+		})
+		if errors.Is(err, errMessageNotFound) {
+			err = q.insert(msg, attemptCmds...)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore message: %w", err)
+		}
+		return nil
+	})
+}
+
+func (q *RedisQueue) Close() error {
+	q.pool.close()
+	return nil
+}
+
+func (q *RedisQueue) GetStats() (map[string]interface{}, error) {
+	stats := make(map[string]interface{})
+	statuses := []models.MessageStatus{models.StatusQueued, models.StatusProcessing, models.StatusSending, models.StatusSent,
+		models.StatusFailed, models.StatusAuthError, models.StatusDead, models.StatusCancelled, models.StatusExpired}
+
+	var cmds [][]interface{}
+	for _, status := range statuses {
+		cmds = append(cmds, []interface{}{"ZCARD", q.statusKey(status)})
+	}
+	for _, lane := range models.Priorities {
+		cmds = append(cmds, []interface{}{"ZCARD", q.delayedKey(lane)})
+	}
+	cmds = append(cmds, []interface{}{"ZCARD", q.indexKey()})
+
+	replies, err := q.pool.pipeline(cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []map[string]interface{}
+	for i, status := range statuses {
+		if count, _ := redisInt(replies[i], nil); count > 0 {
+			counts = append(counts, map[string]interface{}{
+				"Status": string(status),
+				"Count":  int(count),
+			})
+		}
+	}
+	stats["statusCounts"] = counts
+
+	// Depth of each priority lane: entries not yet delivered plus messages waiting
+	// in the delayed set for send_at, a retry or a deferral
+	var laneCounts []map[string]interface{}
+	for i, lane := range models.Priorities {
+		streams, err := q.laneStreams(lane)
+		if err != nil {
+			return nil, err
+		}
+		depth, _ := redisInt(replies[len(statuses)+i], nil)
+		for _, stream := range streams {
+			depth += int64(stream.Due.Count)
+		}
+		if depth > 0 {
+			laneCounts = append(laneCounts, map[string]interface{}{
+				"Priority": string(lane),
+				"Count":    int(depth),
+			})
+		}
+	}
+	stats["laneCounts"] = laneCounts
+
+	total, _ := redisInt(replies[len(replies)-1], nil)
+	stats["total"] = int(total)
+
+	return stats, nil
+}
+
+func (q *RedisQueue) GetMessages(limit, offset int, status string) ([]*models.Message, error) {
+	key := q.indexKey()
+	if status != "" && status != "all" {
+		key = q.statusKey(models.MessageStatus(status))
+	}
+	return q.messagesIn(key, offset, offset+limit-1)
+}
+
+// GetSentCountsByWorkspaceAndSender returns sent message counts for the last 24 hours
+func (q *RedisQueue) GetSentCountsByWorkspaceAndSender() (map[string]map[string]int, error) {
+	cutoff := score(time.Now().Add(-24 * time.Hour))
+	if _, err := q.pool.do("ZREMRANGEBYSCORE", q.sentKey(), "-inf", fmt.Sprintf("(%d", cutoff)); err != nil {
+		return nil, err
+	}
+	ids, err := redisStrings(q.pool.do("ZRANGE", q.sentKey(), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([][]interface{}, len(ids))
+	for i, id := range ids {
+		cmds[i] = []interface{}{"HMGET", q.messageKey(id), "workspace", "from"}
+	}
+	replies, err := q.pool.pipeline(cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	// Structure: workspace_id -> sender_email -> count
+	counts := make(map[string]map[string]int)
+	for _, reply := range replies {
+		values, _ := redisStrings(reply, nil)
+		if len(values) != 2 {
+			continue
+		}
+		if counts[values[0]] == nil {
+			counts[values[0]] = make(map[string]int)
+		}
+		counts[values[0]][values[1]]++
+	}
+	return counts, nil
+}
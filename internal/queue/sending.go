@@ -0,0 +1,28 @@
+package queue
+
+import (
+	"time"
+
+	"relay/pkg/models"
+)
+
+// unconfirmed reports whether msg was handed to a provider by a worker that
+// stopped renewing its lease before recording the outcome
+func unconfirmed(msg *models.Message, now time.Time) bool {
+	return msg.Status == models.StatusSending && (msg.LeaseExpiresAt == nil || msg.LeaseExpiresAt.Before(now))
+}
+
+// markSent moves a message that was handed to a provider to sent
+func markSent(msg *models.Message, providerID, providerMessageID string, now time.Time) {
+	msg.Status = models.StatusSent
+	msg.RetryCount++
+	msg.NextAttemptAt = nil
+	msg.ProcessedAt = &now
+	releaseLease(msg)
+	if providerID != "" {
+		msg.SentVia = providerID
+	}
+	if providerMessageID != "" {
+		msg.ProviderMessageID = providerMessageID
+	}
+}
@@ -135,6 +135,26 @@ func (warl *WorkspaceAwareRateLimiter) GetWorkspaceStatus(workspaceID string) (s
 	return workspaceLimiter.GetStatus()
 }
 
+// Exhausted reports whether sends for senderEmail in workspaceID would be refused
+// right now, without counting a send the way Allow does. An empty senderEmail
+// checks only the workspace-level limit.
+func (warl *WorkspaceAwareRateLimiter) Exhausted(workspaceID, senderEmail string) bool {
+	if warl == nil {
+		return false
+	}
+
+	if senderEmail == "" {
+		_, remaining, _ := warl.GetWorkspaceStatus(workspaceID)
+		warl.mu.RLock()
+		workspace, exists := warl.workspaceConfigs[workspaceID]
+		warl.mu.RUnlock()
+		return exists && workspace != nil && workspace.RateLimits.WorkspaceDaily > 0 && remaining == 0
+	}
+
+	_, remaining, _ := warl.GetStatus(workspaceID, senderEmail)
+	return remaining == 0
+}
+
 // RecordSend records a successful send for rate limit tracking
 func (warl *WorkspaceAwareRateLimiter) RecordSend(workspaceID, senderEmail string) {
 	warl.mu.RLock()
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
+	"relay/internal/database"
 	"relay/pkg/models"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -29,28 +31,24 @@ func (s *Service) UpsertRecipient(recipient *models.Recipient) error {
 		metadata = []byte("{}")
 	}
 
+	d := database.For(s.db)
 	query := `
 		INSERT INTO recipients (
 			email_address, provider_id, user_id, campaign_id, 
 			first_name, last_name, status, opt_in_date, opt_out_date,
 			bounce_count, last_bounce_date, bounce_type, metadata
 		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON DUPLICATE KEY UPDATE
-			user_id = COALESCE(VALUES(user_id), user_id),
-			campaign_id = COALESCE(VALUES(campaign_id), campaign_id),
-			first_name = COALESCE(VALUES(first_name), first_name),
-			last_name = COALESCE(VALUES(last_name), last_name),
-			status = VALUES(status),
-			opt_in_date = COALESCE(VALUES(opt_in_date), opt_in_date),
-			opt_out_date = VALUES(opt_out_date),
-			bounce_count = VALUES(bounce_count),
-			last_bounce_date = VALUES(last_bounce_date),
-			bounce_type = VALUES(bounce_type),
-			metadata = VALUES(metadata),
+		` + d.Upsert([]string{"email_address", "provider_id"},
+		"status", "opt_out_date", "bounce_count", "last_bounce_date", "bounce_type", "metadata") + `,
+			user_id = COALESCE(` + d.Excluded("user_id") + `, user_id),
+			campaign_id = COALESCE(` + d.Excluded("campaign_id") + `, campaign_id),
+			first_name = COALESCE(` + d.Excluded("first_name") + `, first_name),
+			last_name = COALESCE(` + d.Excluded("last_name") + `, last_name),
+			opt_in_date = COALESCE(` + d.Excluded("opt_in_date") + `, opt_in_date),
 			updated_at = CURRENT_TIMESTAMP
 	`
 
-	result, err := s.db.Exec(query,
+	id, err := d.InsertID(s.db, query,
 		recipient.EmailAddress,
 		recipient.ProviderID,
 		recipient.InvitationID,
@@ -70,10 +68,7 @@ func (s *Service) UpsertRecipient(recipient *models.Recipient) error {
 
 	// Update the ID if this was an insert
 	if recipient.ID == 0 {
-		id, err := result.LastInsertId()
-		if err == nil {
-			recipient.ID = id
-		}
+		recipient.ID = id
 	}
 
 	return nil
@@ -221,7 +216,7 @@ func (s *Service) upsertRecipientInTransaction(tx *sql.Tx, email string, message
 			INSERT INTO recipients (email_address, provider_id, invitation_id, status)
 			VALUES (?, ?, ?, ?)
 		`
-		result, err := tx.Exec(insertQuery,
+		recipientID, err = database.For(s.db).InsertID(tx, insertQuery,
 			email,
 			message.ProviderID,
 			message.InvitationID,
@@ -230,11 +225,6 @@ func (s *Service) upsertRecipientInTransaction(tx *sql.Tx, email string, message
 		if err != nil {
 			return 0, err
 		}
-
-		recipientID, err = result.LastInsertId()
-		if err != nil {
-			return 0, err
-		}
 	} else if err != nil {
 		return 0, err
 	}
@@ -245,9 +235,9 @@ func (s *Service) upsertRecipientInTransaction(tx *sql.Tx, email string, message
 // createMessageRecipientInTransaction creates a message recipient record within a transaction
 func (s *Service) createMessageRecipientInTransaction(tx *sql.Tx, messageID string, recipientID int64, recipientType models.RecipientType) error {
 	query := `
-		INSERT IGNORE INTO message_recipients (message_id, recipient_id, recipient_type, delivery_status)
+		INSERT INTO message_recipients (message_id, recipient_id, recipient_type, delivery_status)
 		VALUES (?, ?, ?, ?)
-	`
+		` + database.For(s.db).OnConflictIgnore("message_id")
 
 	_, err := tx.Exec(query, messageID, recipientID, recipientType, models.DeliveryStatusPending)
 	return err
@@ -256,17 +246,20 @@ func (s *Service) createMessageRecipientInTransaction(tx *sql.Tx, messageID stri
 // UpdateDeliveryStatus updates the delivery status for a message recipient
 func (s *Service) UpdateDeliveryStatus(messageID string, email string, status models.DeliveryStatus, bounceReason *string) error {
 	query := `
-		UPDATE message_recipients mr
-		JOIN recipients r ON mr.recipient_id = r.id
-		JOIN messages m ON mr.message_id = m.id
-		SET mr.delivery_status = ?,
-			mr.sent_at = CASE WHEN ? = 'SENT' THEN CURRENT_TIMESTAMP ELSE mr.sent_at END,
-			mr.bounce_reason = ?,
-			mr.updated_at = CURRENT_TIMESTAMP
-		WHERE mr.message_id = ? AND r.email_address = ? AND m.provider_id = r.provider_id
+		UPDATE message_recipients
+		SET delivery_status = ?,
+			sent_at = CASE WHEN ? = 'SENT' THEN CURRENT_TIMESTAMP ELSE sent_at END,
+			bounce_reason = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE message_id = ? AND recipient_id IN (
+			SELECT r.id
+			FROM recipients r
+			JOIN messages m ON m.provider_id = r.provider_id
+			WHERE m.id = ? AND r.email_address = ?
+		)
 	`
 
-	_, err := s.db.Exec(query, status, string(status), bounceReason, messageID, email)
+	_, err := s.db.Exec(query, status, string(status), bounceReason, messageID, messageID, email)
 	if err != nil {
 		return fmt.Errorf("failed to update delivery status: %w", err)
 	}
@@ -291,18 +284,18 @@ func (s *Service) updateRecipientBounceStatus(email, messageID string, bounceRea
 		}
 	}
 
+	// status is assigned first so both MySQL and PostgreSQL compare the count before the increment
 	query := `
-		UPDATE recipients r
-		JOIN messages m ON r.provider_id = m.provider_id
-		SET r.bounce_count = r.bounce_count + 1,
-			r.last_bounce_date = CURRENT_TIMESTAMP,
-			r.bounce_type = ?,
-			r.status = CASE 
-				WHEN ? = 'HARD' OR r.bounce_count >= 5 THEN 'BOUNCED'
-				ELSE r.status
+		UPDATE recipients
+		SET status = CASE 
+				WHEN ? = 'HARD' OR bounce_count + 1 >= 5 THEN 'BOUNCED'
+				ELSE status
 			END,
-			r.updated_at = CURRENT_TIMESTAMP
-		WHERE r.email_address = ? AND m.id = ?
+			bounce_count = bounce_count + 1,
+			last_bounce_date = CURRENT_TIMESTAMP,
+			bounce_type = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE email_address = ? AND provider_id = (SELECT provider_id FROM messages WHERE id = ?)
 	`
 
 	_, err := s.db.Exec(query, bounceType, bounceType, email, messageID)
@@ -366,10 +359,9 @@ func (s *Service) RecordEngagementEvent(messageID, email string, eventType model
 	case models.EventTypeUnsubscribe:
 		// Update recipient status
 		updateRecipientQuery := `
-			UPDATE recipients r
-			JOIN message_recipients mr ON r.id = mr.recipient_id
-			SET r.status = 'UNSUBSCRIBED', r.opt_out_date = CURRENT_TIMESTAMP, r.updated_at = CURRENT_TIMESTAMP
-			WHERE mr.id = ?
+			UPDATE recipients
+			SET status = 'UNSUBSCRIBED', opt_out_date = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE id = (SELECT recipient_id FROM message_recipients WHERE id = ?)
 		`
 		_, err = tx.Exec(updateRecipientQuery, messageRecipientID)
 		if err != nil {
@@ -480,14 +472,15 @@ func (s *Service) GetCampaignStats(campaignID, workspaceID string) (*models.Invi
 // CleanupInactiveRecipients removes inactive recipients based on retention policy
 func (s *Service) CleanupInactiveRecipients(retentionDays int) error {
 	query := `
-		DELETE r FROM recipients r
-		LEFT JOIN message_recipients mr ON r.id = mr.recipient_id
-		WHERE r.status = 'INACTIVE'
-		  AND r.updated_at < DATE_SUB(NOW(), INTERVAL ? DAY)
-		  AND mr.id IS NULL  -- No associated messages
+		DELETE FROM recipients
+		WHERE status = 'INACTIVE'
+		  AND updated_at < ?
+		  AND NOT EXISTS (  -- No associated messages
+			SELECT 1 FROM message_recipients mr WHERE mr.recipient_id = recipients.id
+		  )
 	`
 
-	result, err := s.db.Exec(query, retentionDays)
+	result, err := s.db.Exec(query, time.Now().AddDate(0, 0, -retentionDays))
 	if err != nil {
 		return fmt.Errorf("failed to cleanup inactive recipients: %w", err)
 	}
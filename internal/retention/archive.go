@@ -0,0 +1,199 @@
+package retention
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"relay/internal/blobstore"
+	"relay/internal/config"
+	"relay/pkg/models"
+)
+
+// ErrNotArchived is returned when no archive holds the requested message
+var ErrNotArchived = errors.New("message is not archived")
+
+// Record is one line of an archive file: a finished message with its content
+// inlined, as it was before retention purged anything
+type Record struct {
+	Message    *models.Message  `json:"message"`
+	Raw        []byte           `json:"raw,omitempty"` // Message.RawMessage, which the message does not serialize
+	Attempts   []models.Attempt `json:"attempts,omitempty"`
+	ArchivedAt time.Time        `json:"archived_at"`
+}
+
+// indexEntry points from a message ID to the archive file holding it
+type indexEntry struct {
+	ID   string `json:"id"`
+	File string `json:"file"` // Relative to the archive root
+}
+
+// Archive keeps gzip compressed NDJSON files in an object store, one file per
+// workspace per batch, laid out as <workspace>/<yyyy>/<mm>/<dd>/<time>-<random>.ndjson.gz.
+// Each archived message has a small index object pointing at the file holding
+// its latest copy, so a message is found without reading every archive. Every
+// replica writes its own files and index objects, so the archive can be shared.
+type Archive struct {
+	objects  blobstore.Objects
+	location string // Where the archive is kept, for logs
+}
+
+// NewArchive opens or creates an archive in the directory dir. Replicas only
+// share it when dir is on a volume they all mount.
+func NewArchive(dir string) (*Archive, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("archive path is empty")
+	}
+	store, err := blobstore.NewFileStore(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	return &Archive{objects: store, location: dir}, nil
+}
+
+// OpenArchive opens the archive selected by RETENTION_ARCHIVE_STORE: a directory
+// at RETENTION_ARCHIVE_PATH, or objects under RETENTION_ARCHIVE_S3_PREFIX in the
+// bucket of the s3 attachment store
+func OpenArchive(cfg config.RetentionConfig, s3 config.S3Config) (*Archive, error) {
+	switch cfg.ArchiveStore {
+	case "", "fs":
+		return NewArchive(cfg.ArchivePath)
+	case "s3":
+		s3.Prefix = cfg.ArchiveS3Prefix
+		store, err := blobstore.NewS3Store(s3)
+		if err != nil {
+			return nil, err
+		}
+		return &Archive{objects: store, location: "s3://" + s3.Bucket + "/" + s3.Prefix}, nil
+	default:
+		return nil, fmt.Errorf("unsupported RETENTION_ARCHIVE_STORE %q (want fs or s3)", cfg.ArchiveStore)
+	}
+}
+
+// Location describes where the archive is kept
+func (a *Archive) Location() string {
+	return a.location
+}
+
+// Write stores records in a new archive file and indexes them. The file is
+// stored before it is indexed, so an indexed message is always readable.
+func (a *Archive) Write(workspaceID string, records []Record, now time.Time) (string, error) {
+	if len(records) == 0 {
+		return "", nil
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to name archive file: %w", err)
+	}
+	file := path.Join(safeName(workspaceID), now.UTC().Format("2006/01/02"),
+		fmt.Sprintf("%s-%s.ndjson.gz", now.UTC().Format("20060102T150405.000000000Z"), hex.EncodeToString(suffix)))
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return "", fmt.Errorf("failed to encode message %s: %w", record.Message.ID, err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress archive: %w", err)
+	}
+	if err := a.objects.PutObject(context.Background(), file, buf.Bytes()); err != nil {
+		return "", fmt.Errorf("failed to store archive: %w", err)
+	}
+
+	return file, a.index(records, file)
+}
+
+// index points the index object of each record at file
+func (a *Archive) index(records []Record, file string) error {
+	for _, record := range records {
+		entry, _ := json.Marshal(indexEntry{ID: record.Message.ID, File: file})
+		if err := a.objects.PutObject(context.Background(), indexName(record.Message.ID), entry); err != nil {
+			return fmt.Errorf("failed to write archive index: %w", err)
+		}
+	}
+	return nil
+}
+
+// Find returns the most recently archived copy of a message
+func (a *Archive) Find(id string) (*Record, error) {
+	file, err := a.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := a.objects.OpenObject(context.Background(), file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %w", file, err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive %s: %w", file, err)
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(gz)
+	for {
+		var record Record
+		if err := dec.Decode(&record); err != nil {
+			return nil, fmt.Errorf("message %s not found in archive %s: %w", id, file, err)
+		}
+		if record.Message != nil && record.Message.ID == id {
+			record.Message.RawMessage = record.Raw
+			return &record, nil
+		}
+	}
+}
+
+// lookup returns the file the index object of id points at
+func (a *Archive) lookup(id string) (string, error) {
+	r, err := a.objects.OpenObject(context.Background(), indexName(id))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return "", ErrNotArchived
+	} else if err != nil {
+		return "", fmt.Errorf("failed to open archive index: %w", err)
+	}
+	defer r.Close()
+
+	var entry indexEntry
+	if err := json.NewDecoder(r).Decode(&entry); err != nil {
+		return "", fmt.Errorf("failed to read archive index: %w", err)
+	}
+	if entry.ID != id || entry.File == "" {
+		return "", ErrNotArchived
+	}
+	return entry.File, nil
+}
+
+// indexName spreads the index objects of message IDs over 256 directories
+func indexName(id string) string {
+	sum := sha1.Sum([]byte(id))
+	name := hex.EncodeToString(sum[:])
+	return "index/" + name[:2] + "/" + name + ".json"
+}
+
+// safeName turns a workspace ID into a single path element
+func safeName(id string) string {
+	if id == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, id)
+}
@@ -0,0 +1,289 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"relay/internal/blobstore"
+	"relay/internal/config"
+	"relay/internal/queue"
+	"relay/pkg/models"
+)
+
+// WorkspaceSource lists the workspaces whose messages retention applies to
+type WorkspaceSource interface {
+	GetAllWorkspaces() map[string]*config.WorkspaceConfig
+}
+
+// Result counts what one run of the retention job did
+type Result struct {
+	Archived          int `json:"archived"`
+	BodiesPurged      int `json:"bodies_purged"`
+	AttachmentsPurged int `json:"attachments_purged"`
+	Deleted           int `json:"deleted"`
+}
+
+// archiveClaim is how long a run holds the messages it is archiving. Other
+// replicas skip them meanwhile; if the run dies they are archived once it ends.
+const archiveClaim = 15 * time.Minute
+
+// Service archives finished messages once the earliest stage of their
+// workspace's retention policy is due, then strips bodies, strips attachments
+// and deletes rows as each stage comes due. Nothing is removed from a message
+// before it is archived. Every replica may run the service: each batch is
+// claimed in the queue, and the archive must be storage they all share.
+type Service struct {
+	queue      queue.Archiver
+	archive    *Archive
+	workspaces WorkspaceSource
+	blobs      blobstore.Store
+	cfg        config.RetentionConfig
+	now        func() time.Time
+
+	mu     sync.Mutex // Serializes runs
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewService creates a retention service. blobs is the attachment store, or
+// nil when attachments are kept inline.
+func NewService(q queue.Archiver, archive *Archive, workspaces WorkspaceSource, blobs blobstore.Store, cfg config.RetentionConfig) *Service {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Service{
+		queue:      q,
+		archive:    archive,
+		workspaces: workspaces,
+		blobs:      blobs,
+		cfg:        cfg,
+		now:        time.Now,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Start runs the job every configured interval until Stop is called
+func (s *Service) Start() {
+	interval := s.cfg.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	log.Printf("Starting message retention job (every %v, archive at %s)", interval, s.archive.Location())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Run(); err != nil {
+				log.Printf("Error during retention run: %v", err)
+			}
+		case <-s.ctx.Done():
+			log.Println("Message retention job stopped")
+			return
+		}
+	}
+}
+
+// Stop ends the job; a run in progress stops after its current batch
+func (s *Service) Stop() {
+	s.cancel()
+}
+
+// Policy returns the retention policy in effect for a workspace
+func (s *Service) Policy(ws *config.WorkspaceConfig) config.RetentionPolicy {
+	if ws == nil {
+		return s.cfg.Default
+	}
+	return s.cfg.Default.Merge(ws.Retention)
+}
+
+// Run applies every workspace's policy once. A failing workspace is logged
+// and does not stop the others; the first error is returned.
+func (s *Service) Run() (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total Result
+	var firstErr error
+	for id, ws := range s.workspaces.GetAllWorkspaces() {
+		result, err := s.runWorkspace(id, s.Policy(ws))
+		total.Archived += result.Archived
+		total.BodiesPurged += result.BodiesPurged
+		total.AttachmentsPurged += result.AttachmentsPurged
+		total.Deleted += result.Deleted
+		if err != nil {
+			log.Printf("Error: Retention failed for workspace %s: %v", id, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("workspace %s: %w", id, err)
+			}
+		}
+	}
+
+	if total != (Result{}) {
+		log.Printf("Retention run archived %d messages, stripped %d bodies and %d attachment sets, deleted %d messages",
+			total.Archived, total.BodiesPurged, total.AttachmentsPurged, total.Deleted)
+	}
+	return total, firstErr
+}
+
+// runWorkspace archives the workspace's messages due for their first stage,
+// then applies each stage
+func (s *Service) runWorkspace(workspaceID string, policy config.RetentionPolicy) (Result, error) {
+	var result Result
+	stages := []struct {
+		scope queue.PurgeScope
+		days  int
+		count *int
+	}{
+		{queue.PurgeBodies, policy.BodyDays, &result.BodiesPurged},
+		{queue.PurgeAttachments, policy.AttachmentDays, &result.AttachmentsPurged},
+		{queue.PurgeRows, policy.DeleteDays, &result.Deleted},
+	}
+
+	earliest := 0
+	for _, stage := range stages {
+		if stage.days > 0 && (earliest == 0 || stage.days < earliest) {
+			earliest = stage.days
+		}
+	}
+	if earliest == 0 {
+		return result, nil
+	}
+
+	now := s.now()
+	archived, err := s.archiveDue(workspaceID, cutoff(now, earliest))
+	result.Archived = archived
+	if err != nil {
+		return result, err
+	}
+
+	for _, stage := range stages {
+		if stage.days <= 0 {
+			continue
+		}
+		filter := queue.RetentionFilter{WorkspaceID: workspaceID, Before: cutoff(now, stage.days), Limit: s.cfg.BatchSize}
+		for s.ctx.Err() == nil {
+			n, err := s.queue.Purge(stage.scope, filter)
+			*stage.count += n
+			if err != nil {
+				return result, fmt.Errorf("failed to purge %s: %w", stage.scope, err)
+			}
+			if n < filter.Limit {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// archiveDue claims unarchived messages finished before the cutoff, writes them
+// to the archive, one file per batch, and marks them archived
+func (s *Service) archiveDue(workspaceID string, before time.Time) (int, error) {
+	filter := queue.RetentionFilter{WorkspaceID: workspaceID, Before: before, Limit: s.cfg.BatchSize}
+	archived := 0
+	for s.ctx.Err() == nil {
+		messages, err := s.queue.ClaimUnarchived(filter, time.Now().Add(archiveClaim))
+		if err != nil {
+			return archived, fmt.Errorf("failed to list messages to archive: %w", err)
+		}
+		if len(messages) == 0 {
+			return archived, nil
+		}
+
+		now := s.now()
+		records := make([]Record, 0, len(messages))
+		ids := make([]string, 0, len(messages))
+		for _, msg := range messages {
+			record, err := s.record(msg, now)
+			if err != nil {
+				return archived, err
+			}
+			records = append(records, record)
+			ids = append(ids, msg.ID)
+		}
+
+		if _, err := s.archive.Write(workspaceID, records, now); err != nil {
+			return archived, err
+		}
+		if err := s.queue.MarkArchived(ids, now); err != nil {
+			// The messages are archived again once the claim runs out; the index points at the newest copy
+			return archived, fmt.Errorf("failed to mark messages archived: %w", err)
+		}
+		archived += len(ids)
+
+		if len(messages) < filter.Limit {
+			break
+		}
+	}
+	return archived, nil
+}
+
+// record builds the archive record of a message with its attachment content
+// read back from the store. The queue's message is not modified.
+func (s *Service) record(msg *models.Message, now time.Time) (Record, error) {
+	attempts, err := s.queue.GetAttempts(msg.ID)
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to read attempts of message %s: %w", msg.ID, err)
+	}
+
+	archived := *msg
+	archived.Attachments = make([]models.Attachment, len(msg.Attachments))
+	for i, att := range msg.Attachments {
+		if att.BlobKey != "" {
+			content, err := blobstore.ReadAll(s.ctx, s.blobs, att)
+			switch {
+			case err == nil:
+				att.Content = content
+				att.BlobKey = ""
+			case errors.Is(err, blobstore.ErrNotFound):
+				// Keep the reference; the content is gone and waiting will not bring it back
+				log.Printf("Warning: Attachment %s of message %s is missing from the store, archiving its reference", att.Name, msg.ID)
+			default:
+				return Record{}, fmt.Errorf("failed to read attachment %s of message %s: %w", att.Name, msg.ID, err)
+			}
+		}
+		archived.Attachments[i] = att
+	}
+	archived.RawMessage = nil
+	archived.ArchivedAt = &now
+
+	return Record{
+		Message:    &archived,
+		Raw:        msg.RawMessage,
+		Attempts:   attempts,
+		ArchivedAt: now,
+	}, nil
+}
+
+// Find returns the archived copy of a message
+func (s *Service) Find(id string) (*Record, error) {
+	return s.archive.Find(id)
+}
+
+// Restore puts an archived message back into the queue with its content and
+// attempt history. It is not sent again; its retention clock restarts so it
+// stays available for investigation for another full policy period.
+func (s *Service) Restore(id string) (*models.Message, error) {
+	record, err := s.archive.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.queue.Restore(record.Message, record.Attempts); err != nil {
+		return nil, fmt.Errorf("failed to restore message %s: %w", id, err)
+	}
+	log.Printf("Restored message %s from the archive", id)
+	return record.Message, nil
+}
+
+// cutoff is the finish time before which messages are past days of retention
+func cutoff(now time.Time, days int) time.Time {
+	return now.AddDate(0, 0, -days)
+}
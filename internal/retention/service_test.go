@@ -0,0 +1,209 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"relay/internal/blobstore"
+	"relay/internal/config"
+	"relay/internal/queue"
+	"relay/pkg/models"
+)
+
+// staticWorkspaces is a fixed set of workspaces
+type staticWorkspaces map[string]*config.WorkspaceConfig
+
+func (w staticWorkspaces) GetAllWorkspaces() map[string]*config.WorkspaceConfig {
+	return w
+}
+
+func TestRetentionArchivesPurgesAndRestores(t *testing.T) {
+	ctx := context.Background()
+	blobs, err := blobstore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	q := queue.NewMemoryQueue()
+	q.SetAttachmentStore(blobs)
+
+	enqueue := func(id, workspaceID string, status models.MessageStatus) {
+		msg := &models.Message{
+			ID:          id,
+			From:        "sender@example.com",
+			To:          []string{"patient@example.com"},
+			Subject:     "Results",
+			HTML:        "<p>Your results</p>",
+			Text:        "Your results",
+			Attachments: []models.Attachment{{Name: "results.pdf", ContentType: "application/pdf", Content: []byte("%PDF-1.4 " + id)}},
+			ProviderID:  workspaceID,
+			Status:      models.StatusQueued,
+			QueuedAt:    time.Now(),
+		}
+		msg.RawMessage = []byte("Subject: Results\r\n\r\nYour results")
+		if err := q.Enqueue(msg); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		if status == models.StatusSent {
+			// Sent the way the processor sends, so the provider is recorded apart from the workspace
+			providerID := "gmail-" + workspaceID
+			q.Dequeue(1)
+			q.RecordAttempt(id, models.Attempt{Number: 1, ProviderID: providerID, AttemptedAt: time.Now()})
+			if err := q.MarkSending(id, providerID); err != nil {
+				t.Fatalf("MarkSending: %v", err)
+			}
+			if err := q.MarkSent(id, providerID, "provider-"+id); err != nil {
+				t.Fatalf("MarkSent: %v", err)
+			}
+		}
+	}
+	enqueue("sent-ws1", "ws1", models.StatusSent)
+	enqueue("sent-ws2", "ws2", models.StatusSent)
+	enqueue("queued-ws1", "ws1", models.StatusQueued)
+
+	archive, err := NewArchive(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewArchive: %v", err)
+	}
+	workspaces := staticWorkspaces{
+		"ws1": {ID: "ws1", Retention: &config.RetentionPolicy{AttachmentDays: 20}},
+		"ws2": {ID: "ws2", Retention: &config.RetentionPolicy{BodyDays: -1, DeleteDays: -1}},
+	}
+	service := NewService(q, archive, workspaces, blobs, config.RetentionConfig{
+		BatchSize: 1,
+		Default:   config.RetentionPolicy{BodyDays: 10, DeleteDays: 60},
+	})
+	start := time.Now()
+
+	// Past the body stage only
+	service.now = func() time.Time { return start.AddDate(0, 0, 15) }
+	result, err := service.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if result != (Result{Archived: 1, BodiesPurged: 1}) {
+		t.Errorf("first run = %+v", result)
+	}
+	msg, _ := q.Get("sent-ws1")
+	if msg.HTML != "" || msg.Text != "" || msg.RawMessage != nil || msg.ArchivedAt == nil {
+		t.Errorf("body not purged after archiving: %+v", msg)
+	}
+	if len(msg.Attachments) != 1 {
+		t.Errorf("attachments purged before their stage")
+	}
+	for _, id := range []string{"queued-ws1", "sent-ws2"} {
+		if msg, _ := q.Get(id); msg.HTML == "" || msg.ArchivedAt != nil {
+			t.Errorf("%s was touched: %+v", id, msg)
+		}
+	}
+
+	record, err := service.Find("sent-ws1")
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if record.Message.HTML != "<p>Your results</p>" || string(record.Message.RawMessage) != "Subject: Results\r\n\r\nYour results" {
+		t.Errorf("archived bodies = %q, %q", record.Message.HTML, record.Message.RawMessage)
+	}
+	if att := record.Message.Attachments[0]; string(att.Content) != "%PDF-1.4 sent-ws1" || att.BlobKey != "" {
+		t.Errorf("archived attachment not inlined: %+v", att)
+	}
+	if len(record.Attempts) != 1 {
+		t.Errorf("archived %d attempts, want 1", len(record.Attempts))
+	}
+	if _, err := service.Find("queued-ws1"); err != ErrNotArchived {
+		t.Errorf("Find unarchived = %v, want ErrNotArchived", err)
+	}
+
+	// Past every stage
+	service.now = func() time.Time { return start.AddDate(0, 0, 70) }
+	result, err = service.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if result != (Result{AttachmentsPurged: 1, Deleted: 1}) {
+		t.Errorf("second run = %+v", result)
+	}
+	if _, err := q.Get("sent-ws1"); err == nil {
+		t.Error("message not deleted")
+	}
+	if _, err := q.CollectBlobs(time.Now().Add(time.Minute), 100); err != nil {
+		t.Fatalf("CollectBlobs: %v", err)
+	}
+	if _, err := blobs.Open(ctx, blobstore.Key([]byte("%PDF-1.4 sent-ws1"))); err != blobstore.ErrNotFound {
+		t.Errorf("purged attachment left in the store: %v", err)
+	}
+
+	restored, err := service.Restore("sent-ws1")
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	msg, err = q.Get("sent-ws1")
+	if err != nil {
+		t.Fatalf("restored message missing: %v", err)
+	}
+	if msg.ID != restored.ID || msg.HTML != "<p>Your results</p>" || msg.Status != models.StatusSent || msg.RestoredAt == nil {
+		t.Errorf("restored message = %+v", msg)
+	}
+	if att := msg.Attachments[0]; att.Content != nil || att.BlobKey != blobstore.Key([]byte("%PDF-1.4 sent-ws1")) {
+		t.Errorf("restored attachment not put back in the store: %+v", att)
+	}
+	if content, err := blobstore.ReadAll(ctx, blobs, msg.Attachments[0]); err != nil || string(content) != "%PDF-1.4 sent-ws1" {
+		t.Errorf("restored attachment content = %q, %v", content, err)
+	}
+	if attempts, _ := q.GetAttempts("sent-ws1"); len(attempts) != 1 {
+		t.Errorf("restored %d attempts, want 1", len(attempts))
+	}
+}
+
+func TestReplicasArchiveEachMessageOnce(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("sent-%d", i)
+		q.Enqueue(&models.Message{ID: id, To: []string{"patient@example.com"}, ProviderID: "ws1", Status: models.StatusQueued, QueuedAt: time.Now()})
+		q.Dequeue(1)
+		q.MarkSending(id, "gmail-ws1")
+		q.MarkSent(id, "gmail-ws1", "provider-"+id)
+	}
+
+	dir := t.TempDir()
+	workspaces := staticWorkspaces{"ws1": {ID: "ws1"}}
+	cfg := config.RetentionConfig{BatchSize: 3, Default: config.RetentionPolicy{BodyDays: 10}}
+	results := make(chan Result, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		// Each replica opens the shared archive itself
+		archive, err := NewArchive(dir)
+		if err != nil {
+			t.Fatalf("NewArchive: %v", err)
+		}
+		service := NewService(q, archive, workspaces, nil, cfg)
+		service.now = func() time.Time { return time.Now().AddDate(0, 0, 15) }
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := service.Run()
+			if err != nil {
+				t.Errorf("Run: %v", err)
+			}
+			results <- result
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	archived := 0
+	for result := range results {
+		archived += result.Archived
+	}
+	if archived != 20 {
+		t.Errorf("replicas archived %d messages, want each of the 20 once", archived)
+	}
+	archive, _ := NewArchive(dir)
+	for i := 0; i < 20; i++ {
+		if _, err := archive.Find(fmt.Sprintf("sent-%d", i)); err != nil {
+			t.Errorf("Find: %v", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package smtp
+
+import (
+	"fmt"
+
+	"relay/internal/workspace"
+	"relay/pkg/models"
+)
+
+// IntakeMessage builds a message the same way an SMTP session does: sender
+// workspace resolution and domain rewriting, recipient validation, X-MC-*
+// header handling and workspace header rewrite rules. It lets other intake
+// paths such as the Mandrill HTTP API produce identical queued messages.
+type IntakeMessage struct {
+	session *Session
+}
+
+// NewIntakeMessage starts a message for the given envelope sender and recipients
+func NewIntakeMessage(workspaceManager *workspace.Manager, from string, to []string) (*IntakeMessage, error) {
+	session := &Session{workspaceManager: workspaceManager}
+
+	if err := session.Mail(from, nil); err != nil {
+		return nil, err
+	}
+	for _, rcpt := range to {
+		if err := session.Rcpt(rcpt, nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(session.to) == 0 {
+		return nil, fmt.Errorf("at least one recipient is required")
+	}
+
+	session.message.To = session.to
+	return &IntakeMessage{session: session}, nil
+}
+
+// ParseRaw parses raw RFC 5322 bytes into the message, as SMTP DATA does
+func (m *IntakeMessage) ParseRaw(data []byte) error {
+	return m.session.parseMessage(data)
+}
+
+// ApplyHeaders processes structured headers through the SMTP header rules.
+// It should be called once, after which missing rewrite-rule headers are added.
+func (m *IntakeMessage) ApplyHeaders(headers map[string]string) {
+	for name, value := range headers {
+		m.session.processHeader(name, value)
+	}
+	m.session.addMissingHeaders()
+}
+
+// SetIdempotencyKey sets the client's idempotency key, taking the place of any
+// Idempotency-Key header in the message
+func (m *IntakeMessage) SetIdempotencyKey(key string) {
+	m.session.clientKey = key
+}
+
+// IdempotencyKey returns the deduplication key of the message, built as SMTP
+// DATA builds it, or "" when the message has no idempotency key or Message-ID
+func (m *IntakeMessage) IdempotencyKey() string {
+	return m.session.idempotencyKey()
+}
+
+// ApplyExpiry sets the message's expires_at from X-Relay-Expires or the
+// workspace default for its email_type, as SMTP DATA does before queueing. Call
+// it once send_at and any expires_at given by the caller are set.
+func (m *IntakeMessage) ApplyExpiry() {
+	m.session.applyExpiry()
+}
+
+// Message returns the message being built
+func (m *IntakeMessage) Message() *models.Message {
+	return m.session.message
+}
@@ -2,17 +2,19 @@ package smtp
 
 import (
 	"crypto/subtle"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"relay/internal/config"
+	"relay/internal/mimeparser"
 	"relay/internal/queue"
 	"relay/internal/validation"
 	"relay/internal/workspace"
@@ -26,6 +28,7 @@ type Server struct {
 	config           *config.SMTPConfig
 	queue            queue.Queue
 	workspaceManager *workspace.Manager
+	backend          *Backend
 	server           *smtp.Server
 }
 
@@ -65,10 +68,17 @@ func NewServer(cfg *config.SMTPConfig, q queue.Queue, workspaceManager *workspac
 	server.MaxRecipients = 100
 	server.AllowInsecureAuth = false // Require secure authentication
 
+	s.backend = backend
 	s.server = server
 	return s
 }
 
+// SetDedupWindow sets how long a message's idempotency key or Message-ID keeps
+// later copies of it from being queued; zero turns deduplication off
+func (s *Server) SetDedupWindow(window time.Duration) {
+	s.backend.dedupWindow = window
+}
+
 func (s *Server) Start() error {
 	// Defensive programming: check for nil server
 	if s == nil {
@@ -94,6 +104,7 @@ func (s *Server) Stop() error {
 type Backend struct {
 	queue            queue.Queue
 	workspaceManager *workspace.Manager
+	dedupWindow      time.Duration
 }
 
 func (b *Backend) NewSession(_ *smtp.Conn) (smtp.Session, error) {
@@ -109,15 +120,19 @@ func (b *Backend) NewSession(_ *smtp.Conn) (smtp.Session, error) {
 		log.Printf("Warning: WorkspaceManager is nil in session creation - workspace routing disabled")
 	}
 	
-	return &Session{queue: b.queue, workspaceManager: b.workspaceManager}, nil
+	return &Session{queue: b.queue, workspaceManager: b.workspaceManager, dedupWindow: b.dedupWindow}, nil
 }
 
 type Session struct {
 	queue            queue.Queue
 	workspaceManager *workspace.Manager
+	dedupWindow      time.Duration
 	from             string
 	to               []string
 	message          *models.Message
+	clientKey        string // Idempotency-Key or X-Idempotency-Key header
+	messageID        string // Message-ID header
+	expiresIn        time.Duration // X-Relay-Expires given as a duration
 }
 
 func (s *Session) AuthPlain(username, password string) error {
@@ -266,18 +281,58 @@ func (s *Session) Data(r io.Reader) error {
 		return fmt.Errorf("message is nil - cannot enqueue")
 	}
 	
-	if err := s.queue.Enqueue(s.message); err != nil {
+	s.applyExpiry()
+
+	id, duplicate, err := queue.EnqueueDeduplicated(s.queue, s.message, s.idempotencyKey(), s.dedupWindow)
+	if err != nil {
 		return fmt.Errorf("failed to queue message: %w", err)
 	}
 
-	log.Printf("Message %s queued successfully", s.message.ID)
-	return nil
+	if duplicate {
+		log.Printf("Message %s is a retry of message %s - not queued again", s.message.ID, id)
+	} else {
+		log.Printf("Message %s queued successfully", id)
+	}
+	return queuedAs(id)
+}
+
+// queuedAs is the reply to an accepted DATA. go-smtp sends any SMTPError
+// returned by Data as the reply, which lets a 250 carry the message ID.
+func queuedAs(id string) error {
+	return &smtp.SMTPError{
+		Code:         250,
+		EnhancedCode: smtp.EnhancedCode{2, 0, 0},
+		Message:      "OK: queued as " + id,
+	}
+}
+
+// idempotencyKey identifies retries of this transaction: the client's
+// idempotency key, or failing that its Message-ID, for the same envelope sender
+// and recipients. It is empty when the message carries neither.
+func (s *Session) idempotencyKey() string {
+	kind, value := "key", s.clientKey
+	if value == "" {
+		kind, value = "message-id", s.messageID
+	}
+	if value == "" {
+		return ""
+	}
+
+	recipients := make([]string, len(s.to))
+	for i, rcpt := range s.to {
+		recipients[i] = strings.ToLower(rcpt)
+	}
+	sort.Strings(recipients)
+	return queue.IdempotencyKey(kind, strings.ToLower(s.from), value, strings.Join(recipients, ","))
 }
 
 func (s *Session) Reset() {
 	s.from = ""
 	s.to = nil
 	s.message = nil
+	s.clientKey = ""
+	s.messageID = ""
+	s.expiresIn = 0
 }
 
 func (s *Session) Logout() error {
@@ -285,107 +340,76 @@ func (s *Session) Logout() error {
 }
 
 func (s *Session) parseMessage(data []byte) error {
-	lines := strings.Split(string(data), "\n")
-	headers := true
-	var body strings.Builder
-	
-	// Variables to handle multi-line headers (RFC 5322 folding)
-	var currentHeaderKey string
-	var currentHeaderValue strings.Builder
-
-	for _, line := range lines {
-		if headers {
-			if line == "" || line == "\r" {
-				// Process any pending header before switching to body
-				if currentHeaderKey != "" {
-					s.processHeader(currentHeaderKey, strings.TrimSpace(currentHeaderValue.String()))
-					currentHeaderKey = ""
-					currentHeaderValue.Reset()
-				}
-				headers = false
-				continue
-			}
+	parsed, err := mimeparser.Parse(data)
+	if err != nil {
+		log.Printf("Failed to parse MIME message %s: %v", s.message.ID, err)
+		return fmt.Errorf("malformed message: %w", err)
+	}
 
-			// Check if this is a continuation of the previous header (starts with whitespace)
-			if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && currentHeaderKey != "" {
-				// This is a continuation line - append to current header value
-				currentHeaderValue.WriteString(" ")
-				currentHeaderValue.WriteString(strings.TrimSpace(line))
-				continue
-			}
+	for _, header := range parsed.Headers {
+		s.processHeader(header.Name, header.Value)
+	}
 
-			// Process any pending header
-			if currentHeaderKey != "" {
-				s.processHeader(currentHeaderKey, strings.TrimSpace(currentHeaderValue.String()))
-				currentHeaderKey = ""
-				currentHeaderValue.Reset()
-			}
+	s.message.HTML = parsed.HTML
+	s.message.Text = parsed.Text
+	s.message.Attachments = parsed.Attachments
 
-			// Parse new header
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				currentHeaderKey = strings.TrimSpace(parts[0])
-				currentHeaderValue.WriteString(strings.TrimSpace(parts[1]))
-			}
-		} else {
-			body.WriteString(line)
-			body.WriteString("\n")
-		}
-	}
-	
-	// Process any remaining header
-	if headers && currentHeaderKey != "" {
-		s.processHeader(currentHeaderKey, strings.TrimSpace(currentHeaderValue.String()))
+	if len(parsed.Attachments) > 0 {
+		log.Printf("DEBUG: Parsed %d attachment(s) for message %s", len(parsed.Attachments), s.message.ID)
 	}
 
-	bodyContent := strings.TrimSpace(body.String())
+	// Add any missing headers defined in workspace rewrite rules
+	s.addMissingHeaders()
 
-	// Handle Content-Transfer-Encoding
-	if encoding := s.message.Headers["Content-Transfer-Encoding"]; encoding != "" {
-		switch strings.ToLower(encoding) {
-		case "base64":
-			if decoded, err := base64.StdEncoding.DecodeString(bodyContent); err == nil {
-				bodyContent = string(decoded)
-			} else {
-				log.Printf("Warning: Failed to decode Base64 body: %v", err)
-			}
-		case "quoted-printable":
-			// Could add quoted-printable decoding here if needed
-			log.Printf("Warning: Quoted-printable encoding detected but not decoded")
-		}
+	// Keep the original bytes for workspaces that send them unchanged
+	if s.usesRawPassthrough() {
+		s.message.RawMessage = data
 	}
 
-	if isHTML(s.message.Headers["Content-Type"]) {
-		s.message.HTML = bodyContent
-	} else {
-		s.message.Text = bodyContent
+	return nil
+}
+
+// usesRawPassthrough reports whether the sender's workspace submits the original DATA bytes
+func (s *Session) usesRawPassthrough() bool {
+	if s.workspaceManager == nil || s.message == nil || s.message.ProviderID == "" {
+		return false
 	}
 
-	// Add any missing headers defined in workspace rewrite rules
-	s.addMissingHeaders()
+	workspace, err := s.workspaceManager.GetWorkspaceForSender(s.from)
+	if err != nil || workspace == nil {
+		return false
+	}
 
-	return nil
+	return workspace.Gmail != nil && workspace.Gmail.Enabled && workspace.Gmail.RawPassthrough
 }
 
 // processHeader processes a single header key-value pair
 func (s *Session) processHeader(key, value string) {
+	if strings.EqualFold(key, "message-id") {
+		s.messageID = strings.TrimSpace(value)
+	}
+
+	// Relay-control headers (X-MC-SendAt, X-Relay-Expires, Idempotency-Key and
+	// X-MC-Important) are consumed at intake and not passed on to providers
 	switch strings.ToLower(key) {
 	case "subject":
+		value = mimeparser.DecodeHeaderValue(value)
 		// Validate subject
 		if err := validation.ValidateSubject(value); err != nil {
 			log.Printf("Invalid subject: %v", err)
 			value = validation.SanitizeString(value) // Sanitize instead of rejecting
 		}
 		s.message.Subject = value
-	case "content-type":
-		s.message.Headers["Content-Type"] = value
-	case "content-transfer-encoding":
-		s.message.Headers["Content-Transfer-Encoding"] = value
+	case "content-type", "content-transfer-encoding", "mime-version":
+		// The body has already been decoded into HTML/Text/Attachments, so the
+		// original MIME structure headers no longer describe it
+		return
 	case "cc":
 		s.message.CC = parseAddresses(value)
 	case "bcc":
 		s.message.BCC = parseAddresses(value)
 	case "x-mc-tags":
+		value = mimeparser.DecodeHeaderValue(value)
 		// Store the original header for visibility
 		s.message.Headers["X-MC-Tags"] = value
 		// Parse tags array - could be JSON array or comma-separated
@@ -396,7 +420,31 @@ func (s *Session) processHeader(key, value string) {
 			}
 			s.message.Metadata["tags"] = tags
 		}
+	case "x-mc-sendat":
+		sendAt, err := parseSendAtHeader(value)
+		if err != nil {
+			log.Printf("Warning: Ignoring invalid X-MC-SendAt %q: %v", value, err)
+			return
+		}
+		if sendAt.After(time.Now()) {
+			s.message.SendAt = &sendAt
+		}
+	case "x-relay-expires":
+		expiresAt, expiresIn, err := parseExpiresHeader(value)
+		if err != nil {
+			log.Printf("Warning: Ignoring invalid X-Relay-Expires %q: %v", value, err)
+			return
+		}
+		s.message.ExpiresAt, s.expiresIn = expiresAt, expiresIn
+	case "idempotency-key", "x-idempotency-key":
+		s.clientKey = strings.TrimSpace(value)
+	case "x-mc-important":
+		// Important messages take the high priority lane
+		if important, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil && important {
+			s.message.Priority = models.PriorityHigh
+		}
 	case "x-mc-metadata":
+		value = mimeparser.DecodeHeaderValue(value)
 		// Store the original header for visibility
 		s.message.Headers["X-MC-Metadata"] = value
 		// Parse JSON metadata
@@ -413,6 +461,13 @@ func (s *Session) processHeader(key, value string) {
 			log.Printf("DEBUG: Removing header %s based on workspace rules", key)
 		}
 
+		// X-Priority picks the dequeue lane unless X-MC-Important already marked the message high
+		if strings.EqualFold(key, "x-priority") && s.message.Priority != models.PriorityHigh {
+			if priority, ok := models.ParsePriority(value); ok {
+				s.message.Priority = priority
+			}
+		}
+
 		// Extract recipient metadata from X-Recipient-* headers
 		if strings.HasPrefix(strings.ToLower(key), "x-recipient-") {
 			if s.message.Metadata["recipient"] == nil {
@@ -420,7 +475,7 @@ func (s *Session) processHeader(key, value string) {
 			}
 			recipientKey := strings.TrimPrefix(strings.ToLower(key), "x-recipient-")
 			if recipientMap, ok := s.message.Metadata["recipient"].(map[string]interface{}); ok {
-				recipientMap[recipientKey] = value
+				recipientMap[recipientKey] = mimeparser.DecodeHeaderValue(value)
 			}
 		}
 	}
@@ -706,8 +761,58 @@ func parseAddresses(addresses string) []string {
 	return result
 }
 
-func isHTML(contentType string) bool {
-	return strings.Contains(strings.ToLower(contentType), "text/html")
+// parseSendAtHeader parses X-MC-SendAt, which Mandrill defines as UTC
+// "YYYY-MM-DD HH:MM:SS"; RFC 3339 timestamps are accepted as well
+func parseSendAtHeader(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.UTC); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseExpiresHeader parses X-Relay-Expires: either a duration such as "15m",
+// counted from when the message is due to be sent, or a timestamp in the
+// formats X-MC-SendAt accepts
+func parseExpiresHeader(value string) (*time.Time, time.Duration, error) {
+	if ttl, err := time.ParseDuration(strings.TrimSpace(value)); err == nil {
+		if ttl <= 0 {
+			return nil, 0, fmt.Errorf("duration must be positive")
+		}
+		return nil, ttl, nil
+	}
+	expiresAt, err := parseSendAtHeader(value)
+	if err != nil {
+		return nil, 0, fmt.Errorf("expected a duration or a timestamp")
+	}
+	return &expiresAt, 0, nil
+}
+
+// applyExpiry sets expires_at once the message is complete, from an
+// X-Relay-Expires duration or else the workspace's default for its email_type.
+// The time to live counts from send_at for scheduled messages. An explicit
+// expires_at is kept as is.
+func (s *Session) applyExpiry() {
+	if s.message.ExpiresAt != nil {
+		return
+	}
+
+	ttl := s.expiresIn
+	if ttl <= 0 && s.workspaceManager != nil && s.message.ProviderID != "" && s.message.EmailType != "" {
+		if workspace, err := s.workspaceManager.GetWorkspaceByID(s.message.ProviderID); err == nil && workspace != nil {
+			ttl = workspace.MessageTTL(s.message.EmailType)
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	start := time.Now()
+	if s.message.SendAt != nil && s.message.SendAt.After(start) {
+		start = *s.message.SendAt
+	}
+	expiresAt := start.Add(ttl)
+	s.message.ExpiresAt = &expiresAt
 }
 
 // parseTagsHeader parses X-MC-Tags header which can be JSON array or comma-separated values
@@ -0,0 +1,297 @@
+package smtp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"relay/internal/config"
+	"relay/internal/queue"
+	"relay/internal/workspace"
+	"relay/pkg/models"
+
+	"github.com/emersion/go-smtp"
+)
+
+func newTestSession(q queue.Queue) *Session {
+	return &Session{
+		queue:            q,
+		workspaceManager: workspace.NewManager(&config.WorkspaceConfig{ID: "ws", Domain: "example.com"}),
+		dedupWindow:      time.Hour,
+	}
+}
+
+// sendData runs one mail transaction and returns the ID from the 250 reply
+func sendData(t *testing.T, s *Session, from string, to []string, data string) string {
+	t.Helper()
+	if err := s.Mail(from, nil); err != nil {
+		t.Fatalf("Mail: %v", err)
+	}
+	for _, rcpt := range to {
+		if err := s.Rcpt(rcpt, nil); err != nil {
+			t.Fatalf("Rcpt: %v", err)
+		}
+	}
+	err := s.Data(strings.NewReader(data))
+	s.Reset()
+
+	var reply *smtp.SMTPError
+	if !errors.As(err, &reply) || reply.Code != 250 {
+		t.Fatalf("Data = %v, want a 250 reply", err)
+	}
+	id, ok := strings.CutPrefix(reply.Message, "OK: queued as ")
+	if !ok || id == "" {
+		t.Fatalf("reply %q does not carry the message ID", reply.Message)
+	}
+	return id
+}
+
+func TestSessionDataRepliesWithQueuedID(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	s := newTestSession(q)
+
+	id := sendData(t, s, "news@example.com", []string{"ana@example.org"},
+		"Subject: Hello\r\nContent-Type: text/plain\r\n\r\nBody\r\n")
+
+	msg, err := q.Get(id)
+	if err != nil {
+		t.Fatalf("reply ID %s is not queued: %v", id, err)
+	}
+	if msg.ProviderID != "ws" || msg.Subject != "Hello" || msg.Text != "Body" || msg.Status != models.StatusQueued {
+		t.Errorf("queued message workspace %q subject %q text %q status %s", msg.ProviderID, msg.Subject, msg.Text, msg.Status)
+	}
+}
+
+func TestSessionDataDeduplicatesRetries(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	s := newTestSession(q)
+	to := []string{"ana@example.org", "bo@example.org"}
+
+	byMessageID := "Message-ID: <abc@example.com>\r\nSubject: Hi\r\n\r\nBody\r\n"
+	first := sendData(t, s, "news@example.com", to, byMessageID)
+	// Recipient order and case do not make a retry a new message
+	if retry := sendData(t, s, "news@example.com", []string{"BO@example.org", "ana@example.org"}, byMessageID); retry != first {
+		t.Errorf("retry with the same Message-ID queued as %s, want %s", retry, first)
+	}
+	if other := sendData(t, s, "news@example.com", to[:1], byMessageID); other == first {
+		t.Error("the same Message-ID to other recipients was treated as a retry")
+	}
+
+	// The client's key wins over the Message-ID
+	keyed := sendData(t, s, "news@example.com", to, "Idempotency-Key: order-1\r\nMessage-ID: <one@example.com>\r\n\r\nBody\r\n")
+	if retry := sendData(t, s, "news@example.com", to, "Idempotency-Key: order-1\r\nMessage-ID: <two@example.com>\r\n\r\nBody\r\n"); retry != keyed {
+		t.Errorf("retry with the same Idempotency-Key queued as %s, want %s", retry, keyed)
+	}
+
+	// Without either, every transaction is queued
+	plain := "Subject: No ID\r\n\r\nBody\r\n"
+	if sendData(t, s, "news@example.com", to, plain) == sendData(t, s, "news@example.com", to, plain) {
+		t.Error("messages without a key or Message-ID were deduplicated")
+	}
+}
+
+func TestSessionDataWithoutTransaction(t *testing.T) {
+	s := newTestSession(queue.NewMemoryQueue())
+	if err := s.Data(strings.NewReader("Subject: x\r\n\r\nBody\r\n")); err == nil {
+		t.Error("Data without MAIL FROM was accepted")
+	}
+}
+
+func TestSessionIdempotencyKey(t *testing.T) {
+	s := &Session{from: "News@Example.com", to: []string{"b@example.org", "A@example.org"}}
+	if key := s.idempotencyKey(); key != "" {
+		t.Errorf("key without a client key or Message-ID = %q", key)
+	}
+
+	s.messageID = "<abc@example.com>"
+	byMessageID := s.idempotencyKey()
+	if byMessageID != queue.IdempotencyKey("message-id", "news@example.com", "<abc@example.com>", "a@example.org,b@example.org") {
+		t.Errorf("Message-ID key = %q, want sender and recipients lowercased and sorted", byMessageID)
+	}
+
+	s.clientKey = "<abc@example.com>"
+	if key := s.idempotencyKey(); key == byMessageID || key == "" {
+		t.Errorf("client key %q should be distinct from the Message-ID key", key)
+	}
+}
+
+func TestParseSendAtHeader(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "2026-10-16 12:30:00", want: "2026-10-16T12:30:00Z"},
+		{value: "  2026-10-16 12:30:00\t", want: "2026-10-16T12:30:00Z"},
+		{value: "2026-10-16T12:30:00Z", want: "2026-10-16T12:30:00Z"},
+		{value: "2026-10-16T14:30:00+02:00", want: "2026-10-16T12:30:00Z"},
+		{value: "2026-10-16", wantErr: true},
+		{value: "16/10/2026 12:30", wantErr: true},
+		{value: "2026-10-16 25:00:00", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSendAtHeader(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSendAtHeader(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSendAtHeader(%q): %v", tt.value, err)
+		} else if got.UTC().Format(time.RFC3339) != tt.want {
+			t.Errorf("parseSendAtHeader(%q) = %s, want %s", tt.value, got.UTC().Format(time.RFC3339), tt.want)
+		}
+	}
+}
+
+func TestSessionDataSendAtHeader(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	tests := []struct {
+		name  string
+		value string
+		want  *time.Time
+	}{
+		{name: "future time schedules the message", value: future.Format("2006-01-02 15:04:05"), want: &future},
+		{name: "past time sends now", value: "2020-01-01 00:00:00"},
+		{name: "invalid value is ignored", value: "next tuesday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := queue.NewMemoryQueue()
+			id := sendData(t, newTestSession(q), "news@example.com", []string{"ana@example.org"},
+				"X-MC-SendAt: "+tt.value+"\r\nSubject: Later\r\n\r\nBody\r\n")
+
+			msg, err := q.Get(id)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if (msg.SendAt == nil) != (tt.want == nil) || (tt.want != nil && !msg.SendAt.Equal(*tt.want)) {
+				t.Errorf("send_at = %v, want %v", msg.SendAt, tt.want)
+			}
+			for name := range msg.Headers {
+				if strings.EqualFold(name, "X-MC-SendAt") {
+					t.Error("X-MC-SendAt was passed on to providers")
+				}
+			}
+		})
+	}
+}
+
+func TestParseExpiresHeader(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantAt  string
+		wantTTL time.Duration
+		wantErr bool
+	}{
+		{value: "15m", wantTTL: 15 * time.Minute},
+		{value: " 1h30m ", wantTTL: 90 * time.Minute},
+		{value: "2026-10-16 12:00:00", wantAt: "2026-10-16T12:00:00Z"},
+		{value: "2026-10-16T14:00:00+02:00", wantAt: "2026-10-16T12:00:00Z"},
+		{value: "0s", wantErr: true},
+		{value: "-5m", wantErr: true},
+		{value: "tomorrow", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		expiresAt, ttl, err := parseExpiresHeader(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseExpiresHeader(%q) accepted an invalid value", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseExpiresHeader(%q): %v", tt.value, err)
+			continue
+		}
+		if ttl != tt.wantTTL {
+			t.Errorf("parseExpiresHeader(%q) duration = %v, want %v", tt.value, ttl, tt.wantTTL)
+		}
+		if (expiresAt != nil) != (tt.wantAt != "") {
+			t.Errorf("parseExpiresHeader(%q) timestamp = %v, want %q", tt.value, expiresAt, tt.wantAt)
+		} else if expiresAt != nil && expiresAt.UTC().Format(time.RFC3339) != tt.wantAt {
+			t.Errorf("parseExpiresHeader(%q) timestamp = %s, want %s", tt.value, expiresAt.UTC().Format(time.RFC3339), tt.wantAt)
+		}
+	}
+}
+
+func TestSessionApplyExpiry(t *testing.T) {
+	manager := workspace.NewManager(&config.WorkspaceConfig{
+		ID:                "ws",
+		Domain:            "example.com",
+		MessageTTLSeconds: map[string]int{"otp": 600},
+	})
+	explicit := time.Now().Add(time.Hour)
+	sendAt := time.Now().Add(2 * time.Hour)
+
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		message   models.Message
+		want      *time.Time    // Exact expires_at, for explicit ones
+		wantTTL   time.Duration // Time to live from now or send_at; zero for none
+		fromSend  bool
+	}{
+		{name: "explicit timestamp is kept", message: models.Message{ProviderID: "ws", EmailType: "otp", ExpiresAt: &explicit}, want: &explicit},
+		{name: "header duration", expiresIn: 5 * time.Minute, message: models.Message{ProviderID: "ws", EmailType: "otp"}, wantTTL: 5 * time.Minute},
+		{name: "workspace default by email type", message: models.Message{ProviderID: "ws", EmailType: "otp"}, wantTTL: 10 * time.Minute},
+		{name: "email type without default", message: models.Message{ProviderID: "ws", EmailType: "newsletter"}},
+		{name: "unknown workspace", message: models.Message{ProviderID: "other", EmailType: "otp"}},
+		{name: "counted from send_at", expiresIn: 5 * time.Minute, message: models.Message{ProviderID: "ws", SendAt: &sendAt}, wantTTL: 5 * time.Minute, fromSend: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.message
+			s := &Session{workspaceManager: manager, message: &msg, expiresIn: tt.expiresIn}
+			before := time.Now()
+			s.applyExpiry()
+			after := time.Now()
+
+			got := s.message.ExpiresAt
+			switch {
+			case tt.want != nil:
+				if got == nil || !got.Equal(*tt.want) {
+					t.Errorf("expires_at = %v, want %v", got, *tt.want)
+				}
+			case tt.wantTTL == 0:
+				if got != nil {
+					t.Errorf("expires_at = %v, want none", *got)
+				}
+			case tt.fromSend:
+				if got == nil || !got.Equal(sendAt.Add(tt.wantTTL)) {
+					t.Errorf("expires_at = %v, want %v after send_at", got, tt.wantTTL)
+				}
+			default:
+				if got == nil || got.Before(before.Add(tt.wantTTL)) || got.After(after.Add(tt.wantTTL)) {
+					t.Errorf("expires_at = %v, want %v from now", got, tt.wantTTL)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionDataExpiresHeader(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	s := newTestSession(q)
+
+	before := time.Now()
+	id := sendData(t, s, "news@example.com", []string{"ana@example.org"},
+		"X-Relay-Expires: 15m\r\nSubject: Code\r\n\r\n123456\r\n")
+
+	msg, err := q.Get(id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if msg.ExpiresAt == nil || msg.ExpiresAt.Before(before.Add(15*time.Minute)) || msg.ExpiresAt.After(time.Now().Add(15*time.Minute)) {
+		t.Errorf("expires_at = %v, want 15 minutes from intake", msg.ExpiresAt)
+	}
+	for name := range msg.Headers {
+		if strings.EqualFold(name, "X-Relay-Expires") {
+			t.Error("X-Relay-Expires was passed on to providers")
+		}
+	}
+}
@@ -110,6 +110,20 @@ func (c *Client) SendRejectEvent(ctx context.Context, msg *models.Message, reaso
 	})
 }
 
+// SendExpiredEvent reports a message that reached its expires_at before it was
+// sent as a reject with reason "expired"
+func (c *Client) SendExpiredEvent(ctx context.Context, msg *models.Message) error {
+	detail := "message expired before it could be sent"
+	return c.SendEvent(ctx, msg, "reject", map[string]interface{}{
+		"reject": map[string]interface{}{
+			"reason":      "expired",
+			"detail":      detail,
+			"last_event":  "expired",
+			"description": detail,
+		},
+	})
+}
+
 func (c *Client) createMandrillEvent(msg *models.Message, eventType string, details map[string]interface{}) models.MandrillWebhookEvent {
 	// Extract the first recipient for the event
 	email := ""
@@ -129,8 +143,13 @@ func (c *Client) createMandrillEvent(msg *models.Message, eventType string, deta
 		Metadata: msg.Metadata,
 	}
 
-	// Merge additional details into the message
+	// Merge additional details into a copy of the metadata, which may be nil
 	if details != nil {
+		metadata := make(map[string]interface{}, len(msg.Metadata)+len(details))
+		for k, v := range msg.Metadata {
+			metadata[k] = v
+		}
+		mandrillMsg.Metadata = metadata
 		for k, v := range details {
 			switch k {
 			case "bounce_description":
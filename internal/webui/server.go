@@ -79,6 +79,26 @@ func NewServerWithDB(q QueueStats, gc *gmail.Client, p ProcessorInterface, rs *r
 	return s
 }
 
+// RegisterMandrillAPI registers the Mandrill-compatible HTTP API routes
+func (s *Server) RegisterMandrillAPI(mandrillAPI *api.MandrillAPI) {
+	if mandrillAPI == nil {
+		log.Printf("Warning: Mandrill API is nil - routes not registered")
+		return
+	}
+	mandrillAPI.RegisterRoutes(s.router)
+	log.Println("Mandrill API routes registered successfully")
+}
+
+// RegisterArchiveAPI registers the message archive and retention routes
+func (s *Server) RegisterArchiveAPI(archiveAPI *api.ArchiveAPI) {
+	if archiveAPI == nil {
+		log.Printf("Warning: Archive API is nil - routes not registered")
+		return
+	}
+	archiveAPI.RegisterRoutes(s.router)
+	log.Println("Archive API routes registered successfully")
+}
+
 func (s *Server) setupRoutes() {
 	// Register dashboard routes first if available
 	if s.dashboard != nil {
@@ -106,6 +126,36 @@ func (s *Server) setupRoutes() {
 	s.router.HandleFunc("/api/loadbalancing/pools", s.handleGetLoadBalancingPools).Methods("GET")
 	s.router.HandleFunc("/api/loadbalancing/selections", s.handleGetLoadBalancingSelections).Methods("GET")
 	
+	// Scheduled message endpoints (only for queues that support send_at)
+	if scheduler, ok := s.queue.(queue.Scheduler); ok {
+		api.NewScheduledAPI(scheduler).RegisterRoutes(s.router)
+		log.Println("Scheduled message API routes registered successfully")
+	}
+
+	// Dead-letter endpoints (only for queues that keep attempt history)
+	if store, ok := s.queue.(api.DeadLetterStore); ok {
+		api.NewDeadLettersAPI(store).RegisterRoutes(s.router)
+		log.Println("Dead-letter API routes registered successfully")
+	}
+
+	// Bulk operations (only for queues that can change messages by filter)
+	if manager, ok := s.queue.(queue.BulkManager); ok {
+		api.NewBulkOperationsAPI(manager, s.db).RegisterRoutes(s.router)
+		log.Println("Bulk operations API routes registered successfully")
+	}
+
+	// Pauses (only for queues that store them)
+	if pauser, ok := s.queue.(queue.Pauser); ok {
+		api.NewPausesAPI(pauser).RegisterRoutes(s.router)
+		log.Println("Pause API routes registered successfully")
+	}
+
+	// Worker registry (replicas sharing the queue and the messages they hold)
+	if registry, ok := s.queue.(queue.WorkerRegistry); ok {
+		api.NewWorkersAPI(registry).RegisterRoutes(s.router)
+		log.Println("Worker API routes registered successfully")
+	}
+
 	s.router.HandleFunc("/validate", s.handleValidateServiceAccount).Methods("GET")
 	s.router.HandleFunc("/webhook/test", s.handleWebhookTest).Methods("POST")
 
@@ -52,9 +52,10 @@ func (m *DBManager) loadWorkspacesFromDB() error {
 		SELECT provider_id, display_name, domain, 
 		       rate_limit_workspace_daily, rate_limit_per_user_daily,
 		       rate_limit_custom_users, provider_type, provider_config,
-		       enabled, service_account_json
+		       enabled, service_account_json, retry_policy, retention_policy, message_ttl,
+		       failover
 		FROM providers
-		WHERE enabled = 1
+		WHERE enabled = TRUE
 		ORDER BY created_at DESC
 	`
 	
@@ -73,13 +74,14 @@ func (m *DBManager) loadWorkspacesFromDB() error {
 		var workspaceDaily, perUserDaily int
 		var customLimits, providerConfig sql.NullString
 		var enabled bool
-		var serviceAccountJSON sql.NullString
+		var serviceAccountJSON, retryPolicy, retentionPolicy, messageTTL, failover sql.NullString
 		
 		err := rows.Scan(
 			&ws.ID, &displayName, &domain,
 			&workspaceDaily, &perUserDaily,
 			&customLimits, &providerType, &providerConfig,
-			&enabled, &serviceAccountJSON,
+			&enabled, &serviceAccountJSON, &retryPolicy, &retentionPolicy, &messageTTL,
+			&failover,
 		)
 		if err != nil {
 			log.Printf("Error scanning workspace row: %v", err)
@@ -103,6 +105,40 @@ func (m *DBManager) loadWorkspacesFromDB() error {
 			}
 		}
 		
+		// Parse workspace retry policy override
+		if retryPolicy.Valid && retryPolicy.String != "" {
+			var policy config.RetryPolicy
+			if err := json.Unmarshal([]byte(retryPolicy.String), &policy); err == nil {
+				ws.RetryPolicy = &policy
+			} else {
+				log.Printf("Warning: Invalid retry policy for workspace %s: %v", ws.ID, err)
+			}
+		}
+		
+		// Parse workspace retention policy override
+		if retentionPolicy.Valid && retentionPolicy.String != "" {
+			var policy config.RetentionPolicy
+			if err := json.Unmarshal([]byte(retentionPolicy.String), &policy); err == nil {
+				ws.Retention = &policy
+			} else {
+				log.Printf("Warning: Invalid retention policy for workspace %s: %v", ws.ID, err)
+			}
+		}
+		
+		// Parse default message expiry by email type
+		if messageTTL.Valid && messageTTL.String != "" {
+			if err := json.Unmarshal([]byte(messageTTL.String), &ws.MessageTTLSeconds); err != nil {
+				log.Printf("Warning: Invalid message TTL for workspace %s: %v", ws.ID, err)
+			}
+		}
+		
+		// Parse the providers tried in turn within one send attempt
+		if failover.Valid && failover.String != "" {
+			if err := json.Unmarshal([]byte(failover.String), &ws.Failover); err != nil {
+				log.Printf("Warning: Invalid failover chain for workspace %s: %v", ws.ID, err)
+			}
+		}
+		
 		// Parse provider configuration and set enabled status
 		switch providerType {
 		case "gmail":
@@ -20,6 +20,25 @@ type Manager struct {
 
 // JSON loading functions removed - using database only
 
+// NewManager returns a manager serving the given workspaces, without a database
+// behind it. Each workspace is routed for its Domain and Domains.
+func NewManager(workspaces ...*config.WorkspaceConfig) *Manager {
+	m := &Manager{
+		workspaces:        make(map[string]*config.WorkspaceConfig, len(workspaces)),
+		domainToWorkspace: make(map[string]string),
+	}
+	for _, ws := range workspaces {
+		m.workspaces[ws.ID] = ws
+		if ws.Domain != "" {
+			m.domainToWorkspace[ws.Domain] = ws.ID
+		}
+		for _, domain := range ws.Domains {
+			m.domainToWorkspace[domain] = ws.ID
+		}
+	}
+	return m
+}
+
 // GetWorkspaceByDomain returns a workspace for the given domain
 func (m *Manager) GetWorkspaceByDomain(domain string) (*config.WorkspaceConfig, error) {
 	// Defensive programming: validate manager and input
@@ -0,0 +1,8 @@
+-- Store the original DATA bytes for providers that send the message unchanged
+-- Date: 2026-10-16
+
+ALTER TABLE messages
+    ADD COLUMN raw_message LONGBLOB NULL AFTER attachments;
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,19 @@
+-- Migration to store templates for the Mandrill-compatible send-template API
+-- Date: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS mandrill_templates (
+    slug VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    from_email VARCHAR(255),
+    from_name VARCHAR(255),
+    subject TEXT,
+    code LONGTEXT,
+    text LONGTEXT,
+    labels JSON,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_name (name)
+);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
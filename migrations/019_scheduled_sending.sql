@@ -0,0 +1,11 @@
+-- Migration to support scheduled sending via send_at / X-MC-SendAt
+-- Date: 2026-10-16
+
+ALTER TABLE messages
+    ADD COLUMN send_at TIMESTAMP NULL DEFAULT NULL AFTER queued_at;
+
+-- Dequeue filters queued messages on send_at
+CREATE INDEX idx_messages_status_send_at ON messages (status, send_at);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,20 @@
+-- Migration to support exponential backoff retries
+-- Date: 2026-10-16
+
+-- Failed messages are retried only once next_attempt_at is due; NULL means no further retries
+ALTER TABLE messages
+    ADD COLUMN next_attempt_at TIMESTAMP NULL DEFAULT NULL AFTER send_at;
+
+CREATE INDEX idx_messages_status_next_attempt ON messages (status, next_attempt_at);
+
+-- Keep retrying messages that the old retry_count < 3 rule would still have picked up
+UPDATE messages
+SET next_attempt_at = NOW()
+WHERE status = 'failed' AND retry_count < 3;
+
+-- Per-workspace retry policy override (see RetryPolicy in internal/config)
+ALTER TABLE providers
+    ADD COLUMN retry_policy JSON NULL AFTER rate_limit_custom_users;
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
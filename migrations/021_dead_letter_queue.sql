@@ -0,0 +1,33 @@
+-- Migration to add the dead-letter status and per-message attempt history
+-- Date: 2026-10-16
+
+-- Messages that failed permanently or used up their retries move to 'dead'
+ALTER TABLE messages
+    MODIFY COLUMN status ENUM('queued', 'processing', 'sent', 'failed', 'auth_error', 'dead') NOT NULL DEFAULT 'queued';
+
+-- Provider pinned by a dead-letter replay; NULL means normal routing
+ALTER TABLE messages
+    ADD COLUMN provider_override VARCHAR(255) NULL DEFAULT NULL AFTER provider_id;
+
+-- Failed messages with no retry pending were dead letters under the old semantics
+UPDATE messages
+SET status = 'dead'
+WHERE status = 'failed' AND next_attempt_at IS NULL;
+
+-- One row per send attempt, including the one that succeeded
+CREATE TABLE IF NOT EXISTS message_attempts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    message_id VARCHAR(36) NOT NULL,
+    attempt INT NOT NULL,
+    provider_id VARCHAR(255) NULL,
+    error_class VARCHAR(32) NULL,
+    error TEXT NULL,
+    attempted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_message_attempts_message (message_id, attempted_at),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_messages_status_processed ON messages (status, processed_at);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,17 @@
+-- Migration to lease dequeued messages to the worker processing them
+-- Date: 2026-10-16
+
+-- locked_by identifies the worker holding the message; the lease must be renewed before lease_expires_at
+ALTER TABLE messages
+    ADD COLUMN locked_by VARCHAR(255) NULL DEFAULT NULL AFTER status,
+    ADD COLUMN lease_expires_at TIMESTAMP NULL DEFAULT NULL AFTER locked_by;
+
+CREATE INDEX idx_messages_status_lease ON messages (status, lease_expires_at);
+
+-- Messages stuck in processing from before leases existed are returned by the first reaper run
+UPDATE messages
+SET lease_expires_at = NOW()
+WHERE status = 'processing';
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,19 @@
+-- Migration to register relay replicas that share the queue
+-- Date: 2026-10-16
+
+-- One row per replica; locked_by on messages refers to workers.id
+CREATE TABLE IF NOT EXISTS workers (
+    id VARCHAR(255) PRIMARY KEY,
+    hostname VARCHAR(255) NOT NULL,
+    pid INT NOT NULL,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_heartbeat_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    heartbeat_interval_seconds INT NOT NULL DEFAULT 15,
+    stopped_at TIMESTAMP NULL DEFAULT NULL,
+    INDEX idx_workers_stopped (stopped_at)
+);
+
+CREATE INDEX idx_messages_status_locked_by ON messages (status, locked_by);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,12 @@
+-- Migration to add priority lanes to the message queue
+-- Date: 2026-10-16
+
+-- Dequeue splits each batch across lanes by weight (QUEUE_PRIORITY_WEIGHTS)
+ALTER TABLE messages
+    ADD COLUMN priority ENUM('high', 'normal', 'low') NOT NULL DEFAULT 'normal' AFTER status;
+
+-- Each lane is read oldest first
+CREATE INDEX idx_messages_status_priority_queued ON messages (status, priority, queued_at);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
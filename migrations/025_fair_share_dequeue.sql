@@ -0,0 +1,8 @@
+-- Migration to support fair-share dequeueing across workspaces
+-- Date: 2026-10-16
+
+-- Dequeue counts due messages per workspace and sender in each lane, then reads each workspace oldest first
+CREATE INDEX idx_messages_status_priority_provider ON messages (status, priority, provider_id, from_email, queued_at);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
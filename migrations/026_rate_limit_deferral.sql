@@ -0,0 +1,10 @@
+-- Migration to park rate limited messages until their limit resets
+-- Date: 2026-10-16
+
+-- Rate limited messages stay queued with next_attempt_at set to the limit's reset time;
+-- deferred_at records the first deferral so the deferral webhook is sent only once
+ALTER TABLE messages
+    ADD COLUMN deferred_at TIMESTAMP NULL DEFAULT NULL AFTER next_attempt_at;
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,18 @@
+-- Migration to archive and purge finished messages by retention policy
+-- Date: 2026-10-16
+
+-- Per-workspace override of the RETENTION_* defaults; NULL inherits them
+ALTER TABLE providers
+    ADD COLUMN retention_policy JSON NULL AFTER retry_policy;
+
+-- archived_at records when the message was written to the archive; nothing is
+-- purged before it is set. restored_at restarts the retention clock of a
+-- message restored from the archive.
+ALTER TABLE messages
+    ADD COLUMN archived_at TIMESTAMP NULL DEFAULT NULL AFTER lease_expires_at,
+    ADD COLUMN restored_at TIMESTAMP NULL DEFAULT NULL AFTER archived_at;
+
+CREATE INDEX idx_messages_provider_status_processed ON messages (provider_id, status, processed_at);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,23 @@
+-- Migration to add the cancelled status and the bulk operation audit log
+-- Date: 2026-10-16
+
+-- Messages withdrawn by a bulk cancel move to 'cancelled' and are never sent
+ALTER TABLE messages
+    MODIFY COLUMN status ENUM('queued', 'processing', 'sent', 'failed', 'auth_error', 'dead', 'cancelled') NOT NULL DEFAULT 'queued';
+
+-- One row per applied bulk operation: who ran it, on what filter, and how many messages changed
+CREATE TABLE IF NOT EXISTS bulk_operations (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    action ENUM('cancel', 'requeue', 'reschedule', 'delete') NOT NULL,
+    filter JSON NOT NULL,
+    send_at TIMESTAMP NULL DEFAULT NULL,
+    actor VARCHAR(255) NOT NULL,
+    reason TEXT NULL,
+    remote_addr VARCHAR(255) NULL,
+    affected INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_bulk_operations_created (created_at)
+);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,15 @@
+-- Migration to add the idempotency keys used to deduplicate retried submissions
+-- Date: 2026-10-16
+
+-- One row per claimed key (a SHA-256 of the client's Idempotency-Key or the
+-- Message-ID, with the envelope sender and recipients). A submission whose key
+-- is held by an unexpired row gets that row's message ID instead of being queued.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key CHAR(64) NOT NULL PRIMARY KEY,
+    message_id VARCHAR(36) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    INDEX idx_idempotency_keys_expires (expires_at)
+);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,15 @@
+-- Migration to record sends before the provider is called
+-- Date: 2026-10-16
+
+-- A message moves to 'sending' just before it is handed to a provider and to
+-- 'sent' once the provider accepted it. A message left in 'sending' by a
+-- crashed worker is checked with the provider instead of being sent again.
+ALTER TABLE messages
+    MODIFY COLUMN status ENUM('queued', 'processing', 'sending', 'sent', 'failed', 'auth_error', 'dead', 'cancelled') NOT NULL DEFAULT 'queued';
+
+-- The ID the provider assigned to the sent message, e.g. the Gmail message ID
+ALTER TABLE messages
+    ADD COLUMN provider_message_id VARCHAR(255) NULL DEFAULT NULL AFTER provider_id;
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,18 @@
+-- Migration to add the pauses that hold queued messages by workspace, provider or sender
+-- Date: 2026-10-16
+
+-- One row per pause. pause_key is the workspace ID, provider ID or lower-cased
+-- sender address, and empty for the global pause. Rows whose resume_at has
+-- passed are deleted by the next dequeue.
+CREATE TABLE IF NOT EXISTS queue_pauses (
+    scope ENUM('global', 'workspace', 'provider', 'sender') NOT NULL,
+    pause_key VARCHAR(255) NOT NULL DEFAULT '',
+    reason TEXT NULL,
+    paused_by VARCHAR(255) NULL,
+    paused_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resume_at TIMESTAMP NULL DEFAULT NULL,
+    PRIMARY KEY (scope, pause_key)
+);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
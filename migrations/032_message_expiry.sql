@@ -0,0 +1,16 @@
+-- Migration to expire time-sensitive messages that were not sent in time
+-- Date: 2026-10-16
+
+-- A message still unsent at its expires_at moves to 'expired' and is never sent
+ALTER TABLE messages
+    MODIFY COLUMN status ENUM('queued', 'processing', 'sending', 'sent', 'failed', 'auth_error', 'dead', 'cancelled', 'expired') NOT NULL DEFAULT 'queued';
+
+ALTER TABLE messages
+    ADD COLUMN expires_at TIMESTAMP NULL DEFAULT NULL AFTER send_at;
+
+-- Per-workspace default time to live in seconds by email_type, e.g. {"otp": 600}
+ALTER TABLE providers
+    ADD COLUMN message_ttl JSON NULL AFTER retention_policy;
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,9 @@
+-- Migration to fail over between a workspace's providers within one send attempt
+-- Date: 2026-10-16
+
+-- Providers tried in turn by type or ID, e.g. ["gmail", "mailgun"]
+ALTER TABLE providers
+    ADD COLUMN failover JSON NULL AFTER message_ttl;
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,20 @@
+-- Migration to keep the workspace in messages.provider_id and record the provider a message was handed to separately
+-- Date: 2026-10-16
+
+-- Provider of the latest send attempt, e.g. gmail-<workspace>
+ALTER TABLE messages
+    ADD COLUMN sent_via VARCHAR(255) NULL DEFAULT NULL AFTER provider_id;
+
+-- Retries and status updates used to write the provider over the workspace;
+-- move those provider IDs to sent_via and put the workspace back
+UPDATE messages SET sent_via = provider_id, provider_id = SUBSTRING(provider_id, 7)
+WHERE provider_id LIKE 'gmail-%' AND provider_id NOT IN (SELECT provider_id FROM providers WHERE provider_id IS NOT NULL);
+
+UPDATE messages SET sent_via = provider_id, provider_id = SUBSTRING(provider_id, 9)
+WHERE provider_id LIKE 'mailgun-%' AND provider_id NOT IN (SELECT provider_id FROM providers WHERE provider_id IS NOT NULL);
+
+UPDATE messages SET sent_via = provider_id, provider_id = SUBSTRING(provider_id, 10)
+WHERE provider_id LIKE 'mandrill\_%' AND provider_id NOT IN (SELECT provider_id FROM providers WHERE provider_id IS NOT NULL);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,8 @@
+-- Migration to find expired messages without scanning every waiting message
+-- Date: 2026-10-16
+
+-- The processor expires queued and failed messages past their expires_at every reaper interval
+CREATE INDEX idx_messages_status_expires ON messages (status, expires_at);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
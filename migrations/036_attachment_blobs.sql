@@ -0,0 +1,16 @@
+-- Migration to count the messages referencing each attachment blob
+-- Date: 2026-10-16
+
+-- One row per blob in the attachment store. refcount is changed in the same
+-- transaction that inserts, deletes or strips a message; released_at is when it
+-- last dropped to zero. Rows at zero for longer than ATTACHMENT_GC_GRACE are
+-- deleted together with their blob.
+CREATE TABLE IF NOT EXISTS attachment_blobs (
+    blob_key CHAR(64) NOT NULL PRIMARY KEY,
+    refcount INT NOT NULL DEFAULT 0,
+    released_at TIMESTAMP NULL DEFAULT NULL,
+    INDEX idx_attachment_blobs_released (refcount, released_at)
+);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
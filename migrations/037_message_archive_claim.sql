@@ -0,0 +1,10 @@
+-- Migration to let one replica at a time archive a message
+-- Date: 2026-10-16
+
+-- Set while a retention run writes the message to the archive; other runs skip
+-- it until then, and a run that died leaves it to be claimed again afterwards
+ALTER TABLE messages
+    ADD COLUMN archive_claimed_until TIMESTAMP NULL DEFAULT NULL AFTER archived_at;
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
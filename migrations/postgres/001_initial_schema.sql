@@ -0,0 +1,383 @@
+-- Migration to create the relay schema on PostgreSQL
+-- Date: 2026-10-16
+--
+-- This is the PostgreSQL equivalent of schema.sql plus MySQL migrations 001-026,
+-- with the columns the relay reads and writes today. Run it once against an
+-- empty database, then set DB_DRIVER=postgres.
+--
+-- ENUM columns become VARCHAR with CHECK constraints, AUTO_INCREMENT becomes
+-- BIGSERIAL, timestamps are TIMESTAMPTZ and ON UPDATE CURRENT_TIMESTAMP is
+-- emulated with the touch_updated_at trigger. JSON documents are stored as TEXT
+-- so LIKE searches and string scans behave as they do on MySQL; queries cast
+-- them to jsonb where JSON operators are needed.
+
+CREATE OR REPLACE FUNCTION touch_updated_at() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at = CURRENT_TIMESTAMP;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Providers (formerly workspaces) and their settings
+CREATE TABLE IF NOT EXISTS providers (
+    id VARCHAR(255) PRIMARY KEY,
+    provider_id VARCHAR(255) NULL UNIQUE,
+    display_name VARCHAR(255) NOT NULL,
+    domain VARCHAR(255) NOT NULL,
+    rate_limit_workspace_daily INT DEFAULT 2000,
+    rate_limit_per_user_daily INT DEFAULT 100,
+    rate_limit_custom_users TEXT,
+    retry_policy TEXT NULL,
+    provider_type VARCHAR(20) NOT NULL CHECK (provider_type IN ('gmail', 'mailgun', 'mandrill', 'sendgrid', 'ses')),
+    provider_config TEXT,
+    service_account_json TEXT,
+    credentials_updated_at TIMESTAMPTZ NULL,
+    enabled BOOLEAN DEFAULT TRUE,
+    priority INT DEFAULT 10,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (id, provider_type)
+);
+CREATE INDEX IF NOT EXISTS idx_providers_domain ON providers (domain);
+CREATE INDEX IF NOT EXISTS idx_providers_enabled ON providers (enabled);
+CREATE TRIGGER providers_updated_at BEFORE UPDATE ON providers
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+-- Kept for the rate limit report, which still joins the legacy workspaces table
+CREATE TABLE IF NOT EXISTS workspaces (
+    id VARCHAR(255) PRIMARY KEY,
+    display_name VARCHAR(255) NOT NULL,
+    domain VARCHAR(255) NOT NULL UNIQUE,
+    rate_limit_workspace_daily INT NOT NULL DEFAULT 2000,
+    rate_limit_per_user_daily INT NOT NULL DEFAULT 100,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS provider_rate_limits (
+    id BIGSERIAL PRIMARY KEY,
+    provider_id VARCHAR(255) NOT NULL UNIQUE REFERENCES providers(id) ON DELETE CASCADE,
+    daily INT NOT NULL DEFAULT 2000,
+    hourly INT NOT NULL DEFAULT 100,
+    per_user_daily INT NOT NULL DEFAULT 100,
+    per_user_hourly INT NOT NULL DEFAULT 10,
+    workspace_daily INT NOT NULL DEFAULT 2000,
+    burst_limit INT DEFAULT 50,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TRIGGER provider_rate_limits_updated_at BEFORE UPDATE ON provider_rate_limits
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+CREATE TABLE IF NOT EXISTS provider_user_rate_limits (
+    id BIGSERIAL PRIMARY KEY,
+    provider_id VARCHAR(255) NOT NULL REFERENCES providers(id) ON DELETE CASCADE,
+    user_email VARCHAR(255) NOT NULL,
+    email_address VARCHAR(255) NULL,
+    daily INT NOT NULL DEFAULT 100,
+    daily_limit INT NULL,
+    hourly INT NOT NULL DEFAULT 10,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider_id, user_email)
+);
+CREATE INDEX IF NOT EXISTS idx_provider_user_rate_limits_email ON provider_user_rate_limits (user_email);
+CREATE TRIGGER provider_user_rate_limits_updated_at BEFORE UPDATE ON provider_user_rate_limits
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+CREATE TABLE IF NOT EXISTS provider_header_rewrite_rules (
+    id BIGSERIAL PRIMARY KEY,
+    provider_id VARCHAR(255) NOT NULL REFERENCES providers(id) ON DELETE CASCADE,
+    header_name VARCHAR(255) NOT NULL,
+    action VARCHAR(10) NOT NULL DEFAULT 'remove' CHECK (action IN ('remove', 'replace', 'add')),
+    value TEXT NULL,
+    condition TEXT NULL,
+    priority INT DEFAULT 10,
+    enabled BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_header_rules_provider ON provider_header_rewrite_rules (provider_id, header_name);
+CREATE TRIGGER provider_header_rewrite_rules_updated_at BEFORE UPDATE ON provider_header_rewrite_rules
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+CREATE TABLE IF NOT EXISTS mailgun_provider_configs (
+    provider_id VARCHAR(255) PRIMARY KEY REFERENCES providers(id) ON DELETE CASCADE,
+    api_key_env VARCHAR(255) NULL,
+    domain VARCHAR(255) NOT NULL,
+    base_url VARCHAR(500) DEFAULT 'https://api.mailgun.net/v3',
+    region VARCHAR(50) DEFAULT 'us',
+    track_opens BOOLEAN DEFAULT TRUE,
+    track_clicks BOOLEAN DEFAULT TRUE,
+    track_unsubscribes BOOLEAN DEFAULT TRUE,
+    webhook_signing_key VARCHAR(255) NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS mandrill_provider_configs (
+    provider_id VARCHAR(255) PRIMARY KEY REFERENCES providers(id) ON DELETE CASCADE,
+    api_key_env VARCHAR(255) NULL,
+    subaccount VARCHAR(255) NULL,
+    default_from_name VARCHAR(255) NULL,
+    default_from_email VARCHAR(255) NOT NULL,
+    track_opens BOOLEAN DEFAULT TRUE,
+    track_clicks BOOLEAN DEFAULT TRUE,
+    default_tags TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS credential_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    provider_id VARCHAR(255) NOT NULL,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('uploaded', 'updated', 'deleted', 'rotated')),
+    performed_by VARCHAR(255),
+    performed_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    details TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_credential_audit_log_provider ON credential_audit_log (provider_id);
+
+-- Message queue
+CREATE TABLE IF NOT EXISTS messages (
+    id VARCHAR(36) PRIMARY KEY,
+    from_email VARCHAR(255) NOT NULL,
+    to_emails TEXT NOT NULL,
+    cc_emails TEXT,
+    bcc_emails TEXT,
+    subject TEXT,
+    html_body TEXT,
+    text_body TEXT,
+    headers TEXT,
+    attachments TEXT,
+    raw_message BYTEA NULL,
+    metadata TEXT,
+    invitation_id VARCHAR(255),
+    email_type VARCHAR(100),
+    invitation_dispatch_id VARCHAR(255),
+    provider_id VARCHAR(255),
+    provider_override VARCHAR(255) NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued'
+        CHECK (status IN ('queued', 'processing', 'sent', 'failed', 'auth_error', 'dead')),
+    locked_by VARCHAR(255) NULL,
+    lease_expires_at TIMESTAMPTZ NULL,
+    priority VARCHAR(10) NOT NULL DEFAULT 'normal' CHECK (priority IN ('high', 'normal', 'low')),
+    queued_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    send_at TIMESTAMPTZ NULL,
+    next_attempt_at TIMESTAMPTZ NULL,
+    deferred_at TIMESTAMPTZ NULL,
+    processed_at TIMESTAMPTZ NULL,
+    sent_at TIMESTAMPTZ NULL,
+    error TEXT,
+    retry_count INT DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_messages_queued_at ON messages (queued_at);
+CREATE INDEX IF NOT EXISTS idx_messages_provider_id ON messages (provider_id);
+CREATE INDEX IF NOT EXISTS idx_messages_invitation_id ON messages (invitation_id);
+CREATE INDEX IF NOT EXISTS idx_messages_status_send_at ON messages (status, send_at);
+CREATE INDEX IF NOT EXISTS idx_messages_status_next_attempt ON messages (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_messages_status_processed ON messages (status, processed_at);
+CREATE INDEX IF NOT EXISTS idx_messages_status_lease ON messages (status, lease_expires_at);
+CREATE INDEX IF NOT EXISTS idx_messages_status_locked_by ON messages (status, locked_by);
+CREATE INDEX IF NOT EXISTS idx_messages_status_priority_queued ON messages (status, priority, queued_at);
+CREATE INDEX IF NOT EXISTS idx_messages_status_priority_provider ON messages (status, priority, provider_id, from_email, queued_at);
+
+CREATE TABLE IF NOT EXISTS message_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    message_id VARCHAR(36) NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    attempt INT NOT NULL,
+    provider_id VARCHAR(255) NULL,
+    error_class VARCHAR(32) NULL,
+    error TEXT NULL,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_message_attempts_message ON message_attempts (message_id, attempted_at);
+
+CREATE TABLE IF NOT EXISTS workers (
+    id VARCHAR(255) PRIMARY KEY,
+    hostname VARCHAR(255) NOT NULL,
+    pid INT NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    heartbeat_interval_seconds INT NOT NULL DEFAULT 15,
+    stopped_at TIMESTAMPTZ NULL
+);
+CREATE INDEX IF NOT EXISTS idx_workers_stopped ON workers (stopped_at);
+
+-- Recipient tracking
+CREATE TABLE IF NOT EXISTS recipients (
+    id BIGSERIAL PRIMARY KEY,
+    email_address VARCHAR(320) NOT NULL,
+    provider_id VARCHAR(255) NOT NULL,
+    invitation_id VARCHAR(255) NULL,
+    user_id VARCHAR(255) NULL,
+    campaign_id VARCHAR(255) NULL,
+    first_name VARCHAR(100) NULL,
+    last_name VARCHAR(100) NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'ACTIVE' CHECK (status IN ('ACTIVE', 'INACTIVE', 'BOUNCED', 'UNSUBSCRIBED')),
+    opt_in_date TIMESTAMPTZ NULL,
+    opt_out_date TIMESTAMPTZ NULL,
+    bounce_count INT NOT NULL DEFAULT 0,
+    last_bounce_date TIMESTAMPTZ NULL,
+    bounce_type VARCHAR(10) NULL CHECK (bounce_type IN ('SOFT', 'HARD')),
+    metadata TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (email_address, provider_id)
+);
+CREATE INDEX IF NOT EXISTS idx_recipients_provider_status ON recipients (provider_id, status);
+CREATE INDEX IF NOT EXISTS idx_recipients_invitation_id ON recipients (invitation_id);
+CREATE INDEX IF NOT EXISTS idx_recipients_bounce_tracking ON recipients (status, bounce_count, last_bounce_date);
+CREATE TRIGGER recipients_updated_at BEFORE UPDATE ON recipients
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+CREATE TABLE IF NOT EXISTS message_recipients (
+    id BIGSERIAL PRIMARY KEY,
+    message_id VARCHAR(36) NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    recipient_id BIGINT NOT NULL REFERENCES recipients(id) ON DELETE CASCADE,
+    recipient_type VARCHAR(3) NOT NULL CHECK (recipient_type IN ('TO', 'CC', 'BCC')),
+    delivery_status VARCHAR(10) NOT NULL DEFAULT 'PENDING'
+        CHECK (delivery_status IN ('PENDING', 'SENT', 'BOUNCED', 'FAILED', 'DEFERRED')),
+    sent_at TIMESTAMPTZ NULL,
+    bounce_reason TEXT NULL,
+    opens INT NOT NULL DEFAULT 0,
+    clicks INT NOT NULL DEFAULT 0,
+    last_open_at TIMESTAMPTZ NULL,
+    last_click_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (message_id, recipient_id)
+);
+CREATE INDEX IF NOT EXISTS idx_message_recipients_recipient ON message_recipients (recipient_id);
+CREATE INDEX IF NOT EXISTS idx_message_recipients_status ON message_recipients (delivery_status);
+CREATE TRIGGER message_recipients_updated_at BEFORE UPDATE ON message_recipients
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+CREATE TABLE IF NOT EXISTS recipient_events (
+    id BIGSERIAL PRIMARY KEY,
+    message_recipient_id BIGINT NOT NULL REFERENCES message_recipients(id) ON DELETE CASCADE,
+    event_type VARCHAR(20) NOT NULL CHECK (event_type IN ('OPEN', 'CLICK', 'UNSUBSCRIBE', 'COMPLAINT', 'BOUNCE')),
+    event_data TEXT,
+    ip_address VARCHAR(45) NULL,
+    user_agent TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_recipient_events_recipient ON recipient_events (message_recipient_id);
+CREATE INDEX IF NOT EXISTS idx_recipient_events_type_created ON recipient_events (event_type, created_at);
+
+-- Load balancing
+CREATE TABLE IF NOT EXISTS load_balancing_pools (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    domain_patterns TEXT NOT NULL DEFAULT '[]',
+    strategy VARCHAR(20) DEFAULT 'capacity_weighted'
+        CHECK (strategy IN ('capacity_weighted', 'round_robin', 'least_used', 'random_weighted')),
+    enabled BOOLEAN DEFAULT TRUE,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TRIGGER load_balancing_pools_updated_at BEFORE UPDATE ON load_balancing_pools
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+CREATE TABLE IF NOT EXISTS pool_providers (
+    id BIGSERIAL PRIMARY KEY,
+    pool_id VARCHAR(255) NOT NULL REFERENCES load_balancing_pools(id) ON DELETE CASCADE,
+    provider_id VARCHAR(255) NOT NULL REFERENCES providers(id) ON DELETE CASCADE,
+    weight INT DEFAULT 1,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (pool_id, provider_id)
+);
+
+CREATE TABLE IF NOT EXISTS pool_members (
+    id BIGSERIAL PRIMARY KEY,
+    pool_id VARCHAR(255) NOT NULL REFERENCES load_balancing_pools(id) ON DELETE CASCADE,
+    provider_id VARCHAR(255) NOT NULL,
+    weight INT NOT NULL DEFAULT 1,
+    priority INT NOT NULL DEFAULT 0,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (pool_id, provider_id)
+);
+
+CREATE TABLE IF NOT EXISTS pool_statistics (
+    id BIGSERIAL PRIMARY KEY,
+    pool_id VARCHAR(255) NOT NULL REFERENCES load_balancing_pools(id) ON DELETE CASCADE,
+    provider_id VARCHAR(255) NOT NULL,
+    total_requests BIGINT NOT NULL DEFAULT 0,
+    successful_requests BIGINT NOT NULL DEFAULT 0,
+    failed_requests BIGINT NOT NULL DEFAULT 0,
+    avg_response_time_ms INT NULL,
+    last_used_at TIMESTAMPTZ NULL,
+    hour_bucket TIMESTAMPTZ NOT NULL,
+    UNIQUE (pool_id, provider_id, hour_bucket)
+);
+
+CREATE TABLE IF NOT EXISTS load_balancing_selections (
+    id BIGSERIAL PRIMARY KEY,
+    pool_id VARCHAR(255) NOT NULL REFERENCES load_balancing_pools(id) ON DELETE CASCADE,
+    provider_id VARCHAR(255) NOT NULL,
+    sender_email VARCHAR(320) NOT NULL,
+    selected_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    success BOOLEAN NOT NULL,
+    capacity_score DECIMAL(5,4) NOT NULL,
+    selection_reason VARCHAR(500) NULL,
+    response_time_ms INT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_lb_selections_pool_time ON load_balancing_selections (pool_id, success, selected_at);
+CREATE INDEX IF NOT EXISTS idx_lb_selections_sender ON load_balancing_selections (sender_email, selected_at);
+
+CREATE TABLE IF NOT EXISTS provider_selections (
+    id BIGSERIAL PRIMARY KEY,
+    pool_id VARCHAR(255) NOT NULL,
+    provider_id VARCHAR(255) NOT NULL,
+    message_id VARCHAR(36) NULL,
+    algorithm_used VARCHAR(50) NOT NULL DEFAULT '',
+    success BOOLEAN NOT NULL,
+    response_time_ms INT NULL,
+    error_message TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_provider_selections_created ON provider_selections (created_at);
+
+CREATE TABLE IF NOT EXISTS provider_health (
+    id BIGSERIAL PRIMARY KEY,
+    provider_id VARCHAR(255) NOT NULL UNIQUE,
+    healthy BOOLEAN NOT NULL,
+    last_check_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    error_message TEXT NULL,
+    response_time_ms INT NULL,
+    consecutive_failures INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_usage (
+    id BIGSERIAL PRIMARY KEY,
+    provider_id VARCHAR(255) NOT NULL,
+    user_email VARCHAR(320) NULL,
+    date_bucket DATE NOT NULL,
+    hour_bucket INT NOT NULL,
+    message_count INT NOT NULL DEFAULT 0,
+    UNIQUE (provider_id, user_email, date_bucket, hour_bucket)
+);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_usage_provider_date ON rate_limit_usage (provider_id, date_bucket);
+
+-- Mandrill-compatible templates
+CREATE TABLE IF NOT EXISTS mandrill_templates (
+    slug VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    from_email VARCHAR(255),
+    from_name VARCHAR(255),
+    subject TEXT,
+    code TEXT,
+    text TEXT,
+    labels TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_mandrill_templates_name ON mandrill_templates (name);
+CREATE TRIGGER mandrill_templates_updated_at BEFORE UPDATE ON mandrill_templates
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,16 @@
+-- Migration to archive and purge finished messages by retention policy
+-- Date: 2026-10-16
+--
+-- PostgreSQL equivalent of MySQL migration 027.
+
+ALTER TABLE providers
+    ADD COLUMN IF NOT EXISTS retention_policy TEXT NULL;
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ NULL,
+    ADD COLUMN IF NOT EXISTS restored_at TIMESTAMPTZ NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_provider_status_processed ON messages (provider_id, status, processed_at);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,24 @@
+-- Migration to add the cancelled status and the bulk operation audit log
+-- Date: 2026-10-16
+--
+-- PostgreSQL equivalent of MySQL migration 028.
+
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_status_check;
+ALTER TABLE messages ADD CONSTRAINT messages_status_check
+    CHECK (status IN ('queued', 'processing', 'sent', 'failed', 'auth_error', 'dead', 'cancelled'));
+
+CREATE TABLE IF NOT EXISTS bulk_operations (
+    id BIGSERIAL PRIMARY KEY,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('cancel', 'requeue', 'reschedule', 'delete')),
+    filter TEXT NOT NULL,
+    send_at TIMESTAMPTZ NULL,
+    actor VARCHAR(255) NOT NULL,
+    reason TEXT NULL,
+    remote_addr VARCHAR(255) NULL,
+    affected INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_bulk_operations_created ON bulk_operations (created_at);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
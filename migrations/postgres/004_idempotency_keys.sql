@@ -0,0 +1,14 @@
+-- Migration to add the idempotency keys used to deduplicate retried submissions
+-- Date: 2026-10-16
+--
+-- PostgreSQL equivalent of MySQL migration 029.
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key CHAR(64) NOT NULL PRIMARY KEY,
+    message_id VARCHAR(36) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys (expires_at);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
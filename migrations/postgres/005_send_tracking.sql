@@ -0,0 +1,14 @@
+-- Migration to record sends before the provider is called
+-- Date: 2026-10-16
+--
+-- PostgreSQL equivalent of MySQL migration 030.
+
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_status_check;
+ALTER TABLE messages ADD CONSTRAINT messages_status_check
+    CHECK (status IN ('queued', 'processing', 'sending', 'sent', 'failed', 'auth_error', 'dead', 'cancelled'));
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS provider_message_id VARCHAR(255) NULL;
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
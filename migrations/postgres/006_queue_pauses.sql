@@ -0,0 +1,17 @@
+-- Migration to add the pauses that hold queued messages by workspace, provider or sender
+-- Date: 2026-10-16
+--
+-- PostgreSQL equivalent of MySQL migration 031.
+
+CREATE TABLE IF NOT EXISTS queue_pauses (
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('global', 'workspace', 'provider', 'sender')),
+    pause_key VARCHAR(255) NOT NULL DEFAULT '',
+    reason TEXT NULL,
+    paused_by VARCHAR(255) NULL,
+    paused_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resume_at TIMESTAMPTZ NULL,
+    PRIMARY KEY (scope, pause_key)
+);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
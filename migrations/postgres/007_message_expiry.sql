@@ -0,0 +1,17 @@
+-- Migration to expire time-sensitive messages that were not sent in time
+-- Date: 2026-10-16
+--
+-- PostgreSQL equivalent of MySQL migration 032.
+
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_status_check;
+ALTER TABLE messages ADD CONSTRAINT messages_status_check
+    CHECK (status IN ('queued', 'processing', 'sending', 'sent', 'failed', 'auth_error', 'dead', 'cancelled', 'expired'));
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NULL;
+
+ALTER TABLE providers
+    ADD COLUMN IF NOT EXISTS message_ttl TEXT NULL;
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
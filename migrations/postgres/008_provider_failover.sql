@@ -0,0 +1,10 @@
+-- Migration to fail over between a workspace's providers within one send attempt
+-- Date: 2026-10-16
+--
+-- PostgreSQL equivalent of MySQL migration 033.
+
+ALTER TABLE providers
+    ADD COLUMN IF NOT EXISTS failover TEXT NULL;
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,9 @@
+-- Migration to find expired messages without scanning every waiting message
+-- Date: 2026-10-16
+--
+-- PostgreSQL equivalent of MySQL migration 035.
+
+CREATE INDEX IF NOT EXISTS idx_messages_status_expires ON messages (status, expires_at);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
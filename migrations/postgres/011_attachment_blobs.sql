@@ -0,0 +1,15 @@
+-- Migration to count the messages referencing each attachment blob
+-- Date: 2026-10-16
+--
+-- PostgreSQL equivalent of MySQL migration 036.
+
+CREATE TABLE IF NOT EXISTS attachment_blobs (
+    blob_key CHAR(64) NOT NULL PRIMARY KEY,
+    refcount INT NOT NULL DEFAULT 0,
+    released_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachment_blobs_released ON attachment_blobs (refcount, released_at);
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -0,0 +1,10 @@
+-- Migration to let one replica at a time archive a message
+-- Date: 2026-10-16
+--
+-- PostgreSQL equivalent of MySQL migration 037.
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS archive_claimed_until TIMESTAMPTZ NULL;
+
+-- Verify the migration
+SELECT 'Migration completed successfully' as status;
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,24 +19,66 @@ type Message struct {
 	Headers     map[string]string      `json:"headers,omitempty"`
 	Attachments []Attachment           `json:"attachments,omitempty"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+
+	// RawMessage holds the original DATA bytes when the workspace sends in raw passthrough mode
+	RawMessage []byte `json:"-"`
 	
 	// Invitation tracking
 	InvitationID         string `json:"invitation_id,omitempty"`
 	EmailType            string `json:"email_type,omitempty"`
 	InvitationDispatchID string `json:"invitation_dispatch_id,omitempty"`
-	ProviderID           string `json:"provider_id,omitempty"`
+	ProviderID           string `json:"provider_id,omitempty"` // Workspace the message belongs to
+	SentVia              string `json:"sent_via,omitempty"`    // Provider the message was last handed to, e.g. gmail-<workspace>
 	
 	Status      MessageStatus          `json:"status"`
+	Priority    MessagePriority        `json:"priority,omitempty"` // Dequeue lane; empty means normal
 	QueuedAt    time.Time              `json:"queued_at"`
+	SendAt      *time.Time             `json:"send_at,omitempty"` // Not dequeued before this time when set
+	ExpiresAt   *time.Time             `json:"expires_at,omitempty"` // Not sent after this time when set
 	ProcessedAt *time.Time             `json:"processed_at,omitempty"`
 	Error       string                 `json:"error,omitempty"`
+
+	// Retry tracking: RetryCount is the number of send attempts made so far
+	RetryCount    int        `json:"retry_count"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	DeferredAt    *time.Time `json:"deferred_at,omitempty"` // First time the message was parked, e.g. by a rate limit
+
+	// Lease held by the worker processing the message; cleared when the message leaves processing
+	LockedBy       string     `json:"locked_by,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+
+	// ProviderMessageID is the ID the provider assigned to the sent message, e.g. the Gmail message ID
+	ProviderMessageID string `json:"provider_message_id,omitempty"`
+
+	// ProviderOverride pins the next send to a specific provider, set when a dead letter is replayed
+	ProviderOverride string `json:"provider_override,omitempty"`
+
+	// Retention: ArchivedAt is set once the message is written to an archive, after
+	// which its bodies, attachments and row may be purged. RestoredAt is set when an
+	// archived message is brought back, and restarts its retention clock.
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	RestoredAt *time.Time `json:"restored_at,omitempty"`
+}
+
+// Attempt records the outcome of one send attempt; Error is empty for the attempt that succeeded
+type Attempt struct {
+	Number      int       `json:"number"`
+	ProviderID  string    `json:"provider_id,omitempty"`
+	ErrorClass  string    `json:"error_class,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	AttemptedAt time.Time `json:"attempted_at"`
 }
 
 type Attachment struct {
 	Name        string `json:"name"`
 	Type        string `json:"type"`
-	Content     []byte `json:"content"`
+	Content     []byte `json:"content,omitempty"` // Empty once the content has been moved to the attachment store
 	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id,omitempty"` // Set for inline parts referenced via cid:
+
+	// BlobKey is the SHA-256 the content is stored under in the attachment store
+	BlobKey string `json:"blob_key,omitempty"`
+	Size    int64  `json:"size,omitempty"`
 }
 
 type MessageStatus string
@@ -41,11 +86,90 @@ type MessageStatus string
 const (
 	StatusQueued     MessageStatus = "queued"
 	StatusProcessing MessageStatus = "processing"
+	StatusSending    MessageStatus = "sending" // Handed to a provider; the outcome is not recorded yet
 	StatusSent       MessageStatus = "sent"
 	StatusFailed     MessageStatus = "failed"
 	StatusAuthError  MessageStatus = "auth_error"
+	StatusDead       MessageStatus = "dead"      // Failed permanently or out of retries; kept for inspection and replay
+	StatusCancelled  MessageStatus = "cancelled" // Withdrawn by an operator before it was sent
+	StatusExpired    MessageStatus = "expired"   // Reached its expires_at before it could be sent
 )
 
+// MessagePriority is the dequeue lane a message waits in
+type MessagePriority string
+
+const (
+	PriorityHigh   MessagePriority = "high"   // Transactional mail such as password resets and invitations
+	PriorityNormal MessagePriority = "normal"
+	PriorityLow    MessagePriority = "low"    // Bulk mail such as digests
+)
+
+// Priorities lists the lanes from highest to lowest
+var Priorities = []MessagePriority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// Lane returns the lane for p, treating empty or unknown values as normal
+func (p MessagePriority) Lane() MessagePriority {
+	switch p {
+	case PriorityHigh, PriorityLow:
+		return p
+	}
+	return PriorityNormal
+}
+
+// ParsePriority parses a lane name or an X-Priority value, where 1 is highest and
+// 5 lowest and a trailing label such as "1 (Highest)" is ignored
+func ParsePriority(value string) (MessagePriority, bool) {
+	fields := strings.Fields(strings.ToLower(value))
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	switch p := MessagePriority(fields[0]); p {
+	case PriorityHigh, PriorityNormal, PriorityLow:
+		return p, true
+	}
+
+	level, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return "", false
+	}
+	switch {
+	case level <= 2:
+		return PriorityHigh, true
+	case level == 3:
+		return PriorityNormal, true
+	default:
+		return PriorityLow, true
+	}
+}
+
+// RFCMessageID returns the Message-ID header the message is sent with: the one
+// it was submitted with, or one derived from its ID so that every attempt to
+// send it carries the same value
+func (m *Message) RFCMessageID() string {
+	for name, value := range m.Headers {
+		if strings.EqualFold(name, "Message-ID") && strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	domain := "localhost"
+	if at := strings.LastIndex(m.From, "@"); at >= 0 && at < len(m.From)-1 {
+		domain = strings.ToLower(m.From[at+1:])
+	}
+	return "<" + m.ID + "@" + domain + ">"
+}
+
+// Expired reports whether the message's expires_at has passed at now
+func (m *Message) Expired(now time.Time) bool {
+	return m.ExpiresAt != nil && !m.ExpiresAt.After(now)
+}
+
+// ExpiryError is the error recorded on a message that expired before it was sent
+func (m *Message) ExpiryError() error {
+	return fmt.Errorf("not sent before it expired at %s", m.ExpiresAt.Format(time.RFC3339))
+}
+
 type MandrillWebhookEvent struct {
 	Event   string                 `json:"event"`
 	Msg     MandrillMessage        `json:"msg"`
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePriority(t *testing.T) {
+	tests := map[string]MessagePriority{
+		"1 (Highest)": PriorityHigh,
+		"2":           PriorityHigh,
+		"3 (Normal)":  PriorityNormal,
+		"5 (Lowest)":  PriorityLow,
+		"Low":         PriorityLow,
+	}
+	for value, want := range tests {
+		if got, ok := ParsePriority(value); !ok || got != want {
+			t.Errorf("ParsePriority(%q) = %q, want %q", value, got, want)
+		}
+	}
+	if _, ok := ParsePriority("urgent"); ok {
+		t.Error("ParsePriority accepted an unknown value")
+	}
+}
+
+func TestRFCMessageID(t *testing.T) {
+	msg := &Message{ID: "3f1c", From: "Alerts@Example.com"}
+	if got := msg.RFCMessageID(); got != "<3f1c@example.com>" {
+		t.Errorf("derived Message-ID = %q", got)
+	}
+
+	msg.Headers = map[string]string{"message-id": " <abc@client.example> "}
+	if got := msg.RFCMessageID(); got != "<abc@client.example>" {
+		t.Errorf("submitted Message-ID = %q", got)
+	}
+}
+
+func TestMessageExpired(t *testing.T) {
+	now := time.Now()
+	msg := &Message{}
+	if msg.Expired(now) {
+		t.Error("message without expires_at expired")
+	}
+
+	expiresAt := now.Add(time.Minute)
+	msg.ExpiresAt = &expiresAt
+	if msg.Expired(now) {
+		t.Error("message expired before its expires_at")
+	}
+	if !msg.Expired(expiresAt) {
+		t.Error("message did not expire at its expires_at")
+	}
+}